@@ -2,11 +2,27 @@ package baseline
 
 import (
 	"encoding/json"
+	"fmt"
+	"sort"
 	"time"
 
+	"github.com/jinzhu/gorm"
+	dbconf "github.com/kthomas/go-db-config"
+	"github.com/kthomas/go-redisutil"
 	uuid "github.com/kthomas/go.uuid"
+	"github.com/provideplatform/baseline/common"
+	"github.com/provideplatform/provide-go/api/ident"
 )
 
+const analyticsActivityMaxItems = 50
+const analyticsCacheTTL = time.Minute
+const analyticsDefaultRange = time.Hour * 24 * 30
+const analyticsMaxRange = time.Hour * 24 * 366
+
+// workflowDelayedThreshold is the duration after which a workflow instance which has not
+// progressed is considered delayed
+const workflowDelayedThreshold = time.Hour * 72
+
 // WorkgroupDashboardAPIResponse is a general response containing data related to the current workgroup and organization context
 type WorkgroupDashboardAPIResponse struct {
 	Activity     []*ActivityAPIResponseItem `json:"activity"`
@@ -64,31 +80,337 @@ type WorkflowsAPIResponse struct {
 	PublishedCount *uint64 `json:"published_count"`
 }
 
+// analyticsRangeFactory returns the day-aligned [start_at, end_at) range for the given optional bounds;
+// the range defaults to the 30 days ending with the current day
+func analyticsRangeFactory(startAt, endAt *time.Time) (*time.Time, *time.Time, error) {
+	var end time.Time
+	if endAt != nil {
+		end = endAt.UTC().Truncate(time.Hour * 24)
+		if end.Before(*endAt) {
+			end = end.Add(time.Hour * 24)
+		}
+	} else {
+		end = time.Now().UTC().Truncate(time.Hour * 24).Add(time.Hour * 24)
+	}
+
+	var start time.Time
+	if startAt != nil {
+		start = startAt.UTC().Truncate(time.Hour * 24)
+	} else {
+		start = end.Add(-analyticsDefaultRange)
+	}
+
+	if !start.Before(end) {
+		return nil, nil, fmt.Errorf("start_at must precede end_at")
+	}
+
+	if end.Sub(start) > analyticsMaxRange {
+		return nil, nil, fmt.Errorf("analytics range must not exceed %d days", int(analyticsMaxRange.Hours()/24))
+	}
+
+	return &start, &end, nil
+}
+
+func analyticsCacheKey(workgroupID uuid.UUID, startAt, endAt time.Time) string {
+	return fmt.Sprintf("baseline.workgroup.%s.analytics.%d.%d", workgroupID, startAt.Unix(), endAt.Unix())
+}
+
 // queryAnalytics calculates or retrieves high-level analytics for the given BPI workgroup id
-func (w *Workgroup) queryAnalytics() (*WorkgroupDashboardAPIResponse, error) {
-	activity := make([]*ActivityAPIResponseItem, 0)
-	tree := &TreeAnalyticsAPIResponse{
-		Items: make([]*TreeAnalyticsAPIResponseItem, 0),
+// within the given range; results are cached per workgroup and range
+func (w *Workgroup) queryAnalytics(token string, startAt, endAt *time.Time) (*WorkgroupDashboardAPIResponse, error) {
+	start, end, err := analyticsRangeFactory(startAt, endAt)
+	if err != nil {
+		return nil, err
 	}
 
-	participants := &ParticipantsAPIResponse{
-		ActionItemsCount:   nil,
-		UsersCount:         nil,
-		OrganizationsCount: nil,
+	key := analyticsCacheKey(w.ID, *start, *end)
+	if raw, err := redisutil.Get(key); err == nil && raw != nil {
+		var cached *WorkgroupDashboardAPIResponse
+		if err := json.Unmarshal([]byte(*raw), &cached); err == nil && cached != nil {
+			common.Log.Debugf("resolved cached analytics for workgroup: %s", w.ID)
+			return cached, nil
+		}
+	}
+
+	db := dbconf.DatabaseConnection()
+
+	activity, err := w.queryActivity(*start, *end, db)
+	if err != nil {
+		return nil, err
 	}
 
-	workflows := &WorkflowsAPIResponse{
-		DelayedCount:   nil,
-		DraftCount:     nil,
-		PublishedCount: nil,
+	tree, err := w.queryTreeAnalytics(*start, *end, db)
+	if err != nil {
+		return nil, err
 	}
 
-	return &WorkgroupDashboardAPIResponse{
+	participants, err := w.queryParticipantsAnalytics(token, db)
+	if err != nil {
+		return nil, err
+	}
+
+	workflows, err := w.queryWorkflowsAnalytics(db)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &WorkgroupDashboardAPIResponse{
 		Activity: activity,
 		Analytics: &AnalyticsAPIResponse{
 			Tree: tree,
 		},
 		Participants: participants,
 		Workflows:    workflows,
+	}
+
+	raw, _ := json.Marshal(resp)
+	ttl := analyticsCacheTTL
+	if err := redisutil.Set(key, raw, &ttl); err != nil {
+		common.Log.Warningf("failed to cache analytics for workgroup: %s; %s", w.ID, err.Error())
+	}
+
+	return resp, nil
+}
+
+// queryActivity returns the most recent workflow and workstep transitions within the range
+func (w *Workgroup) queryActivity(startAt, endAt time.Time, db *gorm.DB) ([]*ActivityAPIResponseItem, error) {
+	type transition struct {
+		WorkflowID  uuid.UUID
+		WorkstepID  *uuid.UUID
+		Name        *string
+		Version     *string
+		Cardinality *int
+		Participant *string
+		Kind        string
+		OccurredAt  time.Time
+	}
+
+	transitions := make([]*transition, 0)
+	err := db.Raw(
+		`SELECT id AS workflow_id, name, version, 'deployed' AS kind, deployed_at AS occurred_at FROM workflows
+		 WHERE workgroup_id = ? AND workflow_id IS NULL AND deployed_at >= ? AND deployed_at < ?
+		 UNION ALL
+		 SELECT id AS workflow_id, name, version, 'created' AS kind, created_at AS occurred_at FROM workflows
+		 WHERE workgroup_id = ? AND workflow_id IS NOT NULL AND created_at >= ? AND created_at < ?
+		 UNION ALL
+		 SELECT id AS workflow_id, name, version, 'completed' AS kind, updated_at AS occurred_at FROM workflows
+		 WHERE workgroup_id = ? AND workflow_id IS NOT NULL AND status = ? AND updated_at >= ? AND updated_at < ?
+		 ORDER BY occurred_at DESC LIMIT ?`,
+		w.ID, startAt, endAt,
+		w.ID, startAt, endAt,
+		w.ID, workflowStatusCompleted, startAt, endAt,
+		analyticsActivityMaxItems,
+	).Scan(&transitions).Error
+	if err != nil && !gorm.IsRecordNotFoundError(err) {
+		return nil, fmt.Errorf("failed to query workflow activity for workgroup: %s; %s", w.ID, err.Error())
+	}
+
+	executions := make([]*transition, 0)
+	err = db.Raw(
+		`SELECT ws.workflow_id, ws.id AS workstep_id, ws.name, ws.cardinality, wp.participant, 'executed' AS kind, wp.witnessed_at AS occurred_at
+		 FROM worksteps_participants wp
+		 JOIN worksteps ws ON ws.id = wp.workstep_id
+		 JOIN workflows wf ON wf.id = ws.workflow_id
+		 WHERE wf.workgroup_id = ? AND wp.witnessed_at >= ? AND wp.witnessed_at < ?
+		 ORDER BY wp.witnessed_at DESC LIMIT ?`,
+		w.ID, startAt, endAt, analyticsActivityMaxItems,
+	).Scan(&executions).Error
+	if err != nil && !gorm.IsRecordNotFoundError(err) {
+		return nil, fmt.Errorf("failed to query workstep activity for workgroup: %s; %s", w.ID, err.Error())
+	}
+
+	transitions = append(transitions, executions...)
+	sort.SliceStable(transitions, func(i, j int) bool {
+		return transitions[i].OccurredAt.After(transitions[j].OccurredAt)
+	})
+	if len(transitions) > analyticsActivityMaxItems {
+		transitions = transitions[0:analyticsActivityMaxItems]
+	}
+
+	activity := make([]*ActivityAPIResponseItem, 0)
+	for _, t := range transitions {
+		name := ""
+		if t.Name != nil {
+			name = *t.Name
+		}
+
+		item := &ActivityAPIResponseItem{
+			WorkflowID: &t.WorkflowID,
+			WorkstepID: t.WorkstepID,
+		}
+		timestamp := t.OccurredAt
+		item.Timestamp = &timestamp
+
+		switch t.Kind {
+		case "deployed":
+			item.Title = common.StringOrNil("Workflow deployed")
+			if t.Version != nil {
+				item.Subtitle = common.StringOrNil(fmt.Sprintf("%s v%s", name, *t.Version))
+			} else {
+				item.Subtitle = common.StringOrNil(name)
+			}
+		case "created":
+			item.Title = common.StringOrNil("Workflow instance created")
+			item.Subtitle = common.StringOrNil(name)
+		case "completed":
+			item.Title = common.StringOrNil("Workflow instance completed")
+			item.Subtitle = common.StringOrNil(name)
+		case "executed":
+			item.Title = common.StringOrNil("Workstep executed")
+			if t.Participant != nil {
+				item.Subtitle = common.StringOrNil(fmt.Sprintf("%s by %s", name, *t.Participant))
+			} else {
+				item.Subtitle = common.StringOrNil(name)
+			}
+
+			metadata := map[string]interface{}{
+				"participant": t.Participant,
+			}
+			if t.Cardinality != nil {
+				metadata["cardinality"] = *t.Cardinality
+			}
+			raw, _ := json.Marshal(metadata)
+			_metadata := json.RawMessage(raw)
+			item.Metadata = &_metadata
+		}
+
+		activity = append(activity, item)
+	}
+
+	return activity, nil
+}
+
+// queryTreeAnalytics returns the per-day volume and size of records baselined within the range;
+// each workstep execution by a participant baselines a record, the size of which is its proof
+func (w *Workgroup) queryTreeAnalytics(startAt, endAt time.Time, db *gorm.DB) (*TreeAnalyticsAPIResponse, error) {
+	type volume struct {
+		Date  time.Time
+		Count uint64
+		Size  uint64
+	}
+
+	volumes := make([]*volume, 0)
+	err := db.Raw(
+		`SELECT date_trunc('day', wp.witnessed_at AT TIME ZONE 'UTC') AS date, count(*) AS count, COALESCE(sum(octet_length(wp.proof)), 0) AS size
+		 FROM worksteps_participants wp
+		 JOIN worksteps ws ON ws.id = wp.workstep_id
+		 JOIN workflows wf ON wf.id = ws.workflow_id
+		 WHERE wf.workgroup_id = ? AND wp.witnessed_at >= ? AND wp.witnessed_at < ?
+		 GROUP BY 1 ORDER BY 1`,
+		w.ID, startAt, endAt,
+	).Scan(&volumes).Error
+	if err != nil && !gorm.IsRecordNotFoundError(err) {
+		return nil, fmt.Errorf("failed to query baselined record volume for workgroup: %s; %s", w.ID, err.Error())
+	}
+
+	volumesByDate := map[string]*volume{}
+	for _, v := range volumes {
+		volumesByDate[v.Date.Format("2006-01-02")] = v
+	}
+
+	tree := &TreeAnalyticsAPIResponse{
+		StartAt: &startAt,
+		EndAt:   &endAt,
+		Items:   make([]*TreeAnalyticsAPIResponseItem, 0),
+	}
+
+	for date := startAt; date.Before(endAt); date = date.Add(time.Hour * 24) {
+		var count, size uint64
+		if v, ok := volumesByDate[date.Format("2006-01-02")]; ok {
+			count = v.Count
+			size = v.Size
+		}
+
+		raw, _ := json.Marshal(map[string]interface{}{
+			"count": count,
+		})
+		metadata := json.RawMessage(raw)
+
+		_date := date
+		tree.Items = append(tree.Items, &TreeAnalyticsAPIResponseItem{
+			Date:     &_date,
+			Metadata: &metadata,
+			Size:     size,
+			Subtitle: common.StringOrNil(date.Format("Jan 2, 2006")),
+			Title:    common.StringOrNil(fmt.Sprintf("%d baselined record(s)", count)),
+		})
+	}
+
+	return tree, nil
+}
+
+// queryParticipantsAnalytics counts the active workgroup participants and the executions they owe
+// on running workflow instances; the users count is resolved from ident on a best-effort basis
+func (w *Workgroup) queryParticipantsAnalytics(token string, db *gorm.DB) (*ParticipantsAPIResponse, error) {
+	var organizationsCount uint64
+	err := db.Raw(
+		"SELECT count(*) FROM workgroups_participants WHERE workgroup_id = ? AND status = ?",
+		w.ID, workgroupParticipantStatusActive,
+	).Row().Scan(&organizationsCount)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count participants for workgroup: %s; %s", w.ID, err.Error())
+	}
+
+	var actionItemsCount uint64
+	err = db.Raw(
+		`SELECT count(*) FROM worksteps_participants wp
+		 JOIN worksteps ws ON ws.id = wp.workstep_id
+		 JOIN workflows wf ON wf.id = ws.workflow_id
+		 WHERE wf.workgroup_id = ? AND wf.workflow_id IS NOT NULL AND ws.status IN (?) AND wp.proof IS NULL`,
+		w.ID, []string{workstepStatusInit, workstepStatusRunning},
+	).Row().Scan(&actionItemsCount)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count action items for workgroup: %s; %s", w.ID, err.Error())
+	}
+
+	participants := &ParticipantsAPIResponse{
+		ActionItemsCount:   &actionItemsCount,
+		OrganizationsCount: &organizationsCount,
+	}
+
+	users, err := ident.ListApplicationUsers(token, w.ID.String(), map[string]interface{}{})
+	if err != nil {
+		common.Log.Debugf("failed to resolve users count for workgroup: %s; %s", w.ID, err.Error())
+	} else {
+		usersCount := uint64(len(users))
+		participants.UsersCount = &usersCount
+	}
+
+	return participants, nil
+}
+
+// queryWorkflowsAnalytics counts the draft and deployed workflow prototypes and the delayed
+// workflow instances of the workgroup
+func (w *Workgroup) queryWorkflowsAnalytics(db *gorm.DB) (*WorkflowsAPIResponse, error) {
+	var draftCount, publishedCount, delayedCount uint64
+
+	err := db.Raw(
+		"SELECT count(*) FROM workflows WHERE workgroup_id = ? AND workflow_id IS NULL AND status = ?",
+		w.ID, workflowStatusDraft,
+	).Row().Scan(&draftCount)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count draft workflows for workgroup: %s; %s", w.ID, err.Error())
+	}
+
+	err = db.Raw(
+		"SELECT count(*) FROM workflows WHERE workgroup_id = ? AND workflow_id IS NULL AND status = ?",
+		w.ID, workflowStatusDeployed,
+	).Row().Scan(&publishedCount)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count deployed workflows for workgroup: %s; %s", w.ID, err.Error())
+	}
+
+	err = db.Raw(
+		"SELECT count(*) FROM workflows WHERE workgroup_id = ? AND workflow_id IS NOT NULL AND status IN (?) AND COALESCE(updated_at, created_at) < ?",
+		w.ID, []string{workflowStatusInit, workflowStatusRunning}, time.Now().Add(-workflowDelayedThreshold),
+	).Row().Scan(&delayedCount)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count delayed workflows for workgroup: %s; %s", w.ID, err.Error())
+	}
+
+	return &WorkflowsAPIResponse{
+		DelayedCount:   &delayedCount,
+		DraftCount:     &draftCount,
+		PublishedCount: &publishedCount,
 	}, nil
 }
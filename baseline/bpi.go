@@ -17,12 +17,14 @@
 package baseline
 
 import (
+	"context"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
@@ -33,6 +35,7 @@ import (
 	uuid "github.com/kthomas/go.uuid"
 	"github.com/provideplatform/baseline/common"
 	"github.com/provideplatform/baseline/middleware"
+	"github.com/provideplatform/baseline/tracing"
 	provide "github.com/provideplatform/provide-go/api"
 	"github.com/provideplatform/provide-go/api/baseline"
 	"github.com/provideplatform/provide-go/api/ident"
@@ -51,6 +54,14 @@ var (
 
 	// SubjectAccountsByID lazy loaded, in-memory cache for subject account id -> BPI subject account; in-memory cache available only to instances serving the API
 	SubjectAccountsByID map[string][]*SubjectAccount
+
+	// systemSORs cache the systems of record built for each subject account id, keyed by system config
+	systemSORs     = map[string]map[string]middleware.SOR{}
+	systemSORsLock = &sync.Mutex{}
+
+	// systemWatchers cancel the system of record watchers started for each subject account id
+	systemWatchers     = map[string]context.CancelFunc{}
+	systemWatchersLock = &sync.Mutex{}
 )
 
 // InviteClaims represent JWT invitation claims
@@ -173,20 +184,95 @@ func (s *SubjectAccount) listSystems() ([]*middleware.System, error) {
 	return systems, nil
 }
 
+// resolveSystem returns the system of record of the subject account which maps the given type;
+// unmapped types resolve to the sap system or, absent one, to the only configured system
 func (s *SubjectAccount) resolveSystem(mappingType string) (middleware.SOR, error) {
 	systems, err := s.listSystems()
 	if err != nil {
 		return nil, err
 	}
 
+	candidates := make([]*middleware.System, 0)
 	for _, sys := range systems {
-		if sys.Name != nil && *sys.Name == "sap" {
-			// HACK!!! TODO-- reesolve all systems and return []*middleware.SOR
-			return middleware.SystemFactory(sys), nil
+		if sys.MapsType(mappingType) {
+			candidates = append(candidates, sys)
+		}
+	}
+
+	if len(candidates) == 0 {
+		// types which are not mapped by any system are resolved to the sap system, if any, as
+		// was the case before systems declared the types they map
+		for _, sys := range systems {
+			if sys.Name != nil && *sys.Name == "sap" {
+				candidates = append(candidates, sys)
+				break
+			}
+		}
+	}
+
+	if len(candidates) == 0 {
+		if len(systems) > 1 {
+			return nil, fmt.Errorf("no system resolved for type: %s; none of the %d configured systems maps it", mappingType, len(systems))
+		}
+		candidates = systems
+	}
+
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("no system resolved for type: %s", mappingType)
+	} else if len(candidates) > 1 {
+		return nil, fmt.Errorf("no system resolved for type: %s; it is mapped by %d systems", mappingType, len(candidates))
+	}
+
+	sor := s.systemSOR(candidates[0], systems)
+	if sor == nil {
+		return nil, fmt.Errorf("no system resolved for type: %s; unsupported or misconfigured system", mappingType)
+	}
+
+	return sor, nil
+}
+
+// systemSOR returns the system of record built for the given system of the subject account, such
+// that authorization and connections are reused across messages; cached systems of record which
+// are no longer among the given configured systems are evicted
+func (s *SubjectAccount) systemSOR(sys *middleware.System, systems []*middleware.System) middleware.SOR {
+	configured := map[string]bool{}
+	for _, other := range systems {
+		configured[systemCacheKey(other)] = true
+	}
+
+	systemSORsLock.Lock()
+	defer systemSORsLock.Unlock()
+
+	sors, ok := systemSORs[*s.ID]
+	if !ok {
+		sors = map[string]middleware.SOR{}
+		systemSORs[*s.ID] = sors
+	}
+
+	for key := range sors {
+		if !configured[key] {
+			delete(sors, key)
 		}
 	}
 
-	return nil, fmt.Errorf("no system resolved for type: %s", mappingType)
+	key := systemCacheKey(sys)
+	if sor, ok := sors[key]; ok {
+		return sor
+	}
+
+	sor := middleware.SystemFactory(sys)
+	if sor != nil {
+		sors[key] = sor
+	}
+
+	return sor
+}
+
+// systemCacheKey returns a digest of the given system config, such that changes to the config,
+// including rotated credentials, result in the system of record being rebuilt
+func systemCacheKey(sys *middleware.System) string {
+	raw, _ := json.Marshal(sys)
+	return common.SHA256(string(raw))
 }
 
 func (s *SubjectAccount) persistCredentials() bool {
@@ -984,6 +1070,96 @@ func (s *SubjectAccount) startDaemon(refreshToken *string) error {
 		}
 	}()
 
+	s.startSystemWatcher()
+
+	return nil
+}
+
+// startSystemWatcher starts watching each of the systems configured for the subject account
+// which is a watcher for records to be baselined; any watchers previously started for the
+// subject account are stopped
+func (s *SubjectAccount) startSystemWatcher() {
+	systems, err := s.listSystems()
+	if err != nil {
+		common.Log.Warningf("failed to resolve systems to watch for BPI subject account: %s; %s", *s.ID, err.Error())
+		return
+	}
+
+	watchers := make([]middleware.Watcher, 0)
+	for _, sys := range systems {
+		if watcher, ok := middleware.WatcherFor(s.systemSOR(sys, systems)); ok {
+			watchers = append(watchers, watcher)
+		}
+	}
+
+	if len(watchers) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	systemWatchersLock.Lock()
+	if cancelPrevious, ok := systemWatchers[*s.ID]; ok {
+		cancelPrevious()
+	}
+	systemWatchers[*s.ID] = cancel
+	systemWatchersLock.Unlock()
+
+	for _, watcher := range watchers {
+		go func(watcher middleware.Watcher) {
+			common.Log.Debugf("watching system of record for BPI subject account: %s", *s.ID)
+			err := watcher.Watch(ctx, *s.Metadata.OrganizationID, *s.Metadata.WorkgroupID, s.baselineSystemRecord)
+			if err != nil {
+				common.Log.Warningf("failed to watch system of record for BPI subject account: %s; %s", *s.ID, err.Error())
+			}
+		}(watcher)
+	}
+}
+
+// baselineSystemRecord sends the given record read from the system of record as an outbound
+// protocol message, as though it had been sent using the protocol messages API
+func (s *SubjectAccount) baselineSystemRecord(record *middleware.OutboundRecord) error {
+	token, err := s.authorizeAccessToken()
+	if err != nil {
+		return err
+	}
+
+	message := &Message{}
+	message.ID = common.StringOrNil(record.ID)
+	message.Type = common.StringOrNil(record.Type)
+	message.Payload = record.Payload
+	message.subjectAccount = s
+	message.token = token.AccessToken
+
+	if record.BaselineID != nil {
+		baselineID, err := uuid.FromString(*record.BaselineID)
+		if err != nil {
+			return fmt.Errorf("invalid baseline id: %s; %s", *record.BaselineID, err.Error())
+		}
+		message.BaselineID = &baselineID
+	}
+
+	if baselineRecord := lookupBaselineRecordByInternalID(record.ID); baselineRecord != nil {
+		_, err := message.authorizeSender(*s.SubjectID, baselineRecord)
+		if err != nil {
+			return err
+		}
+	}
+
+	ctx, span := tracing.Start(context.Background(), "sor.watch")
+	defer span.End()
+	message.ctx = ctx
+
+	if !message.send() {
+		errs := make([]string, 0)
+		for _, err := range message.Errors {
+			if err.Message != nil {
+				errs = append(errs, *err.Message)
+			}
+		}
+		return fmt.Errorf("failed to baseline record: %s; %s", record.ID, strings.Join(errs, "; "))
+	}
+
 	return nil
 }
 
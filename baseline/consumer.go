@@ -17,6 +17,7 @@
 package baseline
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"sync"
@@ -27,17 +28,22 @@ import (
 	uuid "github.com/kthomas/go.uuid"
 	"github.com/nats-io/nats.go"
 	"github.com/provideplatform/baseline/common"
+	"github.com/provideplatform/baseline/metrics"
+	"github.com/provideplatform/baseline/tracing"
 	"github.com/provideplatform/provide-go/api/baseline"
 	"github.com/provideplatform/provide-go/api/ident"
 	"github.com/provideplatform/provide-go/api/nchain"
 	"github.com/provideplatform/provide-go/api/privacy"
 	"github.com/provideplatform/provide-go/api/vault"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const defaultNatsStream = "baseline"
 
 const protomsgPayloadTypeCircuit = "prover"
 const protomsgPayloadTypeWorkflow = "workflow"
+const protomsgPayloadTypeWorkgroupParticipant = "workgroup_participant"
 
 const natsDispatchInvitationSubject = "baseline.invitation.outbound"
 const natsDispatchInvitationMaxInFlight = 2048
@@ -59,11 +65,21 @@ const natsBaselineWorkstepFinalizeDeployMessageMaxInFlight = 2048
 const baselineWorkstepFinalizeDeployMessageAckWait = time.Second * 5
 const natsBaselineWorkstepFinalizeDeployMessageMaxDeliveries = 1000
 
+const natsWebhookDeliverySubject = "baseline.webhook.delivery"
+const natsWebhookDeliveryMaxInFlight = 2048
+const webhookDeliveryAckWait = time.Second * 30
+const natsWebhookDeliveryMaxDeliveries = 1000
+
 const natsDispatchProtocolMessageSubject = "baseline.protocolmessage.outbound"
 const natsDispatchProtocolMessageMaxInFlight = 2048
 const dispatchProtocolMessageAckWait = time.Second * 30
 const natsDispatchProtocolMessageMaxDeliveries = 10
 
+const dispatchFailureReasonConnectionFailed = "connection_failed"
+const dispatchFailureReasonCredentialUnresolved = "credential_unresolved"
+const dispatchFailureReasonEndpointUnresolved = "endpoint_unresolved"
+const dispatchFailureReasonPublishFailed = "publish_failed"
+
 const natsBaselineProxyInboundSubject = "baseline.inbound"
 const natsBaselineProxyInboundMaxInFlight = 2048
 const baselineProxyInboundAckWait = time.Second * 30
@@ -92,6 +108,7 @@ type Message struct {
 	ProtocolMessage *ProtocolMessage `sql:"-" json:"protocol_message,omitempty"`
 
 	// HACK -- convenience ptr ... for access during baselineOutbound()
+	ctx            context.Context `sql:"-" json:"-"`
 	subjectAccount *SubjectAccount `sql:"-" json:"-"`
 	token          *string         `sql:"-" json:"-"`
 }
@@ -101,6 +118,9 @@ type Message struct {
 type ProtocolMessage struct {
 	baseline.ProtocolMessage
 
+	// TraceContext propagates the trace context of the sender across BPIs
+	TraceContext map[string]string `sql:"-" json:"trace_context,omitempty"`
+
 	// HACK -- convenience ptr ... for access during baselineInbound()
 	subjectAccount *SubjectAccount `sql:"-" json:"-"`
 	token          *string         `sql:"-" json:"-"`
@@ -126,6 +146,7 @@ func init() {
 	createNatsDispatchInvitationSubscriptions(&waitGroup)
 	createNatsDispatchProtocolMessageSubscriptions(&waitGroup)
 	createNatsSubjectAccountRegistrationSubscriptions(&waitGroup)
+	createNatsWebhookDeliverySubscriptions(&waitGroup)
 }
 
 func createNatsBaselineProxySubscriptions(wg *sync.WaitGroup) {
@@ -146,14 +167,15 @@ func createNatsBaselineProxySubscriptions(wg *sync.WaitGroup) {
 	conn, _ := natsutil.GetSharedNatsConnection(nil)
 	conn.Subscribe(natsBaselineSubject, func(msg *nats.Msg) {
 		common.Log.Debugf("consuming %d-byte NATS inbound protocol message on subject: %s", len(msg.Data), msg.Subject)
-		_, err := natsutil.NatsJetstreamPublish(natsBaselineProxyInboundSubject, msg.Data)
+		ctx := tracing.ExtractHeader(context.Background(), msg.Header)
+		err := natsJetstreamPublishWithContext(ctx, natsBaselineProxyInboundSubject, msg.Data)
 		if err != nil {
 			common.Log.Warningf("failed to publish inbound protocol message to local jetstream consumers; %s", err.Error())
-			msg.Nak()
+			metrics.Nak(msg)
 			return
 		}
 
-		msg.Ack()
+		metrics.Ack(msg)
 	})
 }
 
@@ -257,47 +279,83 @@ func createNatsSubjectAccountRegistrationSubscriptions(wg *sync.WaitGroup) {
 	}
 }
 
+func createNatsWebhookDeliverySubscriptions(wg *sync.WaitGroup) {
+	for i := uint64(0); i < natsutil.GetNatsConsumerConcurrency(); i++ {
+		natsutil.RequireNatsJetstreamSubscription(wg,
+			webhookDeliveryAckWait,
+			natsWebhookDeliverySubject,
+			natsWebhookDeliverySubject,
+			natsWebhookDeliverySubject,
+			consumeWebhookDeliveryMsg,
+			webhookDeliveryAckWait,
+			natsWebhookDeliveryMaxInFlight,
+			natsWebhookDeliveryMaxDeliveries,
+			nil,
+		)
+	}
+}
+
 func consumeBaselineProxyInboundSubscriptionsMsg(msg *nats.Msg) {
 	common.Log.Debugf("consuming %d-byte NATS inbound protocol message on internal subject: %s", len(msg.Data), msg.Subject)
 
+	opcode := "unknown"
+	success := false
+	defer func() {
+		metrics.ObserveProtocolMessage(metrics.ProtocolMessageDirectionInbound, opcode, success)
+	}()
+
 	protomsg := &ProtocolMessage{}
 	err := json.Unmarshal(msg.Data, &protomsg)
 	if err != nil {
 		common.Log.Warningf("failed to umarshal inbound protocol message; %s", err.Error())
-		msg.Nak()
+		metrics.Nak(msg)
 		return
 	}
 
 	if protomsg.Opcode == nil {
 		common.Log.Warningf("inbound protocol message specified invalid opcode; %s", err.Error())
-		msg.Term()
+		metrics.Term(msg)
 		return
 	}
+	opcode = *protomsg.Opcode
+
+	ctx, span := tracing.Start(protocolMessageContext(msg, protomsg), "protocol_message.inbound",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(attribute.String("baseline.opcode", opcode)),
+	)
+	defer span.End()
+	protomsg.TraceContext = tracing.Inject(ctx)
 
 	switch *protomsg.Opcode {
 	case baseline.ProtocolMessageOpcodeBaseline:
 		if protomsg.Identifier == nil {
 			common.Log.Warningf("inbound protocol message specified invalid workflow identifier; %s", err.Error())
-			msg.Term()
+			metrics.Term(msg)
 			return
 		}
 
 		if protomsg.Recipient == nil {
 			common.Log.Warningf("inbound protocol message specified invalid recipient; %s", err.Error())
-			msg.Term()
+			metrics.Term(msg)
 			return
 		}
 
 		if protomsg.Sender == nil {
 			common.Log.Warningf("inbound protocol message specified invalid sender; %s", err.Error())
-			msg.Term()
+			metrics.Term(msg)
 			return
 		}
 
 		workflow := FindWorkflowByID(*protomsg.Identifier)
 		if workflow == nil {
 			common.Log.Warningf("inbound protocol message failed to resolve workflow: %s", protomsg.Identifier.String())
-			msg.Term() // FIXME-- should this just return and allow for redelivery in case of temporary latency issues?
+			metrics.Term(msg) // FIXME-- should this just return and allow for redelivery in case of temporary latency issues?
+			return
+		}
+
+		if isSuspendedWorkgroupParticipant(workflow.WorkgroupID, *protomsg.Sender) {
+			common.Log.Warningf("inbound protocol message sender is a suspended participant of workgroup: %s", workflow.WorkgroupID)
+			metrics.Term(msg)
 			return
 		}
 
@@ -309,16 +367,34 @@ func consumeBaselineProxyInboundSubscriptionsMsg(msg *nats.Msg) {
 
 		if protomsg.subjectAccount == nil {
 			common.Log.Warningf("inbound protocol message failed to resolve subject account for recipient: %s; workflow id: %s", *protomsg.Recipient, *protomsg.Identifier)
-			msg.Term() // FIXME-- should this just return and allow for redelivery in case of temporary latency issues?
+			metrics.Term(msg) // FIXME-- should this just return and allow for redelivery in case of temporary latency issues?
 			return
 		}
 
-		success := protomsg.baselineInbound()
-		if !success {
+		var organizationID *uuid.UUID
+		if protomsg.subjectAccount.Metadata != nil && protomsg.subjectAccount.Metadata.OrganizationID != nil {
+			orgID, err := uuid.FromString(*protomsg.subjectAccount.Metadata.OrganizationID)
+			if err == nil {
+				organizationID = &orgID
+			}
+		}
+
+		event := map[string]interface{}{
+			"baseline_id": protomsg.BaselineID,
+			"opcode":      protomsg.Opcode,
+			"sender":      protomsg.Sender,
+			"recipient":   protomsg.Recipient,
+			"workflow_id": workflow.ID,
+		}
+
+		if !protomsg.baselineInbound() {
 			common.Log.Warning("failed to baseline inbound protocol message")
+			dispatchWebhookEvent(organizationID, workflow.WorkgroupID, webhookEventProtocolMessageFailed, event)
 			return
 		}
 
+		dispatchWebhookEvent(organizationID, workflow.WorkgroupID, webhookEventProtocolMessageReceived, event)
+
 	case baseline.ProtocolMessageOpcodeJoin:
 		common.Log.Warningf("JOIN opcode not yet implemented")
 		// const payload = JSON.parse(msg.payload.toString());
@@ -387,7 +463,7 @@ func consumeBaselineProxyInboundSubscriptionsMsg(msg *nats.Msg) {
 			err = workflow.Cache()
 			if err != nil {
 				common.Log.Warningf("failed to handle inbound sync protocol message; failed to cache workflow; %s", err.Error())
-				msg.Nak()
+				metrics.Nak(msg)
 				return
 			}
 
@@ -395,21 +471,42 @@ func consumeBaselineProxyInboundSubscriptionsMsg(msg *nats.Msg) {
 				err = workflow.CacheByBaselineID(protomsg.BaselineID.String())
 				if err != nil {
 					common.Log.Warningf("failed to handle inbound sync protocol message; failed to cache workflow identifier by baseline id; %s", err.Error())
-					msg.Nak()
+					metrics.Nak(msg)
 					return
 				}
 			}
 
 			common.Log.Debugf("cached %d-workstep workflow: %s", len(workflow.Worksteps), workflow.ID)
+		} else if protomsg.Payload.Type != nil && *protomsg.Payload.Type == protomsgPayloadTypeWorkgroupParticipant {
+			participant, _ := protomsg.Payload.Object["participant"].(string)
+			status, _ := protomsg.Payload.Object["status"].(string)
+
+			workgroup := FindWorkgroupByID(*protomsg.Identifier)
+			if workgroup == nil {
+				common.Log.Warningf("failed to handle inbound sync protocol message; failed to resolve workgroup: %s", *protomsg.Identifier)
+				metrics.Term(msg)
+				return
+			}
+
+			if protomsg.Sender == nil || !workgroup.hasParticipant(*protomsg.Sender, dbconf.DatabaseConnection()) {
+				common.Log.Warningf("failed to handle inbound sync protocol message; sender is not an active participant of workgroup: %s", workgroup.ID)
+				metrics.Term(msg)
+				return
+			}
+
+			// participant status is managed by the workgroup owner; the notification is informational
+			common.Log.Debugf("participant %s notified status of participant %s in workgroup %s: %s", *protomsg.Sender, participant, workgroup.ID, status)
 		}
 
 	default:
+		opcode = "unknown" // avoid unbounded label cardinality
 		common.Log.Warningf("inbound protocol message specified invalid opcode; %s", err.Error())
-		msg.Term()
+		metrics.Term(msg)
 		return
 	}
 
-	msg.Ack()
+	success = true
+	metrics.Ack(msg)
 }
 
 func consumeBaselineWorkflowFinalizeDeploySubscriptionsMsg(msg *nats.Msg) {
@@ -420,14 +517,14 @@ func consumeBaselineWorkflowFinalizeDeploySubscriptionsMsg(msg *nats.Msg) {
 	err := json.Unmarshal(msg.Data, &params)
 	if err != nil {
 		common.Log.Warningf("failed to umarshal baseline workflow deploy message; %s", err.Error())
-		msg.Nak()
+		metrics.Nak(msg)
 		return
 	}
 
 	workflowID, err := uuid.FromString(params["workflow_id"].(string))
 	if err != nil {
 		common.Log.Warningf("failed to parse baseline workflow id; %s", err.Error())
-		msg.Nak()
+		metrics.Nak(msg)
 		return
 	}
 
@@ -450,7 +547,13 @@ func consumeBaselineWorkflowFinalizeDeploySubscriptionsMsg(msg *nats.Msg) {
 		workflow.Status = common.StringOrNil(workstepStatusDeployed)
 
 		db.Save(&workflow)
-		msg.Ack()
+		metrics.Ack(msg)
+
+		dispatchWebhookEvent(workflow.OrganizationID, workflow.WorkgroupID, webhookEventWorkflowDeployed, map[string]interface{}{
+			"workflow_id": workflow.ID,
+			"version":     workflow.Version,
+			"deployed_at": workflow.DeployedAt,
+		})
 	} else {
 		common.Log.Warningf("deployment not finalized for workflow: %s", workflow.ID)
 	}
@@ -464,35 +567,35 @@ func consumeBaselineWorkstepDeploySubscriptionsMsg(msg *nats.Msg) {
 	err := json.Unmarshal(msg.Data, &params)
 	if err != nil {
 		common.Log.Warningf("failed to umarshal baseline workstep deploy message; %s", err.Error())
-		msg.Nak()
+		metrics.Nak(msg)
 		return
 	}
 
 	organizationID, err := uuid.FromString(params["organization_id"].(string))
 	if err != nil {
 		common.Log.Warningf("failed to parse organization id; %s", err.Error())
-		msg.Nak()
+		metrics.Nak(msg)
 		return
 	}
 
 	workstepID, err := uuid.FromString(params["workstep_id"].(string))
 	if err != nil {
 		common.Log.Warningf("failed to parse baseline workstep id; %s", err.Error())
-		msg.Nak()
+		metrics.Nak(msg)
 		return
 	}
 
 	workstep := FindWorkstepByID(workstepID)
 	if workstep == nil {
 		common.Log.Warningf("failed to resolve baseline workstep: %s", workstepID)
-		msg.Nak()
+		metrics.Nak(msg)
 		return
 	}
 
 	workflow := FindWorkflowByID(*workstep.WorkflowID)
 	if workflow == nil {
 		common.Log.Errorf("failed to resolve baseline workflow: %s", workstep.WorkflowID)
-		msg.Nak()
+		metrics.Nak(msg)
 		return
 	}
 
@@ -500,19 +603,19 @@ func consumeBaselineWorkstepDeploySubscriptionsMsg(msg *nats.Msg) {
 	subjectAccount, err := resolveSubjectAccount(subjectAccountID)
 	if err != nil {
 		common.Log.Errorf("failed to resolve BPI subject account for workflow: %s; %s", workstep.WorkflowID, err.Error())
-		msg.Nak()
+		metrics.Nak(msg)
 		return
 	}
 
 	if subjectAccount.Metadata == nil || subjectAccount.Metadata.OrganizationID == nil {
 		common.Log.Errorf("failed to resolve BPI subject account; organization id required")
-		msg.Nak()
+		metrics.Nak(msg)
 		return
 	}
 
 	if *subjectAccount.Metadata.OrganizationID != organizationID.String() {
 		common.Log.Error("failed to resolve BPI subject account; organization id mismatch")
-		msg.Nak()
+		metrics.Nak(msg)
 		return
 	}
 
@@ -527,7 +630,7 @@ func consumeBaselineWorkstepDeploySubscriptionsMsg(msg *nats.Msg) {
 
 	if workstep.deploy(*token.AccessToken, organizationID) {
 		common.Log.Debugf("workstep pending deployment: %s", workstep.ID)
-		msg.Ack()
+		metrics.Ack(msg)
 	} else {
 		common.Log.Warningf("deployment not finalized for workstep: %s", workstep.ID)
 	}
@@ -541,35 +644,35 @@ func consumeBaselineWorkstepFinalizeDeploySubscriptionsMsg(msg *nats.Msg) {
 	err := json.Unmarshal(msg.Data, &params)
 	if err != nil {
 		common.Log.Warningf("failed to umarshal baseline workstep finalize deploy message; %s", err.Error())
-		msg.Nak()
+		metrics.Nak(msg)
 		return
 	}
 
 	organizationID, err := uuid.FromString(params["organization_id"].(string))
 	if err != nil {
 		common.Log.Warningf("failed to parse organization id; %s", err.Error())
-		msg.Nak()
+		metrics.Nak(msg)
 		return
 	}
 
 	workstepID, err := uuid.FromString(params["workstep_id"].(string))
 	if err != nil {
 		common.Log.Warningf("failed to parse baseline workstep id; %s", err.Error())
-		msg.Nak()
+		metrics.Nak(msg)
 		return
 	}
 
 	workstep := FindWorkstepByID(workstepID)
 	if workstep == nil {
 		common.Log.Warningf("failed to resolve baseline workstep: %s", workstepID)
-		msg.Nak()
+		metrics.Nak(msg)
 		return
 	}
 
 	workflow := FindWorkflowByID(*workstep.WorkflowID)
 	if workflow == nil {
 		common.Log.Warningf("failed to resolve baseline workflow: %s", workstep.WorkflowID)
-		msg.Nak()
+		metrics.Nak(msg)
 		return
 	}
 
@@ -577,13 +680,13 @@ func consumeBaselineWorkstepFinalizeDeploySubscriptionsMsg(msg *nats.Msg) {
 	subjectAccount, err := resolveSubjectAccount(subjectAccountID)
 	if err != nil {
 		common.Log.Errorf("failed to resolve BPI subject account for workflow: %s; %s", workstep.WorkflowID, err.Error())
-		msg.Nak()
+		metrics.Nak(msg)
 		return
 	}
 
 	if subjectAccount.Metadata.OrganizationID == nil {
 		common.Log.Error("failed to resolve BPI subject account; organization id required")
-		msg.Nak()
+		metrics.Nak(msg)
 		return
 	}
 
@@ -598,12 +701,45 @@ func consumeBaselineWorkstepFinalizeDeploySubscriptionsMsg(msg *nats.Msg) {
 
 	if workstep.finalizeDeploy(*token.AccessToken) {
 		common.Log.Debugf("deployed workstep: %s", workstep.ID)
-		msg.Ack()
+		metrics.Ack(msg)
 	} else {
 		common.Log.Warningf("deployment not finalized for workstep: %s", workstep.ID)
 	}
 }
 
+func consumeWebhookDeliveryMsg(msg *nats.Msg) {
+	common.Log.Debugf("consuming %d-byte NATS webhook delivery message on subject: %s", len(msg.Data), msg.Subject)
+
+	var params map[string]interface{}
+
+	err := json.Unmarshal(msg.Data, &params)
+	if err != nil {
+		common.Log.Warningf("failed to umarshal webhook delivery message; %s", err.Error())
+		metrics.Nak(msg)
+		return
+	}
+
+	deliveryIDStr, _ := params["delivery_id"].(string)
+	deliveryID, err := uuid.FromString(deliveryIDStr)
+	if err != nil {
+		common.Log.Warningf("failed to parse webhook delivery id; %s", err.Error())
+		metrics.Term(msg)
+		return
+	}
+
+	delivery := FindWebhookDeliveryByID(deliveryID)
+	if delivery == nil {
+		common.Log.Warningf("failed to resolve webhook delivery: %s", deliveryID)
+		metrics.Term(msg)
+		return
+	}
+
+	// deliveries awaiting backoff are not acked so the message is redelivered after the ack wait
+	if delivery.attempt(FindWebhookByID(delivery.WebhookID)) {
+		metrics.Ack(msg)
+	}
+}
+
 func consumeDispatchInvitationSubscriptionsMsg(msg *nats.Msg) {
 	common.Log.Debugf("consuming %d-byte NATS dispatch invitation message on subject: %s", len(msg.Data), msg.Subject)
 
@@ -612,13 +748,13 @@ func consumeDispatchInvitationSubscriptionsMsg(msg *nats.Msg) {
 	err := json.Unmarshal(msg.Data, &params)
 	if err != nil {
 		common.Log.Warningf("failed to umarshal dispatch invitation message; %s", err.Error())
-		msg.Nak()
+		metrics.Nak(msg)
 		return
 	}
 
 	// TODO!
 
-	msg.Ack()
+	metrics.Ack(msg)
 }
 
 func consumeDispatchProtocolMessageSubscriptionsMsg(msg *nats.Msg) {
@@ -628,7 +764,7 @@ func consumeDispatchProtocolMessageSubscriptionsMsg(msg *nats.Msg) {
 	err := json.Unmarshal(msg.Data, &params)
 	if err != nil {
 		common.Log.Warningf("failed to umarshal baseline workstep finalize deploy message; %s", err.Error())
-		msg.Nak()
+		metrics.Nak(msg)
 		return
 	}
 
@@ -636,54 +772,68 @@ func consumeDispatchProtocolMessageSubscriptionsMsg(msg *nats.Msg) {
 	err = json.Unmarshal(msg.Data, &protomsg)
 	if err != nil {
 		common.Log.Warningf("failed to umarshal dispatch protocol message; %s", err.Error())
-		msg.Nak()
+		metrics.Nak(msg)
 		return
 	}
 
 	if protomsg.Recipient == nil {
 		common.Log.Warningf("no participant specified in protocol message; %s", err.Error())
-		msg.Term()
+		metrics.Term(msg)
 		return
 	}
 
 	if protomsg.Identifier == nil {
 		common.Log.Warningf("no workflow identifier specified in protocol message; %s", err.Error())
-		msg.Term()
+		metrics.Term(msg)
 		return
 	}
 
+	ctx, span := tracing.Start(protocolMessageContext(msg, protomsg), "protocol_message.dispatch",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(attribute.String("baseline.recipient", *protomsg.Recipient)),
+	)
+	defer span.End()
+
 	organizationID, err := uuid.FromString(params["organization_id"].(string))
 	if err != nil {
 		common.Log.Warningf("failed to parse organization id; %s", err.Error())
-		msg.Nak()
+		metrics.Nak(msg)
 		return
 	}
 
-	workflow := FindWorkflowByID(*protomsg.Identifier)
-	if workflow == nil {
-		common.Log.Warningf("failed to resolve baseline workflow: %s", protomsg.Identifier)
-		msg.Nak()
+	// the identifier references a workflow or, in the case of workgroup notifications, a workgroup
+	var workgroupID *uuid.UUID
+	if workflow := FindWorkflowByID(*protomsg.Identifier); workflow != nil {
+		workgroupID = workflow.WorkgroupID
+	} else if workgroup := FindWorkgroupByID(*protomsg.Identifier); workgroup != nil {
+		workgroupID = &workgroup.ID
+	}
+
+	if workgroupID == nil {
+		common.Log.Warningf("failed to resolve baseline workflow or workgroup: %s", protomsg.Identifier)
+		metrics.Nak(msg)
 		return
 	}
 
 	url := lookupBaselineOrganizationMessagingEndpoint(*protomsg.Recipient)
 	if url == nil {
 		common.Log.Warningf("failed to lookup recipient messaging endpoint: %s", *protomsg.Recipient)
-		msg.Nak()
+		metrics.ObserveDispatchFailure(*protomsg.Recipient, dispatchFailureReasonEndpointUnresolved)
+		metrics.Nak(msg)
 		return
 	}
 
-	subjectAccountID := subjectAccountIDFactory(organizationID.String(), workflow.WorkgroupID.String())
+	subjectAccountID := subjectAccountIDFactory(organizationID.String(), workgroupID.String())
 	subjectAccount, err := resolveSubjectAccount(subjectAccountID)
 	if err != nil {
 		common.Log.Errorf("failed to resolve BPI subject account for workflow: %s; %s", *protomsg.Identifier, err.Error())
-		msg.Nak()
+		metrics.Nak(msg)
 		return
 	}
 
 	if subjectAccount.Metadata.OrganizationID == nil {
 		common.Log.Error("failed to resolve BPI subject account; organization id required")
-		msg.Nak()
+		metrics.Nak(msg)
 		return
 	}
 
@@ -695,14 +845,16 @@ func consumeDispatchProtocolMessageSubscriptionsMsg(msg *nats.Msg) {
 			subjectAccount.resolveWorkgroupParticipants() // HACK-- this should not re-resolve all counterparties...
 
 			common.Log.Warningf("failed to request verifiable credential from recipient counterparty: %s; %s", *protomsg.Recipient, err.Error())
-			msg.Nak()
+			metrics.ObserveDispatchFailure(*protomsg.Recipient, dispatchFailureReasonCredentialUnresolved)
+			metrics.Nak(msg)
 			return
 		}
 	}
 
 	if jwt == nil {
 		common.Log.Warningf("failed to dispatch protocol message to recipient: %s; no bearer token resolved", *protomsg.Recipient)
-		msg.Nak()
+		metrics.ObserveDispatchFailure(*protomsg.Recipient, dispatchFailureReasonCredentialUnresolved)
+		metrics.Nak(msg)
 		return
 	}
 
@@ -711,14 +863,26 @@ func consumeDispatchProtocolMessageSubscriptionsMsg(msg *nats.Msg) {
 	conn, err := natsutil.GetNatsConnection(name, *url, time.Second*10, jwt)
 	if err != nil {
 		common.Log.Warningf("failed to establish NATS connection to recipient: %s; %s", *protomsg.Recipient, err.Error())
-		msg.Nak()
+		metrics.ObserveDispatchFailure(*protomsg.Recipient, dispatchFailureReasonConnectionFailed)
+		metrics.Nak(msg)
 		return
 	}
 
 	defer conn.Close()
 
-	err = conn.Publish(natsBaselineSubject, msg.Data)
+	// the protocol message is forwarded verbatim; the dispatch span is propagated in the headers
+	outbound := nats.NewMsg(natsBaselineSubject)
+	outbound.Data = msg.Data
+	tracing.InjectHeader(ctx, outbound.Header)
+
+	err = conn.PublishMsg(outbound)
+	if err == nats.ErrHeadersNotSupported {
+		outbound.Header = nil
+		err = conn.PublishMsg(outbound)
+	}
 	if err != nil {
+		span.RecordError(err)
+
 		// clear cached endpoint so it will be re-fetched...
 		// counterparty := lookupBaselineOrganization(*protomsg.Recipient)
 		// counterparty.MessagingEndpoint = nil
@@ -727,19 +891,20 @@ func consumeDispatchProtocolMessageSubscriptionsMsg(msg *nats.Msg) {
 		subjectAccount.resolveWorkgroupParticipants() // HACK-- this should not re-resolve all counterparties...
 
 		common.Log.Warningf("failed to publish protocol message to recipient: %s; %s", *protomsg.Recipient, err.Error())
-		msg.Nak()
+		metrics.ObserveDispatchFailure(*protomsg.Recipient, dispatchFailureReasonPublishFailed)
+		metrics.Nak(msg)
 		return
 	}
 
-	common.Log.Debugf("broadcast %d-byte protocol message to recipient: %s", len(msg.Data), *protomsg.Recipient)
-	msg.Ack()
+	common.Log.Debugf("broadcast %d-byte protocol message to recipient: %s", len(outbound.Data), *protomsg.Recipient)
+	metrics.Ack(msg)
 }
 
 func consumeSubjectAccountRegistrationMsg(msg *nats.Msg) {
 	// defer func() {
 	// 	if r := recover(); r != nil {
 	// 		common.Log.Warningf("recovered in BPI subject account registration message handler; %s", r)
-	// 		msg.Nak()
+	// 		metrics.Nak(msg)
 	// 	}
 	// }()
 
@@ -749,14 +914,14 @@ func consumeSubjectAccountRegistrationMsg(msg *nats.Msg) {
 	err := json.Unmarshal(msg.Data, &params)
 	if err != nil {
 		common.Log.Warningf("failed to unmarshal BPI subject account registration message; %s", err.Error())
-		msg.Nak()
+		metrics.Nak(msg)
 		return
 	}
 
 	subjectAccountID, subjectAccountIDOk := params["subject_account_id"].(string)
 	if !subjectAccountIDOk {
 		common.Log.Warning("failed to parse BPI subject_account_id during BPI subject account registration message handler")
-		msg.Nak()
+		metrics.Nak(msg)
 		return
 	}
 
@@ -771,14 +936,14 @@ func consumeSubjectAccountRegistrationMsg(msg *nats.Msg) {
 	subjectAccount := FindSubjectAccountByID(subjectAccountID)
 	if subjectAccount == nil || subjectAccount.ID == nil {
 		common.Log.Warningf("failed to resolve BPI subject account during BPI subject account registration message handler; BPI subject account id: %s", subjectAccountID)
-		msg.Nak()
+		metrics.Nak(msg)
 		return
 	}
 
 	err = subjectAccount.enrich()
 	if err != nil {
 		common.Log.Warningf("failed to enrich BPI subject account during BPI subject account registration message handler; BPI subject account id: %s", subjectAccountID)
-		msg.Nak()
+		metrics.Nak(msg)
 		return
 	}
 
@@ -787,21 +952,21 @@ func consumeSubjectAccountRegistrationMsg(msg *nats.Msg) {
 
 	if workgroup == nil || workgroup.ID == uuid.Nil {
 		common.Log.Warningf("failed to resolve organization during BPI subject account registration message handler; BPI subject account id: %s", subjectAccountID)
-		msg.Nak()
+		metrics.Nak(msg)
 		return
 	}
 
 	orgToken, err := subjectAccount.authorizeAccessToken()
 	if err != nil {
 		common.Log.Warningf("failed to authorize access token for BPI subject account registration message handler; BPI subject account id: %s", subjectAccountID)
-		msg.Nak()
+		metrics.Nak(msg)
 		return
 	}
 
 	organization, err := ident.GetOrganizationDetails(*orgToken.AccessToken, *subjectAccount.SubjectID, map[string]interface{}{})
 	if err != nil {
 		common.Log.Warningf("failed to fetch organization details during BPI subject account registration message handler; BPI subject account id: %s", subjectAccountID)
-		msg.Nak()
+		metrics.Nak(msg)
 		return
 	}
 
@@ -811,7 +976,7 @@ func consumeSubjectAccountRegistrationMsg(msg *nats.Msg) {
 	vaults, err := vault.ListVaults(*orgToken.AccessToken, map[string]interface{}{})
 	if err != nil {
 		common.Log.Warningf("failed to fetch vaults during implicit key exchange message handler; BPI subject account id: %s", subjectAccountID)
-		msg.Nak()
+		metrics.Nak(msg)
 		return
 	}
 
@@ -827,7 +992,7 @@ func consumeSubjectAccountRegistrationMsg(msg *nats.Msg) {
 		})
 		if err != nil {
 			common.Log.Warningf("failed to fetch babyJubJub keys from vault during implicit key exchange message handler; BPI subject account id: %s", subjectAccountID)
-			msg.Nak()
+			metrics.Nak(msg)
 			return
 		}
 		if len(keys) > 0 {
@@ -853,33 +1018,33 @@ func consumeSubjectAccountRegistrationMsg(msg *nats.Msg) {
 
 	if subjectAccount.Metadata.OrganizationAddress == nil {
 		common.Log.Warningf("failed to resolve organization public address for storage in the public org registry; BPI subject account id: %s", subjectAccountID)
-		msg.Nak()
+		metrics.Nak(msg)
 		return
 	}
 
 	// FIXME!!
 	// if subjectAccount.Metadata.Domain == nil {
 	// 	common.Log.Warningf("failed to resolve organization domain for storage in the public org registry; BPI subject account id: %s", subjectAccountID)
-	// 	msg.Nak()
+	// 	metrics.Nak(msg)
 	// 	return
 	// }
 
 	if subjectAccount.Metadata.OrganizationMessagingEndpoint == nil {
 		common.Log.Warningf("failed to resolve organization messaging endpoint for storage in the public org registry; BPI subject account id: %s", subjectAccountID)
-		msg.Nak()
+		metrics.Nak(msg)
 		return
 	}
 
 	if orgZeroKnowledgePublicKey == nil {
 		common.Log.Warningf("failed to resolve organization zero-knowledge public key for storage in the public org registry; BPI subject account id: %s", subjectAccountID)
-		msg.Nak()
+		metrics.Nak(msg)
 		return
 	}
 
 	contracts, err := nchain.ListContracts(*orgToken.AccessToken, map[string]interface{}{})
 	if err != nil {
 		common.Log.Warningf("failed to resolve organization registry contract to which the organization registration tx should be sent; BPI subject account id: %s", subjectAccountID)
-		msg.Nak()
+		metrics.Nak(msg)
 		return
 	}
 
@@ -898,7 +1063,7 @@ func consumeSubjectAccountRegistrationMsg(msg *nats.Msg) {
 	})
 	if err != nil {
 		common.Log.Warningf("failed to create organization HD wallet for organization registration tx should be sent; BPI subject account id: %s", subjectAccountID)
-		msg.Nak()
+		metrics.Nak(msg)
 		return
 	}
 
@@ -909,7 +1074,7 @@ func consumeSubjectAccountRegistrationMsg(msg *nats.Msg) {
 		resp, err := nchain.GetContractDetails(*orgToken.AccessToken, c.ID.String(), map[string]interface{}{})
 		if err != nil {
 			common.Log.Warningf("failed to resolve organization registry contract to which the organization registration tx should be sent; BPI subject account id: %s", subjectAccountID)
-			msg.Nak()
+			metrics.Nak(msg)
 			return
 		}
 
@@ -927,19 +1092,19 @@ func consumeSubjectAccountRegistrationMsg(msg *nats.Msg) {
 
 	if erc1820RegistryContractID == nil || erc1820RegistryContractAddress == nil {
 		common.Log.Warningf("failed to resolve ERC1820 registry contract; BPI subject account id: %s", subjectAccountID)
-		msg.Nak()
+		metrics.Nak(msg)
 		return
 	}
 
 	if orgRegistryContractID == nil || orgRegistryContractAddress == nil {
 		common.Log.Warningf("failed to resolve organization registry contract; BPI subject account id: %s", subjectAccountID)
-		msg.Nak()
+		metrics.Nak(msg)
 		return
 	}
 
 	if orgWalletID == nil {
 		common.Log.Warningf("failed to resolve organization HD wallet for signing organization impl transaction transaction; BPI subject account id: %s", subjectAccountID)
-		msg.Nak()
+		metrics.Nak(msg)
 		return
 	}
 
@@ -974,5 +1139,31 @@ func consumeSubjectAccountRegistrationMsg(msg *nats.Msg) {
 	}
 
 	common.Log.Debugf("broadcast organization registry and interface impl transactions on behalf of organization: %s", *subjectAccount.SubjectID)
-	msg.Ack()
+	metrics.Ack(msg)
+}
+
+// protocolMessageContext returns the trace context propagated in the headers of the given NATS
+// message or, when absent, in the envelope of the given protocol message
+func protocolMessageContext(msg *nats.Msg, protomsg *ProtocolMessage) context.Context {
+	ctx := tracing.ExtractHeader(context.Background(), msg.Header)
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		ctx = tracing.Extract(ctx, protomsg.TraceContext)
+	}
+	return ctx
+}
+
+// natsJetstreamPublishWithContext publishes the given payload with the trace context of the
+// given context in the message headers
+func natsJetstreamPublishWithContext(ctx context.Context, subject string, payload []byte) error {
+	js, err := natsutil.GetSharedJetstreamContext(nil)
+	if err != nil {
+		return err
+	}
+
+	msg := nats.NewMsg(subject)
+	msg.Data = payload
+	tracing.InjectHeader(ctx, msg.Header)
+
+	_, err = js.PublishMsg(msg)
+	return err
 }
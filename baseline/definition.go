@@ -0,0 +1,430 @@
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package baseline
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+	dbconf "github.com/kthomas/go-db-config"
+	uuid "github.com/kthomas/go.uuid"
+	"github.com/provideplatform/baseline/common"
+	provide "github.com/provideplatform/provide-go/api"
+	"github.com/provideplatform/provide-go/api/baseline"
+	"gopkg.in/yaml.v2"
+)
+
+const workflowDefinitionFormatJSON = "json"
+const workflowDefinitionFormatYAML = "yaml"
+
+// WorkflowDefinition is a declarative, portable representation of a workflow prototype
+// which can be exported from one environment and imported into another
+type WorkflowDefinition struct {
+	Name         *string                `json:"name"`
+	Description  *string                `json:"description,omitempty"`
+	Version      *string                `json:"version"`
+	Metadata     map[string]interface{} `json:"metadata,omitempty"`
+	Participants []string               `json:"participants,omitempty"`
+	Worksteps    []*WorkstepDefinition  `json:"worksteps"`
+	Mappings     []*MappingDefinition   `json:"mappings,omitempty"`
+	Errors       []*provide.Error       `json:"-"`
+}
+
+// WorkstepDefinition is the declarative representation of a workstep prototype
+type WorkstepDefinition struct {
+	Name            *string                `json:"name"`
+	Description     *string                `json:"description,omitempty"`
+	Metadata        map[string]interface{} `json:"metadata,omitempty"`
+	Participants    []string               `json:"participants,omitempty"`
+	RequireFinality bool                   `json:"require_finality"`
+}
+
+// MappingDefinition is the declarative representation of a mapping
+type MappingDefinition struct {
+	Name        string                   `json:"name"`
+	Description *string                  `json:"description,omitempty"`
+	Type        *string                  `json:"type"`
+	Version     *string                  `json:"version,omitempty"`
+	Models      []*baseline.MappingModel `json:"models"`
+}
+
+// parseWorkflowDefinition parses a JSON or YAML workflow definition
+func parseWorkflowDefinition(raw []byte) (*WorkflowDefinition, error) {
+	var definition *WorkflowDefinition
+
+	trimmed := strings.TrimSpace(string(raw))
+	if strings.HasPrefix(trimmed, "{") {
+		err := json.Unmarshal(raw, &definition)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse JSON workflow definition; %s", err.Error())
+		}
+		return definition, nil
+	}
+
+	var doc interface{}
+	err := yaml.Unmarshal(raw, &doc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse YAML workflow definition; %s", err.Error())
+	}
+
+	// YAML maps are keyed by interface{}; normalize them so the document can be read using the JSON tags
+	normalized, err := json.Marshal(normalizeYAML(doc))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse YAML workflow definition; %s", err.Error())
+	}
+
+	err = json.Unmarshal(normalized, &definition)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse YAML workflow definition; %s", err.Error())
+	}
+
+	return definition, nil
+}
+
+func normalizeYAML(val interface{}) interface{} {
+	switch v := val.(type) {
+	case map[interface{}]interface{}:
+		m := map[string]interface{}{}
+		for key, value := range v {
+			m[fmt.Sprintf("%v", key)] = normalizeYAML(value)
+		}
+		return m
+	case []interface{}:
+		for i, value := range v {
+			v[i] = normalizeYAML(value)
+		}
+		return v
+	}
+	return val
+}
+
+// marshal the workflow definition using the given format
+func (d *WorkflowDefinition) marshal(format string) ([]byte, error) {
+	raw, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	if format != workflowDefinitionFormatYAML {
+		return raw, nil
+	}
+
+	var doc map[string]interface{}
+	err = json.Unmarshal(raw, &doc)
+	if err != nil {
+		return nil, err
+	}
+
+	return yaml.Marshal(doc)
+}
+
+// exportWorkflowDefinition builds a declarative definition of the given workflow prototype,
+// including its worksteps, participants and the mappings of its workgroup
+func exportWorkflowDefinition(workflow *Workflow, tx *gorm.DB) (*WorkflowDefinition, error) {
+	if !workflow.isPrototype() {
+		return nil, fmt.Errorf("cannot export workflow instance: %s", workflow.ID)
+	}
+
+	definition := &WorkflowDefinition{
+		Name:         workflow.Name,
+		Description:  workflow.Description,
+		Version:      workflow.Version,
+		Participants: workflow.participantAddresses(tx),
+		Worksteps:    make([]*WorkstepDefinition, 0),
+		Mappings:     make([]*MappingDefinition, 0),
+	}
+
+	if workflow.Metadata != nil {
+		definition.Metadata = workflow.ParseMetadata()
+	}
+
+	for _, workstep := range FindWorkstepsByWorkflowID(workflow.ID) {
+		wsdef := &WorkstepDefinition{
+			Name:            workstep.Name,
+			Description:     workstep.Description,
+			Participants:    workstep.participantAddresses(tx),
+			RequireFinality: workstep.RequireFinality,
+		}
+
+		if workstep.Metadata != nil {
+			wsdef.Metadata = workstep.ParseMetadata()
+		}
+
+		definition.Worksteps = append(definition.Worksteps, wsdef)
+	}
+
+	if workflow.WorkgroupID != nil {
+		var mappings []*Mapping
+		tx.Where("workgroup_id = ?", workflow.WorkgroupID).Order("type DESC").Find(&mappings)
+		for _, mapping := range mappings {
+			mapping.enrich()
+
+			mdef := &MappingDefinition{
+				Name:        mapping.Name,
+				Description: mapping.Description,
+				Type:        mapping.Type,
+				Version:     mapping.Version,
+				Models:      make([]*baseline.MappingModel, 0),
+			}
+
+			for _, model := range mapping.Models {
+				mdl := &baseline.MappingModel{
+					Description: model.Description,
+					Fields:      make([]*baseline.MappingField, 0),
+					PrimaryKey:  model.PrimaryKey,
+					Standard:    model.Standard,
+					Type:        model.Type,
+				}
+
+				for _, field := range model.Fields {
+					fld := field.MappingField
+					mdl.Fields = append(mdl.Fields, &fld)
+				}
+
+				mdef.Models = append(mdef.Models, mdl)
+			}
+
+			definition.Mappings = append(definition.Mappings, mdef)
+		}
+	}
+
+	return definition, nil
+}
+
+// Validate the workflow definition against the participants of the given workgroup
+func (d *WorkflowDefinition) Validate(workgroup *Workgroup, tx *gorm.DB) bool {
+	if d.Name == nil || *d.Name == "" {
+		d.Errors = append(d.Errors, &provide.Error{
+			Message: common.StringOrNil("name is required"),
+		})
+	}
+
+	if d.Version == nil {
+		d.Errors = append(d.Errors, &provide.Error{
+			Message: common.StringOrNil("version is required"),
+		})
+	} else if _, err := common.ParseSemver(*d.Version); err != nil {
+		d.Errors = append(d.Errors, &provide.Error{
+			Message: common.StringOrNil(err.Error()),
+		})
+	}
+
+	workgroupParticipants := map[string]bool{}
+	for _, p := range workgroup.listParticipants(tx) {
+		if p.Participant != nil {
+			workgroupParticipants[strings.ToLower(*p.Participant)] = true
+		}
+	}
+
+	validateParticipants := func(context string, participants []string) {
+		for _, participant := range participants {
+			if !workgroupParticipants[strings.ToLower(participant)] {
+				d.Errors = append(d.Errors, &provide.Error{
+					Message: common.StringOrNil(fmt.Sprintf("%s participant %s is not a member of workgroup: %s", context, participant, workgroup.ID)),
+				})
+			}
+		}
+	}
+
+	validateParticipants("workflow", d.Participants)
+
+	if len(d.Worksteps) == 0 {
+		d.Errors = append(d.Errors, &provide.Error{
+			Message: common.StringOrNil("at least one workstep is required"),
+		})
+	}
+
+	names := map[string]bool{}
+	for i, workstep := range d.Worksteps {
+		if workstep.Name == nil || *workstep.Name == "" {
+			d.Errors = append(d.Errors, &provide.Error{
+				Message: common.StringOrNil(fmt.Sprintf("name is required for workstep at index %d", i)),
+			})
+			continue
+		}
+
+		if names[*workstep.Name] {
+			d.Errors = append(d.Errors, &provide.Error{
+				Message: common.StringOrNil(fmt.Sprintf("duplicate workstep name: %s", *workstep.Name)),
+			})
+		}
+		names[*workstep.Name] = true
+
+		if !hasProverMetadata(workstep.Metadata) {
+			d.Errors = append(d.Errors, &provide.Error{
+				Message: common.StringOrNil(fmt.Sprintf("prover is required on workstep: %s", *workstep.Name)),
+			})
+		}
+
+		if workstep.RequireFinality && i != len(d.Worksteps)-1 {
+			d.Errors = append(d.Errors, &provide.Error{
+				Message: common.StringOrNil(fmt.Sprintf("only the final workstep may require finality; workstep: %s", *workstep.Name)),
+			})
+		} else if !workstep.RequireFinality && i == len(d.Worksteps)-1 {
+			d.Errors = append(d.Errors, &provide.Error{
+				Message: common.StringOrNil(fmt.Sprintf("final workstep must require finality; workstep: %s", *workstep.Name)),
+			})
+		}
+
+		validateParticipants(fmt.Sprintf("workstep %s", *workstep.Name), workstep.Participants)
+	}
+
+	for _, mapping := range d.Mappings {
+		if mapping.Type == nil {
+			d.Errors = append(d.Errors, &provide.Error{
+				Message: common.StringOrNil(fmt.Sprintf("type is required on mapping: %s", mapping.Name)),
+			})
+		}
+	}
+
+	return len(d.Errors) == 0
+}
+
+// importWorkflowDefinition transactionally creates a draft workflow prototype, its worksteps,
+// participants and mappings from the given definition
+func importWorkflowDefinition(definition *WorkflowDefinition, organizationID, workgroupID uuid.UUID) (*Workflow, bool) {
+	workgroup := FindWorkgroupByID(workgroupID)
+	if workgroup == nil {
+		definition.Errors = append(definition.Errors, &provide.Error{
+			Message: common.StringOrNil(fmt.Sprintf("workgroup not found: %s", workgroupID)),
+		})
+		return nil, false
+	}
+
+	db := dbconf.DatabaseConnection()
+	tx := db.Begin()
+	defer tx.RollbackUnlessCommitted()
+
+	if !definition.Validate(workgroup, tx) {
+		return nil, false
+	}
+
+	workflow := &Workflow{
+		Name:           definition.Name,
+		Description:    definition.Description,
+		OrganizationID: &organizationID,
+		WorkgroupID:    &workgroupID,
+	}
+	workflow.Status = common.StringOrNil(workflowStatusDraft)
+	workflow.Version = definition.Version
+
+	if definition.Metadata != nil {
+		raw, _ := json.Marshal(definition.Metadata)
+		metadata := json.RawMessage(raw)
+		workflow.Metadata = &metadata
+	}
+
+	for _, participant := range definition.Participants {
+		workflow.Participants = append(workflow.Participants, &Participant{
+			Address: common.StringOrNil(participant),
+		})
+	}
+
+	if !workflow.Create(tx) {
+		definition.Errors = append(definition.Errors, workflow.Errors...)
+		return nil, false
+	}
+
+	for _, participant := range definition.Participants {
+		if !workflow.addParticipant(participant, tx) {
+			definition.Errors = append(definition.Errors, workflow.Errors...)
+			return nil, false
+		}
+	}
+
+	workflow.Worksteps = make([]*Workstep, 0)
+	for _, wsdef := range definition.Worksteps {
+		workstep := &Workstep{
+			Description: wsdef.Description,
+		}
+		workstep.Name = wsdef.Name
+		workstep.RequireFinality = wsdef.RequireFinality
+		workstep.Status = common.StringOrNil(workstepStatusDraft)
+		workstep.WorkflowID = &workflow.ID
+
+		if wsdef.Metadata != nil {
+			raw, _ := json.Marshal(wsdef.Metadata)
+			metadata := json.RawMessage(raw)
+			workstep.Metadata = &metadata
+		}
+
+		for _, participant := range wsdef.Participants {
+			workstep.Participants = append(workstep.Participants, &Participant{
+				Address: common.StringOrNil(participant),
+			})
+		}
+
+		if !workstep.Create(tx) {
+			definition.Errors = append(definition.Errors, workstep.Errors...)
+			return nil, false
+		}
+
+		for _, participant := range wsdef.Participants {
+			if !workstep.addParticipant(participant, tx) {
+				definition.Errors = append(definition.Errors, workstep.Errors...)
+				return nil, false
+			}
+		}
+
+		workflow.Worksteps = append(workflow.Worksteps, workstep)
+	}
+	workflow.WorkstepsCount = len(workflow.Worksteps)
+
+	for _, mdef := range definition.Mappings {
+		mapping := &Mapping{
+			OrganizationID: &organizationID,
+			Version:        mdef.Version,
+			WorkgroupID:    &workgroupID,
+		}
+		mapping.Name = mdef.Name
+		mapping.Description = mdef.Description
+		mapping.Type = mdef.Type
+
+		for _, mdl := range mdef.Models {
+			model := &MappingModel{
+				MappingModel: *mdl,
+			}
+			for _, fld := range mdl.Fields {
+				model.Fields = append(model.Fields, &MappingField{
+					MappingField: *fld,
+				})
+			}
+			model.MappingModel.Fields = nil
+			mapping.Models = append(mapping.Models, model)
+		}
+
+		if !mapping.Create(tx) {
+			definition.Errors = append(definition.Errors, mapping.Errors...)
+			return nil, false
+		}
+	}
+
+	result := tx.Commit()
+	if errors := result.GetErrors(); len(errors) > 0 {
+		for _, err := range errors {
+			definition.Errors = append(definition.Errors, &provide.Error{
+				Message: common.StringOrNil(err.Error()),
+			})
+		}
+		return nil, false
+	}
+
+	common.Log.Debugf("imported workflow definition %s as workflow prototype: %s", *definition.Name, workflow.ID)
+	return workflow, true
+}
@@ -0,0 +1,111 @@
+// +build unit
+
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package baseline
+
+import (
+	"fmt"
+
+	dbconf "github.com/kthomas/go-db-config"
+	uuid "github.com/kthomas/go.uuid"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/provideplatform/baseline/common"
+)
+
+func workflowDefinitionFactory(worksteps int) *WorkflowDefinition {
+	definition := &WorkflowDefinition{
+		Name:      common.StringOrNil(fmt.Sprintf("workflow %s", uuid.Must(uuid.NewV4()))),
+		Version:   common.StringOrNil("1.0.0"),
+		Worksteps: make([]*WorkstepDefinition, 0),
+	}
+
+	for i := 0; i < worksteps; i++ {
+		definition.Worksteps = append(definition.Worksteps, &WorkstepDefinition{
+			Name: common.StringOrNil(fmt.Sprintf("workstep %d", i+1)),
+			Metadata: map[string]interface{}{
+				workstepMetadataProverKey: map[string]interface{}{
+					"identifier":     "cubic",
+					"name":           "General Consistency",
+					"provider":       "gnark",
+					"proving_scheme": "groth16",
+					"curve":          "BN254",
+				},
+			},
+			RequireFinality: i == worksteps-1,
+		})
+	}
+
+	return definition
+}
+
+var _ = Describe("WorkflowDefinition", func() {
+	var organizationID uuid.UUID
+	var workgroup *Workgroup
+
+	BeforeEach(func() {
+		organizationID, _ = uuid.NewV4()
+		workgroup = &Workgroup{
+			Name:           common.StringOrNil("definition round-trip"),
+			OrganizationID: &organizationID,
+		}
+		workgroup.ID, _ = uuid.NewV4()
+		Expect(workgroup.Create()).To(BeTrue())
+	})
+
+	It("imports worksteps in order with sequential cardinality", func() {
+		definition := workflowDefinitionFactory(3)
+
+		workflow, ok := importWorkflowDefinition(definition, organizationID, workgroup.ID)
+		Expect(ok).To(BeTrue(), fmt.Sprintf("%v", definition.Errors))
+		Expect(workflow.Worksteps).To(HaveLen(3))
+
+		for i, workstep := range FindWorkstepsByWorkflowID(workflow.ID) {
+			Expect(workstep.Cardinality).To(Equal(i + 1))
+			Expect(*workstep.Name).To(Equal(*definition.Worksteps[i].Name))
+		}
+	})
+
+	It("round-trips an exported workflow definition through import", func() {
+		source, ok := importWorkflowDefinition(workflowDefinitionFactory(3), organizationID, workgroup.ID)
+		Expect(ok).To(BeTrue())
+
+		exported, err := exportWorkflowDefinition(source, dbconf.DatabaseConnection())
+		Expect(err).To(BeNil())
+		Expect(exported.Worksteps).To(HaveLen(3))
+
+		raw, err := exported.marshal(workflowDefinitionFormatYAML)
+		Expect(err).To(BeNil())
+
+		definition, err := parseWorkflowDefinition(raw)
+		Expect(err).To(BeNil())
+
+		workflow, ok := importWorkflowDefinition(definition, organizationID, workgroup.ID)
+		Expect(ok).To(BeTrue(), fmt.Sprintf("%v", definition.Errors))
+		Expect(workflow.ID).NotTo(Equal(source.ID))
+		Expect(*workflow.Version).To(Equal(*source.Version))
+
+		imported := FindWorkstepsByWorkflowID(workflow.ID)
+		Expect(imported).To(HaveLen(3))
+		for i, workstep := range imported {
+			Expect(workstep.Cardinality).To(Equal(i + 1))
+			Expect(*workstep.Name).To(Equal(*exported.Worksteps[i].Name))
+			Expect(workstep.RequireFinality).To(Equal(exported.Worksteps[i].RequireFinality))
+		}
+	})
+})
@@ -0,0 +1,193 @@
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package baseline
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	dbconf "github.com/kthomas/go-db-config"
+	natsutil "github.com/kthomas/go-natsutil"
+	uuid "github.com/kthomas/go.uuid"
+	"github.com/provideplatform/baseline/common"
+	provide "github.com/provideplatform/provide-go/api"
+	"github.com/provideplatform/provide-go/api/privacy"
+)
+
+const proverStatusFailed = "failed"
+
+// workflowDeploymentStallTimeout is the duration after which the workflow deploy message is no
+// longer redelivered; a workflow still pending deployment after this long will never finalize
+const workflowDeploymentStallTimeout = baselineWorkflowDeployMessageAckWait * natsBaselineWorkflowDeployMessageMaxDeliveries
+
+// WorkflowDeploymentStatus is the deployment state of a workflow prototype and its worksteps
+type WorkflowDeploymentStatus struct {
+	WorkflowID   uuid.UUID                   `json:"workflow_id"`
+	Status       *string                     `json:"status"`
+	DeployedAt   *time.Time                  `json:"deployed_at"`
+	Stalled      bool                        `json:"stalled"`
+	Redeployable bool                        `json:"redeployable"`
+	Worksteps    []*WorkstepDeploymentStatus `json:"worksteps"`
+}
+
+// WorkstepDeploymentStatus is the deployment state of a workstep prototype and its prover
+type WorkstepDeploymentStatus struct {
+	WorkstepID   uuid.UUID        `json:"workstep_id"`
+	Name         *string          `json:"name"`
+	Cardinality  int              `json:"cardinality"`
+	Status       *string          `json:"status"`
+	DeployedAt   *time.Time       `json:"deployed_at"`
+	ProverID     *uuid.UUID       `json:"prover_id"`
+	ProverStatus *string          `json:"prover_status"`
+	Failed       bool             `json:"failed"`
+	Errors       []*provide.Error `json:"errors,omitempty"`
+
+	workstep *Workstep
+}
+
+// deploymentStatus resolves the deployment state of the workflow prototype, including the state
+// of the prover underlying each of its worksteps
+func (w *Workflow) deploymentStatus(token string) *WorkflowDeploymentStatus {
+	status := &WorkflowDeploymentStatus{
+		WorkflowID: w.ID,
+		Status:     w.Status,
+		DeployedAt: w.DeployedAt,
+		Worksteps:  make([]*WorkstepDeploymentStatus, 0),
+	}
+
+	pending := w.Status != nil && *w.Status == workflowStatusPendingDeployment
+	if pending && w.UpdatedAt != nil && time.Since(*w.UpdatedAt) > workflowDeploymentStallTimeout {
+		status.Stalled = true
+	}
+
+	for _, workstep := range FindWorkstepsByWorkflowID(w.ID) {
+		wsStatus := &WorkstepDeploymentStatus{
+			WorkstepID:  workstep.ID,
+			Name:        workstep.Name,
+			Cardinality: workstep.Cardinality,
+			Status:      workstep.Status,
+			DeployedAt:  workstep.DeployedAt,
+			ProverID:    workstep.ProverID,
+			workstep:    workstep,
+		}
+
+		if workstep.ProverID != nil {
+			prover, err := privacy.GetProverDetails(token, workstep.ProverID.String())
+			if err != nil {
+				wsStatus.Failed = true
+				wsStatus.Errors = append(wsStatus.Errors, &provide.Error{
+					Message: common.StringOrNil(fmt.Sprintf("failed to resolve prover: %s; %s", workstep.ProverID, err.Error())),
+				})
+			} else {
+				wsStatus.ProverStatus = prover.Status
+				wsStatus.Failed = prover.Status != nil && *prover.Status == proverStatusFailed
+			}
+		}
+
+		if pending && (wsStatus.Failed || status.Stalled) && (workstep.Status == nil || *workstep.Status != workstepStatusDeployed) {
+			status.Redeployable = true
+		}
+
+		status.Worksteps = append(status.Worksteps, wsStatus)
+	}
+
+	return status
+}
+
+// redeploy resets the failed worksteps of a workflow prototype stuck pending deployment and
+// republishes the deploy messages; worksteps with a healthy prover are only finalized again
+func (w *Workflow) redeploy(token string) bool {
+	if w.Status == nil || *w.Status != workflowStatusPendingDeployment {
+		w.Errors = append(w.Errors, &provide.Error{
+			Message: common.StringOrNil("cannot redeploy workflow which is not pending deployment"),
+		})
+		return false
+	}
+
+	status := w.deploymentStatus(token)
+	if !status.Redeployable {
+		w.Errors = append(w.Errors, &provide.Error{
+			Message: common.StringOrNil("cannot redeploy workflow; deployment is still in progress"),
+		})
+		return false
+	}
+
+	db := dbconf.DatabaseConnection()
+
+	for _, wsStatus := range status.Worksteps {
+		workstep := wsStatus.workstep
+		if workstep.Status != nil && *workstep.Status == workstepStatusDeployed {
+			continue
+		}
+
+		subject := natsBaselineWorkstepDeployMessageSubject
+		if wsStatus.Failed || workstep.Status == nil || *workstep.Status != workstepStatusPendingDeployment {
+			// reset the workstep so a new prover is created upon deploy
+			result := db.Exec("UPDATE worksteps SET status=?, prover_id=NULL WHERE id=?", workstepStatusDraft, workstep.ID)
+			if err := result.Error; err != nil {
+				w.Errors = append(w.Errors, &provide.Error{
+					Message: common.StringOrNil(fmt.Sprintf("failed to reset workstep: %s; %s", workstep.ID, err.Error())),
+				})
+				return false
+			}
+			common.Log.Debugf("reset workstep %s for redeployment of workflow: %s", workstep.ID, w.ID)
+		} else {
+			subject = natsBaselineWorkstepFinalizeDeployMessageSubject
+		}
+
+		payload, _ := json.Marshal(map[string]interface{}{
+			"organization_id": w.OrganizationID.String(),
+			"workstep_id":     workstep.ID.String(),
+		})
+		_, err := natsutil.NatsJetstreamPublish(subject, payload)
+		if err != nil {
+			common.Log.Warningf("failed to redeploy workstep; failed to publish %s message; %s", subject, err.Error())
+			w.Errors = append(w.Errors, &provide.Error{
+				Message: common.StringOrNil(fmt.Sprintf("failed to redeploy workstep: %s", workstep.ID)),
+			})
+			return false
+		}
+	}
+
+	// restart the stall clock before the workflow deploy message is redelivered
+	updatedAt := time.Now()
+	w.UpdatedAt = &updatedAt
+	result := db.Exec("UPDATE workflows SET updated_at=? WHERE id=?", updatedAt, w.ID)
+	if err := result.Error; err != nil {
+		w.Errors = append(w.Errors, &provide.Error{
+			Message: common.StringOrNil(err.Error()),
+		})
+		return false
+	}
+
+	payload, _ := json.Marshal(map[string]interface{}{
+		"organization_id": w.OrganizationID.String(),
+		"workflow_id":     w.ID.String(),
+	})
+	_, err := natsutil.NatsJetstreamPublish(natsBaselineWorkflowDeployMessageSubject, payload)
+	if err != nil {
+		common.Log.Warningf("failed to redeploy workflow; failed to publish deploy message; %s", err.Error())
+		w.Errors = append(w.Errors, &provide.Error{
+			Message: common.StringOrNil(fmt.Sprintf("failed to redeploy workflow: %s", w.ID)),
+		})
+		return false
+	}
+
+	common.Log.Debugf("republished deploy messages for workflow: %s", w.ID)
+	return true
+}
@@ -0,0 +1,307 @@
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package baseline
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/jinzhu/gorm"
+	uuid "github.com/kthomas/go.uuid"
+	"github.com/provideplatform/baseline/common"
+)
+
+// WorkflowVersionDiff is a structural comparison of two versions of a workflow prototype
+type WorkflowVersionDiff struct {
+	From         *WorkflowVersion  `json:"from"`
+	To           *WorkflowVersion  `json:"to"`
+	Participants *ParticipantsDiff `json:"participants"`
+	Worksteps    *WorkstepsDiff    `json:"worksteps"`
+}
+
+// ParticipantsDiff contains the participant addresses added and removed between two versions
+type ParticipantsDiff struct {
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+}
+
+// WorkstepsDiff contains the worksteps added, removed, reordered and changed between two versions
+type WorkstepsDiff struct {
+	Added     []*WorkstepDiffItem   `json:"added"`
+	Removed   []*WorkstepDiffItem   `json:"removed"`
+	Reordered []*WorkstepReordering `json:"reordered"`
+	Changed   []*WorkstepChange     `json:"changed"`
+}
+
+// WorkstepDiffItem references a workstep which exists in only one of the compared versions
+type WorkstepDiffItem struct {
+	WorkstepID  uuid.UUID `json:"workstep_id"`
+	Name        *string   `json:"name"`
+	Cardinality int       `json:"cardinality"`
+}
+
+// WorkstepReordering references a workstep which exists in both versions at different positions
+type WorkstepReordering struct {
+	Name            *string `json:"name"`
+	FromCardinality int     `json:"from_cardinality"`
+	ToCardinality   int     `json:"to_cardinality"`
+}
+
+// WorkstepChange describes the modifications to a workstep which exists in both versions
+type WorkstepChange struct {
+	Name           *string           `json:"name"`
+	FromWorkstepID uuid.UUID         `json:"from_workstep_id"`
+	ToWorkstepID   uuid.UUID         `json:"to_workstep_id"`
+	Prover         *ValueChange      `json:"prover,omitempty"`
+	Finality       *ValueChange      `json:"require_finality,omitempty"`
+	Participants   *ParticipantsDiff `json:"participants,omitempty"`
+}
+
+// ValueChange contains the previous and current values of a changed attribute
+type ValueChange struct {
+	From interface{} `json:"from"`
+	To   interface{} `json:"to"`
+}
+
+// workstepDiffKey returns the func used to key worksteps when matching them across the given
+// versions; worksteps are copied by name when a workflow is versioned, so the name is used when
+// present, unless it is not unique in either version, in which case worksteps are matched by
+// cardinality
+func workstepDiffKey(from, to []*Workstep) func(*Workstep) string {
+	duplicated := map[string]bool{}
+	for _, worksteps := range [][]*Workstep{from, to} {
+		names := map[string]bool{}
+		for _, workstep := range worksteps {
+			if workstep.Name == nil {
+				continue
+			}
+			if names[*workstep.Name] {
+				duplicated[*workstep.Name] = true
+			}
+			names[*workstep.Name] = true
+		}
+	}
+
+	return func(workstep *Workstep) string {
+		if workstep.Name != nil && !duplicated[*workstep.Name] {
+			return fmt.Sprintf("name:%s", *workstep.Name)
+		}
+		return fmt.Sprintf("cardinality:%d", workstep.Cardinality)
+	}
+}
+
+// workstepProverMetadata returns the prover parameters or prover template reference from the workstep metadata
+func workstepProverMetadata(workstep *Workstep) interface{} {
+	metadata := workstep.ParseMetadata()
+	if metadata == nil {
+		return nil
+	}
+	if prover, proverOk := metadata[workstepMetadataProverKey]; proverOk {
+		return prover
+	}
+	return metadata[workstepMetadataProverTemplateKey]
+}
+
+func diffParticipants(from, to []string) *ParticipantsDiff {
+	diff := &ParticipantsDiff{
+		Added:   make([]string, 0),
+		Removed: make([]string, 0),
+	}
+
+	fromSet := map[string]bool{}
+	for _, p := range from {
+		fromSet[p] = true
+	}
+
+	toSet := map[string]bool{}
+	for _, p := range to {
+		toSet[p] = true
+		if !fromSet[p] {
+			diff.Added = append(diff.Added, p)
+		}
+	}
+
+	for _, p := range from {
+		if !toSet[p] {
+			diff.Removed = append(diff.Removed, p)
+		}
+	}
+
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Removed)
+	return diff
+}
+
+func (p *ParticipantsDiff) empty() bool {
+	return len(p.Added) == 0 && len(p.Removed) == 0
+}
+
+func (w *Workflow) participantAddresses(tx *gorm.DB) []string {
+	addresses := make([]string, 0)
+	for _, p := range w.listParticipants(tx) {
+		if p.Participant != nil {
+			addresses = append(addresses, *p.Participant)
+		}
+	}
+	return addresses
+}
+
+func (w *Workstep) participantAddresses(tx *gorm.DB) []string {
+	addresses := make([]string, 0)
+	for _, p := range w.listParticipants(tx) {
+		if p.Participant != nil {
+			addresses = append(addresses, *p.Participant)
+		}
+	}
+	return addresses
+}
+
+// findVersion resolves the workflow prototype with the given version in the lineage of the workflow
+func (w *Workflow) findVersion(version string, tx *gorm.DB) *WorkflowVersion {
+	for _, v := range w.listVersions(tx) {
+		if v.Version == version {
+			return v
+		} else if cmp, err := common.CompareSemver(v.Version, version); err == nil && cmp == 0 {
+			return v
+		}
+	}
+	return nil
+}
+
+// diffWorkflowVersions structurally compares two workflow prototypes in the same lineage
+func diffWorkflowVersions(from, to *Workflow, tx *gorm.DB) *WorkflowVersionDiff {
+	diff := &WorkflowVersionDiff{
+		From: &WorkflowVersion{
+			WorkflowID: from.ID,
+		},
+		To: &WorkflowVersion{
+			WorkflowID: to.ID,
+		},
+		Participants: diffParticipants(from.participantAddresses(tx), to.participantAddresses(tx)),
+	}
+
+	if initialWorkflowID, version := from.initialWorkflowVersion(tx); initialWorkflowID != nil {
+		diff.From.InitialWorkflowID = *initialWorkflowID
+		diff.From.Version = *version
+	}
+
+	if initialWorkflowID, version := to.initialWorkflowVersion(tx); initialWorkflowID != nil {
+		diff.To.InitialWorkflowID = *initialWorkflowID
+		diff.To.Version = *version
+	}
+
+	diff.Worksteps = diffWorksteps(
+		FindWorkstepsByWorkflowID(from.ID),
+		FindWorkstepsByWorkflowID(to.ID),
+		func(workstep *Workstep) []string {
+			return workstep.participantAddresses(tx)
+		},
+	)
+
+	return diff
+}
+
+// diffWorksteps compares the worksteps of two versions, resolving the participants of each
+// workstep using the given func
+func diffWorksteps(fromWorksteps, toWorksteps []*Workstep, participants func(*Workstep) []string) *WorkstepsDiff {
+	diff := &WorkstepsDiff{
+		Added:     make([]*WorkstepDiffItem, 0),
+		Removed:   make([]*WorkstepDiffItem, 0),
+		Reordered: make([]*WorkstepReordering, 0),
+		Changed:   make([]*WorkstepChange, 0),
+	}
+
+	key := workstepDiffKey(fromWorksteps, toWorksteps)
+
+	fromIndex := map[string]*Workstep{}
+	for _, workstep := range fromWorksteps {
+		fromIndex[key(workstep)] = workstep
+	}
+
+	toIndex := map[string]*Workstep{}
+	for _, workstep := range toWorksteps {
+		toIndex[key(workstep)] = workstep
+	}
+
+	for _, workstep := range fromWorksteps {
+		if _, ok := toIndex[key(workstep)]; !ok {
+			diff.Removed = append(diff.Removed, &WorkstepDiffItem{
+				WorkstepID:  workstep.ID,
+				Name:        workstep.Name,
+				Cardinality: workstep.Cardinality,
+			})
+		}
+	}
+
+	for _, workstep := range toWorksteps {
+		previous, ok := fromIndex[key(workstep)]
+		if !ok {
+			diff.Added = append(diff.Added, &WorkstepDiffItem{
+				WorkstepID:  workstep.ID,
+				Name:        workstep.Name,
+				Cardinality: workstep.Cardinality,
+			})
+			continue
+		}
+
+		if previous.Cardinality != workstep.Cardinality && workstep.Name != nil {
+			diff.Reordered = append(diff.Reordered, &WorkstepReordering{
+				Name:            workstep.Name,
+				FromCardinality: previous.Cardinality,
+				ToCardinality:   workstep.Cardinality,
+			})
+		}
+
+		change := &WorkstepChange{
+			Name:           workstep.Name,
+			FromWorkstepID: previous.ID,
+			ToWorkstepID:   workstep.ID,
+		}
+		changed := false
+
+		previousProver := workstepProverMetadata(previous)
+		prover := workstepProverMetadata(workstep)
+		if !reflect.DeepEqual(previousProver, prover) {
+			change.Prover = &ValueChange{
+				From: previousProver,
+				To:   prover,
+			}
+			changed = true
+		}
+
+		if previous.RequireFinality != workstep.RequireFinality {
+			change.Finality = &ValueChange{
+				From: previous.RequireFinality,
+				To:   workstep.RequireFinality,
+			}
+			changed = true
+		}
+
+		participantsDiff := diffParticipants(participants(previous), participants(workstep))
+		if !participantsDiff.empty() {
+			change.Participants = participantsDiff
+			changed = true
+		}
+
+		if changed {
+			diff.Changed = append(diff.Changed, change)
+		}
+	}
+
+	return diff
+}
@@ -0,0 +1,169 @@
+// +build unit
+
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package baseline
+
+import (
+	"encoding/json"
+
+	uuid "github.com/kthomas/go.uuid"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/provideplatform/baseline/common"
+)
+
+func diffWorkstepFactory(name string, cardinality int, prover string) *Workstep {
+	metadata, _ := json.Marshal(map[string]interface{}{
+		workstepMetadataProverKey: map[string]interface{}{"identifier": prover},
+	})
+	raw := json.RawMessage(metadata)
+
+	workstep := &Workstep{}
+	workstep.ID = uuid.Must(uuid.NewV4())
+	workstep.Cardinality = cardinality
+	workstep.Metadata = &raw
+	if name != "" {
+		workstep.Name = common.StringOrNil(name)
+	}
+	return workstep
+}
+
+var _ = Describe("diffWorkflowVersions", func() {
+	var participants map[uuid.UUID][]string
+
+	participantsOf := func(workstep *Workstep) []string {
+		return participants[workstep.ID]
+	}
+
+	BeforeEach(func() {
+		participants = map[uuid.UUID][]string{}
+	})
+
+	It("should report no differences between identical versions", func() {
+		from := []*Workstep{diffWorkstepFactory("approve", 1, "cubic"), diffWorkstepFactory("invoice", 2, "cubic")}
+		to := []*Workstep{diffWorkstepFactory("approve", 1, "cubic"), diffWorkstepFactory("invoice", 2, "cubic")}
+
+		diff := diffWorksteps(from, to, participantsOf)
+		Expect(diff.Added).To(BeEmpty())
+		Expect(diff.Removed).To(BeEmpty())
+		Expect(diff.Reordered).To(BeEmpty())
+		Expect(diff.Changed).To(BeEmpty())
+	})
+
+	It("should report added and removed worksteps by name", func() {
+		from := []*Workstep{diffWorkstepFactory("approve", 1, "cubic"), diffWorkstepFactory("invoice", 2, "cubic")}
+		to := []*Workstep{diffWorkstepFactory("approve", 1, "cubic"), diffWorkstepFactory("settle", 2, "cubic")}
+
+		diff := diffWorksteps(from, to, participantsOf)
+		Expect(diff.Removed).To(HaveLen(1))
+		Expect(*diff.Removed[0].Name).To(Equal("invoice"))
+		Expect(diff.Removed[0].WorkstepID).To(Equal(from[1].ID))
+		Expect(diff.Added).To(HaveLen(1))
+		Expect(*diff.Added[0].Name).To(Equal("settle"))
+		Expect(diff.Added[0].WorkstepID).To(Equal(to[1].ID))
+	})
+
+	It("should report reordered worksteps", func() {
+		from := []*Workstep{diffWorkstepFactory("approve", 1, "cubic"), diffWorkstepFactory("invoice", 2, "cubic")}
+		to := []*Workstep{diffWorkstepFactory("invoice", 1, "cubic"), diffWorkstepFactory("approve", 2, "cubic")}
+
+		diff := diffWorksteps(from, to, participantsOf)
+		Expect(diff.Added).To(BeEmpty())
+		Expect(diff.Removed).To(BeEmpty())
+		Expect(diff.Reordered).To(ConsistOf(
+			&WorkstepReordering{Name: common.StringOrNil("invoice"), FromCardinality: 2, ToCardinality: 1},
+			&WorkstepReordering{Name: common.StringOrNil("approve"), FromCardinality: 1, ToCardinality: 2},
+		))
+	})
+
+	It("should report changes to the prover, finality and participants of a workstep", func() {
+		from := []*Workstep{diffWorkstepFactory("approve", 1, "cubic")}
+		to := []*Workstep{diffWorkstepFactory("approve", 1, "purchase_order")}
+		to[0].RequireFinality = true
+		participants[from[0].ID] = []string{"0xA"}
+		participants[to[0].ID] = []string{"0xA", "0xB"}
+
+		diff := diffWorksteps(from, to, participantsOf)
+		Expect(diff.Changed).To(HaveLen(1))
+
+		change := diff.Changed[0]
+		Expect(change.FromWorkstepID).To(Equal(from[0].ID))
+		Expect(change.ToWorkstepID).To(Equal(to[0].ID))
+		Expect(change.Prover).To(Equal(&ValueChange{
+			From: map[string]interface{}{"identifier": "cubic"},
+			To:   map[string]interface{}{"identifier": "purchase_order"},
+		}))
+		Expect(change.Finality).To(Equal(&ValueChange{From: false, To: true}))
+		Expect(change.Participants).To(Equal(&ParticipantsDiff{Added: []string{"0xB"}, Removed: []string{}}))
+	})
+
+	It("should match unnamed worksteps by cardinality", func() {
+		from := []*Workstep{diffWorkstepFactory("", 1, "cubic"), diffWorkstepFactory("", 2, "cubic")}
+		to := []*Workstep{diffWorkstepFactory("", 1, "cubic")}
+
+		diff := diffWorksteps(from, to, participantsOf)
+		Expect(diff.Removed).To(HaveLen(1))
+		Expect(diff.Removed[0].WorkstepID).To(Equal(from[1].ID))
+		Expect(diff.Added).To(BeEmpty())
+	})
+
+	It("should match worksteps having duplicate names by cardinality", func() {
+		from := []*Workstep{
+			diffWorkstepFactory("approve", 1, "cubic"),
+			diffWorkstepFactory("approve", 2, "purchase_order"),
+		}
+		to := []*Workstep{
+			diffWorkstepFactory("approve", 1, "cubic"),
+			diffWorkstepFactory("approve", 2, "invoice"),
+			diffWorkstepFactory("approve", 3, "cubic"),
+		}
+
+		diff := diffWorksteps(from, to, participantsOf)
+		Expect(diff.Removed).To(BeEmpty())
+		Expect(diff.Reordered).To(BeEmpty())
+		Expect(diff.Added).To(HaveLen(1))
+		Expect(diff.Added[0].WorkstepID).To(Equal(to[2].ID))
+		Expect(diff.Changed).To(HaveLen(1))
+		Expect(diff.Changed[0].FromWorkstepID).To(Equal(from[1].ID))
+		Expect(diff.Changed[0].ToWorkstepID).To(Equal(to[1].ID))
+	})
+
+	It("should match by cardinality when a name is duplicated in only one version", func() {
+		from := []*Workstep{diffWorkstepFactory("approve", 1, "cubic")}
+		to := []*Workstep{diffWorkstepFactory("approve", 1, "cubic"), diffWorkstepFactory("approve", 2, "cubic")}
+
+		diff := diffWorksteps(from, to, participantsOf)
+		Expect(diff.Removed).To(BeEmpty())
+		Expect(diff.Changed).To(BeEmpty())
+		Expect(diff.Added).To(HaveLen(1))
+		Expect(diff.Added[0].WorkstepID).To(Equal(to[1].ID))
+	})
+})
+
+var _ = Describe("diffParticipants", func() {
+	It("should report the sorted addresses added and removed", func() {
+		diff := diffParticipants([]string{"0xC", "0xA"}, []string{"0xB", "0xA", "0xD"})
+		Expect(diff.Added).To(Equal([]string{"0xB", "0xD"}))
+		Expect(diff.Removed).To(Equal([]string{"0xC"}))
+		Expect(diff.empty()).To(BeFalse())
+	})
+
+	It("should be empty when the participants are unchanged", func() {
+		Expect(diffParticipants([]string{"0xA"}, []string{"0xA"}).empty()).To(BeTrue())
+	})
+})
@@ -0,0 +1,164 @@
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package baseline
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/provideplatform/baseline/common"
+	"github.com/provideplatform/baseline/metrics"
+	provide "github.com/provideplatform/provide-go/api"
+	"github.com/provideplatform/provide-go/api/privacy"
+	"github.com/provideplatform/provide-go/api/vault"
+)
+
+const workstepExecutionStatusPending = "pending"
+const workstepExecutionStatusVerified = "verified"
+const workstepExecutionStatusUnverified = "unverified"
+const workstepExecutionStatusFailed = "failed"
+
+// WorkstepExecution is the execution of a workstep instance by a single participant
+type WorkstepExecution struct {
+	Participant        *string          `json:"address"`
+	Executed           bool             `json:"executed"`
+	Proof              *string          `json:"proof"`
+	VerificationStatus *string          `json:"verification_status"`
+	Witness            interface{}      `json:"witness,omitempty"`
+	WitnessedAt        *time.Time       `json:"witnessed_at"`
+	Errors             []*provide.Error `json:"errors,omitempty"`
+
+	witnessSecretID *string
+}
+
+// listExecutions returns the execution history of the workstep instance, ordered by the time
+// each participant witnessed it; participants which have yet to execute are listed last
+func (w *Workstep) listExecutions(tx *gorm.DB) []*WorkstepExecution {
+	executions := make([]*WorkstepExecution, 0)
+	pending := make([]*WorkstepExecution, 0)
+
+	participants := w.listParticipants(tx)
+	for _, p := range participants {
+		execution := &WorkstepExecution{
+			Participant: p.Participant,
+			Executed:    p.Proof != nil,
+			Proof:       p.Proof,
+			WitnessedAt: p.WitnessedAt,
+		}
+		if p.WitnessSecretID != nil {
+			execution.witnessSecretID = common.StringOrNil(p.WitnessSecretID.String())
+		}
+
+		if execution.Executed {
+			execution.VerificationStatus = common.StringOrNil(workstepExecutionStatusUnverified)
+			executions = append(executions, execution)
+		} else {
+			execution.VerificationStatus = common.StringOrNil(workstepExecutionStatusPending)
+			pending = append(pending, execution)
+		}
+	}
+
+	sort.SliceStable(executions, func(i, j int) bool {
+		return executions[i].WitnessedAt != nil && (executions[j].WitnessedAt == nil || executions[i].WitnessedAt.Before(*executions[j].WitnessedAt))
+	})
+
+	return append(executions, pending...)
+}
+
+// resolveExecutionHistory enriches the execution history of the workstep instance with the
+// verification status of each proof; the witness of the executing participant of the given
+// subject account is decrypted from its vault and included only when requested; proofs whose
+// witness cannot be resolved are left unverified
+func (w *Workstep) resolveExecutionHistory(token string, subjectAccount *SubjectAccount, includeWitness bool, tx *gorm.DB) []*WorkstepExecution {
+	executions := w.listExecutions(tx)
+
+	var address *string
+	if subjectAccount != nil && subjectAccount.Metadata != nil {
+		address = subjectAccount.Metadata.OrganizationAddress
+	}
+
+	for _, execution := range executions {
+		if !execution.Executed {
+			continue
+		}
+
+		var witness interface{}
+		owned := address != nil && execution.Participant != nil && strings.EqualFold(*execution.Participant, *address)
+		if owned && execution.witnessSecretID != nil {
+			var err error
+			witness, err = fetchWorkstepExecutionWitness(token, subjectAccount, *execution.witnessSecretID)
+			if err != nil {
+				execution.Errors = append(execution.Errors, &provide.Error{
+					Message: common.StringOrNil(err.Error()),
+				})
+			} else if includeWitness {
+				execution.Witness = witness
+			}
+		}
+
+		if w.ProverID == nil || witness == nil {
+			continue
+		}
+
+		startedAt := time.Now()
+		resp, err := privacy.Verify(token, w.ProverID.String(), map[string]interface{}{
+			"proof":   execution.Proof,
+			"witness": witness,
+		})
+		metrics.ObserveVerify(startedAt, err)
+		if err != nil {
+			common.Log.Debugf("failed to verify execution of workstep %s by participant %s; %s", w.ID, *execution.Participant, err.Error())
+			continue
+		}
+
+		if resp.Result {
+			execution.VerificationStatus = common.StringOrNil(workstepExecutionStatusVerified)
+		} else {
+			execution.VerificationStatus = common.StringOrNil(workstepExecutionStatusFailed)
+		}
+	}
+
+	return executions
+}
+
+// fetchWorkstepExecutionWitness decrypts the witness stored by setParticipantExecutionPayload
+func fetchWorkstepExecutionWitness(token string, subjectAccount *SubjectAccount, secretID string) (interface{}, error) {
+	if subjectAccount.VaultID == nil {
+		return nil, fmt.Errorf("failed to fetch workstep execution witness; subject account vault not resolved")
+	}
+
+	secret, err := vault.FetchSecret(token, subjectAccount.VaultID.String(), secretID, map[string]interface{}{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch workstep execution witness; %s", err.Error())
+	}
+
+	if secret.Value == nil {
+		return nil, nil
+	}
+
+	var witness interface{}
+	err = json.Unmarshal([]byte(*secret.Value), &witness)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal workstep execution witness; %s", err.Error())
+	}
+
+	return witness, nil
+}
@@ -23,6 +23,7 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/dgrijalva/jwt-go"
 	"github.com/ethereum/go-ethereum/crypto"
@@ -76,6 +77,25 @@ func InstallMappingsAPI(r *gin.Engine) {
 	r.DELETE("/api/v1/mappings/:id", deleteMappingHandler)
 }
 
+// InstallProverTemplatesAPI installs prover template management APIs
+func InstallProverTemplatesAPI(r *gin.Engine) {
+	r.GET("/api/v1/prover_templates", listProverTemplatesHandler)
+	r.POST("/api/v1/prover_templates", createProverTemplateHandler)
+	r.GET("/api/v1/prover_templates/:id", proverTemplateDetailsHandler)
+	r.PUT("/api/v1/prover_templates/:id", updateProverTemplateHandler)
+	r.DELETE("/api/v1/prover_templates/:id", deleteProverTemplateHandler)
+}
+
+// InstallWebhooksAPI installs webhook subscription management APIs
+func InstallWebhooksAPI(r *gin.Engine) {
+	r.GET("/api/v1/webhooks", listWebhooksHandler)
+	r.POST("/api/v1/webhooks", createWebhookHandler)
+	r.GET("/api/v1/webhooks/:id", webhookDetailsHandler)
+	r.PUT("/api/v1/webhooks/:id", updateWebhookHandler)
+	r.DELETE("/api/v1/webhooks/:id", deleteWebhookHandler)
+	r.GET("/api/v1/webhooks/:id/deliveries", listWebhookDeliveriesHandler)
+}
+
 // InstallPublicWorkgroupAPI installs an API servicing a configured public workgroup
 func InstallPublicWorkgroupAPI(r *gin.Engine) {
 	r.POST("/api/v1/pub/invite", createPublicWorkgroupInviteHandler)
@@ -99,6 +119,10 @@ func InstallWorkgroupsAPI(r *gin.Engine) {
 	r.GET("/api/v1/workgroups/:id/analytics", workgroupAnalyticsHandler)
 	r.POST("/api/v1/workgroups", createWorkgroupHandler)
 	r.PUT("/api/v1/workgroups/:id", updateWorkgroupHandler)
+	r.GET("/api/v1/workgroups/:id/participants", listWorkgroupParticipantsHandler)
+	r.POST("/api/v1/workgroups/:id/participants", createWorkgroupParticipantHandler)
+	r.PUT("/api/v1/workgroups/:id/participants/:participantId", updateWorkgroupParticipantHandler)
+	r.DELETE("/api/v1/workgroups/:id/participants/:participantId", deleteWorkgroupParticipantHandler)
 }
 
 // InstallWorkflowsAPI installs workflow management APIs
@@ -106,11 +130,22 @@ func InstallWorkflowsAPI(r *gin.Engine) {
 	r.GET("/api/v1/workflows", listWorkflowsHandler)
 	r.GET("/api/v1/workflows/:id", workflowDetailsHandler)
 	r.POST("/api/v1/workflows", createWorkflowHandler)
+	r.POST("/api/v1/workflows/import", importWorkflowHandler)
+	r.GET("/api/v1/workflows/:id/export", exportWorkflowHandler)
 	r.PUT("/api/v1/workflows/:id", updateWorkflowHandler)
 	r.POST("/api/v1/workflows/:id/deploy", deployWorkflowHandler)
+	r.GET("/api/v1/workflows/:id/deployment", workflowDeploymentStatusHandler)
+	r.POST("/api/v1/workflows/:id/redeploy", redeployWorkflowHandler)
+	r.POST("/api/v1/workflows/:id/simulate", simulateWorkflowHandler)
+	r.POST("/api/v1/workflows/:id/migrate", migrateWorkflowHandler)
 	r.GET("/api/v1/workflows/:id/versions", listWorkflowVersionsHandler)
+	r.GET("/api/v1/workflows/:id/versions/latest", latestWorkflowVersionHandler)
+	r.GET("/api/v1/workflows/:id/versions/diff", diffWorkflowVersionsHandler)
 	r.POST("/api/v1/workflows/:id/versions", versionWorkflowHandler)
 	r.DELETE("/api/v1/workflows/:id", deleteWorkflowHandler)
+	r.GET("/api/v1/workflows/:id/participants", listWorkflowParticipantsHandler)
+	r.POST("/api/v1/workflows/:id/participants", createWorkflowParticipantHandler)
+	r.DELETE("/api/v1/workflows/:id/participants/:participantId", deleteWorkflowParticipantHandler)
 }
 
 // InstallWorkstepsAPI installs workstep management APIs
@@ -122,6 +157,7 @@ func InstallWorkstepsAPI(r *gin.Engine) {
 	r.PUT("/api/v1/workflows/:id/worksteps/:workstepId", updateWorkstepHandler)
 	r.DELETE("/api/v1/workflows/:id/worksteps/:workstepId", deleteWorkstepHandler)
 	r.POST("/api/v1/workflows/:id/worksteps/:workstepId/execute", executeWorkstepHandler)
+	r.GET("/api/v1/workflows/:id/worksteps/:workstepId/executions", listWorkstepExecutionsHandler)
 	r.GET("/api/v1/workflows/:id/worksteps/:workstepId/participants", listWorkstepParticipantsHandler)
 	r.POST("/api/v1/workflows/:id/worksteps/:workstepId/participants", createWorkstepParticipantHandler)
 	r.DELETE("/api/v1/workflows/:id/worksteps/:workstepId/participants/:participantId", deleteWorkstepParticipantHandler)
@@ -154,35 +190,9 @@ func sendProtocolMessageHandler(c *gin.Context) {
 			return
 		}
 
-		workstep, err := record.resolveExecutableWorkstepContext()
-		if err != nil {
-			provide.RenderError(err.Error(), 422, c)
-			return
-		}
-
-		workflow := FindWorkflowByID(*workstep.WorkflowID)
-		if workflow == nil {
-			provide.RenderError("workflow not resolved", 500, c)
-			return
-		}
-
-		subjectAccountID := subjectAccountIDFactory(organizationID.String(), workflow.WorkgroupID.String())
-		message.subjectAccount, err = resolveSubjectAccount(subjectAccountID)
+		status, err := message.authorizeSender(organizationID.String(), record)
 		if err != nil {
-			provide.RenderError("failed to resolve BPI subject account", 403, c)
-			return
-		}
-
-		authorizedSender := false
-		for _, participant := range workstep.Participants {
-			if participant.Address != nil && *participant.Address == *message.subjectAccount.Metadata.OrganizationAddress {
-				authorizedSender = true
-				break
-			}
-		}
-
-		if !authorizedSender {
-			provide.RenderError("forbidden", 403, c)
+			provide.RenderError(err.Error(), status, c)
 			return
 		}
 	}
@@ -190,8 +200,9 @@ func sendProtocolMessageHandler(c *gin.Context) {
 	// HACK!!
 	token, _ := util.ParseBearerAuthorizationHeader(c, nil)
 	message.token = common.StringOrNil(token.Raw)
+	message.ctx = c.Request.Context()
 
-	if message.baselineOutbound() {
+	if message.send() {
 		message.ProtocolMessage.Payload.Object = nil
 		provide.Render(message.ProtocolMessage, 202, c)
 	} else {
@@ -550,6 +561,7 @@ func acceptWorkgroupInvite(c *gin.Context, organizationID uuid.UUID, params map[
 		},
 		nil,
 		nil,
+		nil,
 	}
 	payload, _ := json.Marshal(msg)
 
@@ -698,7 +710,30 @@ func workgroupAnalyticsHandler(c *gin.Context) {
 		return
 	}
 
-	analytics, err := workgroup.queryAnalytics()
+	var startAt, endAt *time.Time
+	if c.Query("start_at") != "" {
+		_startAt, err := time.Parse(time.RFC3339, c.Query("start_at"))
+		if err != nil {
+			provide.RenderError(fmt.Sprintf("invalid start_at; %s", err.Error()), 422, c)
+			return
+		}
+		startAt = &_startAt
+	}
+	if c.Query("end_at") != "" {
+		_endAt, err := time.Parse(time.RFC3339, c.Query("end_at"))
+		if err != nil {
+			provide.RenderError(fmt.Sprintf("invalid end_at; %s", err.Error()), 422, c)
+			return
+		}
+		endAt = &_endAt
+	}
+
+	if _, _, err := analyticsRangeFactory(startAt, endAt); err != nil {
+		provide.RenderError(err.Error(), 422, c)
+		return
+	}
+
+	analytics, err := workgroup.queryAnalytics(token.Raw, startAt, endAt)
 	if err != nil {
 		provide.RenderError(err.Error(), 500, c)
 		return
@@ -707,6 +742,202 @@ func workgroupAnalyticsHandler(c *gin.Context) {
 	provide.Render(analytics, 200, c)
 }
 
+func listWorkgroupParticipantsHandler(c *gin.Context) {
+	organizationID := util.AuthorizedSubjectID(c, "organization")
+	if organizationID == nil {
+		provide.RenderError("unauthorized", 401, c)
+		return
+	}
+
+	workgroupID, err := uuid.FromString(c.Param("id"))
+	if err != nil {
+		provide.RenderError(err.Error(), 422, c)
+		return
+	}
+
+	workgroup := FindWorkgroupByID(workgroupID)
+	if workgroup == nil {
+		provide.RenderError("not found", 404, c)
+		return
+	}
+
+	if !workgroup.isAuthorizedOrganization(*organizationID, dbconf.DatabaseConnection()) {
+		provide.RenderError("forbidden", 403, c)
+		return
+	}
+
+	db := dbconf.DatabaseConnection()
+	participants := workgroup.listParticipants(db)
+	provide.Render(participants, 200, c)
+}
+
+func createWorkgroupParticipantHandler(c *gin.Context) {
+	organizationID := util.AuthorizedSubjectID(c, "organization")
+	if organizationID == nil {
+		provide.RenderError("unauthorized", 401, c)
+		return
+	}
+
+	var participant *WorkgroupParticipant
+
+	buf, err := c.GetRawData()
+	if err != nil {
+		provide.RenderError(err.Error(), 400, c)
+		return
+	}
+
+	err = json.Unmarshal(buf, &participant)
+	if err != nil {
+		msg := fmt.Sprintf("failed to umarshal participant; %s", err.Error())
+		common.Log.Warning(msg)
+		provide.RenderError(msg, 422, c)
+		return
+	}
+
+	workgroupID, err := uuid.FromString(c.Param("id"))
+	if err != nil {
+		provide.RenderError(err.Error(), 422, c)
+		return
+	}
+
+	workgroup := FindWorkgroupByID(workgroupID)
+	if workgroup == nil {
+		provide.RenderError("not found", 404, c)
+		return
+	}
+
+	if !workgroup.isOwner(*organizationID) {
+		provide.RenderError("forbidden", 403, c)
+		return
+	}
+
+	if participant.Participant == nil {
+		provide.RenderError("address required", 422, c)
+		return
+	}
+
+	db := dbconf.DatabaseConnection()
+	if workgroup.findParticipant(*participant.Participant, db) != nil {
+		provide.RenderError(fmt.Sprintf("participant %s is already a member of the workgroup", *participant.Participant), 409, c)
+		return
+	}
+
+	if workgroup.addParticipant(*participant.Participant, db) {
+		provide.Render(nil, 204, c)
+	} else if len(workgroup.Errors) > 0 {
+		obj := map[string]interface{}{}
+		obj["errors"] = workgroup.Errors
+		provide.Render(obj, 422, c)
+	} else {
+		provide.RenderError("internal persistence error", 500, c)
+	}
+}
+
+func updateWorkgroupParticipantHandler(c *gin.Context) {
+	organizationID := util.AuthorizedSubjectID(c, "organization")
+	if organizationID == nil {
+		provide.RenderError("unauthorized", 401, c)
+		return
+	}
+
+	buf, err := c.GetRawData()
+	if err != nil {
+		provide.RenderError(err.Error(), 400, c)
+		return
+	}
+
+	var params map[string]interface{}
+	err = json.Unmarshal(buf, &params)
+	if err != nil {
+		provide.RenderError(err.Error(), 422, c)
+		return
+	}
+
+	address := c.Param("participantId")
+
+	workgroupID, err := uuid.FromString(c.Param("id"))
+	if err != nil {
+		provide.RenderError(err.Error(), 422, c)
+		return
+	}
+
+	workgroup := FindWorkgroupByID(workgroupID)
+	if workgroup == nil {
+		provide.RenderError("not found", 404, c)
+		return
+	}
+
+	if !workgroup.isOwner(*organizationID) {
+		provide.RenderError("forbidden", 403, c)
+		return
+	}
+
+	status, statusOk := params["status"].(string)
+	if !statusOk {
+		provide.RenderError("status is required", 422, c)
+		return
+	}
+
+	db := dbconf.DatabaseConnection()
+	tx := db.Begin()
+	defer tx.RollbackUnlessCommitted()
+
+	if workgroup.setParticipantStatus(address, status, tx) {
+		tx.Commit()
+		go workgroup.notifyParticipants(*organizationID, address, status)
+		provide.Render(nil, 204, c)
+	} else if len(workgroup.Errors) > 0 {
+		obj := map[string]interface{}{}
+		obj["errors"] = workgroup.Errors
+		provide.Render(obj, 422, c)
+	} else {
+		provide.RenderError("internal persistence error", 500, c)
+	}
+}
+
+func deleteWorkgroupParticipantHandler(c *gin.Context) {
+	organizationID := util.AuthorizedSubjectID(c, "organization")
+	if organizationID == nil {
+		provide.RenderError("unauthorized", 401, c)
+		return
+	}
+
+	address := c.Param("participantId")
+
+	workgroupID, err := uuid.FromString(c.Param("id"))
+	if err != nil {
+		provide.RenderError(err.Error(), 422, c)
+		return
+	}
+
+	workgroup := FindWorkgroupByID(workgroupID)
+	if workgroup == nil {
+		provide.RenderError("not found", 404, c)
+		return
+	}
+
+	if !workgroup.isOwner(*organizationID) {
+		provide.RenderError("forbidden", 403, c)
+		return
+	}
+
+	db := dbconf.DatabaseConnection()
+	tx := db.Begin()
+	defer tx.RollbackUnlessCommitted()
+
+	if workgroup.deleteParticipant(address, tx) {
+		tx.Commit()
+		go workgroup.notifyParticipants(*organizationID, address, workgroupParticipantStatusRemoved)
+		provide.Render(nil, 204, c)
+	} else if len(workgroup.Errors) > 0 {
+		obj := map[string]interface{}{}
+		obj["errors"] = workgroup.Errors
+		provide.Render(obj, 422, c)
+	} else {
+		provide.RenderError("internal persistence error", 500, c)
+	}
+}
+
 func createPublicWorkgroupInviteHandler(c *gin.Context) {
 	if common.BaselinePublicWorkgroupID == nil {
 		provide.RenderError("no public workgroup configured", 501, c)
@@ -775,6 +1006,27 @@ func systemReachabilityHandler(c *gin.Context) {
 	switch *system.Type {
 	case "sap":
 		sor := middleware.SAPFactory(&system)
+		if sor == nil {
+			msg := "system healthcheck failed; invalid sap system configuration"
+			provide.RenderError(msg, 422, c)
+			return
+		}
+
+		if err := sor.HealthCheck(); err != nil {
+			msg := fmt.Sprintf("system healthcheck failed; %s", err.Error())
+			provide.RenderError(msg, 422, c)
+			return
+		}
+
+		provide.Render(nil, 204, c)
+	case "qbs":
+		sor := middleware.QBSFactory(&system)
+		if sor == nil {
+			msg := "system healthcheck failed; invalid qbs system configuration"
+			provide.RenderError(msg, 422, c)
+			return
+		}
+
 		if err := sor.HealthCheck(); err != nil {
 			msg := fmt.Sprintf("system healthcheck failed; %s", err.Error())
 			provide.RenderError(msg, 422, c)
@@ -850,7 +1102,7 @@ func createMappingHandler(c *gin.Context) {
 
 	mapping.OrganizationID = organizationID
 
-	if mapping.Create() {
+	if mapping.Create(nil) {
 		provide.Render(mapping, 201, c)
 	} else if len(mapping.Errors) > 0 {
 		obj := map[string]interface{}{}
@@ -944,39 +1196,425 @@ func deleteMappingHandler(c *gin.Context) {
 	}
 }
 
-func listSchemasHandler(c *gin.Context) {
+func listProverTemplatesHandler(c *gin.Context) {
 	organizationID := util.AuthorizedSubjectID(c, "organization")
 	if organizationID == nil {
 		provide.RenderError("unauthorized", 401, c)
 		return
 	}
 
-	var systems []*middleware.System
-	var err error
-
-	useEphemeralSystem := c.Query("system_secret_ids") != ""
-
-	if !useEphemeralSystem {
-		subjectAccountID := subjectAccountIDFactory(organizationID.String(), c.Param("id"))
-		subjectAccount, err := resolveSubjectAccount(subjectAccountID)
-		if err != nil {
-			provide.RenderError(err.Error(), 403, c)
-			return
-		}
+	var templates []*ProverTemplate
 
-		systems, err = subjectAccount.listSystems()
+	db := dbconf.DatabaseConnection()
+	var query *gorm.DB
+	if c.Query("workgroup_id") != "" {
+		workgroupID, err := uuid.FromString(c.Query("workgroup_id"))
 		if err != nil {
-			provide.RenderError("failed to list systems for subject account", 403, c)
+			provide.RenderError(err.Error(), 422, c)
 			return
 		}
+		query = db.Where("organization_id = ? AND workgroup_id = ?", organizationID, workgroupID)
 	} else {
-		if c.Query("vault_id") == "" {
-			provide.RenderError("vault_id required for querying ephemerally-referenced system secrets", 422, c)
-			return
-		}
+		query = db.Where("organization_id = ?", organizationID)
+	}
 
-		token, _ := util.ParseBearerAuthorizationHeader(c, nil)
-		systemSecretIDs := strings.Split(c.Query("system_secret_ids"), ",")
+	if c.Query("name") != "" {
+		query = query.Where("name = ?", c.Query("name"))
+	}
+
+	if c.Query("version") != "" {
+		query = query.Where("version = ?", c.Query("version"))
+	}
+
+	query = query.Order("name ASC, created_at DESC")
+	provide.Paginate(c, query, &ProverTemplate{}).Find(&templates)
+	provide.Render(templates, 200, c)
+}
+
+func createProverTemplateHandler(c *gin.Context) {
+	organizationID := util.AuthorizedSubjectID(c, "organization")
+	if organizationID == nil {
+		provide.RenderError("unauthorized", 401, c)
+		return
+	}
+
+	buf, err := c.GetRawData()
+	if err != nil {
+		provide.RenderError(err.Error(), 400, c)
+		return
+	}
+
+	var template *ProverTemplate
+	err = json.Unmarshal(buf, &template)
+	if err != nil {
+		provide.RenderError(err.Error(), 422, c)
+		return
+	}
+
+	template.OrganizationID = organizationID
+
+	if template.WorkgroupID != nil {
+		workgroup := FindWorkgroupByID(*template.WorkgroupID)
+		if workgroup == nil {
+			provide.RenderError("workgroup not found", 404, c)
+			return
+		}
+
+		if !workgroup.isAuthorizedOrganization(*organizationID, dbconf.DatabaseConnection()) {
+			provide.RenderError("forbidden", 403, c)
+			return
+		}
+	}
+
+	if template.Create() {
+		provide.Render(template, 201, c)
+	} else if len(template.Errors) > 0 {
+		obj := map[string]interface{}{}
+		obj["errors"] = template.Errors
+		provide.Render(obj, 422, c)
+	} else {
+		provide.RenderError("internal persistence error", 500, c)
+	}
+}
+
+func proverTemplateDetailsHandler(c *gin.Context) {
+	organizationID := util.AuthorizedSubjectID(c, "organization")
+	if organizationID == nil {
+		provide.RenderError("unauthorized", 401, c)
+		return
+	}
+
+	templateID, err := uuid.FromString(c.Param("id"))
+	if err != nil {
+		provide.RenderError(err.Error(), 422, c)
+		return
+	}
+
+	template := FindProverTemplateByID(templateID)
+	if template == nil || template.OrganizationID == nil || template.OrganizationID.String() != organizationID.String() {
+		provide.RenderError("prover template not found", 404, c)
+		return
+	}
+
+	provide.Render(template, 200, c)
+}
+
+func updateProverTemplateHandler(c *gin.Context) {
+	organizationID := util.AuthorizedSubjectID(c, "organization")
+	if organizationID == nil {
+		provide.RenderError("unauthorized", 401, c)
+		return
+	}
+
+	buf, err := c.GetRawData()
+	if err != nil {
+		provide.RenderError(err.Error(), 400, c)
+		return
+	}
+
+	templateID, err := uuid.FromString(c.Param("id"))
+	if err != nil {
+		provide.RenderError(err.Error(), 422, c)
+		return
+	}
+
+	template := FindProverTemplateByID(templateID)
+	if template == nil || template.OrganizationID == nil || template.OrganizationID.String() != organizationID.String() {
+		provide.RenderError("not found", 404, c)
+		return
+	}
+
+	_template := &ProverTemplate{}
+	err = json.Unmarshal(buf, _template)
+	if err != nil {
+		provide.RenderError(err.Error(), 422, c)
+		return
+	}
+
+	if _template.ID != uuid.Nil && template.ID != _template.ID {
+		provide.RenderError("cannot modify prover template id", 400, c)
+		return
+	}
+
+	if template.Update(_template) {
+		provide.Render(nil, 204, c)
+	} else if len(template.Errors) > 0 {
+		obj := map[string]interface{}{}
+		obj["errors"] = template.Errors
+		provide.Render(obj, 422, c)
+	} else {
+		provide.RenderError("internal persistence error", 500, c)
+	}
+}
+
+func deleteProverTemplateHandler(c *gin.Context) {
+	organizationID := util.AuthorizedSubjectID(c, "organization")
+	if organizationID == nil {
+		provide.RenderError("unauthorized", 401, c)
+		return
+	}
+
+	templateID, err := uuid.FromString(c.Param("id"))
+	if err != nil {
+		provide.RenderError(err.Error(), 422, c)
+		return
+	}
+
+	template := FindProverTemplateByID(templateID)
+	if template == nil || template.OrganizationID == nil || template.OrganizationID.String() != organizationID.String() {
+		provide.RenderError("not found", 404, c)
+		return
+	}
+
+	if template.Delete() {
+		provide.Render(nil, 204, c)
+	} else if len(template.Errors) > 0 {
+		obj := map[string]interface{}{}
+		obj["errors"] = template.Errors
+		provide.Render(obj, 422, c)
+	} else {
+		provide.RenderError("internal persistence error", 500, c)
+	}
+}
+
+func listWebhooksHandler(c *gin.Context) {
+	organizationID := util.AuthorizedSubjectID(c, "organization")
+	if organizationID == nil {
+		provide.RenderError("unauthorized", 401, c)
+		return
+	}
+
+	var webhooks []*Webhook
+
+	db := dbconf.DatabaseConnection()
+	query := db.Where("organization_id = ?", organizationID)
+	if c.Query("workgroup_id") != "" {
+		workgroupID, err := uuid.FromString(c.Query("workgroup_id"))
+		if err != nil {
+			provide.RenderError(err.Error(), 422, c)
+			return
+		}
+		query = query.Where("workgroup_id = ?", workgroupID)
+	}
+
+	query = query.Order("created_at DESC")
+	provide.Paginate(c, query, &Webhook{}).Find(&webhooks)
+
+	for _, webhook := range webhooks {
+		webhook.Secret = nil
+	}
+
+	provide.Render(webhooks, 200, c)
+}
+
+func createWebhookHandler(c *gin.Context) {
+	organizationID := util.AuthorizedSubjectID(c, "organization")
+	if organizationID == nil {
+		provide.RenderError("unauthorized", 401, c)
+		return
+	}
+
+	buf, err := c.GetRawData()
+	if err != nil {
+		provide.RenderError(err.Error(), 400, c)
+		return
+	}
+
+	var webhook *Webhook
+	err = json.Unmarshal(buf, &webhook)
+	if err != nil {
+		provide.RenderError(err.Error(), 422, c)
+		return
+	}
+
+	webhook.OrganizationID = organizationID
+
+	// the secret is only ever rendered in the response to its creation
+	if webhook.Create() {
+		provide.Render(webhook, 201, c)
+	} else if len(webhook.Errors) > 0 {
+		obj := map[string]interface{}{}
+		obj["errors"] = webhook.Errors
+		provide.Render(obj, 422, c)
+	} else {
+		provide.RenderError("internal persistence error", 500, c)
+	}
+}
+
+func webhookDetailsHandler(c *gin.Context) {
+	organizationID := util.AuthorizedSubjectID(c, "organization")
+	if organizationID == nil {
+		provide.RenderError("unauthorized", 401, c)
+		return
+	}
+
+	webhookID, err := uuid.FromString(c.Param("id"))
+	if err != nil {
+		provide.RenderError(err.Error(), 422, c)
+		return
+	}
+
+	webhook := FindWebhookByID(webhookID)
+	if webhook == nil || webhook.OrganizationID == nil || webhook.OrganizationID.String() != organizationID.String() {
+		provide.RenderError("webhook not found", 404, c)
+		return
+	}
+
+	webhook.Secret = nil
+	provide.Render(webhook, 200, c)
+}
+
+func updateWebhookHandler(c *gin.Context) {
+	organizationID := util.AuthorizedSubjectID(c, "organization")
+	if organizationID == nil {
+		provide.RenderError("unauthorized", 401, c)
+		return
+	}
+
+	buf, err := c.GetRawData()
+	if err != nil {
+		provide.RenderError(err.Error(), 400, c)
+		return
+	}
+
+	webhookID, err := uuid.FromString(c.Param("id"))
+	if err != nil {
+		provide.RenderError(err.Error(), 422, c)
+		return
+	}
+
+	webhook := FindWebhookByID(webhookID)
+	if webhook == nil || webhook.OrganizationID == nil || webhook.OrganizationID.String() != organizationID.String() {
+		provide.RenderError("not found", 404, c)
+		return
+	}
+
+	_webhook := &Webhook{}
+	err = json.Unmarshal(buf, _webhook)
+	if err != nil {
+		provide.RenderError(err.Error(), 422, c)
+		return
+	}
+
+	if _webhook.ID != uuid.Nil && webhook.ID != _webhook.ID {
+		provide.RenderError("cannot modify webhook id", 400, c)
+		return
+	}
+
+	if _webhook.WorkgroupID != nil && (webhook.WorkgroupID == nil || webhook.WorkgroupID.String() != _webhook.WorkgroupID.String()) {
+		provide.RenderError("cannot modify webhook workgroup id", 400, c)
+		return
+	}
+
+	if webhook.Update(_webhook) {
+		provide.Render(nil, 204, c)
+	} else if len(webhook.Errors) > 0 {
+		obj := map[string]interface{}{}
+		obj["errors"] = webhook.Errors
+		provide.Render(obj, 422, c)
+	} else {
+		provide.RenderError("internal persistence error", 500, c)
+	}
+}
+
+func deleteWebhookHandler(c *gin.Context) {
+	organizationID := util.AuthorizedSubjectID(c, "organization")
+	if organizationID == nil {
+		provide.RenderError("unauthorized", 401, c)
+		return
+	}
+
+	webhookID, err := uuid.FromString(c.Param("id"))
+	if err != nil {
+		provide.RenderError(err.Error(), 422, c)
+		return
+	}
+
+	webhook := FindWebhookByID(webhookID)
+	if webhook == nil || webhook.OrganizationID == nil || webhook.OrganizationID.String() != organizationID.String() {
+		provide.RenderError("not found", 404, c)
+		return
+	}
+
+	if webhook.Delete() {
+		provide.Render(nil, 204, c)
+	} else if len(webhook.Errors) > 0 {
+		obj := map[string]interface{}{}
+		obj["errors"] = webhook.Errors
+		provide.Render(obj, 422, c)
+	} else {
+		provide.RenderError("internal persistence error", 500, c)
+	}
+}
+
+func listWebhookDeliveriesHandler(c *gin.Context) {
+	organizationID := util.AuthorizedSubjectID(c, "organization")
+	if organizationID == nil {
+		provide.RenderError("unauthorized", 401, c)
+		return
+	}
+
+	webhookID, err := uuid.FromString(c.Param("id"))
+	if err != nil {
+		provide.RenderError(err.Error(), 422, c)
+		return
+	}
+
+	webhook := FindWebhookByID(webhookID)
+	if webhook == nil || webhook.OrganizationID == nil || webhook.OrganizationID.String() != organizationID.String() {
+		provide.RenderError("not found", 404, c)
+		return
+	}
+
+	var deliveries []*WebhookDelivery
+
+	db := dbconf.DatabaseConnection()
+	query := db.Where("webhook_id = ?", webhook.ID)
+	if c.Query("event") != "" {
+		query = query.Where("event = ?", c.Query("event"))
+	}
+	if c.Query("status") != "" {
+		query = query.Where("status = ?", c.Query("status"))
+	}
+
+	query = query.Order("created_at DESC")
+	provide.Paginate(c, query, &WebhookDelivery{}).Find(&deliveries)
+	provide.Render(deliveries, 200, c)
+}
+
+func listSchemasHandler(c *gin.Context) {
+	organizationID := util.AuthorizedSubjectID(c, "organization")
+	if organizationID == nil {
+		provide.RenderError("unauthorized", 401, c)
+		return
+	}
+
+	var systems []*middleware.System
+	var err error
+
+	useEphemeralSystem := c.Query("system_secret_ids") != ""
+
+	if !useEphemeralSystem {
+		subjectAccountID := subjectAccountIDFactory(organizationID.String(), c.Param("id"))
+		subjectAccount, err := resolveSubjectAccount(subjectAccountID)
+		if err != nil {
+			provide.RenderError(err.Error(), 403, c)
+			return
+		}
+
+		systems, err = subjectAccount.listSystems()
+		if err != nil {
+			provide.RenderError("failed to list systems for subject account", 403, c)
+			return
+		}
+	} else {
+		if c.Query("vault_id") == "" {
+			provide.RenderError("vault_id required for querying ephemerally-referenced system secrets", 422, c)
+			return
+		}
+
+		token, _ := util.ParseBearerAuthorizationHeader(c, nil)
+		systemSecretIDs := strings.Split(c.Query("system_secret_ids"), ",")
 		systems, err = resolveSystems(token.Raw, c.Query("vault_id"), systemSecretIDs)
 		if err != nil {
 			provide.RenderError("failed to list systems for ephemerally-referenced system secrets", 403, c)
@@ -1133,6 +1771,224 @@ func createWorkflowHandler(c *gin.Context) {
 	}
 }
 
+func importWorkflowHandler(c *gin.Context) {
+	organizationID := util.AuthorizedSubjectID(c, "organization")
+	if organizationID == nil {
+		provide.RenderError("unauthorized", 401, c)
+		return
+	}
+
+	buf, err := c.GetRawData()
+	if err != nil {
+		provide.RenderError(err.Error(), 400, c)
+		return
+	}
+
+	workgroupID, err := uuid.FromString(c.Query("workgroup_id"))
+	if err != nil {
+		provide.RenderError("workgroup_id is required", 422, c)
+		return
+	}
+
+	definition, err := parseWorkflowDefinition(buf)
+	if err != nil {
+		provide.RenderError(err.Error(), 422, c)
+		return
+	}
+
+	if workflow, ok := importWorkflowDefinition(definition, *organizationID, workgroupID); ok {
+		provide.Render(workflow, 201, c)
+	} else if len(definition.Errors) > 0 {
+		obj := map[string]interface{}{}
+		obj["errors"] = definition.Errors
+		provide.Render(obj, 422, c)
+	} else {
+		provide.RenderError("internal persistence error", 500, c)
+	}
+}
+
+func exportWorkflowHandler(c *gin.Context) {
+	organizationID := util.AuthorizedSubjectID(c, "organization")
+	if organizationID == nil {
+		provide.RenderError("unauthorized", 401, c)
+		return
+	}
+
+	workflowID, err := uuid.FromString(c.Param("id"))
+	if err != nil {
+		provide.RenderError(err.Error(), 422, c)
+		return
+	}
+
+	workflow := FindWorkflowByID(workflowID)
+	if workflow == nil {
+		provide.RenderError("not found", 404, c)
+		return
+	}
+
+	format := strings.ToLower(c.Query("format"))
+	if format == "" && strings.Contains(c.GetHeader("Accept"), "yaml") {
+		format = workflowDefinitionFormatYAML
+	} else if format == "yml" {
+		format = workflowDefinitionFormatYAML
+	} else if format == "" {
+		format = workflowDefinitionFormatJSON
+	}
+
+	if format != workflowDefinitionFormatJSON && format != workflowDefinitionFormatYAML {
+		provide.RenderError(fmt.Sprintf("unsupported format: %s", format), 422, c)
+		return
+	}
+
+	db := dbconf.DatabaseConnection()
+	definition, err := exportWorkflowDefinition(workflow, db)
+	if err != nil {
+		provide.RenderError(err.Error(), 422, c)
+		return
+	}
+
+	if format == workflowDefinitionFormatJSON {
+		provide.Render(definition, 200, c)
+		return
+	}
+
+	raw, err := definition.marshal(format)
+	if err != nil {
+		provide.RenderError(err.Error(), 500, c)
+		return
+	}
+
+	c.Data(200, "application/x-yaml", raw)
+}
+
+func simulateWorkflowHandler(c *gin.Context) {
+	organizationID := util.AuthorizedSubjectID(c, "organization")
+	if organizationID == nil {
+		provide.RenderError("unauthorized", 401, c)
+		return
+	}
+
+	buf, err := c.GetRawData()
+	if err != nil {
+		provide.RenderError(err.Error(), 400, c)
+		return
+	}
+
+	workflowID, err := uuid.FromString(c.Param("id"))
+	if err != nil {
+		provide.RenderError(err.Error(), 422, c)
+		return
+	}
+
+	workflow := FindWorkflowByID(workflowID)
+	if workflow == nil {
+		provide.RenderError("not found", 404, c)
+		return
+	}
+
+	if !workflow.isPrototype() {
+		provide.RenderError("cannot simulate workflow instance", 422, c)
+		return
+	}
+
+	var req *WorkflowSimulationRequest
+	err = json.Unmarshal(buf, &req)
+	if err != nil {
+		provide.RenderError(err.Error(), 422, c)
+		return
+	}
+
+	if req == nil {
+		provide.RenderError("at least one step is required", 422, c)
+		return
+	}
+
+	err = req.validate()
+	if err != nil {
+		provide.RenderError(err.Error(), 422, c)
+		return
+	}
+
+	db := dbconf.DatabaseConnection()
+	provide.Render(workflow.simulate(req, db), 200, c)
+}
+
+func migrateWorkflowHandler(c *gin.Context) {
+	organizationID := util.AuthorizedSubjectID(c, "organization")
+	if organizationID == nil {
+		provide.RenderError("unauthorized", 401, c)
+		return
+	}
+
+	buf, err := c.GetRawData()
+	if err != nil {
+		provide.RenderError(err.Error(), 400, c)
+		return
+	}
+
+	workflowID, err := uuid.FromString(c.Param("id"))
+	if err != nil {
+		provide.RenderError(err.Error(), 422, c)
+		return
+	}
+
+	workflow := FindWorkflowByID(workflowID)
+	if workflow == nil {
+		provide.RenderError("not found", 404, c)
+		return
+	}
+
+	if workflow.OrganizationID != nil && workflow.OrganizationID.String() != organizationID.String() {
+		provide.RenderError("forbidden", 403, c)
+		return
+	}
+
+	var params map[string]interface{}
+	err = json.Unmarshal(buf, &params)
+	if err != nil {
+		provide.RenderError(err.Error(), 422, c)
+		return
+	}
+
+	dryRun := false
+	if dry, ok := params["dry_run"].(bool); ok {
+		dryRun = dry
+	}
+
+	var target *Workflow
+	if targetID, ok := params["workflow_id"].(string); ok {
+		targetUUID, err := uuid.FromString(targetID)
+		if err != nil {
+			provide.RenderError(err.Error(), 422, c)
+			return
+		}
+		target = FindWorkflowByID(targetUUID)
+	} else if version, ok := params["version"].(string); ok {
+		db := dbconf.DatabaseConnection()
+		if v := workflow.findVersion(version, db); v != nil {
+			target = FindWorkflowByID(v.WorkflowID)
+		}
+	} else {
+		provide.RenderError("workflow_id or version is required", 422, c)
+		return
+	}
+
+	if target == nil {
+		provide.RenderError("target workflow version not found", 404, c)
+		return
+	}
+
+	migration := workflow.migrate(target, dryRun)
+	if len(migration.Errors) > 0 {
+		obj := map[string]interface{}{}
+		obj["errors"] = migration.Errors
+		provide.Render(obj, 422, c)
+		return
+	}
+
+	provide.Render(migration, 200, c)
+}
+
 func deployWorkflowHandler(c *gin.Context) {
 	organizationID := util.AuthorizedSubjectID(c, "organization")
 	if organizationID == nil {
@@ -1165,12 +2021,81 @@ func deployWorkflowHandler(c *gin.Context) {
 		return
 	}
 
-	_workflow := &Workflow{}
-	_workflow.Status = common.StringOrNil(workflowStatusDeployed) // HACK!!!
-	_workflow.Version = workflow.Version
+	_workflow := &Workflow{}
+	_workflow.Status = common.StringOrNil(workflowStatusDeployed) // HACK!!!
+	_workflow.Version = workflow.Version
+
+	if workflow.Update(_workflow) {
+		provide.Render(workflow, 202, c)
+	} else if len(workflow.Errors) > 0 {
+		obj := map[string]interface{}{}
+		obj["errors"] = workflow.Errors
+		provide.Render(obj, 422, c)
+	} else {
+		provide.RenderError("internal persistence error", 500, c)
+	}
+}
+
+func workflowDeploymentStatusHandler(c *gin.Context) {
+	organizationID := util.AuthorizedSubjectID(c, "organization")
+	if organizationID == nil {
+		provide.RenderError("unauthorized", 401, c)
+		return
+	}
+
+	workflowID, err := uuid.FromString(c.Param("id"))
+	if err != nil {
+		provide.RenderError(err.Error(), 422, c)
+		return
+	}
+
+	workflow := FindWorkflowByID(workflowID)
+	if workflow == nil {
+		provide.RenderError("not found", 404, c)
+		return
+	}
+
+	if !workflow.isPrototype() {
+		provide.RenderError("cannot resolve deployment status of workflow instance", 400, c)
+		return
+	}
+
+	token, _ := util.ParseBearerAuthorizationHeader(c, nil)
+	provide.Render(workflow.deploymentStatus(token.Raw), 200, c)
+}
+
+func redeployWorkflowHandler(c *gin.Context) {
+	organizationID := util.AuthorizedSubjectID(c, "organization")
+	if organizationID == nil {
+		provide.RenderError("unauthorized", 401, c)
+		return
+	}
+
+	workflowID, err := uuid.FromString(c.Param("id"))
+	if err != nil {
+		provide.RenderError(err.Error(), 422, c)
+		return
+	}
+
+	workflow := FindWorkflowByID(workflowID)
+	if workflow == nil {
+		provide.RenderError("not found", 404, c)
+		return
+	}
+
+	if workflow.OrganizationID == nil || workflow.OrganizationID.String() != organizationID.String() {
+		provide.RenderError("forbidden", 403, c)
+		return
+	}
 
-	if workflow.Update(_workflow) {
-		provide.Render(workflow, 202, c)
+	if !workflow.isPrototype() {
+		provide.RenderError("cannot redeploy workflow instance", 400, c)
+		return
+	}
+
+	token, _ := util.ParseBearerAuthorizationHeader(c, nil)
+	if workflow.redeploy(token.Raw) {
+		provide.Render(workflow.deploymentStatus(token.Raw), 202, c)
 	} else if len(workflow.Errors) > 0 {
 		obj := map[string]interface{}{}
 		obj["errors"] = workflow.Errors
@@ -1293,6 +2218,101 @@ func listWorkflowVersionsHandler(c *gin.Context) {
 	provide.Render(versions, 200, c)
 }
 
+func latestWorkflowVersionHandler(c *gin.Context) {
+	organizationID := util.AuthorizedSubjectID(c, "organization")
+	if organizationID == nil {
+		provide.RenderError("unauthorized", 401, c)
+		return
+	}
+
+	workflowID, err := uuid.FromString(c.Param("id"))
+	if err != nil {
+		provide.RenderError(err.Error(), 422, c)
+		return
+	}
+
+	workflow := FindWorkflowByID(workflowID)
+	if workflow == nil {
+		provide.RenderError("not found", 404, c)
+		return
+	}
+
+	var rng *common.SemverRange
+	if c.Query("range") != "" {
+		rng, err = common.ParseSemverRange(c.Query("range"))
+		if err != nil {
+			provide.RenderError(err.Error(), 422, c)
+			return
+		}
+	}
+
+	db := dbconf.DatabaseConnection()
+	latest := workflow.latestDeployedVersion(rng, db)
+	if latest == nil {
+		provide.RenderError("no deployed workflow version resolved", 404, c)
+		return
+	}
+
+	provide.Render(latest, 200, c)
+}
+
+func diffWorkflowVersionsHandler(c *gin.Context) {
+	organizationID := util.AuthorizedSubjectID(c, "organization")
+	if organizationID == nil {
+		provide.RenderError("unauthorized", 401, c)
+		return
+	}
+
+	workflowID, err := uuid.FromString(c.Param("id"))
+	if err != nil {
+		provide.RenderError(err.Error(), 422, c)
+		return
+	}
+
+	workflow := FindWorkflowByID(workflowID)
+	if workflow == nil {
+		provide.RenderError("not found", 404, c)
+		return
+	}
+
+	if !workflow.isPrototype() {
+		provide.RenderError("cannot diff workflow instance versions", 422, c)
+		return
+	}
+
+	toVersion := c.Query("to")
+	if toVersion == "" {
+		provide.RenderError("to version is required", 422, c)
+		return
+	}
+
+	db := dbconf.DatabaseConnection()
+
+	from := workflow
+	if fromVersion := c.Query("from"); fromVersion != "" {
+		version := workflow.findVersion(fromVersion, db)
+		if version == nil {
+			provide.RenderError(fmt.Sprintf("workflow version not found: %s", fromVersion), 404, c)
+			return
+		}
+		from = FindWorkflowByID(version.WorkflowID)
+	}
+
+	version := workflow.findVersion(toVersion, db)
+	if version == nil {
+		provide.RenderError(fmt.Sprintf("workflow version not found: %s", toVersion), 404, c)
+		return
+	}
+	to := FindWorkflowByID(version.WorkflowID)
+
+	if from == nil || to == nil {
+		provide.RenderError("workflow version not resolved", 404, c)
+		return
+	}
+
+	provide.Render(diffWorkflowVersions(from, to, db), 200, c)
+}
+
 func updateWorkflowHandler(c *gin.Context) {
 	organizationID := util.AuthorizedSubjectID(c, "organization")
 	if organizationID == nil {
@@ -1637,6 +2657,13 @@ func executeWorkstepHandler(c *gin.Context) {
 		return
 	}
 
+	if errs := workstep.validatePayload(payload); len(errs) > 0 {
+		obj := map[string]interface{}{}
+		obj["errors"] = errs
+		provide.Render(obj, 422, c)
+		return
+	}
+
 	token, _ := util.ParseBearerAuthorizationHeader(c, nil)
 	proof, err := workstep.execute(subjectAccount, token.Raw, payload)
 	if err != nil {
@@ -1789,6 +2816,191 @@ func issueVerifiableCredentialHandler(c *gin.Context) {
 	}
 }
 
+func listWorkflowParticipantsHandler(c *gin.Context) {
+	organizationID := util.AuthorizedSubjectID(c, "organization")
+	if organizationID == nil {
+		provide.RenderError("unauthorized", 401, c)
+		return
+	}
+
+	workflowID, err := uuid.FromString(c.Param("id"))
+	if err != nil {
+		provide.RenderError(err.Error(), 422, c)
+		return
+	}
+
+	workflow := FindWorkflowByID(workflowID)
+	if workflow == nil {
+		provide.RenderError("not found", 404, c)
+		return
+	}
+
+	if workflow.OrganizationID == nil || workflow.OrganizationID.String() != organizationID.String() {
+		provide.RenderError("forbidden", 403, c)
+		return
+	}
+
+	db := dbconf.DatabaseConnection()
+	participants := workflow.listParticipants(db)
+	provide.Render(participants, 200, c)
+}
+
+func createWorkflowParticipantHandler(c *gin.Context) {
+	organizationID := util.AuthorizedSubjectID(c, "organization")
+	if organizationID == nil {
+		provide.RenderError("unauthorized", 401, c)
+		return
+	}
+
+	var participant *WorkflowParticipant
+
+	buf, err := c.GetRawData()
+	if err != nil {
+		provide.RenderError(err.Error(), 400, c)
+		return
+	}
+
+	err = json.Unmarshal(buf, &participant)
+	if err != nil {
+		msg := fmt.Sprintf("failed to umarshal participant; %s", err.Error())
+		common.Log.Warning(msg)
+		provide.RenderError(msg, 422, c)
+		return
+	}
+
+	workflowID, err := uuid.FromString(c.Param("id"))
+	if err != nil {
+		provide.RenderError(err.Error(), 422, c)
+		return
+	}
+
+	workflow := FindWorkflowByID(workflowID)
+	if workflow == nil {
+		provide.RenderError("not found", 404, c)
+		return
+	}
+
+	if workflow.OrganizationID == nil || workflow.OrganizationID.String() != organizationID.String() {
+		provide.RenderError("forbidden", 403, c)
+		return
+	}
+
+	if participant.Participant == nil {
+		provide.RenderError("address required", 422, c)
+		return
+	}
+
+	propagate := strings.ToLower(c.Query("propagate")) == "true"
+
+	if workflow.createParticipant(*participant.Participant, propagate) {
+		provide.Render(nil, 204, c)
+	} else if len(workflow.Errors) > 0 {
+		obj := map[string]interface{}{}
+		obj["errors"] = workflow.Errors
+		provide.Render(obj, 422, c)
+	} else {
+		provide.RenderError("internal persistence error", 500, c)
+	}
+}
+
+func deleteWorkflowParticipantHandler(c *gin.Context) {
+	organizationID := util.AuthorizedSubjectID(c, "organization")
+	if organizationID == nil {
+		provide.RenderError("unauthorized", 401, c)
+		return
+	}
+
+	address := c.Param("participantId")
+
+	workflowID, err := uuid.FromString(c.Param("id"))
+	if err != nil {
+		provide.RenderError(err.Error(), 422, c)
+		return
+	}
+
+	workflow := FindWorkflowByID(workflowID)
+	if workflow == nil {
+		provide.RenderError("not found", 404, c)
+		return
+	}
+
+	if workflow.OrganizationID == nil || workflow.OrganizationID.String() != organizationID.String() {
+		provide.RenderError("forbidden", 403, c)
+		return
+	}
+
+	propagate := strings.ToLower(c.Query("propagate")) == "true"
+
+	if workflow.deleteParticipant(address, propagate) {
+		provide.Render(nil, 204, c)
+	} else if len(workflow.Errors) > 0 {
+		obj := map[string]interface{}{}
+		obj["errors"] = workflow.Errors
+		provide.Render(obj, 422, c)
+	} else {
+		provide.RenderError("internal persistence error", 500, c)
+	}
+}
+
+func listWorkstepExecutionsHandler(c *gin.Context) {
+	organizationID := util.AuthorizedSubjectID(c, "organization")
+	if organizationID == nil {
+		provide.RenderError("unauthorized", 401, c)
+		return
+	}
+
+	workflowID, err := uuid.FromString(c.Param("id"))
+	if err != nil {
+		provide.RenderError(err.Error(), 422, c)
+		return
+	}
+
+	workflow := FindWorkflowByID(workflowID)
+	if workflow == nil {
+		provide.RenderError("not found", 404, c)
+		return
+	}
+
+	workstepID, err := uuid.FromString(c.Param("workstepId"))
+	if err != nil {
+		provide.RenderError(err.Error(), 422, c)
+		return
+	}
+
+	workstep := FindWorkstepByID(workstepID)
+	if workstep == nil {
+		provide.RenderError("not found", 404, c)
+		return
+	} else if workstep.WorkflowID == nil || workstep.WorkflowID.String() != workflowID.String() {
+		provide.RenderError("forbidden", 403, c)
+		return
+	}
+
+	if workstep.isPrototype() {
+		provide.RenderError("cannot list executions of workstep prototype", 400, c)
+		return
+	}
+
+	subjectAccountID := subjectAccountIDFactory(organizationID.String(), workflow.WorkgroupID.String())
+	subjectAccount, err := resolveSubjectAccount(subjectAccountID)
+	if err != nil {
+		provide.RenderError(err.Error(), 403, c)
+		return
+	}
+
+	db := dbconf.DatabaseConnection()
+	if !workstep.hasParticipant(*subjectAccount.Metadata.OrganizationAddress, db) {
+		provide.RenderError("forbidden", 403, c)
+		return
+	}
+
+	includeWitness := strings.ToLower(c.Query("witness")) == "true"
+
+	token, _ := util.ParseBearerAuthorizationHeader(c, nil)
+	executions := workstep.resolveExecutionHistory(token.Raw, subjectAccount, includeWitness, db)
+	provide.Render(executions, 200, c)
+}
+
 func listWorkstepParticipantsHandler(c *gin.Context) {
 	organizationID := util.AuthorizedSubjectID(c, "organization")
 	if organizationID == nil {
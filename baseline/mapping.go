@@ -134,7 +134,7 @@ func (m *Mapping) enrichRef() bool {
 	return true
 }
 
-func (m *Mapping) Create() bool {
+func (m *Mapping) Create(tx *gorm.DB) bool {
 	if !m.Validate() {
 		return false
 	}
@@ -143,13 +143,16 @@ func (m *Mapping) Create() bool {
 		return false
 	}
 
-	db := dbconf.DatabaseConnection()
-	tx := db.Begin()
-	defer tx.RollbackUnlessCommitted()
+	_tx := tx
+	if _tx == nil {
+		db := dbconf.DatabaseConnection()
+		_tx = db.Begin()
+		defer _tx.RollbackUnlessCommitted()
+	}
 
 	success := false
-	if tx.NewRecord(m) {
-		result := tx.Create(&m)
+	if _tx.NewRecord(m) {
+		result := _tx.Create(&m)
 		rowsAffected := result.RowsAffected
 		errors := result.GetErrors()
 		if len(errors) > 0 {
@@ -159,19 +162,21 @@ func (m *Mapping) Create() bool {
 				})
 			}
 		}
-		if !tx.NewRecord(m) {
+		if !_tx.NewRecord(m) {
 			success = rowsAffected > 0
 			if success {
 				for _, model := range m.Models {
 					model.MappingID = m.ID
-					if !model.Create(tx) {
+					if !model.Create(_tx) {
 						common.Log.Warning("failed to create mapping model; transaction will be rolled back")
 						m.Errors = append(m.Errors, model.Errors...)
 						return false
 					}
 				}
 
-				tx.Commit()
+				if tx == nil {
+					_tx.Commit()
+				}
 			}
 		}
 	}
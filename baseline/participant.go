@@ -23,6 +23,7 @@ import (
 	"time"
 
 	"github.com/kthomas/go-redisutil"
+	uuid "github.com/kthomas/go.uuid"
 	"github.com/provideplatform/provide-go/api/baseline"
 )
 
@@ -39,6 +40,8 @@ type Participant struct {
 type WorkgroupParticipant struct {
 	Participant *string     `json:"address"`
 	Proof       *string     `json:"proof"`
+	Status      *string     `json:"status"`
+	SuspendedAt *time.Time  `json:"suspended_at,omitempty"`
 	Witness     interface{} `json:"witness"`
 	WitnessedAt *time.Time  `json:"witnessed_at"`
 }
@@ -53,10 +56,11 @@ type WorkflowParticipant struct {
 
 // WorkstepParticipant is a party to a baseline workstep
 type WorkstepParticipant struct {
-	Participant *string     `json:"address"`
-	Proof       *string     `json:"proof"`
-	Witness     interface{} `json:"witness"`
-	WitnessedAt *time.Time  `json:"witnessed_at"`
+	Participant     *string     `json:"address"`
+	Proof           *string     `json:"proof"`
+	Witness         interface{} `json:"witness"`
+	WitnessedAt     *time.Time  `json:"witnessed_at"`
+	WitnessSecretID *uuid.UUID  `json:"-"`
 }
 
 func (p *Participant) Cache() error {
@@ -0,0 +1,329 @@
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package baseline
+
+import (
+	"encoding/json"
+	"fmt"
+
+	dbconf "github.com/kthomas/go-db-config"
+	uuid "github.com/kthomas/go.uuid"
+	"github.com/provideplatform/baseline/common"
+	provide "github.com/provideplatform/provide-go/api"
+)
+
+const workstepMetadataProverKey = "prover"
+const workstepMetadataProverTemplateKey = "prover_template"
+
+// ProverTemplate is a named, versioned circuit template which worksteps may reference in lieu
+// of embedding raw prover params; templates are scoped to an organization or a workgroup
+type ProverTemplate struct {
+	provide.Model
+	OrganizationID *uuid.UUID       `json:"organization_id"`
+	WorkgroupID    *uuid.UUID       `json:"workgroup_id"`
+	Name           *string          `json:"name"`
+	Version        *string          `json:"version"`
+	Description    *string          `json:"description"`
+	Identifier     *string          `json:"identifier"`
+	Provider       *string          `json:"provider"`
+	ProvingScheme  *string          `json:"proving_scheme"`
+	Curve          *string          `json:"curve"`
+	Metadata       *json.RawMessage `sql:"type:json" json:"metadata,omitempty"`
+}
+
+// FindProverTemplateByID finds a prover template for the given id
+func FindProverTemplateByID(id uuid.UUID) *ProverTemplate {
+	db := dbconf.DatabaseConnection()
+	template := &ProverTemplate{}
+	db.Where("id = ?", id.String()).Find(&template)
+	if template == nil || template.ID == uuid.Nil {
+		return nil
+	}
+	return template
+}
+
+// resolveProverTemplate resolves the latest version of the named prover template satisfying the
+// given version range; templates scoped to the workgroup take precedence over those scoped to the
+// organization, and only those created by the workgroup owner or the given organization are
+// resolved such that other participants cannot shadow them
+func resolveProverTemplate(organizationID, workgroupID *uuid.UUID, name, version string) (*ProverTemplate, error) {
+	rng, err := common.ParseSemverRange(version)
+	if err != nil {
+		return nil, fmt.Errorf("invalid prover template version: %s; %s", version, err.Error())
+	}
+
+	db := dbconf.DatabaseConnection()
+	scopes := make([]func() []*ProverTemplate, 0)
+	if workgroupID != nil {
+		organizationIDs := make([]string, 0)
+		if organizationID != nil {
+			organizationIDs = append(organizationIDs, organizationID.String())
+		}
+		if workgroup := FindWorkgroupByID(*workgroupID); workgroup != nil && workgroup.OrganizationID != nil {
+			organizationIDs = append(organizationIDs, workgroup.OrganizationID.String())
+		}
+
+		if len(organizationIDs) > 0 {
+			scopes = append(scopes, func() []*ProverTemplate {
+				templates := make([]*ProverTemplate, 0)
+				db.Where("workgroup_id = ? AND organization_id IN (?) AND name = ?", workgroupID, organizationIDs, name).Find(&templates)
+				return templates
+			})
+		}
+	}
+	if organizationID != nil {
+		scopes = append(scopes, func() []*ProverTemplate {
+			templates := make([]*ProverTemplate, 0)
+			db.Where("organization_id = ? AND workgroup_id IS NULL AND name = ?", organizationID, name).Find(&templates)
+			return templates
+		})
+	}
+
+	for _, scope := range scopes {
+		var latest *ProverTemplate
+		var latestVersion *common.Semver
+
+		for _, template := range scope() {
+			v, err := common.ParseSemver(*template.Version)
+			if err != nil || !rng.Satisfies(v) {
+				continue
+			}
+			if latestVersion == nil || v.Compare(latestVersion) > 0 {
+				latest = template
+				latestVersion = v
+			}
+		}
+
+		if latest != nil {
+			return latest, nil
+		}
+	}
+
+	return nil, fmt.Errorf("prover template not found: %s@%s", name, version)
+}
+
+// proverParams returns the params used to create a prover from the template
+func (t *ProverTemplate) proverParams() map[string]interface{} {
+	params := map[string]interface{}{}
+	if t.Metadata != nil {
+		json.Unmarshal(*t.Metadata, &params)
+	}
+
+	params["name"] = t.Name
+	params["description"] = t.Description
+	params["identifier"] = t.Identifier
+	params["provider"] = t.Provider
+	params["proving_scheme"] = t.ProvingScheme
+	params["curve"] = t.Curve
+
+	return params
+}
+
+// Create the prover template
+func (t *ProverTemplate) Create() bool {
+	if !t.Validate() {
+		return false
+	}
+
+	db := dbconf.DatabaseConnection()
+
+	success := false
+	if db.NewRecord(t) {
+		result := db.Create(&t)
+		rowsAffected := result.RowsAffected
+		errors := result.GetErrors()
+		if len(errors) > 0 {
+			for _, err := range errors {
+				t.Errors = append(t.Errors, &provide.Error{
+					Message: common.StringOrNil(err.Error()),
+				})
+			}
+		}
+		if !db.NewRecord(t) {
+			success = rowsAffected > 0
+		}
+	}
+
+	return success
+}
+
+// Update the description and metadata of the prover template; the circuit itself is
+// immutable once created, so any other change requires a new template version
+func (t *ProverTemplate) Update(other *ProverTemplate) bool {
+	immutable := map[string]bool{
+		"name":           other.Name != nil && (t.Name == nil || *other.Name != *t.Name),
+		"version":        other.Version != nil && (t.Version == nil || *other.Version != *t.Version),
+		"identifier":     other.Identifier != nil && (t.Identifier == nil || *other.Identifier != *t.Identifier),
+		"provider":       other.Provider != nil && (t.Provider == nil || *other.Provider != *t.Provider),
+		"proving_scheme": other.ProvingScheme != nil && (t.ProvingScheme == nil || *other.ProvingScheme != *t.ProvingScheme),
+		"curve":          other.Curve != nil && (t.Curve == nil || *other.Curve != *t.Curve),
+	}
+	for _, field := range []string{"name", "version", "identifier", "provider", "proving_scheme", "curve"} {
+		if immutable[field] {
+			t.Errors = append(t.Errors, &provide.Error{
+				Message: common.StringOrNil(fmt.Sprintf("cannot modify prover template %s; create a new version instead", field)),
+			})
+		}
+	}
+
+	if len(t.Errors) > 0 {
+		return false
+	}
+
+	if other.Description != nil {
+		t.Description = other.Description
+	}
+
+	if other.Metadata != nil {
+		t.Metadata = other.Metadata
+	}
+
+	db := dbconf.DatabaseConnection()
+	result := db.Save(&t)
+	errors := result.GetErrors()
+	if len(errors) > 0 {
+		for _, err := range errors {
+			t.Errors = append(t.Errors, &provide.Error{
+				Message: common.StringOrNil(err.Error()),
+			})
+		}
+	}
+
+	return len(t.Errors) == 0
+}
+
+// Delete the prover template
+func (t *ProverTemplate) Delete() bool {
+	db := dbconf.DatabaseConnection()
+	result := db.Delete(t)
+	errors := result.GetErrors()
+	if len(errors) > 0 {
+		for _, err := range errors {
+			t.Errors = append(t.Errors, &provide.Error{
+				Message: common.StringOrNil(err.Error()),
+			})
+		}
+	}
+	return len(t.Errors) == 0
+}
+
+// Validate the prover template
+func (t *ProverTemplate) Validate() bool {
+	if t.OrganizationID == nil {
+		t.Errors = append(t.Errors, &provide.Error{
+			Message: common.StringOrNil("organization id is required"),
+		})
+	}
+
+	if t.Name == nil || *t.Name == "" {
+		t.Errors = append(t.Errors, &provide.Error{
+			Message: common.StringOrNil("name is required"),
+		})
+	}
+
+	if t.Version == nil {
+		t.Errors = append(t.Errors, &provide.Error{
+			Message: common.StringOrNil("version is required"),
+		})
+	} else if _, err := common.ParseSemver(*t.Version); err != nil {
+		t.Errors = append(t.Errors, &provide.Error{
+			Message: common.StringOrNil(fmt.Sprintf("invalid version: %s; %s", *t.Version, err.Error())),
+		})
+	}
+
+	if t.Identifier == nil || *t.Identifier == "" {
+		t.Errors = append(t.Errors, &provide.Error{
+			Message: common.StringOrNil("identifier is required"),
+		})
+	}
+
+	if t.Provider == nil || *t.Provider == "" {
+		t.Errors = append(t.Errors, &provide.Error{
+			Message: common.StringOrNil("provider is required"),
+		})
+	}
+
+	if t.ProvingScheme == nil || *t.ProvingScheme == "" {
+		t.Errors = append(t.Errors, &provide.Error{
+			Message: common.StringOrNil("proving scheme is required"),
+		})
+	}
+
+	if t.Curve == nil || *t.Curve == "" {
+		t.Errors = append(t.Errors, &provide.Error{
+			Message: common.StringOrNil("curve is required"),
+		})
+	}
+
+	if t.WorkgroupID != nil && FindWorkgroupByID(*t.WorkgroupID) == nil {
+		t.Errors = append(t.Errors, &provide.Error{
+			Message: common.StringOrNil(fmt.Sprintf("workgroup not found: %s", t.WorkgroupID)),
+		})
+	}
+
+	return len(t.Errors) == 0
+}
+
+// hasProver returns true if the workstep metadata specifies prover params or a prover template
+func (w *Workstep) hasProver() bool {
+	return hasProverMetadata(w.ParseMetadata())
+}
+
+func hasProverMetadata(metadata map[string]interface{}) bool {
+	if _, proverOk := metadata[workstepMetadataProverKey].(map[string]interface{}); proverOk {
+		return true
+	}
+	_, templateOk := metadata[workstepMetadataProverTemplateKey].(map[string]interface{})
+	return templateOk
+}
+
+// resolveProverParams returns the params used to create the prover for the workstep; raw params
+// embedded in the metadata are used as-is, otherwise the referenced prover template is resolved
+// within the organization and workgroup of the given workflow
+func (w *Workstep) resolveProverParams(workflow *Workflow) (map[string]interface{}, error) {
+	metadata := w.ParseMetadata()
+	if params, paramsOk := metadata[workstepMetadataProverKey].(map[string]interface{}); paramsOk {
+		return params, nil
+	}
+
+	ref, refOk := metadata[workstepMetadataProverTemplateKey].(map[string]interface{})
+	if !refOk {
+		return nil, fmt.Errorf("no prover specified")
+	}
+
+	name, _ := ref["name"].(string)
+	if name == "" {
+		return nil, fmt.Errorf("prover template name is required")
+	}
+
+	version, _ := ref["version"].(string)
+	if version == "" {
+		version = "*"
+	}
+
+	if workflow == nil {
+		return nil, fmt.Errorf("failed to resolve prover template: %s@%s; workflow not resolved", name, version)
+	}
+
+	template, err := resolveProverTemplate(workflow.OrganizationID, workflow.WorkgroupID, name, version)
+	if err != nil {
+		return nil, err
+	}
+
+	common.Log.Debugf("resolved prover template %s@%s for workstep: %s", name, *template.Version, w.ID)
+	return template.proverParams(), nil
+}
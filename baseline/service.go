@@ -17,19 +17,25 @@
 package baseline
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"math/big"
 	"strings"
+	"time"
 
 	mimc "github.com/consensys/gnark/crypto/hash/mimc/bn256"
-	natsutil "github.com/kthomas/go-natsutil"
 	uuid "github.com/kthomas/go.uuid"
 	"github.com/provideplatform/baseline/common"
+	"github.com/provideplatform/baseline/metrics"
 	"github.com/provideplatform/baseline/middleware"
+	"github.com/provideplatform/baseline/tracing"
 	provide "github.com/provideplatform/provide-go/api"
 	"github.com/provideplatform/provide-go/api/baseline"
 	"github.com/provideplatform/provide-go/api/privacy"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 func (m *ProtocolMessage) baselineInbound() bool {
@@ -121,13 +127,14 @@ func (m *ProtocolMessage) baselineInbound() bool {
 		common.Log.Warningf("failed to resolve system for subject account for mapping type: %s", *m.Type)
 		return false
 	}
+	sor = middleware.SORWithContext(tracing.Extract(context.Background(), m.TraceContext), sor)
 
 	if baselineRecord.ID == nil {
 		// TODO -- map baseline record id -> internal record id (i.e, this is currently done but lazily on outbound message)
 		resp, err := sor.CreateObject(map[string]interface{}{
 			"baseline_id": baselineRecord.BaselineID.String(),
 			"payload":     m.Payload.Object,
-			"type":        m.Type,
+			"type":        *m.Type,
 		})
 		if err != nil {
 			common.Log.Warningf("failed to create business object during inbound baseline; %s", err.Error())
@@ -145,7 +152,11 @@ func (m *ProtocolMessage) baselineInbound() bool {
 			return false
 		}
 	} else {
-		err := sor.UpdateObject(*baselineRecord.ID, m.Payload.Object)
+		err := sor.UpdateObject(*baselineRecord.ID, map[string]interface{}{
+			"baseline_id": baselineRecord.BaselineID.String(),
+			"payload":     m.Payload.Object,
+			"type":        *m.Type,
+		})
 		if err != nil {
 			common.Log.Warningf("failed to create business object during inbound baseline; %s", err.Error())
 			return false
@@ -155,6 +166,46 @@ func (m *ProtocolMessage) baselineInbound() bool {
 	return true
 }
 
+// authorizeSender resolves the BPI subject account on behalf of which the given organization
+// sends the message for the given previously-baselined record, and ensures the organization is
+// a participant in the workstep to be executed; the returned status is suitable for rendering
+func (m *Message) authorizeSender(organizationID string, record *BaselineRecord) (int, error) {
+	workstep, err := record.resolveExecutableWorkstepContext()
+	if err != nil {
+		return 422, err
+	}
+
+	workflow := FindWorkflowByID(*workstep.WorkflowID)
+	if workflow == nil {
+		return 500, errors.New("workflow not resolved")
+	}
+
+	subjectAccountID := subjectAccountIDFactory(organizationID, workflow.WorkgroupID.String())
+	m.subjectAccount, err = resolveSubjectAccount(subjectAccountID)
+	if err != nil {
+		return 403, errors.New("failed to resolve BPI subject account")
+	}
+
+	if isSuspendedWorkgroupParticipant(workflow.WorkgroupID, *m.subjectAccount.Metadata.OrganizationAddress) {
+		return 403, errors.New("participant is suspended")
+	}
+
+	for _, participant := range workstep.Participants {
+		if participant.Address != nil && *participant.Address == *m.subjectAccount.Metadata.OrganizationAddress {
+			return 200, nil
+		}
+	}
+
+	return 403, errors.New("forbidden")
+}
+
+// send the message as an outbound baseline protocol message, recording the outcome
+func (m *Message) send() bool {
+	success := m.baselineOutbound()
+	metrics.ObserveProtocolMessage(metrics.ProtocolMessageDirectionOutbound, baseline.ProtocolMessageOpcodeBaseline, success)
+	return success
+}
+
 func (m *Message) baselineOutbound() bool {
 	if m.ID == nil {
 		m.Errors = append(m.Errors, &provide.Error{
@@ -203,6 +254,10 @@ func (m *Message) baselineOutbound() bool {
 		return false
 	}
 
+	ctx, span := tracing.Start(m.ctx, "protocol_message.outbound")
+	defer span.End()
+	sor = middleware.SORWithContext(ctx, sor)
+
 	var baselineContext *BaselineContext
 	baselineRecord := lookupBaselineRecordByInternalID(*m.ID)
 	if baselineRecord == nil && m.BaselineID != nil {
@@ -318,6 +373,7 @@ func (m *Message) baselineOutbound() bool {
 					Sender:    nil, // FIXME
 					Type:      m.Type,
 				},
+				tracing.Inject(ctx),
 				nil,
 				nil,
 			}
@@ -371,6 +427,7 @@ func (m *Message) baselineOutbound() bool {
 			Shield: shieldAddress,
 			Type:   m.Type,
 		},
+		tracing.Inject(ctx),
 		nil,
 		nil,
 	}
@@ -436,6 +493,12 @@ func (m *ProtocolMessage) broadcast(recipient string) error {
 		return nil
 	}
 
+	ctx, span := tracing.Start(tracing.Extract(context.Background(), m.TraceContext), "protocol_message.broadcast",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(attribute.String("baseline.recipient", recipient)),
+	)
+	defer span.End()
+
 	payload, err := json.Marshal(&ProtocolMessage{
 		baseline.ProtocolMessage{
 			BaselineID: m.BaselineID,
@@ -448,6 +511,7 @@ func (m *ProtocolMessage) broadcast(recipient string) error {
 			Type:       m.Type,
 			Payload:    m.Payload,
 		},
+		tracing.Inject(ctx),
 		nil,
 		nil,
 	})
@@ -457,9 +521,16 @@ func (m *ProtocolMessage) broadcast(recipient string) error {
 		return err
 	}
 
+	if m.subjectAccount.Metadata.OrganizationID != nil {
+		// the organization id is required to resolve the subject account upon dispatch
+		var params map[string]interface{}
+		json.Unmarshal(payload, &params)
+		params["organization_id"] = *m.subjectAccount.Metadata.OrganizationID
+		payload, _ = json.Marshal(params)
+	}
+
 	common.Log.Debugf("attempting to broadcast %d-byte protocol message", len(payload))
-	_, err = natsutil.NatsJetstreamPublish(natsDispatchProtocolMessageSubject, payload)
-	return err
+	return natsJetstreamPublishWithContext(ctx, natsDispatchProtocolMessageSubject, payload)
 }
 
 func (m *Message) prove() error {
@@ -479,9 +550,11 @@ func (m *Message) prove() error {
 	}
 	prover := baselineRecord.Context.Workflow.Worksteps[index].Prover
 
+	startedAt := time.Now()
 	resp, err := privacy.Prove(*token, prover.ID.String(), map[string]interface{}{
 		"witness": m.ProtocolMessage.Payload.Witness,
 	})
+	metrics.ObserveProve(startedAt, err)
 	if err != nil {
 		common.Log.Warningf("failed to prove prover: %s; %s", prover.ID, err.Error())
 		return err
@@ -509,11 +582,13 @@ func (m *ProtocolMessage) verify(store bool) error {
 	}
 	prover := baselineRecord.Context.Workflow.Worksteps[index].Prover
 
+	startedAt := time.Now()
 	resp, err := privacy.Verify(*token, prover.ID.String(), map[string]interface{}{
 		"store":   store,
 		"proof":   m.Payload.Proof,
 		"witness": m.Payload.Witness,
 	})
+	metrics.ObserveVerify(startedAt, err)
 	if err != nil {
 		common.Log.Warningf("failed to verify: %s; %s", prover.ID, err.Error())
 		return err
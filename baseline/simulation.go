@@ -0,0 +1,292 @@
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package baseline
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+	uuid "github.com/kthomas/go.uuid"
+	"github.com/provideplatform/baseline/common"
+	"github.com/provideplatform/baseline/middleware"
+	provide "github.com/provideplatform/provide-go/api"
+	"github.com/provideplatform/provide-go/api/baseline"
+)
+
+// WorkflowSimulationRequest is a sequence of sample payloads to run through a workflow
+type WorkflowSimulationRequest struct {
+	Steps []*WorkflowSimulationStepRequest `json:"steps"`
+}
+
+// WorkflowSimulationStepRequest is a single sample payload; when the workstep is omitted, the
+// next executable workstep is used, and when the participant is omitted, the payload is treated
+// as having been witnessed by every workstep participant
+type WorkflowSimulationStepRequest struct {
+	Cardinality *int                             `json:"cardinality,omitempty"`
+	Participant *string                          `json:"participant,omitempty"`
+	Payload     *baseline.ProtocolMessagePayload `json:"payload"`
+}
+
+// WorkflowSimulationResult is the result of a dry-run simulation of a workflow
+type WorkflowSimulationResult struct {
+	WorkflowID uuid.UUID                 `json:"workflow_id"`
+	Status     *string                   `json:"status"`
+	Steps      []*WorkflowSimulationStep `json:"steps"`
+}
+
+// WorkflowSimulationStep is the outcome of simulating a single sample payload
+type WorkflowSimulationStep struct {
+	Index       int                     `json:"index"`
+	WorkstepID  *uuid.UUID              `json:"workstep_id,omitempty"`
+	Name        *string                 `json:"name,omitempty"`
+	Cardinality int                     `json:"cardinality,omitempty"`
+	Participant *string                 `json:"participant,omitempty"`
+	Proof       *string                 `json:"proof,omitempty"`
+	RecordID    *string                 `json:"record_id,omitempty"`
+	Transitions []*SimulationTransition `json:"transitions"`
+	Errors      []*provide.Error        `json:"errors,omitempty"`
+}
+
+// SimulationTransition is a status transition observed during a simulation
+type SimulationTransition struct {
+	Type string    `json:"type"`
+	ID   uuid.UUID `json:"id"`
+	From *string   `json:"from"`
+	To   *string   `json:"to"`
+}
+
+// simulatedWorkstep holds the in-memory state of a workstep during a simulation
+type simulatedWorkstep struct {
+	workstep     *Workstep
+	status       string
+	participants []string
+	witnessed    map[string]bool
+}
+
+// validate the simulation request; each step requires a sample payload
+func (r *WorkflowSimulationRequest) validate() error {
+	if len(r.Steps) == 0 {
+		return errors.New("at least one step is required")
+	}
+
+	for i, step := range r.Steps {
+		if step == nil {
+			return fmt.Errorf("step %d is required", i)
+		}
+
+		if step.Payload == nil {
+			return fmt.Errorf("step %d: payload is required", i)
+		}
+
+		if step.Cardinality != nil && *step.Cardinality < 1 {
+			return fmt.Errorf("step %d: invalid cardinality: %d", i, *step.Cardinality)
+		}
+	}
+
+	return nil
+}
+
+// simulate runs the given sample payloads through the workflow state machine without proving,
+// broadcasting or persisting anything; records are written to an in-memory system of record
+func (w *Workflow) simulate(req *WorkflowSimulationRequest, tx *gorm.DB) *WorkflowSimulationResult {
+	worksteps := make([]*simulatedWorkstep, 0)
+	for _, workstep := range FindWorkstepsByWorkflowID(w.ID) {
+		worksteps = append(worksteps, &simulatedWorkstep{
+			workstep:     workstep,
+			status:       workstepStatusInit,
+			participants: workstep.participantAddresses(tx),
+			witnessed:    map[string]bool{},
+		})
+	}
+
+	return simulateWorksteps(w.ID, worksteps, req)
+}
+
+// simulateWorksteps runs the given validated simulation request through the given worksteps of
+// the workflow
+func simulateWorksteps(workflowID uuid.UUID, worksteps []*simulatedWorkstep, req *WorkflowSimulationRequest) *WorkflowSimulationResult {
+	result := &WorkflowSimulationResult{
+		WorkflowID: workflowID,
+		Status:     common.StringOrNil(workflowStatusInit),
+		Steps:      make([]*WorkflowSimulationStep, 0),
+	}
+
+	sor := middleware.InitEphemeralMemoryService(nil)
+
+	records := map[string]string{} // maps baseline ids to in-memory record ids
+
+	for i, sample := range req.Steps {
+		step := &WorkflowSimulationStep{
+			Index:       i,
+			Participant: sample.Participant,
+			Transitions: make([]*SimulationTransition, 0),
+		}
+		result.Steps = append(result.Steps, step)
+
+		fail := func(msg string) {
+			step.Errors = append(step.Errors, &provide.Error{
+				Message: common.StringOrNil(msg),
+			})
+		}
+
+		var target *simulatedWorkstep
+		if sample.Cardinality != nil {
+			for _, ws := range worksteps {
+				if ws.workstep.Cardinality == *sample.Cardinality {
+					target = ws
+					break
+				}
+			}
+		} else {
+			for _, ws := range worksteps {
+				if ws.status == workstepStatusInit || ws.status == workstepStatusRunning {
+					target = ws
+					break
+				}
+			}
+		}
+
+		if target == nil {
+			fail("no executable workstep resolved")
+			continue
+		}
+
+		step.WorkstepID = &target.workstep.ID
+		step.Name = target.workstep.Name
+		step.Cardinality = target.workstep.Cardinality
+
+		if *result.Status != workflowStatusInit && *result.Status != workflowStatusRunning {
+			fail(fmt.Sprintf("cannot execute workstep of workflow with status: %s", *result.Status))
+			continue
+		}
+
+		if target.status != workstepStatusInit && target.status != workstepStatusRunning {
+			fail(fmt.Sprintf("cannot execute workstep with status: %s", target.status))
+			continue
+		}
+
+		for _, ws := range worksteps {
+			if ws.workstep.Cardinality < target.workstep.Cardinality && ws.status != workstepStatusCompleted {
+				fail(fmt.Sprintf("workstep executed out of order; preceding workstep %d has status: %s", ws.workstep.Cardinality, ws.status))
+				break
+			}
+		}
+
+		if !target.workstep.hasProver() {
+			fail("cannot execute workstep without prover")
+		}
+
+		if sample.Participant != nil {
+			authorized := false
+			for _, p := range target.participants {
+				if strings.EqualFold(p, *sample.Participant) {
+					authorized = true
+					break
+				}
+			}
+			if !authorized {
+				fail(fmt.Sprintf("participant %s is not authorized to execute workstep: %s", *sample.Participant, target.workstep.ID))
+			}
+		}
+
+		for _, err := range target.workstep.validatePayload(sample.Payload) {
+			if err.Field != nil {
+				fail(fmt.Sprintf("invalid payload; %s: %s", *err.Field, *err.Message))
+			} else {
+				fail(fmt.Sprintf("invalid payload; %s", *err.Message))
+			}
+		}
+
+		if len(step.Errors) > 0 {
+			continue
+		}
+
+		// stub the proof in lieu of invoking the prover
+		raw, _ := json.Marshal(sample.Payload)
+		step.Proof = common.StringOrNil(common.SHA256(string(raw)))
+
+		// write the record to the in-memory system of record
+		baselineID := ""
+		if id, idOk := sample.Payload.Object["baseline_id"].(string); idOk {
+			baselineID = id
+		}
+		object := map[string]interface{}{}
+		for k, v := range sample.Payload.Object {
+			object[k] = v
+		}
+		if recordID, recordOk := records[baselineID]; recordOk && baselineID != "" {
+			err := sor.UpdateObject(recordID, object)
+			if err != nil {
+				fail(err.Error())
+				continue
+			}
+			step.RecordID = common.StringOrNil(recordID)
+		} else {
+			resp, err := sor.CreateObject(object)
+			if err != nil {
+				fail(err.Error())
+				continue
+			}
+			if recordID, ok := resp.(map[string]interface{})["id"].(string); ok {
+				records[baselineID] = recordID
+				step.RecordID = common.StringOrNil(recordID)
+			}
+		}
+
+		transition := func(typ string, id uuid.UUID, from, to string) {
+			step.Transitions = append(step.Transitions, &SimulationTransition{
+				Type: typ,
+				ID:   id,
+				From: common.StringOrNil(from),
+				To:   common.StringOrNil(to),
+			})
+		}
+
+		if *result.Status == workflowStatusInit {
+			transition("workflow", workflowID, *result.Status, workflowStatusRunning)
+			result.Status = common.StringOrNil(workflowStatusRunning)
+		}
+
+		if target.status != workstepStatusRunning {
+			transition("workstep", target.workstep.ID, target.status, workstepStatusRunning)
+			target.status = workstepStatusRunning
+		}
+
+		if sample.Participant != nil {
+			target.witnessed[strings.ToLower(*sample.Participant)] = true
+		} else {
+			for _, p := range target.participants {
+				target.witnessed[strings.ToLower(p)] = true
+			}
+		}
+
+		if len(target.witnessed) >= len(target.participants) {
+			transition("workstep", target.workstep.ID, target.status, workstepStatusCompleted)
+			target.status = workstepStatusCompleted
+		}
+
+		if target.status == workstepStatusCompleted && target.workstep.Cardinality == len(worksteps) {
+			transition("workflow", workflowID, *result.Status, workflowStatusCompleted)
+			result.Status = common.StringOrNil(workflowStatusCompleted)
+		}
+	}
+
+	return result
+}
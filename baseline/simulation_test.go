@@ -0,0 +1,249 @@
+// +build unit
+
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package baseline
+
+import (
+	"encoding/json"
+	"fmt"
+
+	uuid "github.com/kthomas/go.uuid"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/provideplatform/baseline/common"
+	"github.com/provideplatform/provide-go/api/baseline"
+)
+
+const simulationParticipantA = "0x0a1b2c3d4e5f60718293a4b5c6d7e8f901234567"
+const simulationParticipantB = "0x1b2c3d4e5f60718293a4b5c6d7e8f9012345678a"
+
+func simulatedWorkstepFactory(cardinality int, metadata map[string]interface{}, participants ...string) *simulatedWorkstep {
+	raw, _ := json.Marshal(metadata)
+	rawMetadata := json.RawMessage(raw)
+
+	workstep := &Workstep{}
+	workstep.ID = uuid.Must(uuid.NewV4())
+	workstep.Name = common.StringOrNil(fmt.Sprintf("workstep %d", cardinality))
+	workstep.Cardinality = cardinality
+	workstep.Metadata = &rawMetadata
+
+	return &simulatedWorkstep{
+		workstep:     workstep,
+		status:       workstepStatusInit,
+		participants: participants,
+		witnessed:    map[string]bool{},
+	}
+}
+
+func simulationProverMetadata() map[string]interface{} {
+	return map[string]interface{}{
+		workstepMetadataProverKey: map[string]interface{}{"identifier": "cubic"},
+	}
+}
+
+func simulationStep(object map[string]interface{}) *WorkflowSimulationStepRequest {
+	return &WorkflowSimulationStepRequest{
+		Payload: &baseline.ProtocolMessagePayload{
+			Object: object,
+			Type:   common.StringOrNil("purchase_order"),
+		},
+	}
+}
+
+var _ = Describe("WorkflowSimulationRequest", func() {
+	It("should require at least one step", func() {
+		Expect((&WorkflowSimulationRequest{}).validate()).To(MatchError("at least one step is required"))
+	})
+
+	It("should reject null steps", func() {
+		var req *WorkflowSimulationRequest
+		Expect(json.Unmarshal([]byte(`{"steps": [{"payload": {"object": {}}}, null]}`), &req)).To(Succeed())
+		Expect(req.validate()).To(MatchError("step 1 is required"))
+	})
+
+	It("should require the payload of each step", func() {
+		req := &WorkflowSimulationRequest{Steps: []*WorkflowSimulationStepRequest{{}}}
+		Expect(req.validate()).To(MatchError("step 0: payload is required"))
+	})
+
+	It("should reject invalid cardinalities", func() {
+		step := simulationStep(map[string]interface{}{})
+		step.Cardinality = new(int)
+		Expect((&WorkflowSimulationRequest{Steps: []*WorkflowSimulationStepRequest{step}}).validate()).To(MatchError("step 0: invalid cardinality: 0"))
+	})
+
+	It("should accept valid steps", func() {
+		req := &WorkflowSimulationRequest{Steps: []*WorkflowSimulationStepRequest{simulationStep(map[string]interface{}{})}}
+		Expect(req.validate()).To(Succeed())
+	})
+})
+
+var _ = Describe("simulateWorksteps", func() {
+	var workflowID uuid.UUID
+	var worksteps []*simulatedWorkstep
+
+	BeforeEach(func() {
+		workflowID = uuid.Must(uuid.NewV4())
+		worksteps = []*simulatedWorkstep{
+			simulatedWorkstepFactory(1, simulationProverMetadata(), simulationParticipantA, simulationParticipantB),
+			simulatedWorkstepFactory(2, simulationProverMetadata(), simulationParticipantA, simulationParticipantB),
+		}
+	})
+
+	transition := func(typ string, id uuid.UUID, from, to string) *SimulationTransition {
+		return &SimulationTransition{Type: typ, ID: id, From: common.StringOrNil(from), To: common.StringOrNil(to)}
+	}
+
+	It("should transition the workflow and its worksteps to completed", func() {
+		result := simulateWorksteps(workflowID, worksteps, &WorkflowSimulationRequest{
+			Steps: []*WorkflowSimulationStepRequest{
+				simulationStep(map[string]interface{}{"baseline_id": "po-1", "amount": 100}),
+				simulationStep(map[string]interface{}{"baseline_id": "po-1", "amount": 150}),
+			},
+		})
+
+		Expect(*result.Status).To(Equal(workflowStatusCompleted))
+		Expect(result.Steps).To(HaveLen(2))
+
+		first := result.Steps[0]
+		Expect(first.Errors).To(BeEmpty())
+		Expect(*first.WorkstepID).To(Equal(worksteps[0].workstep.ID))
+		Expect(first.Proof).NotTo(BeNil())
+		Expect(first.RecordID).NotTo(BeNil())
+		Expect(first.Transitions).To(Equal([]*SimulationTransition{
+			transition("workflow", workflowID, workflowStatusInit, workflowStatusRunning),
+			transition("workstep", worksteps[0].workstep.ID, workstepStatusInit, workstepStatusRunning),
+			transition("workstep", worksteps[0].workstep.ID, workstepStatusRunning, workstepStatusCompleted),
+		}))
+
+		second := result.Steps[1]
+		Expect(second.Errors).To(BeEmpty())
+		Expect(*second.WorkstepID).To(Equal(worksteps[1].workstep.ID))
+		Expect(*second.RecordID).To(Equal(*first.RecordID)) // the record of the baseline id is updated
+		Expect(second.Transitions).To(Equal([]*SimulationTransition{
+			transition("workstep", worksteps[1].workstep.ID, workstepStatusInit, workstepStatusRunning),
+			transition("workstep", worksteps[1].workstep.ID, workstepStatusRunning, workstepStatusCompleted),
+			transition("workflow", workflowID, workflowStatusRunning, workflowStatusCompleted),
+		}))
+	})
+
+	It("should complete a workstep once each participant has witnessed it", func() {
+		first := simulationStep(map[string]interface{}{})
+		first.Participant = common.StringOrNil(simulationParticipantA)
+		second := simulationStep(map[string]interface{}{})
+		second.Participant = common.StringOrNil(simulationParticipantB)
+
+		result := simulateWorksteps(workflowID, worksteps, &WorkflowSimulationRequest{
+			Steps: []*WorkflowSimulationStepRequest{first, second},
+		})
+
+		Expect(*result.Status).To(Equal(workflowStatusRunning))
+		Expect(result.Steps[0].Transitions).To(HaveLen(2))
+		Expect(result.Steps[1].Errors).To(BeEmpty())
+		Expect(*result.Steps[1].WorkstepID).To(Equal(worksteps[0].workstep.ID))
+		Expect(result.Steps[1].Transitions).To(Equal([]*SimulationTransition{
+			transition("workstep", worksteps[0].workstep.ID, workstepStatusRunning, workstepStatusCompleted),
+		}))
+	})
+
+	It("should match participants case-insensitively", func() {
+		step := simulationStep(map[string]interface{}{})
+		step.Participant = common.StringOrNil("0X0A1B2C3D4E5F60718293A4B5C6D7E8F901234567")
+
+		result := simulateWorksteps(workflowID, worksteps, &WorkflowSimulationRequest{
+			Steps: []*WorkflowSimulationStepRequest{step},
+		})
+		Expect(result.Steps[0].Errors).To(BeEmpty())
+	})
+
+	It("should reject participants which are not authorized to execute the workstep", func() {
+		step := simulationStep(map[string]interface{}{})
+		step.Participant = common.StringOrNil("0xdeadbeef")
+
+		result := simulateWorksteps(workflowID, worksteps, &WorkflowSimulationRequest{
+			Steps: []*WorkflowSimulationStepRequest{step},
+		})
+		Expect(result.Steps[0].Errors).To(HaveLen(1))
+		Expect(*result.Steps[0].Errors[0].Message).To(ContainSubstring("is not authorized to execute workstep"))
+		Expect(result.Steps[0].Transitions).To(BeEmpty())
+		Expect(*result.Status).To(Equal(workflowStatusInit))
+	})
+
+	It("should reject worksteps executed out of order", func() {
+		step := simulationStep(map[string]interface{}{})
+		cardinality := 2
+		step.Cardinality = &cardinality
+
+		result := simulateWorksteps(workflowID, worksteps, &WorkflowSimulationRequest{
+			Steps: []*WorkflowSimulationStepRequest{step},
+		})
+		Expect(result.Steps[0].Errors).To(HaveLen(1))
+		Expect(*result.Steps[0].Errors[0].Message).To(ContainSubstring("workstep executed out of order"))
+	})
+
+	It("should reject worksteps without a prover", func() {
+		worksteps[0] = simulatedWorkstepFactory(1, map[string]interface{}{}, simulationParticipantA)
+
+		result := simulateWorksteps(workflowID, worksteps, &WorkflowSimulationRequest{
+			Steps: []*WorkflowSimulationStepRequest{simulationStep(map[string]interface{}{})},
+		})
+		Expect(result.Steps[0].Errors).To(HaveLen(1))
+		Expect(*result.Steps[0].Errors[0].Message).To(Equal("cannot execute workstep without prover"))
+	})
+
+	It("should reject payloads which do not validate against the workstep schema", func() {
+		metadata := simulationProverMetadata()
+		metadata[workstepMetadataSchemaKey] = map[string]interface{}{
+			"type":     "object",
+			"required": []interface{}{"amount"},
+		}
+		worksteps[0] = simulatedWorkstepFactory(1, metadata, simulationParticipantA)
+
+		result := simulateWorksteps(workflowID, worksteps, &WorkflowSimulationRequest{
+			Steps: []*WorkflowSimulationStepRequest{simulationStep(map[string]interface{}{})},
+		})
+		Expect(result.Steps[0].Errors).To(HaveLen(1))
+		Expect(*result.Steps[0].Errors[0].Message).To(HavePrefix("invalid payload"))
+		Expect(result.Steps[0].Proof).To(BeNil())
+	})
+
+	It("should reject steps once the workflow is completed", func() {
+		result := simulateWorksteps(workflowID, worksteps, &WorkflowSimulationRequest{
+			Steps: []*WorkflowSimulationStepRequest{
+				simulationStep(map[string]interface{}{}),
+				simulationStep(map[string]interface{}{}),
+				simulationStep(map[string]interface{}{}),
+			},
+		})
+		Expect(*result.Status).To(Equal(workflowStatusCompleted))
+		Expect(result.Steps[2].Errors).To(HaveLen(1))
+		Expect(*result.Steps[2].Errors[0].Message).To(Equal("no executable workstep resolved"))
+	})
+
+	It("should reject steps targeting a completed workstep", func() {
+		step := simulationStep(map[string]interface{}{})
+		cardinality := 1
+		step.Cardinality = &cardinality
+
+		result := simulateWorksteps(workflowID, worksteps, &WorkflowSimulationRequest{
+			Steps: []*WorkflowSimulationStepRequest{simulationStep(map[string]interface{}{}), step},
+		})
+		Expect(result.Steps[1].Errors).To(HaveLen(1))
+		Expect(*result.Steps[1].Errors[0].Message).To(Equal("cannot execute workstep with status: completed"))
+	})
+})
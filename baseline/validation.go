@@ -0,0 +1,393 @@
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package baseline
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	uuid "github.com/kthomas/go.uuid"
+	"github.com/provideplatform/baseline/common"
+	"github.com/provideplatform/provide-go/api/baseline"
+)
+
+// workstep metadata keys which reference the payload validation rules
+const workstepMetadataMappingKey = "mapping"
+const workstepMetadataSchemaKey = "schema"
+
+// PayloadValidationError is a field-level workstep payload validation error
+type PayloadValidationError struct {
+	Field   *string `json:"field,omitempty"`
+	Message *string `json:"message"`
+}
+
+func payloadValidationErrorFactory(field, msg string) *PayloadValidationError {
+	err := &PayloadValidationError{
+		Message: common.StringOrNil(msg),
+	}
+	if field != "" {
+		err.Field = common.StringOrNil(field)
+	}
+	return err
+}
+
+// validatePayload validates the given payload against the mapping model or JSON schema referenced
+// by the workstep metadata, i.e. {"mapping": {"id": "<mapping id>", "model": "<model type>"}} or
+// {"schema": {...}}; no validation is performed when neither is referenced
+func (w *Workstep) validatePayload(payload *baseline.ProtocolMessagePayload) []*PayloadValidationError {
+	errs := make([]*PayloadValidationError, 0)
+	if payload == nil {
+		return append(errs, payloadValidationErrorFactory("", "payload is required"))
+	}
+
+	metadata := w.ParseMetadata()
+	if metadata == nil {
+		return errs
+	}
+
+	if ref, refOk := metadata[workstepMetadataMappingKey].(map[string]interface{}); refOk {
+		model, err := resolveWorkstepMappingModel(ref, payload.Type)
+		if err != nil {
+			return append(errs, payloadValidationErrorFactory("", err.Error()))
+		}
+		errs = append(errs, validateObjectAgainstMappingModel(model, payload.Object)...)
+	}
+
+	if schema, schemaOk := metadata[workstepMetadataSchemaKey].(map[string]interface{}); schemaOk {
+		var object interface{}
+		if payload.Object != nil {
+			object = payload.Object
+		}
+		errs = append(errs, validateJSONSchema(schema, object, "")...)
+	}
+
+	return errs
+}
+
+// validatePayloadReference ensures the validation rules referenced by the workstep metadata resolve
+func (w *Workstep) validatePayloadReference() error {
+	metadata := w.ParseMetadata()
+	if metadata == nil {
+		return nil
+	}
+
+	if ref, refOk := metadata[workstepMetadataMappingKey].(map[string]interface{}); refOk {
+		if _, err := resolveWorkstepMapping(ref); err != nil {
+			return err
+		}
+	}
+
+	if schema, schemaOk := metadata[workstepMetadataSchemaKey]; schemaOk {
+		if _, ok := schema.(map[string]interface{}); !ok {
+			return fmt.Errorf("workstep schema must be a JSON schema object")
+		}
+	}
+
+	return nil
+}
+
+func resolveWorkstepMapping(ref map[string]interface{}) (*Mapping, error) {
+	mappingID, _ := ref["id"].(string)
+	mappingUUID, err := uuid.FromString(mappingID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid workstep mapping id: %s", mappingID)
+	}
+
+	mapping := FindMappingByID(mappingUUID)
+	if mapping == nil {
+		return nil, fmt.Errorf("workstep mapping not found: %s", mappingID)
+	}
+
+	return mapping, nil
+}
+
+// resolveWorkstepMappingModel resolves the mapping model against which payloads are validated; the model
+// is resolved using the referenced model type, the payload type or, failing those, the lone mapping model
+func resolveWorkstepMappingModel(ref map[string]interface{}, payloadType *string) (*MappingModel, error) {
+	mapping, err := resolveWorkstepMapping(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	modelType, _ := ref["model"].(string)
+	if modelType == "" && payloadType != nil {
+		modelType = *payloadType
+	}
+
+	for _, model := range mapping.Models {
+		if model.Type != nil && *model.Type == modelType {
+			return model, nil
+		}
+	}
+
+	if modelType == "" && len(mapping.Models) == 1 {
+		return mapping.Models[0], nil
+	}
+
+	return nil, fmt.Errorf("failed to resolve mapping model for type: %s", modelType)
+}
+
+// validateObjectAgainstMappingModel ensures the object provides each primary key field, contains only
+// mapped fields and that the values are compatible with the mapped field types
+func validateObjectAgainstMappingModel(model *MappingModel, object map[string]interface{}) []*PayloadValidationError {
+	errs := make([]*PayloadValidationError, 0)
+	fields := map[string]*MappingField{}
+
+	for _, field := range model.Fields {
+		fields[field.Name] = field
+
+		val, valOk := object[field.Name]
+		if !valOk || val == nil {
+			if field.IsPrimaryKey && field.DefaultValue == nil {
+				errs = append(errs, payloadValidationErrorFactory(field.Name, "primary key field is required"))
+			}
+			continue
+		}
+
+		if msg := validateMappingFieldType(field.Type, val); msg != nil {
+			errs = append(errs, payloadValidationErrorFactory(field.Name, *msg))
+		}
+	}
+
+	keys := make([]string, 0)
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if key == "baseline_id" {
+			continue
+		}
+		if _, ok := fields[key]; !ok {
+			modelType := ""
+			if model.Type != nil {
+				modelType = *model.Type
+			}
+			errs = append(errs, payloadValidationErrorFactory(key, fmt.Sprintf("field is not mapped by model: %s", modelType)))
+		}
+	}
+
+	return errs
+}
+
+// validateMappingFieldType checks the value against the mapping field type; unrecognized types are not checked
+func validateMappingFieldType(fieldType string, val interface{}) *string {
+	typ := strings.ToLower(fieldType)
+	switch typ {
+	case "string", "text":
+		if _, ok := val.(string); !ok {
+			return common.StringOrNil(fmt.Sprintf("expected %s value", fieldType))
+		}
+	case "number", "integer", "int", "float", "decimal":
+		n, ok := val.(float64)
+		if !ok {
+			return common.StringOrNil(fmt.Sprintf("expected %s value", fieldType))
+		}
+		if (typ == "integer" || typ == "int") && n != float64(int64(n)) {
+			return common.StringOrNil(fmt.Sprintf("expected %s value", fieldType))
+		}
+	case "boolean", "bool":
+		if _, ok := val.(bool); !ok {
+			return common.StringOrNil(fmt.Sprintf("expected %s value", fieldType))
+		}
+	case "object":
+		if _, ok := val.(map[string]interface{}); !ok {
+			return common.StringOrNil(fmt.Sprintf("expected %s value", fieldType))
+		}
+	case "array":
+		if _, ok := val.([]interface{}); !ok {
+			return common.StringOrNil(fmt.Sprintf("expected %s value", fieldType))
+		}
+	case "date", "datetime", "date-time", "timestamp":
+		str, ok := val.(string)
+		if !ok {
+			return common.StringOrNil(fmt.Sprintf("expected %s value", fieldType))
+		}
+		if _, err := time.Parse(time.RFC3339, str); err != nil {
+			if _, err := time.Parse("2006-01-02", str); err != nil {
+				return common.StringOrNil(fmt.Sprintf("expected %s value; %s", fieldType, err.Error()))
+			}
+		}
+	}
+
+	return nil
+}
+
+// validateJSONSchema validates the value against the commonly-used subset of JSON schema keywords:
+// type, properties, required, additionalProperties, items, enum, minLength, maxLength, pattern,
+// minimum, maximum, minItems, maxItems and format (date-time, date)
+func validateJSONSchema(schema map[string]interface{}, val interface{}, path string) []*PayloadValidationError {
+	errs := make([]*PayloadValidationError, 0)
+	field := path
+
+	if typ, ok := schema["type"]; ok {
+		types := make([]string, 0)
+		switch t := typ.(type) {
+		case string:
+			types = append(types, t)
+		case []interface{}:
+			for _, item := range t {
+				if str, ok := item.(string); ok {
+					types = append(types, str)
+				}
+			}
+		}
+
+		matched := false
+		for _, t := range types {
+			if jsonSchemaTypeMatches(t, val) {
+				matched = true
+				break
+			}
+		}
+
+		if !matched {
+			return append(errs, payloadValidationErrorFactory(field, fmt.Sprintf("expected %s value", strings.Join(types, " or "))))
+		}
+	}
+
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, item := range enum {
+			if reflect.DeepEqual(item, val) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			errs = append(errs, payloadValidationErrorFactory(field, "value is not one of the enumerated values"))
+		}
+	}
+
+	switch v := val.(type) {
+	case map[string]interface{}:
+		properties, _ := schema["properties"].(map[string]interface{})
+
+		if required, ok := schema["required"].([]interface{}); ok {
+			for _, item := range required {
+				name, _ := item.(string)
+				if _, present := v[name]; !present {
+					errs = append(errs, payloadValidationErrorFactory(jsonSchemaPath(path, name), "field is required"))
+				}
+			}
+		}
+
+		keys := make([]string, 0)
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			if propertySchema, ok := properties[key].(map[string]interface{}); ok {
+				errs = append(errs, validateJSONSchema(propertySchema, v[key], jsonSchemaPath(path, key))...)
+			} else if additional, ok := schema["additionalProperties"].(bool); ok && !additional {
+				errs = append(errs, payloadValidationErrorFactory(jsonSchemaPath(path, key), "additional field is not permitted"))
+			} else if additional, ok := schema["additionalProperties"].(map[string]interface{}); ok {
+				errs = append(errs, validateJSONSchema(additional, v[key], jsonSchemaPath(path, key))...)
+			}
+		}
+
+	case []interface{}:
+		if min, ok := schema["minItems"].(float64); ok && float64(len(v)) < min {
+			errs = append(errs, payloadValidationErrorFactory(field, fmt.Sprintf("expected at least %v item(s)", min)))
+		}
+		if max, ok := schema["maxItems"].(float64); ok && float64(len(v)) > max {
+			errs = append(errs, payloadValidationErrorFactory(field, fmt.Sprintf("expected at most %v item(s)", max)))
+		}
+		if items, ok := schema["items"].(map[string]interface{}); ok {
+			for i, item := range v {
+				errs = append(errs, validateJSONSchema(items, item, fmt.Sprintf("%s[%d]", path, i))...)
+			}
+		}
+
+	case string:
+		if min, ok := schema["minLength"].(float64); ok && float64(len([]rune(v))) < min {
+			errs = append(errs, payloadValidationErrorFactory(field, fmt.Sprintf("expected a minimum length of %v", min)))
+		}
+		if max, ok := schema["maxLength"].(float64); ok && float64(len([]rune(v))) > max {
+			errs = append(errs, payloadValidationErrorFactory(field, fmt.Sprintf("expected a maximum length of %v", max)))
+		}
+		if pattern, ok := schema["pattern"].(string); ok {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				errs = append(errs, payloadValidationErrorFactory(field, fmt.Sprintf("invalid schema pattern: %s", pattern)))
+			} else if !re.MatchString(v) {
+				errs = append(errs, payloadValidationErrorFactory(field, fmt.Sprintf("value does not match pattern: %s", pattern)))
+			}
+		}
+		if format, ok := schema["format"].(string); ok {
+			if format == "date-time" {
+				if _, err := time.Parse(time.RFC3339, v); err != nil {
+					errs = append(errs, payloadValidationErrorFactory(field, "expected date-time value"))
+				}
+			} else if format == "date" {
+				if _, err := time.Parse("2006-01-02", v); err != nil {
+					errs = append(errs, payloadValidationErrorFactory(field, "expected date value"))
+				}
+			}
+		}
+
+	case float64:
+		if min, ok := schema["minimum"].(float64); ok && v < min {
+			errs = append(errs, payloadValidationErrorFactory(field, fmt.Sprintf("expected a minimum value of %v", min)))
+		}
+		if max, ok := schema["maximum"].(float64); ok && v > max {
+			errs = append(errs, payloadValidationErrorFactory(field, fmt.Sprintf("expected a maximum value of %v", max)))
+		}
+	}
+
+	return errs
+}
+
+func jsonSchemaTypeMatches(typ string, val interface{}) bool {
+	switch typ {
+	case "object":
+		_, ok := val.(map[string]interface{})
+		return ok
+	case "array":
+		_, ok := val.([]interface{})
+		return ok
+	case "string":
+		_, ok := val.(string)
+		return ok
+	case "number":
+		_, ok := val.(float64)
+		return ok
+	case "integer":
+		n, ok := val.(float64)
+		return ok && n == float64(int64(n))
+	case "boolean":
+		_, ok := val.(bool)
+		return ok
+	case "null":
+		return val == nil
+	}
+	return false
+}
+
+func jsonSchemaPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return fmt.Sprintf("%s.%s", path, key)
+}
@@ -0,0 +1,219 @@
+// +build unit
+
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package baseline
+
+import (
+	"encoding/json"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"github.com/provideplatform/baseline/common"
+	"github.com/provideplatform/provide-go/api/baseline"
+)
+
+func payloadValidationSchemaFactory(raw string) map[string]interface{} {
+	var schema map[string]interface{}
+	err := json.Unmarshal([]byte(raw), &schema)
+	Expect(err).NotTo(HaveOccurred())
+	return schema
+}
+
+func payloadValidationErrorFields(errs []*PayloadValidationError) []string {
+	fields := make([]string, 0)
+	for _, err := range errs {
+		field := ""
+		if err.Field != nil {
+			field = *err.Field
+		}
+		fields = append(fields, field)
+	}
+	return fields
+}
+
+var _ = Describe("Payload validation", func() {
+	DescribeTable("validateMappingFieldType",
+		func(fieldType string, val interface{}, valid bool) {
+			msg := validateMappingFieldType(fieldType, val)
+			if valid {
+				Expect(msg).To(BeNil())
+			} else {
+				Expect(msg).NotTo(BeNil())
+			}
+		},
+		Entry("string", "string", "abc", true),
+		Entry("string mismatch", "string", 1.0, false),
+		Entry("text", "Text", "abc", true),
+		Entry("number", "number", 1.5, true),
+		Entry("number mismatch", "number", "1.5", false),
+		Entry("integer", "integer", 2.0, true),
+		Entry("fractional integer", "integer", 2.5, false),
+		Entry("fractional mixed-case integer", "Integer", 2.5, false),
+		Entry("fractional upper-case int", "INT", 2.5, false),
+		Entry("decimal", "Decimal", 2.5, true),
+		Entry("boolean", "bool", true, true),
+		Entry("boolean mismatch", "Boolean", "true", false),
+		Entry("object", "object", map[string]interface{}{}, true),
+		Entry("array", "array", []interface{}{}, true),
+		Entry("array mismatch", "array", map[string]interface{}{}, false),
+		Entry("RFC3339 date-time", "DateTime", "2022-03-01T12:00:00Z", true),
+		Entry("date", "date", "2022-03-01", true),
+		Entry("invalid date", "date", "03/01/2022", false),
+		Entry("unrecognized type", "uuid", 1.0, true),
+	)
+
+	DescribeTable("validateJSONSchema type",
+		func(schema string, val interface{}, valid bool) {
+			errs := validateJSONSchema(payloadValidationSchemaFactory(schema), val, "")
+			Expect(len(errs) == 0).To(Equal(valid))
+		},
+		Entry("string", `{"type": "string"}`, "abc", true),
+		Entry("string mismatch", `{"type": "string"}`, 1.0, false),
+		Entry("integer", `{"type": "integer"}`, 3.0, true),
+		Entry("fractional integer", `{"type": "integer"}`, 3.5, false),
+		Entry("number", `{"type": "number"}`, 3.5, true),
+		Entry("boolean", `{"type": "boolean"}`, false, true),
+		Entry("null", `{"type": "null"}`, nil, true),
+		Entry("nullable string", `{"type": ["string", "null"]}`, nil, true),
+		Entry("nullable string mismatch", `{"type": ["string", "null"]}`, 1.0, false),
+		Entry("object", `{"type": "object"}`, map[string]interface{}{}, true),
+		Entry("array mismatch", `{"type": "array"}`, map[string]interface{}{}, false),
+	)
+
+	DescribeTable("validateJSONSchema required",
+		func(val map[string]interface{}, expected []string) {
+			schema := payloadValidationSchemaFactory(`{
+				"type": "object",
+				"required": ["id", "total"],
+				"properties": {
+					"id": {"type": "string"},
+					"total": {"type": "number"}
+				}
+			}`)
+			errs := validateJSONSchema(schema, val, "")
+			Expect(payloadValidationErrorFields(errs)).To(Equal(expected))
+		},
+		Entry("all present", map[string]interface{}{"id": "po-1", "total": 10.0}, []string{}),
+		Entry("one missing", map[string]interface{}{"id": "po-1"}, []string{"total"}),
+		Entry("all missing", map[string]interface{}{}, []string{"id", "total"}),
+		Entry("present with mismatched type", map[string]interface{}{"id": 1.0, "total": 10.0}, []string{"id"}),
+	)
+
+	DescribeTable("validateJSONSchema enum",
+		func(val interface{}, valid bool) {
+			schema := payloadValidationSchemaFactory(`{"enum": ["draft", "approved", 1]}`)
+			errs := validateJSONSchema(schema, val, "")
+			Expect(len(errs) == 0).To(Equal(valid))
+		},
+		Entry("enumerated string", "approved", true),
+		Entry("enumerated number", 1.0, true),
+		Entry("unenumerated string", "rejected", false),
+		Entry("case mismatch", "Draft", false),
+		Entry("unenumerated number", 2.0, false),
+	)
+
+	DescribeTable("validateJSONSchema schema",
+		func(val map[string]interface{}, expected []string) {
+			schema := payloadValidationSchemaFactory(`{
+				"type": "object",
+				"additionalProperties": false,
+				"properties": {
+					"code": {"type": "string", "pattern": "^[A-Z]{3}$"},
+					"name": {"type": "string", "minLength": 2, "maxLength": 8},
+					"quantity": {"type": "integer", "minimum": 1, "maximum": 10},
+					"delivered_at": {"type": "string", "format": "date-time"},
+					"lines": {
+						"type": "array",
+						"minItems": 1,
+						"items": {
+							"type": "object",
+							"required": ["sku"],
+							"properties": {"sku": {"type": "string"}}
+						}
+					}
+				}
+			}`)
+			errs := validateJSONSchema(schema, val, "")
+			Expect(payloadValidationErrorFields(errs)).To(Equal(expected))
+		},
+		Entry("valid", map[string]interface{}{
+			"code":         "ABC",
+			"name":         "widget",
+			"quantity":     5.0,
+			"delivered_at": "2022-03-01T12:00:00Z",
+			"lines":        []interface{}{map[string]interface{}{"sku": "w-1"}},
+		}, []string{}),
+		Entry("pattern mismatch", map[string]interface{}{"code": "abc"}, []string{"code"}),
+		Entry("too short", map[string]interface{}{"name": "w"}, []string{"name"}),
+		Entry("too long", map[string]interface{}{"name": "widgets and gadgets"}, []string{"name"}),
+		Entry("below minimum", map[string]interface{}{"quantity": 0.0}, []string{"quantity"}),
+		Entry("above maximum", map[string]interface{}{"quantity": 11.0}, []string{"quantity"}),
+		Entry("invalid date-time", map[string]interface{}{"delivered_at": "yesterday"}, []string{"delivered_at"}),
+		Entry("too few items", map[string]interface{}{"lines": []interface{}{}}, []string{"lines"}),
+		Entry("nested required", map[string]interface{}{"lines": []interface{}{map[string]interface{}{}}}, []string{"lines[0].sku"}),
+		Entry("additional field", map[string]interface{}{"color": "red"}, []string{"color"}),
+	)
+
+	DescribeTable("validateObjectAgainstMappingModel",
+		func(object map[string]interface{}, expected []string) {
+			model := &MappingModel{}
+			model.Type = common.StringOrNil("purchase_order")
+			for _, field := range []baseline.MappingField{
+				{Name: "id", Type: "string", IsPrimaryKey: true},
+				{Name: "quantity", Type: "Integer"},
+				{Name: "approved", Type: "boolean"},
+			} {
+				model.Fields = append(model.Fields, &MappingField{MappingField: field})
+			}
+
+			errs := validateObjectAgainstMappingModel(model, object)
+			Expect(payloadValidationErrorFields(errs)).To(Equal(expected))
+		},
+		Entry("valid", map[string]interface{}{"id": "po-1", "quantity": 2.0, "approved": true}, []string{}),
+		Entry("baseline id is permitted", map[string]interface{}{"id": "po-1", "baseline_id": "x"}, []string{}),
+		Entry("missing primary key", map[string]interface{}{"quantity": 2.0}, []string{"id"}),
+		Entry("fractional integer", map[string]interface{}{"id": "po-1", "quantity": 2.5}, []string{"quantity"}),
+		Entry("unmapped field", map[string]interface{}{"id": "po-1", "color": "red"}, []string{"color"}),
+	)
+
+	Describe("validatePayload", func() {
+		It("requires a payload", func() {
+			workstep := &Workstep{}
+			Expect(workstep.validatePayload(nil)).To(HaveLen(1))
+		})
+
+		It("performs no validation without a mapping or schema reference", func() {
+			workstep := &Workstep{}
+			payload := &baseline.ProtocolMessagePayload{Object: map[string]interface{}{"anything": true}}
+			Expect(workstep.validatePayload(payload)).To(BeEmpty())
+		})
+
+		It("validates the payload object against the referenced schema", func() {
+			metadata := json.RawMessage(`{"schema": {"type": "object", "required": ["id"]}}`)
+			workstep := &Workstep{}
+			workstep.Metadata = &metadata
+
+			payload := &baseline.ProtocolMessagePayload{Object: map[string]interface{}{}}
+			Expect(payloadValidationErrorFields(workstep.validatePayload(payload))).To(Equal([]string{"id"}))
+
+			payload.Object["id"] = "po-1"
+			Expect(workstep.validatePayload(payload)).To(BeEmpty())
+		})
+	})
+})
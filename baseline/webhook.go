@@ -0,0 +1,560 @@
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package baseline
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql/driver"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+
+	dbconf "github.com/kthomas/go-db-config"
+	natsutil "github.com/kthomas/go-natsutil"
+	uuid "github.com/kthomas/go.uuid"
+	"github.com/provideplatform/baseline/common"
+	provide "github.com/provideplatform/provide-go/api"
+)
+
+// webhook lifecycle events
+const webhookEventWorkflowDeployed = "workflow.deployed"
+const webhookEventWorkflowInstanceCreated = "workflow.instance.created"
+const webhookEventWorkflowCompleted = "workflow.completed"
+const webhookEventWorkstepExecuted = "workstep.executed"
+const webhookEventWorkstepCompleted = "workstep.completed"
+const webhookEventProtocolMessageReceived = "protocol_message.received"
+const webhookEventProtocolMessageFailed = "protocol_message.failed"
+
+var webhookEvents = []string{
+	webhookEventWorkflowDeployed,
+	webhookEventWorkflowInstanceCreated,
+	webhookEventWorkflowCompleted,
+	webhookEventWorkstepExecuted,
+	webhookEventWorkstepCompleted,
+	webhookEventProtocolMessageReceived,
+	webhookEventProtocolMessageFailed,
+}
+
+const webhookDeliveryStatusPending = "pending"
+const webhookDeliveryStatusDelivered = "delivered"
+const webhookDeliveryStatusFailed = "failed"
+
+const webhookDeliveryMaxAttempts = 8
+const webhookDeliveryInitialBackoff = time.Second * 30
+const webhookDeliveryMaxBackoff = time.Hour
+const webhookDeliveryTimeout = time.Second * 10
+
+const webhookHeaderDelivery = "X-Baseline-Delivery"
+const webhookHeaderEvent = "X-Baseline-Event"
+const webhookHeaderSignature = "X-Baseline-Signature"
+
+// webhookRestrictedNetworks are the private, shared, loopback and link-local ranges to which
+// webhooks are never delivered
+var webhookRestrictedNetworks = func() []*net.IPNet {
+	networks := make([]*net.IPNet, 0)
+	for _, cidr := range []string{
+		"0.0.0.0/8",
+		"10.0.0.0/8",
+		"100.64.0.0/10",
+		"127.0.0.0/8",
+		"169.254.0.0/16",
+		"172.16.0.0/12",
+		"192.168.0.0/16",
+		"::/128",
+		"::1/128",
+		"fc00::/7",
+		"fe80::/10",
+	} {
+		_, network, _ := net.ParseCIDR(cidr)
+		networks = append(networks, network)
+	}
+	return networks
+}()
+
+// webhookHTTPClient refuses to connect to restricted addresses at dial time, so webhook hosts
+// which resolve (or are rebound) to a restricted address after validation are still rejected
+var webhookHTTPClient = &http.Client{
+	Timeout: webhookDeliveryTimeout,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: webhookDeliveryTimeout,
+			Control: func(network, address string, c syscall.RawConn) error {
+				host, _, err := net.SplitHostPort(address)
+				if err != nil {
+					return err
+				}
+				if ip := net.ParseIP(host); ip == nil || isWebhookRestrictedIP(ip) {
+					return fmt.Errorf("webhook delivery to restricted address is not permitted: %s", host)
+				}
+				return nil
+			},
+		}).DialContext,
+		TLSHandshakeTimeout: webhookDeliveryTimeout,
+	},
+}
+
+// isWebhookRestrictedIP returns true if the given address is within a restricted network
+func isWebhookRestrictedIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsMulticast() {
+		return true
+	}
+
+	for _, network := range webhookRestrictedNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// validateWebhookHost resolves the given host and ensures none of its addresses are restricted
+func validateWebhookHost(host string) error {
+	addrs, err := net.DefaultResolver.LookupIPAddr(context.Background(), host)
+	if err != nil {
+		return fmt.Errorf("failed to resolve webhook host: %s", host)
+	}
+
+	for _, addr := range addrs {
+		if isWebhookRestrictedIP(addr.IP) {
+			return fmt.Errorf("webhook host resolves to a restricted address: %s", host)
+		}
+	}
+
+	return nil
+}
+
+// WebhookEvents is the list of events to which a webhook is subscribed
+type WebhookEvents []string
+
+// Value implements driver.Valuer
+func (e WebhookEvents) Value() (driver.Value, error) {
+	if e == nil {
+		return "[]", nil
+	}
+	raw, err := json.Marshal([]string(e))
+	return string(raw), err
+}
+
+// Scan implements sql.Scanner
+func (e *WebhookEvents) Scan(src interface{}) error {
+	switch val := src.(type) {
+	case []byte:
+		return json.Unmarshal(val, e)
+	case string:
+		return json.Unmarshal([]byte(val), e)
+	case nil:
+		*e = nil
+		return nil
+	default:
+		return fmt.Errorf("failed to scan webhook events from %T", src)
+	}
+}
+
+func (e WebhookEvents) includes(event string) bool {
+	for _, evt := range e {
+		if evt == event || evt == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// Webhook is an organization subscription to workflow and workstep lifecycle events
+type Webhook struct {
+	provide.Model
+	OrganizationID *uuid.UUID    `json:"organization_id"`
+	WorkgroupID    *uuid.UUID    `json:"workgroup_id"`
+	URL            *string       `json:"url"`
+	Description    *string       `json:"description"`
+	Events         WebhookEvents `sql:"type:json" json:"events"`
+	Secret         *string       `json:"secret,omitempty"`
+	Active         *bool         `json:"active"`
+}
+
+// WebhookDelivery is a single delivery of an event to a webhook
+type WebhookDelivery struct {
+	provide.Model
+	WebhookID      uuid.UUID        `json:"webhook_id"`
+	Event          *string          `json:"event"`
+	Payload        *json.RawMessage `sql:"type:json" json:"payload"`
+	Status         *string          `json:"status"`
+	Attempts       int              `json:"attempts"`
+	ResponseStatus *int             `json:"response_status"`
+	Error          *string          `json:"error"`
+	LastAttemptAt  *time.Time       `json:"last_attempt_at"`
+	NextAttemptAt  *time.Time       `json:"next_attempt_at"`
+	DeliveredAt    *time.Time       `json:"delivered_at"`
+}
+
+// FindWebhookByID finds a webhook for the given id
+func FindWebhookByID(id uuid.UUID) *Webhook {
+	db := dbconf.DatabaseConnection()
+	webhook := &Webhook{}
+	db.Where("id = ?", id.String()).Find(&webhook)
+	if webhook == nil || webhook.ID == uuid.Nil {
+		return nil
+	}
+	return webhook
+}
+
+// FindWebhookDeliveryByID finds a webhook delivery for the given id
+func FindWebhookDeliveryByID(id uuid.UUID) *WebhookDelivery {
+	db := dbconf.DatabaseConnection()
+	delivery := &WebhookDelivery{}
+	db.Where("id = ?", id.String()).Find(&delivery)
+	if delivery == nil || delivery.ID == uuid.Nil {
+		return nil
+	}
+	return delivery
+}
+
+func webhookSecretFactory() (*string, error) {
+	buf := make([]byte, 32)
+	_, err := io.ReadFull(rand.Reader, buf)
+	if err != nil {
+		return nil, err
+	}
+	return common.StringOrNil(hex.EncodeToString(buf)), nil
+}
+
+// webhookSignatureFactory returns the signature header value for the given payload; the HMAC-SHA256
+// digest is computed over the timestamp and the payload, joined by a period, using the webhook secret
+func webhookSignatureFactory(secret string, timestamp int64, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(fmt.Sprintf("%d.", timestamp)))
+	mac.Write(payload)
+	return fmt.Sprintf("t=%d,v1=%s", timestamp, hex.EncodeToString(mac.Sum(nil)))
+}
+
+// webhookDeliveryBackoff returns the delay before the next attempt following the given number of attempts
+func webhookDeliveryBackoff(attempts int) time.Duration {
+	backoff := webhookDeliveryInitialBackoff
+	for i := 1; i < attempts; i++ {
+		backoff *= 2
+		if backoff >= webhookDeliveryMaxBackoff {
+			return webhookDeliveryMaxBackoff
+		}
+	}
+	return backoff
+}
+
+// dispatchWebhookEvent creates a delivery of the event for each active webhook of the organization
+// subscribed to it and enqueues the deliveries; failures are logged and never surfaced to the caller
+func dispatchWebhookEvent(organizationID, workgroupID *uuid.UUID, event string, data interface{}) {
+	if organizationID == nil {
+		common.Log.Debugf("skipping dispatch of %s webhook event; no organization id provided", event)
+		return
+	}
+
+	db := dbconf.DatabaseConnection()
+	webhooks := make([]*Webhook, 0)
+	query := db.Where("organization_id = ? AND active = true", organizationID)
+	if workgroupID != nil {
+		query = query.Where("workgroup_id IS NULL OR workgroup_id = ?", workgroupID)
+	} else {
+		query = query.Where("workgroup_id IS NULL")
+	}
+	query.Find(&webhooks)
+
+	for _, webhook := range webhooks {
+		if !webhook.Events.includes(event) {
+			continue
+		}
+
+		deliveryID, _ := uuid.NewV4()
+		raw, _ := json.Marshal(map[string]interface{}{
+			"id":              deliveryID.String(),
+			"event":           event,
+			"organization_id": organizationID,
+			"workgroup_id":    workgroupID,
+			"created_at":      time.Now(),
+			"data":            data,
+		})
+		payload := json.RawMessage(raw)
+
+		delivery := &WebhookDelivery{
+			WebhookID: webhook.ID,
+			Event:     common.StringOrNil(event),
+			Payload:   &payload,
+			Status:    common.StringOrNil(webhookDeliveryStatusPending),
+		}
+		delivery.ID = deliveryID
+
+		result := db.Create(&delivery)
+		if err := result.Error; err != nil {
+			common.Log.Warningf("failed to create %s delivery for webhook: %s; %s", event, webhook.ID, err.Error())
+			continue
+		}
+
+		msg, _ := json.Marshal(map[string]interface{}{
+			"delivery_id": delivery.ID.String(),
+		})
+		_, err := natsutil.NatsJetstreamPublish(natsWebhookDeliverySubject, msg)
+		if err != nil {
+			common.Log.Warningf("failed to enqueue %s delivery for webhook: %s; %s", event, webhook.ID, err.Error())
+			continue
+		}
+
+		common.Log.Debugf("enqueued %s delivery %s for webhook: %s", event, delivery.ID, webhook.ID)
+	}
+}
+
+// attempt to deliver the payload to the webhook; returns true when no further attempts should be made
+func (d *WebhookDelivery) attempt(webhook *Webhook) bool {
+	if d.Status != nil && *d.Status != webhookDeliveryStatusPending {
+		return true
+	}
+
+	if d.NextAttemptAt != nil && time.Now().Before(*d.NextAttemptAt) {
+		return false
+	}
+
+	db := dbconf.DatabaseConnection()
+
+	if webhook == nil || webhook.Active == nil || !*webhook.Active || webhook.URL == nil || webhook.Secret == nil {
+		d.Status = common.StringOrNil(webhookDeliveryStatusFailed)
+		d.Error = common.StringOrNil("webhook is inactive or no longer exists")
+		db.Save(&d)
+		return true
+	}
+
+	var payload []byte
+	if d.Payload != nil {
+		payload = *d.Payload
+	}
+
+	attemptedAt := time.Now()
+	d.Attempts++
+	d.LastAttemptAt = &attemptedAt
+	d.NextAttemptAt = nil
+	d.ResponseStatus = nil
+	d.Error = nil
+
+	req, err := http.NewRequest(http.MethodPost, *webhook.URL, bytes.NewReader(payload))
+	if err == nil {
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(webhookHeaderDelivery, d.ID.String())
+		req.Header.Set(webhookHeaderEvent, *d.Event)
+		req.Header.Set(webhookHeaderSignature, webhookSignatureFactory(*webhook.Secret, attemptedAt.Unix(), payload))
+
+		var resp *http.Response
+		resp, err = webhookHTTPClient.Do(req)
+		if err == nil {
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+
+			d.ResponseStatus = &resp.StatusCode
+			if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+				err = fmt.Errorf("webhook responded with status: %d", resp.StatusCode)
+			}
+		}
+	}
+
+	done := true
+	if err == nil {
+		d.Status = common.StringOrNil(webhookDeliveryStatusDelivered)
+		d.DeliveredAt = &attemptedAt
+		common.Log.Debugf("delivered %s event to webhook: %s; delivery id: %s", *d.Event, webhook.ID, d.ID)
+	} else {
+		d.Error = common.StringOrNil(err.Error())
+		if d.Attempts >= webhookDeliveryMaxAttempts {
+			d.Status = common.StringOrNil(webhookDeliveryStatusFailed)
+			common.Log.Warningf("failed to deliver %s event to webhook: %s; delivery id: %s; giving up after %d attempt(s); %s", *d.Event, webhook.ID, d.ID, d.Attempts, err.Error())
+		} else {
+			nextAttemptAt := attemptedAt.Add(webhookDeliveryBackoff(d.Attempts))
+			d.NextAttemptAt = &nextAttemptAt
+			done = false
+			common.Log.Debugf("failed to deliver %s event to webhook: %s; delivery id: %s; next attempt at %s; %s", *d.Event, webhook.ID, d.ID, nextAttemptAt, err.Error())
+		}
+	}
+
+	result := db.Save(&d)
+	if err := result.Error; err != nil {
+		common.Log.Warningf("failed to update webhook delivery: %s; %s", d.ID, err.Error())
+	}
+
+	return done
+}
+
+// Create the webhook; a secret is generated when one is not provided
+func (w *Webhook) Create() bool {
+	if w.Secret == nil {
+		secret, err := webhookSecretFactory()
+		if err != nil {
+			w.Errors = append(w.Errors, &provide.Error{
+				Message: common.StringOrNil(fmt.Sprintf("failed to generate webhook secret; %s", err.Error())),
+			})
+			return false
+		}
+		w.Secret = secret
+	}
+
+	if w.Active == nil {
+		active := true
+		w.Active = &active
+	}
+
+	if !w.Validate() {
+		return false
+	}
+
+	db := dbconf.DatabaseConnection()
+
+	success := false
+	if db.NewRecord(w) {
+		result := db.Create(&w)
+		rowsAffected := result.RowsAffected
+		errors := result.GetErrors()
+		if len(errors) > 0 {
+			for _, err := range errors {
+				w.Errors = append(w.Errors, &provide.Error{
+					Message: common.StringOrNil(err.Error()),
+				})
+			}
+		}
+		if !db.NewRecord(w) {
+			success = rowsAffected > 0
+		}
+	}
+
+	return success
+}
+
+// Update the webhook with values from the given webhook
+func (w *Webhook) Update(other *Webhook) bool {
+	if other.URL != nil {
+		w.URL = other.URL
+	}
+
+	if other.Description != nil {
+		w.Description = other.Description
+	}
+
+	if other.Events != nil {
+		w.Events = other.Events
+	}
+
+	if other.Secret != nil {
+		w.Secret = other.Secret
+	}
+
+	if other.Active != nil {
+		w.Active = other.Active
+	}
+
+	if !w.Validate() {
+		return false
+	}
+
+	db := dbconf.DatabaseConnection()
+	result := db.Save(&w)
+	errors := result.GetErrors()
+	if len(errors) > 0 {
+		for _, err := range errors {
+			w.Errors = append(w.Errors, &provide.Error{
+				Message: common.StringOrNil(err.Error()),
+			})
+		}
+	}
+
+	return len(w.Errors) == 0
+}
+
+// Delete the webhook and its delivery log
+func (w *Webhook) Delete() bool {
+	db := dbconf.DatabaseConnection()
+	result := db.Delete(w)
+	errors := result.GetErrors()
+	if len(errors) > 0 {
+		for _, err := range errors {
+			w.Errors = append(w.Errors, &provide.Error{
+				Message: common.StringOrNil(err.Error()),
+			})
+		}
+	}
+	return len(w.Errors) == 0
+}
+
+// Validate the webhook
+func (w *Webhook) Validate() bool {
+	if w.OrganizationID == nil {
+		w.Errors = append(w.Errors, &provide.Error{
+			Message: common.StringOrNil("organization id is required"),
+		})
+	}
+
+	if w.URL == nil {
+		w.Errors = append(w.Errors, &provide.Error{
+			Message: common.StringOrNil("url is required"),
+		})
+	} else if u, err := url.Parse(*w.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		w.Errors = append(w.Errors, &provide.Error{
+			Message: common.StringOrNil(fmt.Sprintf("invalid url: %s", *w.URL)),
+		})
+	} else if err := validateWebhookHost(u.Hostname()); err != nil {
+		w.Errors = append(w.Errors, &provide.Error{
+			Message: common.StringOrNil(err.Error()),
+		})
+	}
+
+	if len(w.Events) == 0 {
+		w.Errors = append(w.Errors, &provide.Error{
+			Message: common.StringOrNil("at least one event is required"),
+		})
+	}
+
+	for _, event := range w.Events {
+		supported := event == "*"
+		for _, evt := range webhookEvents {
+			if evt == event {
+				supported = true
+				break
+			}
+		}
+		if !supported {
+			w.Errors = append(w.Errors, &provide.Error{
+				Message: common.StringOrNil(fmt.Sprintf("unsupported event: %s", event)),
+			})
+		}
+	}
+
+	if w.Secret == nil || len(*w.Secret) < 16 {
+		w.Errors = append(w.Errors, &provide.Error{
+			Message: common.StringOrNil("secret must be at least 16 characters"),
+		})
+	}
+
+	if w.WorkgroupID != nil && FindWorkgroupByID(*w.WorkgroupID) == nil {
+		w.Errors = append(w.Errors, &provide.Error{
+			Message: common.StringOrNil(fmt.Sprintf("workgroup not found: %s", w.WorkgroupID)),
+		})
+	}
+
+	return len(w.Errors) == 0
+}
@@ -0,0 +1,116 @@
+// +build unit
+
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package baseline
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Webhook", func() {
+	DescribeTable("isWebhookRestrictedIP",
+		func(addr string, restricted bool) {
+			Expect(isWebhookRestrictedIP(net.ParseIP(addr))).To(Equal(restricted))
+		},
+		Entry("public IPv4", "93.184.216.34", false),
+		Entry("public IPv6", "2606:2800:220:1:248:1893:25c8:1946", false),
+		Entry("loopback", "127.0.0.1", true),
+		Entry("IPv6 loopback", "::1", true),
+		Entry("unspecified", "0.0.0.0", true),
+		Entry("private class A", "10.1.2.3", true),
+		Entry("private class B", "172.20.0.5", true),
+		Entry("private class C", "192.168.1.1", true),
+		Entry("shared address space", "100.64.0.1", true),
+		Entry("link-local metadata endpoint", "169.254.169.254", true),
+		Entry("IPv6 unique local", "fd00::1", true),
+		Entry("IPv6 link-local", "fe80::1", true),
+		Entry("IPv4-mapped loopback", "::ffff:127.0.0.1", true),
+	)
+
+	DescribeTable("validateWebhookHost",
+		func(host string, valid bool) {
+			err := validateWebhookHost(host)
+			if valid {
+				Expect(err).NotTo(HaveOccurred())
+			} else {
+				Expect(err).To(HaveOccurred())
+			}
+		},
+		Entry("public address", "93.184.216.34", true),
+		Entry("loopback address", "127.0.0.1", false),
+		Entry("localhost", "localhost", false),
+		Entry("link-local address", "169.254.169.254", false),
+	)
+
+	Describe("webhookSignatureFactory", func() {
+		payload := []byte(`{"event":"workflow.completed"}`)
+
+		It("signs the timestamp and payload using the webhook secret", func() {
+			Expect(webhookSignatureFactory("whsec_3f9a1c", 1700000000, payload)).To(Equal(
+				"t=1700000000,v1=ff3aff22dda525f7d77720517f8117a7ce7a0b306ab8891b490a899ef097eb0c",
+			))
+		})
+
+		It("is verifiable by recomputing the hmac of the signed content", func() {
+			signature := webhookSignatureFactory("whsec_3f9a1c", 1700000000, payload)
+			parts := strings.Split(signature, ",")
+			Expect(parts).To(HaveLen(2))
+			Expect(parts[0]).To(Equal("t=1700000000"))
+
+			mac := hmac.New(sha256.New, []byte("whsec_3f9a1c"))
+			mac.Write([]byte(fmt.Sprintf("%s.%s", strings.TrimPrefix(parts[0], "t="), payload)))
+			Expect(parts[1]).To(Equal(fmt.Sprintf("v1=%s", hex.EncodeToString(mac.Sum(nil)))))
+		})
+
+		It("depends on the secret, timestamp and payload", func() {
+			signature := webhookSignatureFactory("whsec_3f9a1c", 1700000000, payload)
+			Expect(webhookSignatureFactory("whsec_other", 1700000000, payload)).NotTo(Equal(signature))
+			Expect(webhookSignatureFactory("whsec_3f9a1c", 1700000001, payload)).NotTo(Equal(signature))
+			Expect(webhookSignatureFactory("whsec_3f9a1c", 1700000000, []byte(`{}`))).NotTo(Equal(signature))
+		})
+	})
+
+	DescribeTable("webhookDeliveryBackoff",
+		func(attempts int, backoff time.Duration) {
+			Expect(webhookDeliveryBackoff(attempts)).To(Equal(backoff))
+		},
+		Entry("first attempt", 1, time.Second*30),
+		Entry("second attempt", 2, time.Minute),
+		Entry("third attempt", 3, time.Minute*2),
+		Entry("fifth attempt", 5, time.Minute*8),
+		Entry("seventh attempt", 7, time.Minute*32),
+		Entry("capped", 8, time.Hour),
+		Entry("remains capped", 20, time.Hour),
+	)
+
+	It("refuses to dial restricted addresses", func() {
+		_, err := webhookHTTPClient.Get("http://127.0.0.1:1")
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("restricted address"))
+	})
+})
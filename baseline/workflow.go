@@ -20,6 +20,8 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/jinzhu/gorm"
@@ -65,6 +67,10 @@ type Workflow struct {
 	WorkflowID     *uuid.UUID     `json:"workflow_id"` // when nil, indicates the workflow is a prototype (not an instance)
 	Worksteps      []*Workstep    `json:"worksteps,omitempty"`
 	WorkstepsCount int            `json:"worksteps_count,omitempty"`
+
+	// VersionRange optionally constrains the prototype version from which an instance is created;
+	// when present, the latest deployed version in the lineage of workflow_id within the range is used
+	VersionRange *string `sql:"-" json:"version_range,omitempty"`
 }
 
 // WorkflowVersion is a version of a workflow referenced by the initial workflow id
@@ -256,15 +262,16 @@ func (w *Workflow) deploy() bool {
 	}
 
 	for _, workstep := range worksteps {
-		var proverParams map[string]interface{}
-		metadata := workstep.ParseMetadata()
-		if params, paramsOk := metadata["prover"].(map[string]interface{}); paramsOk {
-			proverParams = params
+		if !workstep.hasProver() {
+			w.Errors = append(w.Errors, &provide.Error{
+				Message: common.StringOrNil("failed to deploy workflow; prover is required on each workstep"),
+			})
+			return false
 		}
 
-		if proverParams == nil {
+		if _, err := workstep.resolveProverParams(w); err != nil {
 			w.Errors = append(w.Errors, &provide.Error{
-				Message: common.StringOrNil("failed to deploy workflow; prover is required on each workstep"),
+				Message: common.StringOrNil(fmt.Sprintf("failed to deploy workflow; workstep %d: %s", workstep.Cardinality, err.Error())),
 			})
 			return false
 		}
@@ -372,6 +379,120 @@ func (w *Workflow) addParticipant(participant string, tx *gorm.DB) bool {
 	return len(w.Errors) == 0
 }
 
+func (w *Workflow) hasParticipant(address string, tx *gorm.DB) bool {
+	return w.findParticipant(address, tx) != nil
+}
+
+// findParticipant returns the persisted address of the given workflow participant; addresses
+// are matched case-insensitively
+func (w *Workflow) findParticipant(address string, tx *gorm.DB) *string {
+	for _, p := range w.listParticipants(tx) {
+		if p.Participant != nil && strings.EqualFold(*p.Participant, address) {
+			return p.Participant
+		}
+	}
+
+	return nil
+}
+
+// validateParticipantsMutable ensures the participants of the workflow can be modified; only
+// draft prototypes are mutable
+func (w *Workflow) validateParticipantsMutable() bool {
+	if !w.isPrototype() {
+		w.Errors = append(w.Errors, &provide.Error{
+			Message: common.StringOrNil("cannot modify participants of workflow instance"),
+		})
+	} else if w.Status != nil && *w.Status != workflowStatusDraft {
+		w.Errors = append(w.Errors, &provide.Error{
+			Message: common.StringOrNil(fmt.Sprintf("cannot modify participants of workflow with status: %s", *w.Status)),
+		})
+	}
+
+	return len(w.Errors) == 0
+}
+
+// createParticipant adds the given workgroup participant to the workflow, and optionally
+// to each of the workflow worksteps
+func (w *Workflow) createParticipant(address string, propagate bool) bool {
+	if !w.validateParticipantsMutable() {
+		return false
+	}
+
+	db := dbconf.DatabaseConnection()
+	tx := db.Begin()
+	defer tx.RollbackUnlessCommitted()
+
+	var workgroup *Workgroup
+	if w.WorkgroupID != nil {
+		workgroup = FindWorkgroupByID(*w.WorkgroupID)
+	}
+
+	if workgroup == nil || !workgroup.hasParticipant(address, tx) {
+		w.Errors = append(w.Errors, &provide.Error{
+			Message: common.StringOrNil(fmt.Sprintf("participant %s is not a member of the workgroup", address)),
+		})
+		return false
+	}
+	address = *workgroup.findParticipant(address, tx).Participant
+
+	if w.hasParticipant(address, tx) {
+		w.Errors = append(w.Errors, &provide.Error{
+			Message: common.StringOrNil(fmt.Sprintf("participant %s is already a party to the workflow", address)),
+		})
+		return false
+	}
+
+	if !w.addParticipant(address, tx) {
+		return false
+	}
+
+	if propagate {
+		for _, workstep := range FindWorkstepsByWorkflowID(w.ID) {
+			if workstep.findParticipant(address, tx) == nil && !workstep.addParticipant(address, tx) {
+				w.Errors = append(w.Errors, workstep.Errors...)
+				return false
+			}
+		}
+	}
+
+	return tx.Commit().Error == nil
+}
+
+// deleteParticipant removes the given participant from the workflow, and optionally from each
+// of the workflow worksteps
+func (w *Workflow) deleteParticipant(address string, propagate bool) bool {
+	if !w.validateParticipantsMutable() {
+		return false
+	}
+
+	db := dbconf.DatabaseConnection()
+	tx := db.Begin()
+	defer tx.RollbackUnlessCommitted()
+
+	participant := w.findParticipant(address, tx)
+	if participant == nil {
+		w.Errors = append(w.Errors, &provide.Error{
+			Message: common.StringOrNil(fmt.Sprintf("participant %s is not a party to the workflow", address)),
+		})
+		return false
+	}
+
+	if !w.removeParticipant(*participant, tx) {
+		return false
+	}
+
+	if propagate {
+		for _, workstep := range FindWorkstepsByWorkflowID(w.ID) {
+			if participant := workstep.findParticipant(address, tx); participant != nil && !workstep.removeParticipant(*participant, tx) {
+				w.Errors = append(w.Errors, workstep.Errors...)
+				return false
+			}
+		}
+	}
+
+	return tx.Commit().Error == nil
+}
+
 func (w *Workflow) removeParticipant(participant string, tx *gorm.DB) bool {
 	common.Log.Debugf("removing participant %s to workflow: %s", participant, w.ID)
 	result := tx.Exec("DELETE FROM workflows_participants WHERE workflow_id=? AND participant=?", w.ID, participant)
@@ -402,8 +523,8 @@ func (w *Workflow) Create(tx *gorm.DB) bool {
 	if _tx == nil {
 		db := dbconf.DatabaseConnection()
 		_tx = db.Begin()
+		defer _tx.RollbackUnlessCommitted()
 	}
-	defer _tx.RollbackUnlessCommitted()
 
 	success := false
 	if _tx.NewRecord(w) {
@@ -475,8 +596,17 @@ func (w *Workflow) Create(tx *gorm.DB) bool {
 		}
 	}
 
-	if success {
+	if success && tx == nil {
 		_tx.Commit()
+
+		if !w.isPrototype() {
+			dispatchWebhookEvent(w.OrganizationID, w.WorkgroupID, webhookEventWorkflowInstanceCreated, map[string]interface{}{
+				"workflow_id":     w.ID,
+				"prototype_id":    w.WorkflowID,
+				"version":         w.Version,
+				"worksteps_count": w.WorkstepsCount,
+			})
+		}
 	}
 
 	return success
@@ -575,7 +705,7 @@ func (w *Workflow) createVersion(previous *Workflow, version string) bool {
 		return false
 	}
 
-	newVersionParsed, err := common.ParseIntFromString(version)
+	newVersion, err := common.ParseSemver(version)
 	if err != nil {
 		w.Errors = append(w.Errors, &provide.Error{
 			Message: common.StringOrNil(err.Error()),
@@ -583,22 +713,28 @@ func (w *Workflow) createVersion(previous *Workflow, version string) bool {
 		return false
 	}
 
-	previousVersionParsed, err := common.ParseIntFromString(*previous.Version) // TODO-- use semver
-	if err != nil {
-		w.Errors = append(w.Errors, &provide.Error{
-			Message: common.StringOrNil(err.Error()),
-		})
-		return false
-	}
+	db := dbconf.DatabaseConnection()
+	for _, v := range previous.listVersions(db) {
+		existingVersion, err := common.ParseSemver(v.Version)
+		if err != nil {
+			common.Log.Warningf("failed to parse version %s of workflow: %s; %s", v.Version, v.WorkflowID, err.Error())
+			continue
+		}
 
-	if newVersionParsed < previousVersionParsed {
-		w.Errors = append(w.Errors, &provide.Error{
-			Message: common.StringOrNil("cannot version workflow with older version"),
-		})
-		return false
+		cmp := newVersion.Compare(existingVersion)
+		if cmp == 0 {
+			w.Errors = append(w.Errors, &provide.Error{
+				Message: common.StringOrNil(fmt.Sprintf("cannot version workflow with duplicate version: %s", version)),
+			})
+			return false
+		} else if cmp < 0 {
+			w.Errors = append(w.Errors, &provide.Error{
+				Message: common.StringOrNil(fmt.Sprintf("cannot version workflow with older version; %s precedes %s", version, v.Version)),
+			})
+			return false
+		}
 	}
 
-	db := dbconf.DatabaseConnection()
 	tx := db.Begin()
 	defer tx.RollbackUnlessCommitted()
 
@@ -718,9 +854,86 @@ func (w *Workflow) listVersions(tx *gorm.DB) []*WorkflowVersion {
 		versions = append(versions, v)
 	}
 
+	sort.SliceStable(versions, func(i, j int) bool {
+		cmp, err := common.CompareSemver(versions[i].Version, versions[j].Version)
+		if err != nil {
+			// unparseable versions are ordered last
+			_, ierr := common.ParseSemver(versions[i].Version)
+			return ierr == nil
+		}
+		return cmp < 0
+	})
+
 	return versions
 }
 
+// latestDeployedVersion resolves the deployed prototype having the greatest version in the
+// lineage of the workflow; when a range is given, only versions within the range are eligible,
+// otherwise pre-release versions are excluded
+func (w *Workflow) latestDeployedVersion(rng *common.SemverRange, tx *gorm.DB) *Workflow {
+	var latest *Workflow
+	var latestVersion *common.Semver
+
+	for _, v := range w.listVersions(tx) {
+		version, err := common.ParseSemver(v.Version)
+		if err != nil {
+			continue
+		}
+
+		if rng != nil && !rng.Satisfies(version) {
+			continue
+		} else if rng == nil && version.IsPreRelease() {
+			continue
+		}
+
+		if latestVersion != nil && version.Compare(latestVersion) <= 0 {
+			continue
+		}
+
+		workflow := FindWorkflowByID(v.WorkflowID)
+		if workflow == nil || workflow.Status == nil || *workflow.Status != workflowStatusDeployed {
+			continue
+		}
+
+		latest = workflow
+		latestVersion = version
+	}
+
+	return latest
+}
+
+// resolveVersionRange resolves the prototype of a new workflow instance using its version range
+func (w *Workflow) resolveVersionRange() bool {
+	rng, err := common.ParseSemverRange(*w.VersionRange)
+	if err != nil {
+		w.Errors = append(w.Errors, &provide.Error{
+			Message: common.StringOrNil(err.Error()),
+		})
+		return false
+	}
+
+	proto := FindWorkflowByID(*w.WorkflowID)
+	if proto == nil {
+		w.Errors = append(w.Errors, &provide.Error{
+			Message: common.StringOrNil("workflow prototype not resolved"),
+		})
+		return false
+	}
+
+	latest := proto.latestDeployedVersion(rng, dbconf.DatabaseConnection())
+	if latest == nil {
+		w.Errors = append(w.Errors, &provide.Error{
+			Message: common.StringOrNil(fmt.Sprintf("no deployed workflow prototype version satisfies range: %s", *w.VersionRange)),
+		})
+		return false
+	}
+
+	common.Log.Debugf("resolved workflow prototype %s (version %s) for range %s", latest.ID, *latest.Version, *w.VersionRange)
+	w.WorkflowID = &latest.ID
+	w.Version = nil
+	return true
+}
+
 func (w *Workflow) initialWorkflowVersion(tx *gorm.DB) (*uuid.UUID, *string) {
 	rows, err := tx.Raw("SELECT initial_workflow_id, version FROM workflows_versions WHERE workflow_id=?", w.ID).Rows()
 	if err != nil {
@@ -795,6 +1008,12 @@ func (w *Workflow) Validate() bool {
 		return false
 	}
 
+	if !w.isPrototype() && w.ID == uuid.Nil && w.VersionRange != nil {
+		if !w.resolveVersionRange() {
+			return false
+		}
+	}
+
 	if !w.isPrototype() {
 		proto = FindWorkflowByID(*w.WorkflowID)
 
@@ -824,6 +1043,14 @@ func (w *Workflow) Validate() bool {
 		})
 	}
 
+	if w.isPrototype() && w.Version != nil {
+		if _, err := common.ParseSemver(*w.Version); err != nil {
+			w.Errors = append(w.Errors, &provide.Error{
+				Message: common.StringOrNil(err.Error()),
+			})
+		}
+	}
+
 	if w.Status == nil ||
 		(*w.Status != workflowStatusDraft &&
 			*w.Status != workflowStatusDeployed &&
@@ -0,0 +1,304 @@
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package baseline
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	dbconf "github.com/kthomas/go-db-config"
+	uuid "github.com/kthomas/go.uuid"
+	"github.com/provideplatform/baseline/common"
+	provide "github.com/provideplatform/provide-go/api"
+)
+
+const workstepMigrationActionRemap = "remap"
+const workstepMigrationActionRemove = "remove"
+const workstepMigrationActionSpawn = "spawn"
+
+// WorkflowMigration describes the migration of running instances from one prototype version to another
+type WorkflowMigration struct {
+	FromWorkflowID uuid.UUID                    `json:"from_workflow_id"`
+	ToWorkflowID   uuid.UUID                    `json:"to_workflow_id"`
+	FromVersion    *string                      `json:"from_version"`
+	ToVersion      *string                      `json:"to_version"`
+	DryRun         bool                         `json:"dry_run"`
+	MigratedCount  int                          `json:"migrated_count"`
+	Instances      []*WorkflowInstanceMigration `json:"instances"`
+	Errors         []*provide.Error             `json:"errors,omitempty"`
+}
+
+// WorkflowInstanceMigration describes the migration of a single workflow instance
+type WorkflowInstanceMigration struct {
+	WorkflowID uuid.UUID                    `json:"workflow_id"`
+	Status     *string                      `json:"status"`
+	Eligible   bool                         `json:"eligible"`
+	Migrated   bool                         `json:"migrated"`
+	Reason     *string                      `json:"reason,omitempty"`
+	Worksteps  []*WorkstepInstanceMigration `json:"worksteps"`
+}
+
+// WorkstepInstanceMigration describes the action taken on a single workstep instance; executed
+// worksteps are remapped in place so their participant proofs and witnesses are retained
+type WorkstepInstanceMigration struct {
+	Action         string     `json:"action"`
+	WorkstepID     *uuid.UUID `json:"workstep_id,omitempty"`
+	FromWorkstepID *uuid.UUID `json:"from_workstep_id,omitempty"`
+	ToWorkstepID   *uuid.UUID `json:"to_workstep_id,omitempty"`
+	Status         *string    `json:"status,omitempty"`
+}
+
+// listRunningInstances returns the instances of the workflow prototype which have not yet completed
+func (w *Workflow) listRunningInstances(tx *gorm.DB) []*Workflow {
+	instances := make([]*Workflow, 0)
+	tx.Where("workflow_id = ? AND status IN (?)", w.ID, []string{workflowStatusInit, workflowStatusRunning}).Order("created_at ASC").Find(&instances)
+	return instances
+}
+
+// planMigration maps the worksteps of each running instance of the workflow to the worksteps of the
+// given target prototype; instances having executed worksteps absent from the target are ineligible
+func (w *Workflow) planMigration(target *Workflow, tx *gorm.DB) *WorkflowMigration {
+	migration := &WorkflowMigration{
+		FromWorkflowID: w.ID,
+		ToWorkflowID:   target.ID,
+		FromVersion:    w.Version,
+		ToVersion:      target.Version,
+		Instances:      make([]*WorkflowInstanceMigration, 0),
+	}
+
+	if !w.isPrototype() || !target.isPrototype() {
+		migration.Errors = append(migration.Errors, &provide.Error{
+			Message: common.StringOrNil("only workflow prototypes can be migrated"),
+		})
+		return migration
+	}
+
+	if target.Version == nil {
+		migration.Errors = append(migration.Errors, &provide.Error{
+			Message: common.StringOrNil("target workflow prototype must be versioned"),
+		})
+		return migration
+	}
+
+	if target.Status == nil || *target.Status != workflowStatusDeployed {
+		migration.Errors = append(migration.Errors, &provide.Error{
+			Message: common.StringOrNil("target workflow prototype must be deployed"),
+		})
+		return migration
+	}
+
+	initialWorkflowID, _ := w.initialWorkflowVersion(tx)
+	targetInitialWorkflowID, _ := target.initialWorkflowVersion(tx)
+	if initialWorkflowID == nil || targetInitialWorkflowID == nil || *initialWorkflowID != *targetInitialWorkflowID {
+		migration.Errors = append(migration.Errors, &provide.Error{
+			Message: common.StringOrNil("target workflow prototype is not a version of the migrated workflow"),
+		})
+		return migration
+	}
+
+	if w.Version != nil {
+		cmp, err := common.CompareSemver(*target.Version, *w.Version)
+		if err != nil {
+			migration.Errors = append(migration.Errors, &provide.Error{
+				Message: common.StringOrNil(err.Error()),
+			})
+			return migration
+		} else if cmp <= 0 {
+			migration.Errors = append(migration.Errors, &provide.Error{
+				Message: common.StringOrNil(fmt.Sprintf("cannot migrate to version %s; it does not supersede version %s", *target.Version, *w.Version)),
+			})
+			return migration
+		}
+	}
+
+	worksteps := FindWorkstepsByWorkflowID(w.ID)
+	sourceWorksteps := map[uuid.UUID]*Workstep{}
+	for _, workstep := range worksteps {
+		sourceWorksteps[workstep.ID] = workstep
+	}
+
+	targetWorksteps := FindWorkstepsByWorkflowID(target.ID)
+	key := workstepDiffKey(worksteps, targetWorksteps)
+	targetIndex := map[string]*Workstep{}
+	for _, workstep := range targetWorksteps {
+		targetIndex[key(workstep)] = workstep
+	}
+
+	for _, instance := range w.listRunningInstances(tx) {
+		plan := &WorkflowInstanceMigration{
+			WorkflowID: instance.ID,
+			Status:     instance.Status,
+			Eligible:   true,
+			Worksteps:  make([]*WorkstepInstanceMigration, 0),
+		}
+
+		mapped := map[uuid.UUID]bool{}
+		for _, workstepInstance := range FindWorkstepsByWorkflowID(instance.ID) {
+			var source *Workstep
+			if workstepInstance.WorkstepID != nil {
+				source = sourceWorksteps[*workstepInstance.WorkstepID]
+			}
+
+			var dest *Workstep
+			if source != nil {
+				dest = targetIndex[key(source)]
+			}
+
+			executed := workstepInstance.Status != nil && *workstepInstance.Status != workstepStatusInit
+			if dest == nil {
+				if executed {
+					plan.Eligible = false
+					plan.Reason = common.StringOrNil(fmt.Sprintf("executed workstep %s has no counterpart in version %s", workstepInstance.ID, *target.Version))
+				}
+
+				plan.Worksteps = append(plan.Worksteps, &WorkstepInstanceMigration{
+					Action:         workstepMigrationActionRemove,
+					WorkstepID:     &workstepInstance.ID,
+					FromWorkstepID: workstepInstance.WorkstepID,
+					Status:         workstepInstance.Status,
+				})
+				continue
+			}
+
+			mapped[dest.ID] = true
+			plan.Worksteps = append(plan.Worksteps, &WorkstepInstanceMigration{
+				Action:         workstepMigrationActionRemap,
+				WorkstepID:     &workstepInstance.ID,
+				FromWorkstepID: workstepInstance.WorkstepID,
+				ToWorkstepID:   &dest.ID,
+				Status:         workstepInstance.Status,
+			})
+		}
+
+		for _, workstep := range targetWorksteps {
+			if !mapped[workstep.ID] {
+				workstepID := workstep.ID
+				plan.Worksteps = append(plan.Worksteps, &WorkstepInstanceMigration{
+					Action:       workstepMigrationActionSpawn,
+					ToWorkstepID: &workstepID,
+					Status:       common.StringOrNil(workstepStatusInit),
+				})
+			}
+		}
+
+		migration.Instances = append(migration.Instances, plan)
+	}
+
+	return migration
+}
+
+// migrate the eligible running instances of the workflow to the given target prototype; each
+// instance is migrated within its own transaction
+func (w *Workflow) migrate(target *Workflow, dryRun bool) *WorkflowMigration {
+	db := dbconf.DatabaseConnection()
+	migration := w.planMigration(target, db)
+	migration.DryRun = dryRun
+	if dryRun || len(migration.Errors) > 0 {
+		return migration
+	}
+
+	targetWorksteps := map[uuid.UUID]*Workstep{}
+	for _, workstep := range FindWorkstepsByWorkflowID(target.ID) {
+		targetWorksteps[workstep.ID] = workstep
+	}
+
+	for _, plan := range migration.Instances {
+		if !plan.Eligible {
+			continue
+		}
+
+		err := migrateWorkflowInstance(plan, target, targetWorksteps, db)
+		if err != nil {
+			common.Log.Warningf("failed to migrate workflow instance %s to version %s; %s", plan.WorkflowID, *target.Version, err.Error())
+			plan.Reason = common.StringOrNil(err.Error())
+			continue
+		}
+
+		plan.Migrated = true
+		migration.MigratedCount++
+	}
+
+	common.Log.Debugf("migrated %d of %d running instance(s) of workflow %s to version %s", migration.MigratedCount, len(migration.Instances), w.ID, *target.Version)
+	return migration
+}
+
+func migrateWorkflowInstance(plan *WorkflowInstanceMigration, target *Workflow, targetWorksteps map[uuid.UUID]*Workstep, db *gorm.DB) error {
+	tx := db.Begin()
+	defer tx.RollbackUnlessCommitted()
+
+	// temporarily negate the cardinalities to avoid violating the unique (workflow_id, cardinality) index while remapping
+	result := tx.Exec("UPDATE worksteps SET cardinality=-cardinality WHERE workflow_id=?", plan.WorkflowID)
+	if err := result.Error; err != nil {
+		return err
+	}
+
+	for _, step := range plan.Worksteps {
+		switch step.Action {
+		case workstepMigrationActionRemap:
+			dest := targetWorksteps[*step.ToWorkstepID]
+			result := tx.Exec(
+				"UPDATE worksteps SET workstep_id=?, cardinality=?, prover_id=?, require_finality=? WHERE id=?",
+				dest.ID, dest.Cardinality, dest.ProverID, dest.RequireFinality, step.WorkstepID,
+			)
+			if err := result.Error; err != nil {
+				return err
+			}
+		case workstepMigrationActionRemove:
+			result := tx.Exec("DELETE FROM worksteps WHERE id=?", step.WorkstepID)
+			if err := result.Error; err != nil {
+				return err
+			}
+		case workstepMigrationActionSpawn:
+			dest := targetWorksteps[*step.ToWorkstepID]
+			instance := &Workstep{}
+			instance.Name = dest.Name
+			instance.Description = dest.Description
+			instance.Cardinality = dest.Cardinality
+			instance.Metadata = dest.Metadata
+			instance.ProverID = dest.ProverID
+			instance.RequireFinality = dest.RequireFinality
+			instance.Status = common.StringOrNil(workstepStatusInit)
+			instance.WorkflowID = &plan.WorkflowID
+			instance.WorkstepID = &dest.ID
+
+			result := tx.Create(&instance)
+			if err := result.Error; err != nil {
+				return err
+			}
+
+			for _, p := range dest.listParticipants(tx) {
+				if !instance.addParticipant(*p.Participant, tx) {
+					return fmt.Errorf("failed to add participant %s to workstep instance: %s", *p.Participant, instance.ID)
+				}
+			}
+
+			step.WorkstepID = &instance.ID
+		}
+	}
+
+	updatedAt := time.Now()
+	result = tx.Exec(
+		"UPDATE workflows SET workflow_id=?, version=?, worksteps_count=?, updated_at=? WHERE id=?",
+		target.ID, target.Version, len(targetWorksteps), updatedAt, plan.WorkflowID,
+	)
+	if err := result.Error; err != nil {
+		return err
+	}
+
+	return tx.Commit().Error
+}
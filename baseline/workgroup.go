@@ -19,6 +19,7 @@ package baseline
 import (
 	"encoding/json"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/jinzhu/gorm"
@@ -31,6 +32,10 @@ import (
 	"github.com/provideplatform/provide-go/api/ident"
 )
 
+const workgroupParticipantStatusActive = "active"
+const workgroupParticipantStatusSuspended = "suspended"
+const workgroupParticipantStatusRemoved = "removed"
+
 const requireCounterpartiesSleepInterval = time.Second * 15
 const requireCounterpartiesTickerInterval = time.Second * 30 // HACK
 
@@ -205,6 +210,226 @@ func (w *Workgroup) addParticipant(participant string, tx *gorm.DB) bool {
 	return len(w.Errors) == 0
 }
 
+// hasParticipant returns true if the given address is an active (i.e., not suspended) participant
+func (w *Workgroup) hasParticipant(address string, tx *gorm.DB) bool {
+	p := w.findParticipant(address, tx)
+	return p != nil && (p.Status == nil || *p.Status == workgroupParticipantStatusActive)
+}
+
+// hasSuspendedParticipant returns true if the given address is a suspended participant
+func (w *Workgroup) hasSuspendedParticipant(address string, tx *gorm.DB) bool {
+	p := w.findParticipant(address, tx)
+	return p != nil && p.Status != nil && *p.Status == workgroupParticipantStatusSuspended
+}
+
+// isOwner returns true if the given organization owns the workgroup; only the owner manages
+// the workgroup participants
+func (w *Workgroup) isOwner(organizationID uuid.UUID) bool {
+	return w.OrganizationID != nil && w.OrganizationID.String() == organizationID.String()
+}
+
+// isAuthorizedOrganization returns true if the given organization owns the workgroup or
+// is an active participant of it, and may therefore view its participants
+func (w *Workgroup) isAuthorizedOrganization(organizationID uuid.UUID, tx *gorm.DB) bool {
+	if w.isOwner(organizationID) {
+		return true
+	}
+
+	subjectAccountID := subjectAccountIDFactory(organizationID.String(), w.ID.String())
+	subjectAccount, err := resolveSubjectAccount(subjectAccountID)
+	if err != nil || subjectAccount.Metadata == nil || subjectAccount.Metadata.OrganizationAddress == nil {
+		return false
+	}
+
+	return w.hasParticipant(*subjectAccount.Metadata.OrganizationAddress, tx)
+}
+
+// isSuspendedWorkgroupParticipant returns true if the given address is a suspended participant
+// of the given workgroup
+func isSuspendedWorkgroupParticipant(workgroupID *uuid.UUID, address string) bool {
+	if workgroupID == nil {
+		return false
+	}
+
+	workgroup := FindWorkgroupByID(*workgroupID)
+	if workgroup == nil {
+		return false
+	}
+
+	db := dbconf.DatabaseConnection()
+	return workgroup.hasSuspendedParticipant(address, db)
+}
+
+func (w *Workgroup) findParticipant(address string, tx *gorm.DB) *WorkgroupParticipant {
+	for _, p := range w.listParticipants(tx) {
+		if p.Participant != nil && strings.EqualFold(*p.Participant, address) {
+			return p
+		}
+	}
+
+	return nil
+}
+
+// setParticipantStatus suspends or reactivates the given workgroup participant
+func (w *Workgroup) setParticipantStatus(participant, status string, tx *gorm.DB) bool {
+	if status != workgroupParticipantStatusActive && status != workgroupParticipantStatusSuspended {
+		w.Errors = append(w.Errors, &provide.Error{
+			Message: common.StringOrNil(fmt.Sprintf("invalid participant status: %s", status)),
+		})
+		return false
+	}
+
+	var suspendedAt *time.Time
+	if status == workgroupParticipantStatusSuspended {
+		now := time.Now()
+		suspendedAt = &now
+	}
+
+	common.Log.Debugf("setting status of participant %s in workgroup %s: %s", participant, w.ID, status)
+	result := tx.Exec("UPDATE workgroups_participants SET status=?, suspended_at=? WHERE workgroup_id=? AND participant=?", status, suspendedAt, w.ID, participant)
+	if result.RowsAffected != 1 {
+		common.Log.Warningf("failed to set status of participant %s in workgroup: %s", participant, w.ID)
+		errors := result.GetErrors()
+		if len(errors) > 0 {
+			for _, err := range errors {
+				w.Errors = append(w.Errors, &provide.Error{
+					Message: common.StringOrNil(err.Error()),
+				})
+			}
+		} else {
+			w.Errors = append(w.Errors, &provide.Error{
+				Message: common.StringOrNil(fmt.Sprintf("participant %s is not a member of the workgroup", participant)),
+			})
+		}
+	}
+
+	return len(w.Errors) == 0
+}
+
+// runningInstancesCount returns the number of workflow instances in the workgroup which are
+// not yet completed and to which the given participant is a party
+func (w *Workgroup) runningInstancesCount(participant string, tx *gorm.DB) int {
+	rows, err := tx.Raw(
+		`SELECT count(*) FROM workflows wf JOIN workflows_participants wp ON wp.workflow_id = wf.id
+		WHERE wf.workgroup_id=? AND wf.workflow_id IS NOT NULL AND wf.status IN (?) AND wp.participant=?`,
+		w.ID, []string{workflowStatusInit, workflowStatusRunning}, participant,
+	).Rows()
+	if err != nil {
+		common.Log.Warningf("failed to read running workflow instances count; %s", err.Error())
+		return 0
+	}
+	defer rows.Close()
+
+	var count int
+	for rows.Next() {
+		err = rows.Scan(&count)
+		if err != nil {
+			common.Log.Warningf("failed to read running workflow instances count; %s", err.Error())
+			return 0
+		}
+	}
+
+	return count
+}
+
+// deleteParticipant removes the participant from the workgroup and from each of its draft workflow
+// prototypes and their worksteps; removal is not permitted while the participant is a party to a
+// running workflow instance
+func (w *Workgroup) deleteParticipant(participant string, tx *gorm.DB) bool {
+	if w.findParticipant(participant, tx) == nil {
+		w.Errors = append(w.Errors, &provide.Error{
+			Message: common.StringOrNil(fmt.Sprintf("participant %s is not a member of the workgroup", participant)),
+		})
+		return false
+	}
+
+	if count := w.runningInstancesCount(participant, tx); count > 0 {
+		w.Errors = append(w.Errors, &provide.Error{
+			Message: common.StringOrNil(fmt.Sprintf("cannot remove participant %s; participant is a party to %d running workflow instance(s)", participant, count)),
+		})
+		return false
+	}
+
+	result := tx.Exec(
+		`DELETE FROM worksteps_participants WHERE participant=? AND workstep_id IN (
+			SELECT ws.id FROM worksteps ws JOIN workflows wf ON ws.workflow_id = wf.id
+			WHERE wf.workgroup_id=? AND wf.workflow_id IS NULL AND wf.status=?
+		)`,
+		participant, w.ID, workflowStatusDraft,
+	)
+	if err := result.Error; err != nil {
+		w.Errors = append(w.Errors, &provide.Error{
+			Message: common.StringOrNil(err.Error()),
+		})
+		return false
+	}
+	common.Log.Debugf("removed participant %s from %d draft workstep(s) in workgroup: %s", participant, result.RowsAffected, w.ID)
+
+	result = tx.Exec(
+		`DELETE FROM workflows_participants WHERE participant=? AND workflow_id IN (
+			SELECT id FROM workflows WHERE workgroup_id=? AND workflow_id IS NULL AND status=?
+		)`,
+		participant, w.ID, workflowStatusDraft,
+	)
+	if err := result.Error; err != nil {
+		w.Errors = append(w.Errors, &provide.Error{
+			Message: common.StringOrNil(err.Error()),
+		})
+		return false
+	}
+	common.Log.Debugf("removed participant %s from %d draft workflow(s) in workgroup: %s", participant, result.RowsAffected, w.ID)
+
+	return w.removeParticipant(participant, tx)
+}
+
+// notifyParticipants dispatches a sync protocol message describing the change in status of
+// the given participant to each of the remaining active workgroup participants; the message is
+// informational, as the participants of the workgroup are managed by its owner
+func (w *Workgroup) notifyParticipants(organizationID uuid.UUID, participant, status string) {
+	subjectAccountID := subjectAccountIDFactory(organizationID.String(), w.ID.String())
+	subjectAccount, err := resolveSubjectAccount(subjectAccountID)
+	if err != nil {
+		common.Log.Warningf("failed to notify workgroup participants of participant %s status: %s; %s", participant, status, err.Error())
+		return
+	}
+
+	db := dbconf.DatabaseConnection()
+	for _, p := range w.listParticipants(db) {
+		if p.Participant == nil || strings.EqualFold(*p.Participant, participant) {
+			continue
+		}
+
+		if p.Status != nil && *p.Status != workgroupParticipantStatusActive {
+			continue
+		}
+
+		msg := &ProtocolMessage{
+			baseline.ProtocolMessage{
+				Opcode:     common.StringOrNil(baseline.ProtocolMessageOpcodeSync),
+				Identifier: &w.ID,
+				Payload: &baseline.ProtocolMessagePayload{
+					Object: map[string]interface{}{
+						"participant":  participant,
+						"status":       status,
+						"workgroup_id": w.ID.String(),
+					},
+					Type: common.StringOrNil(protomsgPayloadTypeWorkgroupParticipant),
+				},
+				Recipient: p.Participant,
+				Sender:    subjectAccount.Metadata.OrganizationAddress,
+			},
+			nil,
+			subjectAccount,
+			nil,
+		}
+
+		err := msg.broadcast(*p.Participant)
+		if err != nil {
+			common.Log.Warningf("failed to notify workgroup participant %s of participant %s status: %s; %s", *p.Participant, participant, status, err.Error())
+		}
+	}
+}
+
 func (w *Workgroup) removeParticipant(participant string, tx *gorm.DB) bool {
 	common.Log.Debugf("removing participant %s to workgroup: %s", participant, w.ID)
 	result := tx.Exec("DELETE FROM workgroups_participants WHERE workgroup_id=? AND participant=?", w.ID, participant)
@@ -30,6 +30,7 @@ import (
 	"github.com/kthomas/go-redisutil"
 	uuid "github.com/kthomas/go.uuid"
 	"github.com/provideplatform/baseline/common"
+	"github.com/provideplatform/baseline/metrics"
 	provide "github.com/provideplatform/provide-go/api"
 	"github.com/provideplatform/provide-go/api/baseline"
 	"github.com/provideplatform/provide-go/api/nchain"
@@ -129,13 +130,12 @@ func baselineWorkstepFactory(identifier *string, workflowID *string, prover *pri
 	}
 
 	workstep := &baseline.WorkstepInstance{
-		baseline.Workstep{
+		Workstep: baseline.Workstep{
 			Prover:       prover,
 			ProverID:     &prover.ID,
 			Participants: make([]*baseline.Participant, 0), // FIXME
 			WorkflowID:   &workflowUUID,
 		},
-		nil,
 	}
 
 	workstep.ID = identifierUUID
@@ -300,15 +300,15 @@ func (w *Workstep) deploy(token string, organizationID uuid.UUID) bool {
 		return false
 	}
 
-	var proverParams map[string]interface{}
-	metadata := w.ParseMetadata()
-	if params, paramsOk := metadata["prover"].(map[string]interface{}); paramsOk {
-		proverParams = params
+	var workflow *Workflow
+	if w.WorkflowID != nil {
+		workflow = FindWorkflowByID(*w.WorkflowID)
 	}
 
-	if proverParams == nil {
+	proverParams, err := w.resolveProverParams(workflow)
+	if err != nil {
 		w.Errors = append(w.Errors, &provide.Error{
-			Message: common.StringOrNil("failed to deploy workstep; no prover specified"),
+			Message: common.StringOrNil(fmt.Sprintf("failed to deploy workstep; %s", err.Error())),
 		})
 		return false
 	}
@@ -373,6 +373,16 @@ func (w *Workstep) execute(
 		return nil, fmt.Errorf(*w.Errors[0].Message)
 	}
 
+	if subjectAccount != nil && subjectAccount.Metadata != nil && subjectAccount.Metadata.OrganizationAddress != nil {
+		workflow := FindWorkflowByID(*w.WorkflowID)
+		if workflow != nil && isSuspendedWorkgroupParticipant(workflow.WorkgroupID, *subjectAccount.Metadata.OrganizationAddress) {
+			w.Errors = append(w.Errors, &provide.Error{
+				Message: common.StringOrNil("cannot execute workstep on behalf of suspended participant"),
+			})
+			return nil, fmt.Errorf(*w.Errors[0].Message)
+		}
+	}
+
 	if w.ProverID == nil {
 		w.Errors = append(w.Errors, &provide.Error{
 			Message: common.StringOrNil("cannot execute workstep without prover id"),
@@ -380,10 +390,25 @@ func (w *Workstep) execute(
 		return nil, fmt.Errorf(*w.Errors[0].Message)
 	}
 
+	if errs := w.validatePayload(payload); len(errs) > 0 {
+		for _, err := range errs {
+			msg := *err.Message
+			if err.Field != nil {
+				msg = fmt.Sprintf("%s: %s", *err.Field, msg)
+			}
+			w.Errors = append(w.Errors, &provide.Error{
+				Message: common.StringOrNil(fmt.Sprintf("invalid payload; %s", msg)),
+			})
+		}
+		return nil, fmt.Errorf(*w.Errors[0].Message)
+	}
+
 	var params map[string]interface{}
 	raw, _ := json.Marshal(payload)
 	json.Unmarshal(raw, &params) // HACK
+	startedAt := time.Now()
 	proof, err := privacy.Prove(token, w.ProverID.String(), params)
+	metrics.ObserveProve(startedAt, err)
 	if err != nil {
 		w.Errors = append(w.Errors, &provide.Error{
 			Message: common.StringOrNil(fmt.Sprintf("failed to execute workstep; %s", err.Error())),
@@ -453,6 +478,24 @@ func (w *Workstep) execute(
 		}
 
 		tx.Commit()
+
+		event := map[string]interface{}{
+			"workflow_id": workflow.ID,
+			"workstep_id": w.ID,
+			"cardinality": w.Cardinality,
+			"participant": subjectAccount.Metadata.OrganizationAddress,
+			"proof":       proof.Proof,
+		}
+		dispatchWebhookEvent(workflow.OrganizationID, workflow.WorkgroupID, webhookEventWorkstepExecuted, event)
+		if *w.Status == workstepStatusCompleted {
+			dispatchWebhookEvent(workflow.OrganizationID, workflow.WorkgroupID, webhookEventWorkstepCompleted, event)
+		}
+		if *workflow.Status == workflowStatusCompleted {
+			dispatchWebhookEvent(workflow.OrganizationID, workflow.WorkgroupID, webhookEventWorkflowCompleted, map[string]interface{}{
+				"workflow_id": workflow.ID,
+				"version":     workflow.Version,
+			})
+		}
 	}
 
 	return proof, nil
@@ -635,6 +678,18 @@ func (w *Workstep) hasParticipant(address string, tx *gorm.DB) bool {
 	return false
 }
 
+// findParticipant returns the persisted address of the given workstep participant; addresses
+// are matched case-insensitively
+func (w *Workstep) findParticipant(address string, tx *gorm.DB) *string {
+	for _, p := range w.listParticipants(tx) {
+		if p.Participant != nil && strings.EqualFold(*p.Participant, address) {
+			return p.Participant
+		}
+	}
+
+	return nil
+}
+
 func (w *Workstep) removeParticipant(participant string, tx *gorm.DB) bool {
 	common.Log.Debugf("removing participant %s to workstep: %s", participant, w.ID)
 	result := tx.Exec("DELETE FROM worksteps_participants WHERE workstep_id=? AND participant=?", w.ID, participant)
@@ -824,7 +879,8 @@ func (w *Workstep) Create(tx *gorm.DB) bool {
 	}
 
 	if success {
-		workflow := FindWorkflowByID(*w.WorkflowID)
+		workflow := &Workflow{}
+		_tx.Where("id = ?", w.WorkflowID.String()).Find(&workflow)
 		if w.Participants == nil || len(w.Participants) == 0 {
 			participants := workflow.listParticipants(_tx)
 			common.Log.Debugf("no participants added to workstep; defaulting to %d workflow participant(s)", len(participants))
@@ -838,7 +894,9 @@ func (w *Workstep) Create(tx *gorm.DB) bool {
 		workflow.WorkstepsCount = w.Cardinality
 		_tx.Save(&workflow)
 
-		_tx.Commit()
+		if tx == nil {
+			_tx.Commit()
+		}
 	}
 
 	return success
@@ -962,6 +1020,12 @@ func (w *Workstep) Validate(tx *gorm.DB) bool {
 		})
 	}
 
+	if err := w.validatePayloadReference(); err != nil {
+		w.Errors = append(w.Errors, &provide.Error{
+			Message: common.StringOrNil(err.Error()),
+		})
+	}
+
 	if w.Status == nil ||
 		(*w.Status != workstepStatusDraft &&
 			*w.Status != workstepStatusDeployed &&
@@ -30,7 +30,9 @@ import (
 
 	"github.com/provideplatform/baseline/baseline"
 	"github.com/provideplatform/baseline/common"
+	"github.com/provideplatform/baseline/metrics"
 	"github.com/provideplatform/baseline/stats"
+	"github.com/provideplatform/baseline/tracing"
 	identcommon "github.com/provideplatform/ident/common"
 	"github.com/provideplatform/ident/token"
 
@@ -40,6 +42,7 @@ import (
 
 const runloopSleepInterval = 250 * time.Millisecond
 const runloopTickInterval = 5000 * time.Millisecond
+const tracingServiceName = "baseline-api"
 
 var (
 	cancelF     context.CancelFunc
@@ -47,7 +50,8 @@ var (
 	shutdownCtx context.Context
 	sigs        chan os.Signal
 
-	srv *http.Server
+	srv            *http.Server
+	shutdownTracer func(context.Context) error
 )
 
 func init() {
@@ -66,7 +70,10 @@ func main() {
 	common.Log.Debugf("starting baseline API...")
 	installSignalHandlers()
 
+	shutdownTracer = tracing.RequireTracer(tracingServiceName)
 	runAPI()
+	go metrics.RunMetricsServer(shutdownCtx, common.MetricsListenAddr)
+	go stats.RunRetentionDaemon(shutdownCtx)
 
 	timer := time.NewTicker(runloopTickInterval)
 	defer timer.Stop()
@@ -87,6 +94,7 @@ func main() {
 	}
 
 	common.Log.Debug("exiting baseline API")
+	shutdownTracer(context.Background())
 	cancelF()
 }
 
@@ -109,6 +117,7 @@ func runAPI() {
 	r.Use(gin.Logger())
 	r.Use(gin.Recovery())
 	r.Use(provide.CORSMiddleware())
+	r.Use(tracing.Middleware())
 
 	r.GET("/status", statusHandler)
 	baseline.InstallCredentialsAPI(r)
@@ -122,7 +131,9 @@ func runAPI() {
 
 	baseline.InstallBPIAPI(r)
 	baseline.InstallMappingsAPI(r)
+	baseline.InstallProverTemplatesAPI(r)
 	baseline.InstallSystemsAPI(r)
+	baseline.InstallWebhooksAPI(r)
 	baseline.InstallSchemasAPI(r)
 	baseline.InstallWorkflowsAPI(r)
 	baseline.InstallWorkgroupsAPI(r)
@@ -27,10 +27,13 @@ import (
 	"github.com/kthomas/go-redisutil"
 	_ "github.com/provideplatform/baseline/baseline" // Baseline package
 	"github.com/provideplatform/baseline/common"
+	"github.com/provideplatform/baseline/metrics"
+	"github.com/provideplatform/baseline/tracing"
 )
 
 const natsStreamingSubscriptionStatusTickerInterval = 5 * time.Second
 const natsStreamingSubscriptionStatusSleepInterval = 250 * time.Millisecond
+const tracingServiceName = "baseline-consumer"
 
 var (
 	cancelF     context.CancelFunc
@@ -53,6 +56,10 @@ func main() {
 	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM, syscall.SIGKILL)
 	shutdownCtx, cancelF = context.WithCancel(context.Background())
 
+	go metrics.RunMetricsServer(shutdownCtx, common.MetricsListenAddr)
+	shutdownTracer := tracing.RequireTracer(tracingServiceName)
+	defer shutdownTracer(context.Background())
+
 	common.Log.Debugf("running dedicated NATS streaming subscription consumer main()")
 	timer := time.NewTicker(natsStreamingSubscriptionStatusTickerInterval)
 	defer timer.Stop()
@@ -19,6 +19,7 @@ package common
 import (
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
@@ -29,6 +30,9 @@ import (
 	"github.com/provideplatform/provide-go/common/util"
 )
 
+const defaultMetricsListenAddr = "0.0.0.0:9090"
+const defaultStatsLogRetentionDays = 30
+
 var (
 	// BaselinePublicWorkgroupID is the configured public workgroup id, if any
 	BaselinePublicWorkgroupID *string
@@ -42,6 +46,12 @@ var (
 	// Log is the configured logger
 	Log *logger.Logger
 
+	// MetricsListenAddr is the private address on which the API and consumer processes serve prometheus metrics
+	MetricsListenAddr string
+
+	// StatsLogRetentionDays is the default number of days stats log messages are retained
+	StatsLogRetentionDays int
+
 	// Vault is the vault instance which is used by the BPI to protect sensitive materials across all tenants
 	Vault *vault.Vault
 
@@ -52,6 +62,8 @@ var (
 func init() {
 	requireLogger()
 	requireBaselinePublicWorkgroup()
+	requireMetrics()
+	requireStatsLogRetention()
 	requireVault()
 
 	ConsumeNATSStreamingSubscriptions = strings.ToLower(os.Getenv("CONSUME_NATS_STREAMING_SUBSCRIPTIONS")) == "true"
@@ -104,6 +116,26 @@ func requireBaselinePublicWorkgroup() {
 	common.Log.Debugf("configured public workgroup: %s", *BaselinePublicWorkgroupID)
 }
 
+func requireMetrics() {
+	MetricsListenAddr = defaultMetricsListenAddr
+	if os.Getenv("METRICS_LISTEN_ADDR") != "" {
+		MetricsListenAddr = os.Getenv("METRICS_LISTEN_ADDR")
+	}
+}
+
+func requireStatsLogRetention() {
+	StatsLogRetentionDays = defaultStatsLogRetentionDays
+	if os.Getenv("STATS_LOG_RETENTION_DAYS") != "" {
+		days, err := strconv.Atoi(os.Getenv("STATS_LOG_RETENTION_DAYS"))
+		if err != nil || days <= 0 {
+			Log.Panicf("failed to parse STATS_LOG_RETENTION_DAYS as a positive integer: %s", os.Getenv("STATS_LOG_RETENTION_DAYS"))
+		}
+		StatsLogRetentionDays = days
+	}
+
+	Log.Debugf("configured stats log retention: %d day(s)", StatsLogRetentionDays)
+}
+
 func requireVault() {
 	util.RequireVault()
 
@@ -0,0 +1,360 @@
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package common
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var semverIdentifierPattern = regexp.MustCompile(`^[0-9A-Za-z-]+$`)
+
+// Semver is a parsed semantic version (see https://semver.org)
+type Semver struct {
+	Major      uint64
+	Minor      uint64
+	Patch      uint64
+	PreRelease []string
+	Build      *string
+}
+
+// ParseSemver parses the given semantic version string; a leading "v" is tolerated,
+// as are omitted minor and patch components (i.e., "1" is parsed as "1.0.0")
+func ParseSemver(str string) (*Semver, error) {
+	v, _, err := parseSemver(str, false)
+	return v, err
+}
+
+// parseSemver parses the given version; when wildcards are allowed, "x", "X" and "*"
+// are accepted in place of numeric components and the number of explicitly-provided
+// numeric components is returned
+func parseSemver(str string, allowWildcards bool) (*Semver, int, error) {
+	raw := strings.TrimSpace(str)
+	raw = strings.TrimPrefix(strings.TrimPrefix(raw, "v"), "V")
+	if raw == "" {
+		return nil, 0, fmt.Errorf("invalid semantic version: %s", str)
+	}
+
+	v := &Semver{}
+
+	if i := strings.Index(raw, "+"); i != -1 {
+		build := raw[i+1:]
+		for _, ident := range strings.Split(build, ".") {
+			if !semverIdentifierPattern.MatchString(ident) {
+				return nil, 0, fmt.Errorf("invalid build metadata in semantic version: %s", str)
+			}
+		}
+		v.Build = StringOrNil(build)
+		raw = raw[0:i]
+	}
+
+	if i := strings.Index(raw, "-"); i != -1 {
+		for _, ident := range strings.Split(raw[i+1:], ".") {
+			if !semverIdentifierPattern.MatchString(ident) {
+				return nil, 0, fmt.Errorf("invalid pre-release in semantic version: %s", str)
+			}
+			if isNumericSemverIdentifier(ident) && len(ident) > 1 && ident[0] == '0' {
+				return nil, 0, fmt.Errorf("invalid pre-release in semantic version: %s; numeric identifiers must not include leading zeroes", str)
+			}
+			v.PreRelease = append(v.PreRelease, ident)
+		}
+		raw = raw[0:i]
+	}
+
+	parts := strings.Split(raw, ".")
+	if len(parts) > 3 {
+		return nil, 0, fmt.Errorf("invalid semantic version: %s", str)
+	}
+
+	explicit := 0
+	components := []*uint64{&v.Major, &v.Minor, &v.Patch}
+	for i, part := range parts {
+		if allowWildcards && (part == "x" || part == "X" || part == "*") {
+			break
+		}
+
+		if !isNumericSemverIdentifier(part) || (len(part) > 1 && part[0] == '0') {
+			return nil, 0, fmt.Errorf("invalid semantic version: %s", str)
+		}
+
+		n, err := strconv.ParseUint(part, 10, 64)
+		if err != nil {
+			return nil, 0, fmt.Errorf("invalid semantic version: %s; %s", str, err.Error())
+		}
+		*components[i] = n
+		explicit++
+	}
+
+	if explicit < 3 && len(v.PreRelease) > 0 {
+		return nil, 0, fmt.Errorf("invalid semantic version: %s; pre-release requires major, minor and patch", str)
+	}
+
+	return v, explicit, nil
+}
+
+func isNumericSemverIdentifier(ident string) bool {
+	if ident == "" {
+		return false
+	}
+	for _, r := range ident {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// String returns the canonical representation of the version
+func (v *Semver) String() string {
+	str := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if len(v.PreRelease) > 0 {
+		str = fmt.Sprintf("%s-%s", str, strings.Join(v.PreRelease, "."))
+	}
+	if v.Build != nil {
+		str = fmt.Sprintf("%s+%s", str, *v.Build)
+	}
+	return str
+}
+
+// Compare returns -1, 0 or 1 when v is lower than, equal to or greater than other,
+// respectively; build metadata is ignored as per the specification
+func (v *Semver) Compare(other *Semver) int {
+	if c := compareUint64(v.Major, other.Major); c != 0 {
+		return c
+	}
+	if c := compareUint64(v.Minor, other.Minor); c != 0 {
+		return c
+	}
+	if c := compareUint64(v.Patch, other.Patch); c != 0 {
+		return c
+	}
+
+	// a version without a pre-release has higher precedence than one with a pre-release
+	if len(v.PreRelease) == 0 && len(other.PreRelease) == 0 {
+		return 0
+	} else if len(v.PreRelease) == 0 {
+		return 1
+	} else if len(other.PreRelease) == 0 {
+		return -1
+	}
+
+	for i := 0; i < len(v.PreRelease) && i < len(other.PreRelease); i++ {
+		a := v.PreRelease[i]
+		b := other.PreRelease[i]
+		aNumeric := isNumericSemverIdentifier(a)
+		bNumeric := isNumericSemverIdentifier(b)
+
+		if aNumeric && bNumeric {
+			an, _ := strconv.ParseUint(a, 10, 64)
+			bn, _ := strconv.ParseUint(b, 10, 64)
+			if c := compareUint64(an, bn); c != 0 {
+				return c
+			}
+		} else if aNumeric {
+			return -1
+		} else if bNumeric {
+			return 1
+		} else if c := strings.Compare(a, b); c != 0 {
+			return c
+		}
+	}
+
+	return compareUint64(uint64(len(v.PreRelease)), uint64(len(other.PreRelease)))
+}
+
+// IsPreRelease returns true if the version has a pre-release tag
+func (v *Semver) IsPreRelease() bool {
+	return len(v.PreRelease) > 0
+}
+
+func compareUint64(a, b uint64) int {
+	if a < b {
+		return -1
+	} else if a > b {
+		return 1
+	}
+	return 0
+}
+
+// CompareSemver parses and compares the given versions; see Semver.Compare
+func CompareSemver(a, b string) (int, error) {
+	va, err := ParseSemver(a)
+	if err != nil {
+		return 0, err
+	}
+
+	vb, err := ParseSemver(b)
+	if err != nil {
+		return 0, err
+	}
+
+	return va.Compare(vb), nil
+}
+
+type semverComparator struct {
+	op      string
+	version *Semver
+}
+
+func (c *semverComparator) satisfiedBy(v *Semver) bool {
+	cmp := v.Compare(c.version)
+	switch c.op {
+	case "=":
+		return cmp == 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	}
+	return false
+}
+
+// SemverRange is a parsed semantic version range, i.e. ">=1.2.0 <2.0.0 || ^3.0.0";
+// comparator sets separated by "||" are OR'd, and comparators within a set are AND'd
+type SemverRange struct {
+	raw  string
+	sets [][]*semverComparator
+}
+
+// ParseSemverRange parses the given range; supported comparators are =, >, >=, <, <=,
+// caret (^1.2.3), tilde (~1.2.3) and wildcard (1.2.x, 1.*, *) ranges
+func ParseSemverRange(str string) (*SemverRange, error) {
+	rng := &SemverRange{
+		raw:  str,
+		sets: make([][]*semverComparator, 0),
+	}
+
+	for _, set := range strings.Split(str, "||") {
+		comparators := make([]*semverComparator, 0)
+		for _, token := range strings.FieldsFunc(set, func(r rune) bool { return r == ' ' || r == ',' }) {
+			parsed, err := parseSemverComparator(token)
+			if err != nil {
+				return nil, fmt.Errorf("invalid semantic version range: %s; %s", str, err.Error())
+			}
+			comparators = append(comparators, parsed...)
+		}
+
+		if len(comparators) == 0 {
+			return nil, fmt.Errorf("invalid semantic version range: %s", str)
+		}
+		rng.sets = append(rng.sets, comparators)
+	}
+
+	return rng, nil
+}
+
+func parseSemverComparator(token string) ([]*semverComparator, error) {
+	if token == "*" || token == "x" || token == "X" {
+		return []*semverComparator{{op: ">=", version: &Semver{}}}, nil
+	}
+
+	op := ""
+	for _, prefix := range []string{">=", "<=", ">", "<", "=", "^", "~"} {
+		if strings.HasPrefix(token, prefix) {
+			op = prefix
+			break
+		}
+	}
+
+	v, explicit, err := parseSemver(token[len(op):], op == "" || op == "=" || op == "^" || op == "~")
+	if err != nil {
+		return nil, err
+	}
+
+	// upper bound for partial versions, i.e. 1.2.x is >=1.2.0 <1.3.0
+	partialUpperBound := func() *Semver {
+		if explicit == 0 {
+			return nil
+		} else if explicit == 1 {
+			return &Semver{Major: v.Major + 1}
+		}
+		return &Semver{Major: v.Major, Minor: v.Minor + 1}
+	}
+
+	switch op {
+	case "", "=":
+		if explicit == 3 {
+			return []*semverComparator{{op: "=", version: v}}, nil
+		}
+		comparators := []*semverComparator{{op: ">=", version: v}}
+		if upper := partialUpperBound(); upper != nil {
+			comparators = append(comparators, &semverComparator{op: "<", version: upper})
+		}
+		return comparators, nil
+	case "^":
+		var upper *Semver
+		if v.Major > 0 || explicit == 1 {
+			upper = &Semver{Major: v.Major + 1}
+		} else if v.Minor > 0 || explicit == 2 {
+			upper = &Semver{Minor: v.Minor + 1}
+		} else {
+			upper = &Semver{Patch: v.Patch + 1}
+		}
+		return []*semverComparator{{op: ">=", version: v}, {op: "<", version: upper}}, nil
+	case "~":
+		comparators := []*semverComparator{{op: ">=", version: v}}
+		if upper := partialUpperBound(); upper != nil {
+			comparators = append(comparators, &semverComparator{op: "<", version: upper})
+		}
+		return comparators, nil
+	}
+
+	return []*semverComparator{{op: op, version: v}}, nil
+}
+
+// Satisfies returns true if the given version is within the range; pre-release versions
+// only satisfy a comparator set which itself references a pre-release of the same
+// major, minor and patch version
+func (r *SemverRange) Satisfies(v *Semver) bool {
+	for _, set := range r.sets {
+		satisfied := true
+		for _, comparator := range set {
+			if !comparator.satisfiedBy(v) {
+				satisfied = false
+				break
+			}
+		}
+
+		if satisfied && v.IsPreRelease() {
+			satisfied = false
+			for _, comparator := range set {
+				cv := comparator.version
+				if cv.IsPreRelease() && cv.Major == v.Major && cv.Minor == v.Minor && cv.Patch == v.Patch {
+					satisfied = true
+					break
+				}
+			}
+		}
+
+		if satisfied {
+			return true
+		}
+	}
+
+	return false
+}
+
+// String returns the range as it was originally provided
+func (r *SemverRange) String() string {
+	return r.raw
+}
@@ -0,0 +1,148 @@
+// +build unit
+
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package common
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Semver", func() {
+	DescribeTable("ParseSemver",
+		func(str, expected string) {
+			v, err := ParseSemver(str)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(v.String()).To(Equal(expected))
+		},
+		Entry("full version", "1.2.3", "1.2.3"),
+		Entry("leading v", "v1.2.3", "1.2.3"),
+		Entry("omitted patch", "1.2", "1.2.0"),
+		Entry("omitted minor and patch", "1", "1.0.0"),
+		Entry("pre-release", "1.2.3-alpha.1", "1.2.3-alpha.1"),
+		Entry("build metadata", "1.2.3+build.5", "1.2.3+build.5"),
+		Entry("pre-release and build metadata", "1.2.3-rc.1+build.5", "1.2.3-rc.1+build.5"),
+	)
+
+	DescribeTable("ParseSemver with an invalid version",
+		func(str string) {
+			_, err := ParseSemver(str)
+			Expect(err).To(HaveOccurred())
+		},
+		Entry("empty", ""),
+		Entry("non-numeric component", "1.a.3"),
+		Entry("too many components", "1.2.3.4"),
+		Entry("wildcard", "1.x"),
+		Entry("partial pre-release", "1.2-alpha"),
+	)
+
+	DescribeTable("Compare",
+		func(a, b string, expected int) {
+			cmp, err := CompareSemver(a, b)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cmp).To(Equal(expected))
+
+			cmp, err = CompareSemver(b, a)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cmp).To(Equal(-expected))
+		},
+		Entry("equal", "1.2.3", "1.2.3", 0),
+		Entry("major", "2.0.0", "1.9.9", 1),
+		Entry("minor", "1.10.0", "1.9.0", 1),
+		Entry("patch", "1.2.10", "1.2.9", 1),
+		Entry("build metadata is ignored", "1.2.3+a", "1.2.3+b", 0),
+		Entry("release over pre-release", "1.0.0", "1.0.0-rc.1", 1),
+		Entry("numeric pre-release identifiers compared numerically", "1.0.0-alpha.10", "1.0.0-alpha.2", 1),
+		Entry("alphanumeric over numeric pre-release identifiers", "1.0.0-alpha.beta", "1.0.0-alpha.1", 1),
+		Entry("alphanumeric pre-release identifiers compared lexically", "1.0.0-beta", "1.0.0-alpha", 1),
+		Entry("more pre-release identifiers", "1.0.0-alpha.1", "1.0.0-alpha", 1),
+		Entry("specification precedence: rc.1 over beta.11", "1.0.0-rc.1", "1.0.0-beta.11", 1),
+		Entry("specification precedence: beta.11 over beta.2", "1.0.0-beta.11", "1.0.0-beta.2", 1),
+		Entry("specification precedence: beta.2 over beta", "1.0.0-beta.2", "1.0.0-beta", 1),
+		Entry("specification precedence: beta over alpha.beta", "1.0.0-beta", "1.0.0-alpha.beta", 1),
+	)
+
+	DescribeTable("Satisfies",
+		func(rng, version string, expected bool) {
+			r, err := ParseSemverRange(rng)
+			Expect(err).NotTo(HaveOccurred())
+
+			v, err := ParseSemver(version)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(r.Satisfies(v)).To(Equal(expected))
+		},
+		Entry("exact", "1.2.3", "1.2.3", true),
+		Entry("exact with operator", "=1.2.3", "1.2.4", false),
+		Entry("greater than", ">1.2.3", "1.2.4", true),
+		Entry("greater than or equal", ">=1.2.3", "1.2.3", true),
+		Entry("less than", "<1.2.3", "1.2.3", false),
+		Entry("less than or equal", "<=1.2.3", "1.2.3", true),
+		Entry("and", ">=1.2.0 <2.0.0", "1.9.9", true),
+		Entry("and with comma", ">=1.2.0, <2.0.0", "2.0.0", false),
+		Entry("or", "^1.0.0 || ^3.0.0", "3.1.0", true),
+		Entry("or unsatisfied", "^1.0.0 || ^3.0.0", "2.1.0", false),
+
+		Entry("^1.2.3 lower bound", "^1.2.3", "1.2.2", false),
+		Entry("^1.2.3 within major", "^1.2.3", "1.9.0", true),
+		Entry("^1.2.3 next major", "^1.2.3", "2.0.0", false),
+		Entry("^0.2.3 within minor", "^0.2.3", "0.2.9", true),
+		Entry("^0.2.3 next minor", "^0.2.3", "0.3.0", false),
+		Entry("^0.0.3 exact patch", "^0.0.3", "0.0.3", true),
+		Entry("^0.0.3 next patch", "^0.0.3", "0.0.4", false),
+		Entry("^0.x within major zero", "^0.x", "0.9.0", true),
+		Entry("^0.x next major", "^0.x", "1.0.0", false),
+		Entry("^0.0 within minor zero", "^0.0", "0.0.9", true),
+		Entry("^0.0 next minor", "^0.0", "0.1.0", false),
+
+		Entry("~1.2.3 within minor", "~1.2.3", "1.2.9", true),
+		Entry("~1.2.3 next minor", "~1.2.3", "1.3.0", false),
+		Entry("~1.2 lower bound", "~1.2", "1.2.0", true),
+		Entry("~1.2 within minor", "~1.2", "1.2.9", true),
+		Entry("~1.2 next minor", "~1.2", "1.3.0", false),
+		Entry("~1 within major", "~1", "1.9.0", true),
+		Entry("~1 next major", "~1", "2.0.0", false),
+
+		Entry("1.x within major", "1.x", "1.9.9", true),
+		Entry("1.x previous major", "1.x", "0.9.9", false),
+		Entry("1.x next major", "1.x", "2.0.0", false),
+		Entry("1.2.x within minor", "1.2.x", "1.2.9", true),
+		Entry("1.2.x next minor", "1.2.x", "1.3.0", false),
+		Entry("1.* within major", "1.*", "1.4.0", true),
+		Entry("* any version", "*", "9.9.9", true),
+
+		Entry("pre-release excluded from a range without pre-releases", "^1.2.3", "1.3.0-alpha", false),
+		Entry("pre-release excluded from a wildcard", "*", "1.0.0-alpha", false),
+		Entry("pre-release of the referenced version", ">=1.2.3-alpha", "1.2.3-beta", true),
+		Entry("pre-release of another version", ">=1.2.3-alpha", "1.2.4-beta", false),
+		Entry("release satisfies a range with pre-releases", ">=1.2.3-alpha", "1.2.4", true),
+		Entry("pre-release below the referenced pre-release", ">=1.2.3-beta", "1.2.3-alpha", false),
+	)
+
+	DescribeTable("ParseSemverRange with an invalid range",
+		func(rng string) {
+			_, err := ParseSemverRange(rng)
+			Expect(err).To(HaveOccurred())
+		},
+		Entry("empty", ""),
+		Entry("empty comparator set", "^1.0.0 ||"),
+		Entry("invalid version", ">=1.a"),
+		Entry("wildcard with a comparison operator", ">=1.x"),
+	)
+})
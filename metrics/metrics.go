@@ -0,0 +1,166 @@
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/provideplatform/provide-go/common"
+)
+
+const metricsNamespace = "baseline"
+const metricsPath = "/metrics"
+
+const natsMessageResultAck = "ack"
+const natsMessageResultNak = "nak"
+const natsMessageResultTerm = "term"
+
+const outcomeFailure = "failure"
+const outcomeSuccess = "success"
+
+const proverOperationProve = "prove"
+const proverOperationVerify = "verify"
+
+// ProtocolMessageDirectionInbound is the direction label for protocol messages received from counterparties
+const ProtocolMessageDirectionInbound = "inbound"
+
+// ProtocolMessageDirectionOutbound is the direction label for protocol messages sent to counterparties
+const ProtocolMessageDirectionOutbound = "outbound"
+
+var (
+	protocolMessagesCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "protocol_messages_total",
+		Help:      "Number of baseline protocol messages handled, by direction, opcode and outcome",
+	}, []string{"direction", "opcode", "outcome"})
+
+	natsMessagesCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "nats_messages_total",
+		Help:      "Number of NATS messages consumed, by subject and result (ack, nak or term)",
+	}, []string{"subject", "result"})
+
+	proverDurationHistogram = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "prover_duration_seconds",
+		Help:      "Latency of prove and verify calls, by operation and outcome",
+		Buckets:   []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60},
+	}, []string{"operation", "outcome"})
+
+	sorDurationHistogram = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "sor_request_duration_seconds",
+		Help:      "Latency of system of record calls, by system and operation",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"system", "operation"})
+
+	sorErrorsCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "sor_errors_total",
+		Help:      "Number of failed system of record calls, by system and operation",
+	}, []string{"system", "operation"})
+
+	dispatchFailuresCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "dispatch_failures_total",
+		Help:      "Number of failed protocol message dispatches to counterparties, by recipient and reason",
+	}, []string{"recipient", "reason"})
+)
+
+// RunMetricsServer serves the prometheus metrics endpoint on the given listen address until the
+// given context is done; metrics are never exposed on the public API listener
+func RunMetricsServer(ctx context.Context, addr string) {
+	mux := http.NewServeMux()
+	mux.Handle(metricsPath, promhttp.Handler())
+
+	srv := &http.Server{
+		Addr:    addr,
+		Handler: mux,
+	}
+
+	go func() {
+		<-ctx.Done()
+		srv.Shutdown(context.Background())
+	}()
+
+	common.Log.Debugf("serving metrics on %s", addr)
+	err := srv.ListenAndServe()
+	if err != nil && err != http.ErrServerClosed {
+		common.Log.Warningf("metrics server failed; %s", err.Error())
+	}
+}
+
+// Ack acknowledges the given NATS message and records the result
+func Ack(msg *nats.Msg) error {
+	natsMessagesCounter.WithLabelValues(msg.Subject, natsMessageResultAck).Inc()
+	return msg.Ack()
+}
+
+// Nak negatively acknowledges the given NATS message and records the result
+func Nak(msg *nats.Msg) error {
+	natsMessagesCounter.WithLabelValues(msg.Subject, natsMessageResultNak).Inc()
+	return msg.Nak()
+}
+
+// Term terminates redelivery of the given NATS message and records the result
+func Term(msg *nats.Msg) error {
+	natsMessagesCounter.WithLabelValues(msg.Subject, natsMessageResultTerm).Inc()
+	return msg.Term()
+}
+
+// ObserveProtocolMessage records the outcome of handling a protocol message with the given opcode
+func ObserveProtocolMessage(direction, opcode string, success bool) {
+	protocolMessagesCounter.WithLabelValues(direction, opcode, outcome(success)).Inc()
+}
+
+// ObserveProve records the latency of a prove call which started at the given time
+func ObserveProve(startedAt time.Time, err error) {
+	proverDurationHistogram.WithLabelValues(proverOperationProve, outcome(err == nil)).Observe(time.Since(startedAt).Seconds())
+}
+
+// ObserveVerify records the latency of a verify call which started at the given time
+func ObserveVerify(startedAt time.Time, err error) {
+	proverDurationHistogram.WithLabelValues(proverOperationVerify, outcome(err == nil)).Observe(time.Since(startedAt).Seconds())
+}
+
+// ObserveSORRequest records the latency of a system of record call which started at the given
+// time and counts it as an error when err is non-nil
+func ObserveSORRequest(system, operation string, startedAt time.Time, err error) {
+	sorDurationHistogram.WithLabelValues(system, operation).Observe(time.Since(startedAt).Seconds())
+	if err != nil {
+		sorErrorsCounter.WithLabelValues(system, operation).Inc()
+	}
+}
+
+// ObserveDispatchFailure records a failed protocol message dispatch to the given recipient; the
+// recipients are bounded by the workgroup participants
+func ObserveDispatchFailure(recipient, reason string) {
+	dispatchFailuresCounter.WithLabelValues(recipient, reason).Inc()
+}
+
+func outcome(success bool) string {
+	if success {
+		return outcomeSuccess
+	}
+	return outcomeFailure
+}
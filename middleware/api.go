@@ -16,14 +16,28 @@
 
 package middleware
 
-import "github.com/provideplatform/provide-go/common"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strings"
 
+	"github.com/provideplatform/provide-go/api"
+	"github.com/provideplatform/provide-go/common"
+)
+
+const sorIdentifierCSV = "csv"
 const sorIdentifierDynamics365 = "dynamics365"
 const sorIdentifierEphemeralMemory = "ephemeral"
 const sorIdentifierExcel = "excel"
+const sorIdentifierQBS = "qbs"
+const sorIdentifierREST = "rest"
 const sorIdentifierSalesforce = "salesforce"
 const sorIdentifierSAP = "sap"
 const sorIdentifierServiceNow = "servicenow"
+const sorIdentifierSQL = "sql"
 
 const sorTypeGeneralConsistency = "general_consistency"
 const sorTypeServiceNowIncident = "servicenow_incident"
@@ -32,11 +46,12 @@ const SORBusinessObjectStatusError = "error"
 const SORBusinessObjectStatusSuccess = "success"
 
 type System struct {
-	Auth        *SystemAuthentication `json:"auth"`
-	EndpointURL *string               `json:"endpoint_url"`
-	Name        *string               `json:"name"`
-	System      *string               `json:"system"`
-	Type        *string               `json:"type"`
+	Auth        *SystemAuthentication  `json:"auth"`
+	Config      map[string]interface{} `json:"config,omitempty"`
+	EndpointURL *string                `json:"endpoint_url"`
+	Name        *string                `json:"name"`
+	System      *string                `json:"system"`
+	Type        *string                `json:"type"`
 }
 
 type SystemAuthentication struct {
@@ -46,7 +61,39 @@ type SystemAuthentication struct {
 	RequireClientCredentials bool    `json:"require_client_credentials"`
 	ClientID                 *string `json:"client_id"`
 	ClientSecret             *string `json:"client_secret"`
+	PrivateKey               *string `json:"private_key,omitempty"`
+	RefreshToken             *string `json:"refresh_token,omitempty"`
 	Token                    *string `json:"token"`
+	TokenURL                 *string `json:"token_url,omitempty"`
+	Scope                    *string `json:"scope,omitempty"`
+	APIKey                   *string `json:"api_key,omitempty"`
+	APIKeyHeader             *string `json:"api_key_header,omitempty"`
+}
+
+// MapsType returns true if the system declares the given record type, either by listing it in
+// the types config or by routing it to a table or sObject
+func (s *System) MapsType(recordType string) bool {
+	if s.Config == nil {
+		return false
+	}
+
+	if types, ok := s.Config["types"].([]interface{}); ok {
+		for _, typ := range types {
+			if t, ok := typ.(string); ok && t == recordType {
+				return true
+			}
+		}
+	}
+
+	for _, key := range []string{"tables", "sobjects"} {
+		if routes, ok := s.Config[key].(map[string]interface{}); ok {
+			if _, ok := routes[recordType]; ok {
+				return true
+			}
+		}
+	}
+
+	return false
 }
 
 // SOR defines an interface for system of record backends
@@ -62,21 +109,50 @@ type SOR interface {
 	UpdateObjectStatus(id string, params map[string]interface{}) error
 }
 
+// OutboundRecord is a record read from a system of record which is to be baselined
+type OutboundRecord struct {
+	ID         string                 `json:"id"`
+	BaselineID *string                `json:"baseline_id,omitempty"`
+	Type       string                 `json:"type"`
+	Payload    map[string]interface{} `json:"payload"`
+}
+
+// Watcher is implemented by systems of record which produce records to be baselined on their
+// own, rather than by calling the protocol messages API; records are watched for the given
+// organization in the given workgroup, i.e., for a single subject account
+type Watcher interface {
+	Watch(ctx context.Context, organizationID, workgroupID string, handler func(*OutboundRecord) error) error
+}
+
+// WatcherFor returns the watcher impl of the given system of record, if it is a watcher
+func WatcherFor(sor SOR) (Watcher, bool) {
+	if s, ok := sor.(*instrumentedSOR); ok {
+		sor = s.sor
+	}
+
+	watcher, ok := sor.(Watcher)
+	return watcher, ok
+}
+
 // SORFactory initializes and returns a system of record interface impl
 func SORFactory(params map[string]interface{}, token *string) SOR {
 	switch params["identifier"].(string) {
+	case sorIdentifierCSV:
+		return instrumentSOR(sorIdentifierCSV, InitCSVService(token))
 	case sorIdentifierDynamics365:
-		return InitDynamics365Service(token)
+		return instrumentSOR(sorIdentifierDynamics365, InitDynamics365Service(token))
 	case sorIdentifierEphemeralMemory:
-		return InitEphemeralMemoryService(token)
+		return instrumentSOR(sorIdentifierEphemeralMemory, InitEphemeralMemoryService(token))
 	case sorIdentifierExcel:
-		return InitExcelService(token)
+		return instrumentSOR(sorIdentifierExcel, InitExcelService(token))
+	case sorIdentifierQBS:
+		return instrumentSOR(sorIdentifierQBS, InitQBSService(token))
 	case sorIdentifierSAP:
-		return InitSAPService(token)
+		return instrumentSOR(sorIdentifierSAP, InitSAPService(token))
 	case sorIdentifierSalesforce:
-		return InitSalesforceService(token)
+		return instrumentSOR(sorIdentifierSalesforce, InitSalesforceService(token))
 	case sorIdentifierServiceNow:
-		return InitServiceNowService(token)
+		return instrumentSOR(sorIdentifierServiceNow, InitServiceNowService(token))
 	default:
 		break
 	}
@@ -88,9 +164,9 @@ func SORFactory(params map[string]interface{}, token *string) SOR {
 func SORFactoryByType(params map[string]interface{}, recordType string, token *string) SOR {
 	switch recordType {
 	case sorTypeGeneralConsistency:
-		return InitEphemeralMemoryService(token)
+		return instrumentSOR(sorIdentifierEphemeralMemory, InitEphemeralMemoryService(token))
 	case sorTypeServiceNowIncident:
-		return InitServiceNowService(token)
+		return instrumentSOR(sorIdentifierServiceNow, InitServiceNowService(token))
 	default:
 		break
 	}
@@ -102,6 +178,7 @@ func SORFactoryByType(params map[string]interface{}, recordType string, token *s
 func SystemFactory(params *System) SOR {
 	if params.Name == nil && params.System == nil {
 		common.Log.Warningf("middleware system factory called with invalid system identifier")
+		return nil
 	}
 
 	identifier := params.Name
@@ -111,21 +188,119 @@ func SystemFactory(params *System) SOR {
 	}
 
 	switch *identifier {
+	case sorIdentifierCSV:
+		return instrumentSOR(sorIdentifierCSV, CSVFactory(params))
 	case sorIdentifierDynamics365:
-		return Dynamics365Factory(params)
+		return instrumentSOR(sorIdentifierDynamics365, Dynamics365Factory(params))
 	case sorIdentifierEphemeralMemory:
-		return EphemeralMemoryFactory(params)
+		return instrumentSOR(sorIdentifierEphemeralMemory, EphemeralMemoryFactory(params))
 	case sorIdentifierExcel:
-		return ExcelFactory(params)
+		return instrumentSOR(sorIdentifierExcel, ExcelFactory(params))
+	case sorIdentifierQBS:
+		return instrumentSOR(sorIdentifierQBS, QBSFactory(params))
+	case sorIdentifierREST:
+		return instrumentSOR(sorIdentifierREST, RESTFactory(params))
 	case sorIdentifierSAP:
-		return SAPFactory(params)
+		return instrumentSOR(sorIdentifierSAP, SAPFactory(params))
 	case sorIdentifierSalesforce:
-		return SalesforceFactory(params)
+		return instrumentSOR(sorIdentifierSalesforce, SalesforceFactory(params))
 	case sorIdentifierServiceNow:
-		return ServiceNowFactory(params)
+		return instrumentSOR(sorIdentifierServiceNow, ServiceNowFactory(params))
+	case sorIdentifierSQL:
+		return instrumentSOR(sorIdentifierSQL, SQLFactory(params))
 	default:
 		break
 	}
 
 	return nil
 }
+
+// systemClient returns an api client for the endpoint url of the given system, authorized using
+// the bearer token or basic auth credentials of the system, if any
+func systemClient(params *System) (*api.Client, error) {
+	if params.EndpointURL == nil {
+		return nil, errors.New("endpoint url not provided")
+	}
+
+	endpoint, err := url.Parse(*params.EndpointURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse endpoint url: %s", *params.EndpointURL)
+	}
+
+	if endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid endpoint url: %s", *params.EndpointURL)
+	}
+
+	client := &api.Client{
+		Host:   endpoint.Host,
+		Path:   endpoint.Path,
+		Scheme: endpoint.Scheme,
+	}
+
+	if params.Auth != nil {
+		client.Token = params.Auth.Token
+		client.Username = params.Auth.Username
+		client.Password = params.Auth.Password
+	}
+
+	return client, nil
+}
+
+// systemFilePath returns the local path of the endpoint url of the given system, which may use
+// the file:// scheme; relative paths are resolved against the given root, and paths outside of
+// the root are rejected such that systems cannot read or write arbitrary files
+func systemFilePath(params *System, root string) (string, error) {
+	if params.EndpointURL == nil {
+		return "", errors.New("endpoint url not provided")
+	}
+
+	if root == "" {
+		return "", errors.New("root directory not configured")
+	}
+
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return "", fmt.Errorf("invalid root directory: %s; %s", root, err.Error())
+	}
+
+	path := *params.EndpointURL
+	if endpoint, err := url.Parse(path); err == nil && endpoint.Scheme == "file" {
+		path = endpoint.Path
+	}
+
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(root, path)
+	}
+	path = filepath.Clean(path)
+
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, fmt.Sprintf("..%c", filepath.Separator)) {
+		return "", fmt.Errorf("endpoint url is outside of the root directory: %s", *params.EndpointURL)
+	}
+
+	return path, nil
+}
+
+// sorRecordType returns the record type of the given record or status params
+func sorRecordType(params map[string]interface{}) string {
+	switch typ := params["type"].(type) {
+	case string:
+		return typ
+	case *string:
+		if typ != nil {
+			return *typ
+		}
+	}
+	return ""
+}
+
+// sorRecordParams returns the record type and payload of the given params, which either wrap
+// the payload along with its type or are themselves the payload; the type is only read from
+// wrapped params, such that a type field of the business object is never taken as its type
+func sorRecordParams(params map[string]interface{}) (string, map[string]interface{}) {
+	if payload, ok := params["payload"].(map[string]interface{}); ok {
+		return sorRecordType(params), payload
+	}
+
+	return "", params
+}
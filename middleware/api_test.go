@@ -0,0 +1,61 @@
+// +build unit
+
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package middleware
+
+import (
+	"github.com/provideplatform/provide-go/common"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("System", func() {
+	DescribeTable("MapsType",
+		func(config map[string]interface{}, recordType string, expected bool) {
+			sys := &System{Config: config}
+			Expect(sys.MapsType(recordType)).To(Equal(expected))
+		},
+		Entry("without config", nil, "purchase_order", false),
+		Entry("listed type", map[string]interface{}{"types": []interface{}{"invoice", "purchase_order"}}, "purchase_order", true),
+		Entry("unlisted type", map[string]interface{}{"types": []interface{}{"invoice"}}, "purchase_order", false),
+		Entry("routed table", map[string]interface{}{"tables": map[string]interface{}{"purchase_order": "u_purchase_order"}}, "purchase_order", true),
+		Entry("routed sObject", map[string]interface{}{"sobjects": map[string]interface{}{"purchase_order": "Order"}}, "purchase_order", true),
+		Entry("unrouted type", map[string]interface{}{"sobjects": map[string]interface{}{"invoice": "Invoice__c"}}, "purchase_order", false),
+		Entry("default table only", map[string]interface{}{"default_table": "task"}, "purchase_order", false),
+	)
+})
+
+var _ = Describe("sorRecordParams", func() {
+	It("should return the type and payload of wrapped params", func() {
+		recordType, payload := sorRecordParams(map[string]interface{}{
+			"baseline_id": "b-1",
+			"payload":     map[string]interface{}{"po_number": "PO-1", "type": "standard"},
+			"type":        common.StringOrNil("purchase_order"),
+		})
+		Expect(recordType).To(Equal("purchase_order"))
+		Expect(payload).To(Equal(map[string]interface{}{"po_number": "PO-1", "type": "standard"}))
+	})
+
+	It("should not read the type of unwrapped params", func() {
+		recordType, payload := sorRecordParams(map[string]interface{}{"po_number": "PO-1", "type": "standard"})
+		Expect(recordType).To(BeEmpty())
+		Expect(payload).To(Equal(map[string]interface{}{"po_number": "PO-1", "type": "standard"}))
+	})
+})
@@ -0,0 +1,538 @@
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package middleware
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	uuid "github.com/kthomas/go.uuid"
+	"github.com/provideplatform/provide-go/common"
+)
+
+const csvColumnID = "id"
+const csvColumnBaselineID = "baseline_id"
+const csvColumnOperation = "operation"
+const csvColumnType = "type"
+
+const csvOperationCreate = "create"
+const csvOperationUpdate = "update"
+
+const csvFileExtension = ".csv"
+const csvStatusFileExtension = ".status.json"
+const csvErrorsFileExtension = ".errors.json"
+
+const csvInboundDirName = "inbound"
+const csvOutboundDirName = "outbound"
+const csvProcessingDirName = ".processing"
+const csvProcessedDirName = "processed"
+const csvFailedDirName = "failed"
+
+const csvInboundPollInterval = time.Second * 5
+
+// csvInboundSettleInterval is the minimum age of an inbound file before it is read, such that
+// files which are still being written by the partner are not read prematurely
+const csvInboundSettleInterval = time.Second * 2
+
+// CSVService is a system of record for partners which exchange csv files rather than exposing
+// an API; csv files dropped in the inbound directory are read and each row is handed to the
+// watcher, and records created or updated by the BPI are written as csv files to the outbound
+// directory along with a json status sidecar for each record
+type CSVService struct {
+	mutex       sync.Mutex
+	inboundDir  string
+	outboundDir string
+}
+
+// CSVFactory initializes a CSV instance; the endpoint url is the path to the exchange directory,
+// optionally using the file:// scheme, relative to or within the CSV_EXCHANGE_ROOT directory;
+// the exchange directory contains the inbound and outbound directories
+func CSVFactory(params *System) *CSVService {
+	path, err := systemFilePath(params, os.Getenv("CSV_EXCHANGE_ROOT"))
+	if err != nil {
+		common.Log.Warningf("invalid csv exchange directory; %s", err.Error())
+		return nil
+	}
+
+	return &CSVService{
+		mutex:       sync.Mutex{},
+		inboundDir:  filepath.Join(path, csvInboundDirName),
+		outboundDir: filepath.Join(path, csvOutboundDirName),
+	}
+}
+
+// InitCSVService convenience method to initialize a default `CSVService` instance
+// using the CSV_INBOUND_DIR and CSV_OUTBOUND_DIR directories
+func InitCSVService(token *string) *CSVService {
+	return &CSVService{
+		mutex:       sync.Mutex{},
+		inboundDir:  os.Getenv("CSV_INBOUND_DIR"),
+		outboundDir: os.Getenv("CSV_OUTBOUND_DIR"),
+	}
+}
+
+// Authenticate is not implemented for CSV at this time
+func (s *CSVService) Authenticate() error {
+	return nil
+}
+
+// ConfigureTenant creates the inbound and outbound directories if they do not yet exist
+func (s *CSVService) ConfigureTenant(params map[string]interface{}) error {
+	for _, dir := range []string{
+		s.inboundDir,
+		filepath.Join(s.inboundDir, csvProcessingDirName),
+		filepath.Join(s.inboundDir, csvProcessedDirName),
+		filepath.Join(s.inboundDir, csvFailedDirName),
+		s.outboundDir,
+	} {
+		if dir == "" {
+			return fmt.Errorf("csv exchange directories not configured")
+		}
+
+		err := os.MkdirAll(dir, 0750)
+		if err != nil {
+			return fmt.Errorf("failed to configure csv exchange directory: %s; %s", dir, err.Error())
+		}
+	}
+
+	return nil
+}
+
+// ListSchemas is not supported; csv files do not declare a schema
+func (s *CSVService) ListSchemas(params map[string]interface{}) (interface{}, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+// GetSchema is not supported; csv files do not declare a schema
+func (s *CSVService) GetSchema(recordType string, params map[string]interface{}) (interface{}, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+// CreateObject writes the business object as a csv file to the outbound directory
+func (s *CSVService) CreateObject(params map[string]interface{}) (interface{}, error) {
+	recordType, payload := sorRecordParams(params)
+	if recordType == "" {
+		return nil, fmt.Errorf("failed to create business object; record type required")
+	}
+
+	id, err := uuid.NewV4()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create business object; %s", err.Error())
+	}
+
+	record := map[string]interface{}{}
+	for k, v := range payload {
+		record[k] = v
+	}
+	record[csvColumnID] = id.String()
+	record[csvColumnType] = recordType
+	if baselineID, ok := params[csvColumnBaselineID].(string); ok {
+		record[csvColumnBaselineID] = baselineID
+	}
+
+	err = s.writeOutbound(csvOperationCreate, id.String(), record)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create business object; %s", err.Error())
+	}
+
+	return record, nil
+}
+
+// UpdateObject writes the updated business object as a csv file to the outbound directory
+func (s *CSVService) UpdateObject(id string, params map[string]interface{}) error {
+	recordType, payload := sorRecordParams(params)
+
+	record := map[string]interface{}{}
+	for k, v := range payload {
+		record[k] = v
+	}
+	record[csvColumnID] = id
+	if recordType != "" {
+		record[csvColumnType] = recordType
+	}
+	if baselineID, ok := params[csvColumnBaselineID].(string); ok {
+		record[csvColumnBaselineID] = baselineID
+	}
+
+	err := s.writeOutbound(csvOperationUpdate, id, record)
+	if err != nil {
+		return fmt.Errorf("failed to update business object; %s", err.Error())
+	}
+
+	return nil
+}
+
+// UpdateObjectStatus writes the baseline status of the business object with the given id
+// to its status sidecar in the outbound directory, replacing any previous status
+func (s *CSVService) UpdateObjectStatus(id string, params map[string]interface{}) error {
+	recordType := sorRecordType(params)
+
+	status := map[string]interface{}{
+		"id":         id,
+		"status":     params["status"],
+		"message_id": params["message_id"],
+		"errors":     params["errors"],
+		"updated_at": time.Now().UTC().Format(time.RFC3339),
+	}
+	if recordType != "" {
+		status["type"] = recordType
+	}
+	if baselineID, ok := params[csvColumnBaselineID].(string); ok {
+		status[csvColumnBaselineID] = baselineID
+	}
+
+	raw, err := json.MarshalIndent(status, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to update business object status; %s", err.Error())
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	err = writeFileAtomic(s.outboundDir, fmt.Sprintf("%s%s", csvFileName(id), csvStatusFileExtension), raw)
+	if err != nil {
+		return fmt.Errorf("failed to update business object status; %s", err.Error())
+	}
+
+	return nil
+}
+
+// DeleteTenant drops a proxy configuration for the given organization
+func (s *CSVService) DeleteTenant(organizationID string) error {
+	return fmt.Errorf("not implemented")
+}
+
+// HealthCheck checks that the inbound and outbound directories are accessible
+func (s *CSVService) HealthCheck() error {
+	for _, dir := range []string{s.inboundDir, s.outboundDir} {
+		if dir == "" {
+			return fmt.Errorf("csv exchange directories not configured")
+		}
+
+		info, err := os.Stat(dir)
+		if err != nil {
+			return fmt.Errorf("csv exchange directory not accessible; %s", err.Error())
+		}
+
+		if !info.IsDir() {
+			return fmt.Errorf("csv exchange directory not accessible: %s", dir)
+		}
+	}
+
+	return nil
+}
+
+// TenantHealthCheck checks the health of the exchange directories; they are not tenant-specific
+func (s *CSVService) TenantHealthCheck(organizationID string) error {
+	return s.HealthCheck()
+}
+
+// Watch polls the inbound directory for new csv files until the given context is done; each
+// row of each file is passed to the given handler, after which the file is moved to the processed
+// directory or, if the handler failed for any row, to the failed directory along with a json
+// sidecar describing the failed rows; the directories are those of the system of the subject account
+func (s *CSVService) Watch(ctx context.Context, organizationID, workgroupID string, handler func(*OutboundRecord) error) error {
+	err := s.ConfigureTenant(map[string]interface{}{})
+	if err != nil {
+		return err
+	}
+
+	timer := time.NewTicker(csvInboundPollInterval)
+	defer timer.Stop()
+
+	for {
+		s.pollInbound(handler)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-timer.C:
+		}
+	}
+}
+
+// pollInbound claims and reads each settled csv file in the inbound directory
+func (s *CSVService) pollInbound(handler func(*OutboundRecord) error) {
+	entries, err := ioutil.ReadDir(s.inboundDir)
+	if err != nil {
+		common.Log.Warningf("failed to read csv inbound directory; %s", err.Error())
+		return
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].ModTime().Before(entries[j].ModTime())
+	})
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || strings.HasPrefix(name, ".") || !strings.EqualFold(filepath.Ext(name), csvFileExtension) {
+			continue
+		}
+
+		if time.Since(entry.ModTime()) < csvInboundSettleInterval {
+			continue
+		}
+
+		// claiming the file by moving it ensures it is read once, even when several
+		// processes watch the same inbound directory
+		path := filepath.Join(s.inboundDir, csvProcessingDirName, name)
+		err := os.Rename(filepath.Join(s.inboundDir, name), path)
+		if err != nil {
+			common.Log.Debugf("failed to claim inbound csv file: %s; %s", name, err.Error())
+			continue
+		}
+
+		rowErrors := s.readInbound(path, handler)
+		s.archiveInbound(path, rowErrors)
+	}
+}
+
+// readInbound passes each row of the csv file at the given path to the handler, returning
+// the errors keyed by row number
+func (s *CSVService) readInbound(path string, handler func(*OutboundRecord) error) map[string]string {
+	rowErrors := map[string]string{}
+
+	f, err := os.Open(path)
+	if err != nil {
+		rowErrors["0"] = err.Error()
+		return rowErrors
+	}
+	defer f.Close()
+
+	reader := csv.NewReader(f)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		rowErrors["1"] = fmt.Sprintf("failed to read header row; %s", err.Error())
+		return rowErrors
+	}
+	for i := range header {
+		header[i] = strings.TrimSpace(strings.TrimPrefix(header[i], "\ufeff"))
+	}
+
+	defaultType := csvRecordTypeFromFileName(filepath.Base(path))
+
+	for i := 2; ; i++ {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			rowErrors[fmt.Sprintf("%d", i)] = err.Error()
+			break
+		}
+
+		record := &OutboundRecord{
+			Type:    defaultType,
+			Payload: map[string]interface{}{},
+		}
+
+		for j, name := range header {
+			if j >= len(row) || name == "" {
+				continue
+			}
+
+			switch name {
+			case csvColumnID:
+				record.ID = row[j]
+			case csvColumnBaselineID:
+				if row[j] != "" {
+					record.BaselineID = common.StringOrNil(row[j])
+				}
+			case csvColumnType:
+				if row[j] != "" {
+					record.Type = row[j]
+				}
+			default:
+				record.Payload[name] = row[j]
+			}
+		}
+
+		if record.ID == "" {
+			rowErrors[fmt.Sprintf("%d", i)] = "id required"
+			continue
+		}
+
+		err = handler(record)
+		if err != nil {
+			rowErrors[fmt.Sprintf("%d", i)] = err.Error()
+		}
+	}
+
+	return rowErrors
+}
+
+// archiveInbound moves the processed csv file at the given path to the processed or failed directory;
+// archived files are prefixed with the current time such that partners may reuse file names
+func (s *CSVService) archiveInbound(path string, rowErrors map[string]string) {
+	name := fmt.Sprintf("%d-%s", time.Now().UnixNano(), filepath.Base(path))
+
+	dir := filepath.Join(s.inboundDir, csvProcessedDirName)
+	if len(rowErrors) > 0 {
+		dir = filepath.Join(s.inboundDir, csvFailedDirName)
+
+		raw, _ := json.MarshalIndent(rowErrors, "", "  ")
+		err := writeFileAtomic(dir, fmt.Sprintf("%s%s", name, csvErrorsFileExtension), raw)
+		if err != nil {
+			common.Log.Warningf("failed to write errors for inbound csv file: %s; %s", name, err.Error())
+		}
+
+		common.Log.Warningf("failed to process %d row(s) of inbound csv file: %s", len(rowErrors), name)
+	}
+
+	err := os.Rename(path, filepath.Join(dir, name))
+	if err != nil {
+		common.Log.Warningf("failed to archive inbound csv file: %s; %s", name, err.Error())
+	}
+}
+
+// writeOutbound writes the given record as a single-row csv file in the outbound directory;
+// files are named using the current time such that partners can read them in order
+func (s *CSVService) writeOutbound(operation, id string, record map[string]interface{}) error {
+	columns := []string{csvColumnOperation}
+	for k := range record {
+		if k != csvColumnOperation {
+			columns = append(columns, k)
+		}
+	}
+	sort.Slice(columns, func(i, j int) bool {
+		// the operation, id, baseline id and type are the leading columns
+		return csvColumnOrder(columns[i]) < csvColumnOrder(columns[j]) ||
+			(csvColumnOrder(columns[i]) == csvColumnOrder(columns[j]) && columns[i] < columns[j])
+	})
+
+	values := make([]string, len(columns))
+	values[0] = operation
+	for i, k := range columns[1:] {
+		values[i+1] = csvCellValue(record[k])
+	}
+
+	var buf strings.Builder
+	writer := csv.NewWriter(&buf)
+	writer.Write(columns)
+	writer.Write(values)
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	name := fmt.Sprintf("%d-%s-%s%s", time.Now().UnixNano(), operation, csvFileName(id), csvFileExtension)
+	return writeFileAtomic(s.outboundDir, name, []byte(buf.String()))
+}
+
+func csvColumnOrder(name string) int {
+	switch name {
+	case csvColumnOperation:
+		return 0
+	case csvColumnID:
+		return 1
+	case csvColumnBaselineID:
+		return 2
+	case csvColumnType:
+		return 3
+	default:
+		return 4
+	}
+}
+
+// csvCellValue returns the given value as a csv field; composite values are written as json
+func csvCellValue(val interface{}) string {
+	switch v := val.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case *string:
+		if v == nil {
+			return ""
+		}
+		return *v
+	case map[string]interface{}, []interface{}:
+		raw, _ := json.Marshal(v)
+		return string(raw)
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+// csvFileName returns the given record id in a form which is safe to use in a file name
+func csvFileName(id string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == os.PathSeparator {
+			return '_'
+		}
+		return r
+	}, id)
+}
+
+// csvRecordTypeFromFileName returns the record type of rows which do not include a type column,
+// i.e., the name of the file up to the first '-', such that purchase_order-20220401.csv
+// contains purchase_order records
+func csvRecordTypeFromFileName(name string) string {
+	name = strings.TrimSuffix(name, filepath.Ext(name))
+	if i := strings.Index(name, "-"); i != -1 {
+		name = name[:i]
+	}
+	return name
+}
+
+// writeFileAtomic writes the given file to a temporary file in the given directory which then
+// atomically replaces the named file, such that partners never read partially-written files
+func writeFileAtomic(dir, name string, data []byte) error {
+	if dir == "" {
+		return fmt.Errorf("directory not configured")
+	}
+
+	err := os.MkdirAll(dir, 0750)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(dir, fmt.Sprintf(".%s.*", name))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), filepath.Join(dir, name))
+}
@@ -0,0 +1,279 @@
+// +build unit
+
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package middleware
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/provideplatform/provide-go/common"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("CSV", func() {
+	var dir string
+	var svc *CSVService
+
+	// writeInbound drops a settled csv file in the inbound directory
+	writeInbound := func(name, content string) {
+		path := filepath.Join(svc.inboundDir, name)
+		Expect(ioutil.WriteFile(path, []byte(content), 0640)).To(Succeed())
+
+		settled := time.Now().Add(-csvInboundSettleInterval * 2)
+		Expect(os.Chtimes(path, settled, settled)).To(Succeed())
+	}
+
+	readDir := func(path string) []string {
+		entries, err := ioutil.ReadDir(path)
+		Expect(err).NotTo(HaveOccurred())
+
+		names := make([]string, 0)
+		for _, entry := range entries {
+			if !entry.IsDir() {
+				names = append(names, entry.Name())
+			}
+		}
+		return names
+	}
+
+	readCSV := func(path string) [][]string {
+		f, err := os.Open(path)
+		Expect(err).NotTo(HaveOccurred())
+		defer f.Close()
+
+		rows, err := csv.NewReader(f).ReadAll()
+		Expect(err).NotTo(HaveOccurred())
+		return rows
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "baseline-csv")
+		Expect(err).NotTo(HaveOccurred())
+		os.Setenv("CSV_EXCHANGE_ROOT", dir)
+
+		svc = CSVFactory(&System{EndpointURL: common.StringOrNil("file://" + filepath.Join(dir, "tenant"))})
+		Expect(svc).NotTo(BeNil())
+		Expect(svc.ConfigureTenant(map[string]interface{}{})).To(Succeed())
+	})
+
+	AfterEach(func() {
+		os.Unsetenv("CSV_EXCHANGE_ROOT")
+		os.RemoveAll(dir)
+	})
+
+	Describe("CSVFactory", func() {
+		It("should resolve relative paths against the exchange root", func() {
+			svc := CSVFactory(&System{EndpointURL: common.StringOrNil("tenant")})
+			Expect(svc).NotTo(BeNil())
+			Expect(svc.inboundDir).To(Equal(filepath.Join(dir, "tenant", csvInboundDirName)))
+			Expect(svc.outboundDir).To(Equal(filepath.Join(dir, "tenant", csvOutboundDirName)))
+		})
+
+		DescribeTable("should reject paths outside of the exchange root",
+			func(endpointURL string) {
+				Expect(CSVFactory(&System{EndpointURL: common.StringOrNil(endpointURL)})).To(BeNil())
+			},
+			Entry("parent directory", ".."),
+			Entry("nested parent directory", "tenant/../../exchange"),
+			Entry("absolute path", "/etc"),
+			Entry("file url", "file:///etc"),
+		)
+
+		It("should reject paths when the exchange root is not configured", func() {
+			os.Unsetenv("CSV_EXCHANGE_ROOT")
+			Expect(CSVFactory(&System{EndpointURL: common.StringOrNil(filepath.Join(dir, "tenant"))})).To(BeNil())
+		})
+	})
+
+	Describe("CreateObject", func() {
+		It("should write the business object as a csv file to the outbound directory", func() {
+			resp, err := svc.CreateObject(map[string]interface{}{
+				"type":        "purchase_order",
+				"baseline_id": "b-1",
+				"payload": map[string]interface{}{
+					"amount":    12.5,
+					"lines":     []interface{}{"a", "b"},
+					"po_number": "PO-1",
+				},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			id := resp.(map[string]interface{})["id"].(string)
+
+			names := readDir(svc.outboundDir)
+			Expect(names).To(HaveLen(1))
+			Expect(names[0]).To(HaveSuffix("-create-" + id + csvFileExtension))
+
+			Expect(readCSV(filepath.Join(svc.outboundDir, names[0]))).To(Equal([][]string{
+				{"operation", "id", "baseline_id", "type", "amount", "lines", "po_number"},
+				{"create", id, "b-1", "purchase_order", "12.5", `["a","b"]`, "PO-1"},
+			}))
+		})
+
+		It("should fail without a record type", func() {
+			_, err := svc.CreateObject(map[string]interface{}{"po_number": "PO-1"})
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("UpdateObjectStatus", func() {
+		It("should replace the status sidecar of the business object", func() {
+			for _, status := range []string{"pending", "success"} {
+				Expect(svc.UpdateObjectStatus("po/1", map[string]interface{}{
+					"type":   "purchase_order",
+					"status": status,
+				})).To(Succeed())
+			}
+
+			names := readDir(svc.outboundDir)
+			Expect(names).To(Equal([]string{"po_1" + csvStatusFileExtension}))
+
+			raw, err := ioutil.ReadFile(filepath.Join(svc.outboundDir, names[0]))
+			Expect(err).NotTo(HaveOccurred())
+
+			var status map[string]interface{}
+			Expect(json.Unmarshal(raw, &status)).To(Succeed())
+			Expect(status["id"]).To(Equal("po/1"))
+			Expect(status["status"]).To(Equal("success"))
+			Expect(status["type"]).To(Equal("purchase_order"))
+		})
+	})
+
+	Describe("pollInbound", func() {
+		var records []*OutboundRecord
+
+		handler := func(record *OutboundRecord) error {
+			records = append(records, record)
+			if record.Payload["po_number"] == "invalid" {
+				return errors.New("invalid po number")
+			}
+			return nil
+		}
+
+		BeforeEach(func() {
+			records = make([]*OutboundRecord, 0)
+		})
+
+		It("should pass each row to the handler and archive the file", func() {
+			writeInbound("purchase_order-20220401.csv", "\ufeffid, baseline_id,po_number\n1,b-1,PO-1\n2,,PO-2\n")
+			svc.pollInbound(handler)
+
+			Expect(records).To(Equal([]*OutboundRecord{
+				{ID: "1", BaselineID: common.StringOrNil("b-1"), Type: "purchase_order", Payload: map[string]interface{}{"po_number": "PO-1"}},
+				{ID: "2", Type: "purchase_order", Payload: map[string]interface{}{"po_number": "PO-2"}},
+			}))
+
+			Expect(readDir(svc.inboundDir)).To(BeEmpty())
+			Expect(readDir(filepath.Join(svc.inboundDir, csvProcessingDirName))).To(BeEmpty())
+
+			archived := readDir(filepath.Join(svc.inboundDir, csvProcessedDirName))
+			Expect(archived).To(HaveLen(1))
+			Expect(archived[0]).To(MatchRegexp(`^\d+-purchase_order-20220401\.csv$`))
+		})
+
+		It("should read the record type from the type column", func() {
+			writeInbound("orders.csv", "id,type,po_number\n1,invoice,PO-1\n2,,PO-2\n")
+			svc.pollInbound(handler)
+
+			Expect(records).To(HaveLen(2))
+			Expect(records[0].Type).To(Equal("invoice"))
+			Expect(records[1].Type).To(Equal("orders"))
+		})
+
+		It("should not read files which are still being written", func() {
+			Expect(ioutil.WriteFile(filepath.Join(svc.inboundDir, "orders.csv"), []byte("id\n1\n"), 0640)).To(Succeed())
+			svc.pollInbound(handler)
+
+			Expect(records).To(BeEmpty())
+			Expect(readDir(svc.inboundDir)).To(Equal([]string{"orders.csv"}))
+		})
+
+		It("should ignore files which are not csv files", func() {
+			writeInbound("orders.txt", "id\n1\n")
+			writeInbound(".orders.csv", "id\n1\n")
+			svc.pollInbound(handler)
+
+			Expect(records).To(BeEmpty())
+		})
+
+		It("should archive files having failed rows to the failed directory along with the errors", func() {
+			writeInbound("orders.csv", "id,po_number\n1,PO-1\n,PO-2\n3,invalid\n")
+			svc.pollInbound(handler)
+
+			Expect(records).To(HaveLen(2))
+			Expect(readDir(filepath.Join(svc.inboundDir, csvProcessedDirName))).To(BeEmpty())
+
+			failed := readDir(filepath.Join(svc.inboundDir, csvFailedDirName))
+			Expect(failed).To(HaveLen(2))
+			Expect(failed[0]).To(MatchRegexp(`^\d+-orders\.csv$`))
+			Expect(failed[1]).To(Equal(failed[0] + csvErrorsFileExtension))
+
+			raw, err := ioutil.ReadFile(filepath.Join(svc.inboundDir, csvFailedDirName, failed[1]))
+			Expect(err).NotTo(HaveOccurred())
+
+			var rowErrors map[string]string
+			Expect(json.Unmarshal(raw, &rowErrors)).To(Succeed())
+			Expect(rowErrors).To(Equal(map[string]string{
+				"3": "id required",
+				"4": "invalid po number",
+			}))
+		})
+
+		It("should not overwrite archived files of the same name", func() {
+			writeInbound("orders.csv", "id,po_number\n1,PO-1\n")
+			svc.pollInbound(handler)
+			writeInbound("orders.csv", "id,po_number\n2,PO-2\n")
+			svc.pollInbound(handler)
+
+			Expect(records).To(HaveLen(2))
+			Expect(readDir(filepath.Join(svc.inboundDir, csvProcessedDirName))).To(HaveLen(2))
+		})
+	})
+
+	DescribeTable("csvRecordTypeFromFileName",
+		func(name, expected string) {
+			Expect(csvRecordTypeFromFileName(name)).To(Equal(expected))
+		},
+		Entry("without suffix", "purchase_order.csv", "purchase_order"),
+		Entry("with suffix", "purchase_order-20220401.csv", "purchase_order"),
+		Entry("with several suffixes", "invoice-2022-04-01.CSV", "invoice"),
+	)
+
+	DescribeTable("csvCellValue",
+		func(val interface{}, expected string) {
+			Expect(csvCellValue(val)).To(Equal(expected))
+		},
+		Entry("nil", nil, ""),
+		Entry("string", "PO-1", "PO-1"),
+		Entry("nil string pointer", (*string)(nil), ""),
+		Entry("string pointer", common.StringOrNil("PO-1"), "PO-1"),
+		Entry("number", 12.5, "12.5"),
+		Entry("boolean", true, "true"),
+		Entry("object", map[string]interface{}{"a": 1.0}, `{"a":1}`),
+		Entry("array", []interface{}{"a", 1.0}, `["a",1]`),
+	)
+})
@@ -21,15 +21,14 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
+	"net/url"
 	"os"
-	"os/signal"
+	"strings"
 	"sync"
-	"sync/atomic"
-	"syscall"
 	"time"
 
 	servicebus "github.com/Azure/azure-service-bus-go"
+	uuid "github.com/kthomas/go.uuid"
 	"github.com/provideplatform/provide-go/api"
 	"github.com/provideplatform/provide-go/common"
 )
@@ -38,60 +37,165 @@ const defaultServiceBusInboundQueueName = "baseline.inbound"
 const defaultServiceBusOutboundQueueName = "baseline.outbound"
 const defaultServiceBusContextTimeout = 10 * time.Second
 
-const subscribeTickInterval = 500 * time.Millisecond
-const subscribeSleepInterval = 250 * time.Millisecond
+// defaultDynamics365ReplyTimeout is how long a request sent to D365 awaits its reply
+const defaultDynamics365ReplyTimeout = 30 * time.Second
 
-// Dynamics365Factory initializes a Dynamics365Service instance
+// dynamics365ResubscribeInterval is how long the subscriber waits to receive from the outbound
+// queue again after the receiver fails
+const dynamics365ResubscribeInterval = 5 * time.Second
+
+// dynamics365SubscriberIdleTimeout is how long the subscriber keeps receiving from the outbound
+// queue once it has no pending requests and no watchers
+const dynamics365SubscriberIdleTimeout = time.Minute
+
+const dynamics365OperationConfigureTenant = "configure_tenant"
+const dynamics365OperationCreateObject = "create_object"
+const dynamics365OperationDeleteTenant = "delete_tenant"
+const dynamics365OperationGetSchema = "get_schema"
+const dynamics365OperationHealthCheck = "health_check"
+const dynamics365OperationListSchemas = "list_schemas"
+const dynamics365OperationTenantHealthCheck = "tenant_health_check"
+const dynamics365OperationUpdateObject = "update_object"
+const dynamics365OperationUpdateObjectStatus = "update_object_status"
+
+const dynamics365ReplyStatusError = "error"
+
+// dynamics365Bus sends and receives messages using the queues of a service bus namespace
+type dynamics365Bus interface {
+	Send(ctx context.Context, queueName string, msg *servicebus.Message) error
+
+	// Receive blocks, handling each message received from the given queue until the context is
+	// done; messages are completed if handled without error, and abandoned otherwise
+	Receive(ctx context.Context, queueName string, handler func(*servicebus.Message) error) error
+}
+
+// dynamics365Subscriber is the single subscriber to the outbound queue of a namespace shared by
+// all D365 instances using it; replies are dispatched by correlation id to the pending requests
+// and all other messages are events dispatched to the watcher of the organization and workgroup
+// of the event. The subscriber receives from the queue while it has pending requests or watchers
+type dynamics365Subscriber struct {
+	queueName   string
+	idleTimeout time.Duration
+
+	mutex     sync.Mutex
+	bus       dynamics365Bus
+	connStr   string
+	cancel    context.CancelFunc
+	idleTimer *time.Timer
+	pending   map[string]chan *servicebus.Message
+	watchers  map[dynamics365WatcherKey][]*func(*OutboundRecord) error
+}
+
+// dynamics365WatcherKey identifies the watchers of the events of an organization in a workgroup;
+// an organization participating in several workgroups watches the queue once for each of them
+type dynamics365WatcherKey struct {
+	organizationID string
+	workgroupID    string
+}
+
+var (
+	// dynamics365Subscribers are the shared outbound queue subscribers, keyed by namespace and queue
+	dynamics365Subscribers     = map[string]*dynamics365Subscriber{}
+	dynamics365SubscribersLock = &sync.Mutex{}
+)
+
+// Dynamics365Factory initializes a Dynamics365Service instance; the endpoint url is either a
+// service bus connection string or the sb:// endpoint of the namespace, in which case the auth
+// username and password are the shared access key name and key. The inbound_queue and
+// outbound_queue config override the default queue names, and reply_timeout the number of
+// seconds requests await their replies
 func Dynamics365Factory(params *System) *Dynamics365Service {
-	common.Log.Warningf("Dynamics365Factory not implemented")
-	return nil
+	if params.EndpointURL == nil {
+		common.Log.Warningf("azure service bus endpoint not provided")
+		return nil
+	}
+
+	connStr := *params.EndpointURL
+	if !strings.HasPrefix(connStr, "Endpoint=") {
+		if params.Auth == nil || params.Auth.Username == nil || params.Auth.Password == nil {
+			common.Log.Warningf("azure service bus shared access key name and key required")
+			return nil
+		}
+		connStr = fmt.Sprintf("Endpoint=%s;SharedAccessKeyName=%s;SharedAccessKey=%s", connStr, *params.Auth.Username, *params.Auth.Password)
+	}
+
+	ns, err := servicebus.NewNamespace(servicebus.NamespaceWithConnectionString(connStr))
+	if err != nil {
+		common.Log.Warningf("failed to initialize azure service bus namespace; %s", err.Error())
+		return nil
+	}
+
+	inboundQueueName := defaultServiceBusInboundQueueName
+	if queueName, ok := params.Config["inbound_queue"].(string); ok && queueName != "" {
+		inboundQueueName = queueName
+	}
+
+	outboundQueueName := defaultServiceBusOutboundQueueName
+	if queueName, ok := params.Config["outbound_queue"].(string); ok && queueName != "" {
+		outboundQueueName = queueName
+	}
+
+	replyTimeout := defaultDynamics365ReplyTimeout
+	if timeout, ok := params.Config["reply_timeout"].(float64); ok && timeout > 0 {
+		replyTimeout = time.Duration(timeout * float64(time.Second))
+	}
+
+	var token *string
+	if params.Auth != nil {
+		token = params.Auth.Token
+	}
+
+	return dynamics365ServiceFactory(connStr, &serviceBusNamespace{ns}, inboundQueueName, outboundQueueName, replyTimeout, token)
 }
 
-// Dynamics365Service for the D365 API
+// Dynamics365Service for D365, which exchanges request and reply messages with baseline using
+// the inbound and outbound service bus queues, respectively; D365 also sends events to the
+// outbound queue for records which are to be baselined, each identifying its organization
 type Dynamics365Service struct {
 	client api.Client
 	mutex  sync.Mutex
 
-	inboundQueueName  *string
-	outboundQueueName *string
-
-	ctx    context.Context
-	cancel context.CancelFunc
-
-	ns *servicebus.Namespace
-
-	cancelF     context.CancelFunc
-	closing     uint32
-	shutdownCtx context.Context
+	bus               dynamics365Bus
+	inboundQueueName  string
+	outboundQueueName string
+	replyTimeout      time.Duration
+	subscriber        *dynamics365Subscriber
 }
 
 // InitDynamics365Service convenience method to initialize a default `sap.Dynamics365Service` (i.e., production) instance
 func InitDynamics365Service(token *string) *Dynamics365Service {
-	ns, err := initServiceBusNamespace()
+	connStr := os.Getenv("AZURE_SERVICE_BUS_CONNECTION_STRING")
+	if connStr == "" {
+		common.Log.Warning("failed to parse AZURE_SERVICE_BUS_CONNECTION_STRING from environment")
+		return nil
+	}
+
+	ns, err := servicebus.NewNamespace(servicebus.NamespaceWithConnectionString(connStr))
 	if err != nil {
 		common.Log.Warningf("failed to initialize azure service bus namespace; %s", err.Error())
 		return nil
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), defaultServiceBusContextTimeout)
+	return dynamics365ServiceFactory(connStr, &serviceBusNamespace{ns}, defaultServiceBusInboundQueueName, defaultServiceBusOutboundQueueName, defaultDynamics365ReplyTimeout, token)
+}
 
-	service := &Dynamics365Service{
+// dynamics365ServiceFactory initializes a Dynamics365Service using the shared subscriber of the
+// outbound queue of the namespace identified by the given key
+func dynamics365ServiceFactory(connStr string, bus dynamics365Bus, inboundQueueName, outboundQueueName string, replyTimeout time.Duration, token *string) *Dynamics365Service {
+	return &Dynamics365Service{
 		client: api.Client{
 			Token: token,
 		},
 		mutex:             sync.Mutex{},
-		inboundQueueName:  common.StringOrNil(defaultServiceBusInboundQueueName),
-		outboundQueueName: common.StringOrNil(defaultServiceBusOutboundQueueName),
-		ctx:               ctx,
-		cancel:            cancel,
-		ns:                ns,
+		bus:               bus,
+		inboundQueueName:  inboundQueueName,
+		outboundQueueName: outboundQueueName,
+		replyTimeout:      replyTimeout,
+		subscriber:        dynamics365SubscriberFor(connStr, bus, outboundQueueName),
 	}
-
-	go service.subscribe()
-	return service
 }
 
-// Authenticate a user by email address and password, returning a newly-authorized X-CSRF-Token token
+// Authenticate is not required; the service bus connection string is authorized
 func (s *Dynamics365Service) Authenticate() error {
 	return nil
 }
@@ -101,9 +205,12 @@ func (s *Dynamics365Service) ConfigureTenant(params map[string]interface{}) erro
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	// TODO: use inbound queue to send system requests to D365
+	_, err := s.request(dynamics365OperationConfigureTenant, nil, params)
+	if err != nil {
+		return fmt.Errorf("failed to configure tenant; %s", err.Error())
+	}
 
-	return fmt.Errorf("not implemented")
+	return nil
 }
 
 // ListSchemas retrieves a list of available schemas
@@ -111,7 +218,12 @@ func (s *Dynamics365Service) ListSchemas(params map[string]interface{}) (interfa
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	return nil, fmt.Errorf("not implemented")
+	resp, err := s.request(dynamics365OperationListSchemas, nil, params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch business object model; %s", err.Error())
+	}
+
+	return resp, nil
 }
 
 // GetSchema retrieves a business object model by type
@@ -119,7 +231,14 @@ func (s *Dynamics365Service) GetSchema(recordType string, params map[string]inte
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	return nil, fmt.Errorf("not implemented")
+	resp, err := s.request(dynamics365OperationGetSchema, map[string]interface{}{
+		"type": recordType,
+	}, params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch business object model; %s", err.Error())
+	}
+
+	return resp, nil
 }
 
 // CreateObject is a generic way to create a business object in the D365 environment
@@ -127,19 +246,20 @@ func (s *Dynamics365Service) CreateObject(params map[string]interface{}) (interf
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	q, err := s.ns.NewQueue(*s.inboundQueueName)
-	if err != nil {
-		return nil, err
+	recordType, payload := sorRecordParams(params)
+	properties := map[string]interface{}{
+		"type": recordType,
+	}
+	if baselineID, ok := params["baseline_id"].(string); ok {
+		properties["baseline_id"] = baselineID
 	}
 
-	payload, _ := json.Marshal(params)
-
-	err = q.Send(s.ctx, servicebus.NewMessage(payload))
+	resp, err := s.request(dynamics365OperationCreateObject, properties, payload)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("failed to create business object; %s", err.Error())
 	}
 
-	return nil, nil
+	return resp, nil
 }
 
 // UpdateObject updates a business object
@@ -147,16 +267,13 @@ func (s *Dynamics365Service) UpdateObject(id string, params map[string]interface
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	q, err := s.ns.NewQueue(*s.inboundQueueName)
-	if err != nil {
-		return err
-	}
-
-	payload, _ := json.Marshal(params)
-
-	err = q.Send(s.ctx, servicebus.NewMessage(payload))
+	recordType, payload := sorRecordParams(params)
+	_, err := s.request(dynamics365OperationUpdateObject, map[string]interface{}{
+		"id":   id,
+		"type": recordType,
+	}, payload)
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to update business object; %s", err.Error())
 	}
 
 	return nil
@@ -167,7 +284,12 @@ func (s *Dynamics365Service) UpdateObjectStatus(id string, params map[string]int
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	// TODO
+	_, err := s.request(dynamics365OperationUpdateObjectStatus, map[string]interface{}{
+		"id": id,
+	}, params)
+	if err != nil {
+		return fmt.Errorf("failed to update business object status; %s", err.Error())
+	}
 
 	return nil
 }
@@ -177,106 +299,452 @@ func (s *Dynamics365Service) DeleteTenant(organizationID string) error {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	return fmt.Errorf("not implemented")
+	_, err := s.request(dynamics365OperationDeleteTenant, map[string]interface{}{
+		"organization_id": organizationID,
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("failed to delete tenant; %s", err.Error())
+	}
+
+	return nil
 }
 
-// HealthCheck checks the health of the D365 instance
+// HealthCheck checks the health of the D365 instance, i.e., that it replies to requests
 func (s *Dynamics365Service) HealthCheck() error {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	return fmt.Errorf("not implemented")
+	_, err := s.request(dynamics365OperationHealthCheck, nil, nil)
+	if err != nil {
+		return fmt.Errorf("health check failed; %s", err.Error())
+	}
+
+	return nil
 }
 
-// TenantHealthCheck
+// TenantHealthCheck checks the health of the tenant configuration for the given organization
 func (s *Dynamics365Service) TenantHealthCheck(organizationID string) error {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	return fmt.Errorf("not implemented")
+	_, err := s.request(dynamics365OperationTenantHealthCheck, map[string]interface{}{
+		"organization_id": organizationID,
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("tenant health check failed; %s", err.Error())
+	}
+
+	return nil
 }
 
-func initServiceBusNamespace() (*servicebus.Namespace, error) {
-	var ns *servicebus.Namespace
-	var err error
+// Watch dispatches the events received from D365 for the given organization and workgroup to the
+// given handler until the context is done; D365 identifies the organization and workgroup of each
+// event using its organization_id and workgroup_id properties
+func (s *Dynamics365Service) Watch(ctx context.Context, organizationID, workgroupID string, handler func(*OutboundRecord) error) error {
+	if organizationID == "" {
+		return errors.New("organization id required to watch D365 events")
+	}
 
-	connStr := os.Getenv("AZURE_SERVICE_BUS_CONNECTION_STRING")
-	if connStr == "" {
-		msg := "failed to parse AZURE_SERVICE_BUS_CONNECTION_STRING from environment"
-		common.Log.Warning(msg)
-		return nil, errors.New(msg)
+	if workgroupID == "" {
+		return errors.New("workgroup id required to watch D365 events")
 	}
 
-	ns, err = servicebus.NewNamespace(servicebus.NamespaceWithConnectionString(connStr))
+	unregister := s.subscriber.register(dynamics365WatcherKey{organizationID, workgroupID}, handler)
+	defer unregister()
+
+	<-ctx.Done()
+	return nil
+}
+
+// request sends a request for the given operation to the inbound queue and awaits its reply on
+// the outbound queue; the reply is correlated to the request by the message id of the request
+func (s *Dynamics365Service) request(operation string, properties map[string]interface{}, params map[string]interface{}) (interface{}, error) {
+	id, err := uuid.NewV4()
 	if err != nil {
-		return nil, fmt.Errorf("failed to initialize azure service bus namespace; %s", err.Error())
+		return nil, err
 	}
 
-	return ns, nil
-}
+	var data []byte
+	if params != nil {
+		data, err = json.Marshal(params)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	msg := servicebus.NewMessage(data)
+	msg.ID = id.String()
+	msg.ContentType = "application/json"
+	msg.Label = operation
+	msg.ReplyTo = s.outboundQueueName
+	msg.UserProperties = map[string]interface{}{
+		"operation": operation,
+	}
+	for key, val := range properties {
+		msg.UserProperties[key] = val
+	}
+
+	reply, done := s.subscriber.await(msg.ID)
+	defer done()
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.replyTimeout)
+	defer cancel()
 
-func (s *Dynamics365Service) receive() error {
-	q, err := s.ns.NewQueue(*s.outboundQueueName)
+	err = s.bus.Send(ctx, s.inboundQueueName, msg)
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("failed to send %s request to azure service bus queue: %s; %s", operation, s.inboundQueueName, err.Error())
+	}
+
+	select {
+	case resp := <-reply:
+		return dynamics365Reply(resp)
+	case <-ctx.Done():
+		return nil, fmt.Errorf("timed out awaiting reply to %s request: %s", operation, msg.ID)
 	}
+}
 
-	err = q.ReceiveOne(
-		s.ctx,
-		servicebus.HandlerFunc(func(ctx context.Context, message *servicebus.Message) error {
-			common.Log.Debug(string(message.Data))
-			return message.Complete(ctx)
-		}),
-	)
+// dynamics365Reply returns the result of the given reply, or an error if the request failed
+func dynamics365Reply(msg *servicebus.Message) (interface{}, error) {
+	if status, _ := msg.UserProperties["status"].(string); status == dynamics365ReplyStatusError {
+		return nil, fmt.Errorf("%v", msg.UserProperties["error"])
+	}
+
+	if len(msg.Data) == 0 {
+		return nil, nil
+	}
+
+	var resp interface{}
+	err := json.Unmarshal(msg.Data, &resp)
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("failed to unmarshal reply; %s", err.Error())
 	}
 
-	return nil
+	return resp, nil
 }
 
-func (s *Dynamics365Service) installSignalHandlers() chan os.Signal {
-	common.Log.Tracef("installing subshell signal handlers")
-	sigs := make(chan os.Signal, 1)
-	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
-	return sigs
+// dynamics365OutboundRecord returns the record to be baselined for the given event; the id, type
+// and baseline_id properties identify the record and the message data is its payload
+func dynamics365OutboundRecord(msg *servicebus.Message) (*OutboundRecord, error) {
+	id, _ := msg.UserProperties["id"].(string)
+	if id == "" {
+		return nil, errors.New("id not provided")
+	}
+
+	recordType, _ := msg.UserProperties["type"].(string)
+	if recordType == "" {
+		recordType = msg.Label
+	}
+
+	payload := map[string]interface{}{}
+	if len(msg.Data) > 0 {
+		err := json.Unmarshal(msg.Data, &payload)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal payload; %s", err.Error())
+		}
+	}
+
+	record := &OutboundRecord{
+		ID:      id,
+		Type:    recordType,
+		Payload: payload,
+	}
+	if baselineID, ok := msg.UserProperties["baseline_id"].(string); ok && baselineID != "" {
+		record.BaselineID = &baselineID
+	}
+
+	return record, nil
 }
 
-func (s *Dynamics365Service) subscribe() {
-	sigs := s.installSignalHandlers()
-	s.shutdownCtx, s.cancelF = context.WithCancel(context.Background())
+// dynamics365SubscriberFor returns the shared subscriber of the given queue of the namespace of
+// the given connection string, initializing it if necessary; subscribers are keyed by namespace
+// and queue, such that the subscriber of a namespace whose key is rotated receives using the bus
+// of the new connection string
+func dynamics365SubscriberFor(connStr string, bus dynamics365Bus, queueName string) *dynamics365Subscriber {
+	dynamics365SubscribersLock.Lock()
+	defer dynamics365SubscribersLock.Unlock()
+
+	key := fmt.Sprintf("%s/%s", dynamics365Namespace(connStr), queueName)
+	if subscriber, ok := dynamics365Subscribers[key]; ok {
+		subscriber.rotate(connStr, bus)
+		return subscriber
+	}
 
-	timer := time.NewTicker(subscribeTickInterval)
-	defer timer.Stop()
+	subscriber := &dynamics365Subscriber{
+		queueName:   queueName,
+		idleTimeout: dynamics365SubscriberIdleTimeout,
+		bus:         bus,
+		connStr:     connStr,
+		pending:     map[string]chan *servicebus.Message{},
+		watchers:    map[dynamics365WatcherKey][]*func(*OutboundRecord) error{},
+	}
+	dynamics365Subscribers[key] = subscriber
 
-	for !s.shuttingDown() {
-		select {
-		case <-timer.C:
-			err := s.receive()
-			if err != nil {
-				common.Log.Warningf("failed to receive from azure service bus queue in subscribe runloop; %s", err.Error())
+	return subscriber
+}
+
+// dynamics365Namespace returns the endpoint of the namespace of the given connection string,
+// or the connection string itself if it does not include an endpoint
+func dynamics365Namespace(connStr string) string {
+	for _, part := range strings.Split(connStr, ";") {
+		if strings.HasPrefix(part, "Endpoint=") {
+			endpoint := strings.TrimPrefix(part, "Endpoint=")
+			if u, err := url.Parse(endpoint); err == nil && u.Host != "" {
+				return u.Host
+			}
+			return endpoint
+		}
+	}
+	return connStr
+}
+
+// await registers a pending request with the given message id, returning the channel on which
+// its reply is delivered and a func to unregister it
+func (s *dynamics365Subscriber) await(id string) (chan *servicebus.Message, func()) {
+	reply := make(chan *servicebus.Message, 1)
+
+	s.mutex.Lock()
+	s.pending[id] = reply
+	s.start()
+	s.mutex.Unlock()
+
+	return reply, func() {
+		s.mutex.Lock()
+		delete(s.pending, id)
+		s.stopIfIdle()
+		s.mutex.Unlock()
+	}
+}
+
+// register registers the given event handler for the given organization and workgroup, returning
+// a func to unregister it; a watcher registered while the previous watcher of the organization and
+// workgroup is being stopped supersedes it
+func (s *dynamics365Subscriber) register(key dynamics365WatcherKey, handler func(*OutboundRecord) error) func() {
+	watcher := &handler
+
+	s.mutex.Lock()
+	if len(s.watchers[key]) > 0 {
+		common.Log.Debugf("superseding watcher of organization %s in workgroup %s for events received from azure service bus queue: %s", key.organizationID, key.workgroupID, s.queueName)
+	}
+	s.watchers[key] = append(s.watchers[key], watcher)
+	s.start()
+	s.mutex.Unlock()
+
+	return func() {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+
+		watchers := s.watchers[key]
+		for i := range watchers {
+			if watchers[i] == watcher {
+				watchers = append(watchers[:i], watchers[i+1:]...)
+				break
+			}
+		}
+
+		if len(watchers) == 0 {
+			delete(s.watchers, key)
+		} else {
+			s.watchers[key] = watchers
+		}
+
+		s.stopIfIdle()
+	}
+}
+
+// watcher returns the watcher of the given organization and workgroup; events which do not
+// identify their organization or workgroup are dispatched to the only watcher matching the
+// identifiers they include, if any. False is returned if the event matches several watchers
+func (s *dynamics365Subscriber) watcher(organizationID, workgroupID string) (*func(*OutboundRecord) error, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var watchers []*func(*OutboundRecord) error
+	for key := range s.watchers {
+		if (organizationID == "" || key.organizationID == organizationID) && (workgroupID == "" || key.workgroupID == workgroupID) {
+			if watchers != nil {
+				return nil, false
 			}
-		case sig := <-sigs:
-			fmt.Printf("received signal: %s", sig)
-			s.shutdown()
-		case <-s.shutdownCtx.Done():
-			close(sigs)
+			watchers = s.watchers[key]
+		}
+	}
+
+	if len(watchers) == 0 {
+		return nil, true
+	}
+
+	return watchers[len(watchers)-1], true
+}
+
+// start receiving from the queue, if the subscriber is not already receiving; the caller must
+// hold the mutex of the subscriber
+func (s *dynamics365Subscriber) start() {
+	if s.idleTimer != nil {
+		s.idleTimer.Stop()
+		s.idleTimer = nil
+	}
+
+	if s.cancel != nil {
+		return
+	}
+
+	var ctx context.Context
+	ctx, s.cancel = context.WithCancel(context.Background())
+	go s.receive(ctx, s.bus)
+}
+
+// stopIfIdle stops receiving from the queue once the subscriber has had no pending requests and
+// no watchers for the idle timeout; the caller must hold the mutex of the subscriber
+func (s *dynamics365Subscriber) stopIfIdle() {
+	if s.cancel == nil || s.idleTimer != nil || len(s.pending) > 0 || len(s.watchers) > 0 {
+		return
+	}
+
+	var timer *time.Timer
+	timer = time.AfterFunc(s.idleTimeout, func() {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+
+		if s.idleTimer != timer {
+			return // the subscriber has been used since the timer was started
+		}
+		s.idleTimer = nil
+
+		if s.cancel != nil && len(s.pending) == 0 && len(s.watchers) == 0 {
+			common.Log.Debugf("stopping idle subscriber of azure service bus queue: %s", s.queueName)
+			s.cancel()
+			s.cancel = nil
+		}
+	})
+	s.idleTimer = timer
+}
+
+// rotate the bus of the subscriber if the given connection string differs from that of the
+// subscriber, restarting the receiver using the given bus if the subscriber is receiving
+func (s *dynamics365Subscriber) rotate(connStr string, bus dynamics365Bus) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if connStr == s.connStr {
+		return
+	}
+
+	s.bus = bus
+	s.connStr = connStr
+
+	if s.cancel != nil {
+		common.Log.Debugf("restarting subscriber of azure service bus queue using rotated connection string: %s", s.queueName)
+		s.cancel()
+
+		var ctx context.Context
+		ctx, s.cancel = context.WithCancel(context.Background())
+		go s.receive(ctx, bus)
+	}
+}
+
+// receive from the queue using the given bus until the given context is done
+func (s *dynamics365Subscriber) receive(ctx context.Context, bus dynamics365Bus) {
+	for {
+		err := bus.Receive(ctx, s.queueName, s.dispatch)
+		if ctx.Err() != nil {
+			return
+		}
+
+		if err != nil {
+			common.Log.Warningf("failed to receive from azure service bus queue: %s; %s", s.queueName, err.Error())
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(dynamics365ResubscribeInterval):
+		}
+	}
+}
+
+// dispatch the given message to the pending request it replies to or, if it is an event, to the
+// watcher of its organization and workgroup; replies to requests which are not pending in this
+// process and events received while no watcher is registered are abandoned such that they are
+// redelivered, i.e., to the process which sent the request or is watching
+func (s *dynamics365Subscriber) dispatch(msg *servicebus.Message) error {
+	if msg.CorrelationID != "" {
+		s.mutex.Lock()
+		reply, ok := s.pending[msg.CorrelationID]
+		s.mutex.Unlock()
+
+		if !ok {
+			return fmt.Errorf("no pending request for reply received from azure service bus queue: %s; correlation id: %s", s.queueName, msg.CorrelationID)
+		}
+
+		select {
+		case reply <- msg:
 		default:
-			time.Sleep(subscribeSleepInterval)
+			common.Log.Warningf("dropping duplicate reply received from azure service bus queue: %s; correlation id: %s", s.queueName, msg.CorrelationID)
 		}
+		return nil
 	}
 
-	log.Printf("exiting tunnel runloop")
-	s.cancelF()
+	organizationID, _ := msg.UserProperties["organization_id"].(string)
+	workgroupID, _ := msg.UserProperties["workgroup_id"].(string)
+	watcher, ok := s.watcher(organizationID, workgroupID)
+	if !ok {
+		common.Log.Warningf("dropping event without organization or workgroup id received from azure service bus queue watched by multiple organizations or workgroups: %s", s.queueName)
+		return nil
+	}
+
+	if watcher == nil {
+		return fmt.Errorf("no watcher registered for events of organization %s in workgroup %s received from azure service bus queue: %s", organizationID, workgroupID, s.queueName)
+	}
+
+	record, err := dynamics365OutboundRecord(msg)
+	if err != nil {
+		common.Log.Warningf("dropping invalid event received from azure service bus queue: %s; %s", s.queueName, err.Error())
+		return nil
+	}
+
+	return (*watcher)(record)
+}
+
+// serviceBusNamespace is the dynamics365Bus impl for an azure service bus namespace
+type serviceBusNamespace struct {
+	ns *servicebus.Namespace
+}
+
+// Send the given message to the given queue
+func (b *serviceBusNamespace) Send(ctx context.Context, queueName string, msg *servicebus.Message) error {
+	q, err := b.ns.NewQueue(queueName)
+	if err != nil {
+		return err
+	}
+	defer b.close(q)
+
+	return q.Send(ctx, msg)
 }
 
-func (s *Dynamics365Service) shutdown() {
-	if atomic.AddUint32(&s.closing, 1) == 1 {
-		common.Log.Tracef("shutting down")
-		s.cancelF()
+// Receive from the given queue until the context is done
+func (b *serviceBusNamespace) Receive(ctx context.Context, queueName string, handler func(*servicebus.Message) error) error {
+	q, err := b.ns.NewQueue(queueName)
+	if err != nil {
+		return err
 	}
+	defer b.close(q)
+
+	return q.Receive(ctx, servicebus.HandlerFunc(func(ctx context.Context, msg *servicebus.Message) error {
+		err := handler(msg)
+		if err != nil {
+			common.Log.Warningf("abandoning message received from azure service bus queue: %s; %s", queueName, err.Error())
+			return msg.Abandon(ctx)
+		}
+
+		return msg.Complete(ctx)
+	}))
 }
 
-func (s *Dynamics365Service) shuttingDown() bool {
-	return (atomic.LoadUint32(&s.closing) > 0)
+func (b *serviceBusNamespace) close(q *servicebus.Queue) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultServiceBusContextTimeout)
+	defer cancel()
+
+	err := q.Close(ctx)
+	if err != nil {
+		common.Log.Debugf("failed to close azure service bus queue: %s; %s", q.Name, err.Error())
+	}
 }
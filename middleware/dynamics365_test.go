@@ -0,0 +1,442 @@
+// +build unit
+
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	servicebus "github.com/Azure/azure-service-bus-go"
+	uuid "github.com/kthomas/go.uuid"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// mockServiceBus is a local stand-in for the queues of an azure service bus namespace; messages
+// abandoned by a receiver are requeued for redelivery
+type mockServiceBus struct {
+	mutex     sync.Mutex
+	queues    map[string]chan *servicebus.Message
+	receivers int32
+}
+
+func newMockServiceBus() *mockServiceBus {
+	return &mockServiceBus{
+		queues: map[string]chan *servicebus.Message{},
+	}
+}
+
+func (b *mockServiceBus) queue(name string) chan *servicebus.Message {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if _, ok := b.queues[name]; !ok {
+		b.queues[name] = make(chan *servicebus.Message, 64)
+	}
+	return b.queues[name]
+}
+
+func (b *mockServiceBus) Send(ctx context.Context, queueName string, msg *servicebus.Message) error {
+	select {
+	case b.queue(queueName) <- msg:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (b *mockServiceBus) Receive(ctx context.Context, queueName string, handler func(*servicebus.Message) error) error {
+	atomic.AddInt32(&b.receivers, 1)
+	defer atomic.AddInt32(&b.receivers, -1)
+
+	for {
+		select {
+		case msg := <-b.queue(queueName):
+			if err := handler(msg); err != nil {
+				go func() {
+					time.Sleep(time.Millisecond * 25)
+					b.queue(queueName) <- msg
+				}()
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// mockDynamics365 replies to the requests sent to the inbound queue of the given bus
+type mockDynamics365 struct {
+	bus      *mockServiceBus
+	mutex    sync.Mutex
+	requests []*servicebus.Message
+	silent   bool
+}
+
+func (d *mockDynamics365) serve(ctx context.Context) {
+	d.bus.Receive(ctx, defaultServiceBusInboundQueueName, func(msg *servicebus.Message) error {
+		d.mutex.Lock()
+		d.requests = append(d.requests, msg)
+		silent := d.silent
+		d.mutex.Unlock()
+
+		if silent {
+			return nil
+		}
+
+		reply := servicebus.NewMessage(nil)
+		reply.CorrelationID = msg.ID
+		reply.UserProperties = map[string]interface{}{}
+
+		switch msg.UserProperties["operation"] {
+		case dynamics365OperationCreateObject:
+			reply.Data, _ = json.Marshal(map[string]interface{}{"id": "PO-000042"})
+		case dynamics365OperationGetSchema:
+			if msg.UserProperties["type"] != "purchase_order" {
+				reply.UserProperties["status"] = dynamics365ReplyStatusError
+				reply.UserProperties["error"] = "unknown entity"
+				break
+			}
+			reply.Data, _ = json.Marshal(map[string]interface{}{"name": "PurchaseOrderHeadersV2", "type": "purchase_order"})
+		case dynamics365OperationListSchemas:
+			reply.Data, _ = json.Marshal([]interface{}{map[string]interface{}{"name": "PurchaseOrderHeadersV2", "type": "purchase_order"}})
+		}
+
+		return d.bus.Send(ctx, msg.ReplyTo, reply)
+	})
+}
+
+func (d *mockDynamics365) lastRequest() *servicebus.Message {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.requests[len(d.requests)-1]
+}
+
+// sendEvent sends an event for the given record of the given organization and workgroup to the
+// outbound queue, as D365 would; the organization and workgroup ids are omitted if empty
+func (d *mockDynamics365) sendEvent(organizationID, workgroupID, id, recordType string, payload map[string]interface{}) {
+	event := servicebus.NewMessage(nil)
+	event.Data, _ = json.Marshal(payload)
+	event.UserProperties = map[string]interface{}{
+		"id":   id,
+		"type": recordType,
+	}
+	if organizationID != "" {
+		event.UserProperties["organization_id"] = organizationID
+	}
+	if workgroupID != "" {
+		event.UserProperties["workgroup_id"] = workgroupID
+	}
+	d.bus.Send(context.Background(), defaultServiceBusOutboundQueueName, event)
+}
+
+var _ = Describe("Dynamics365", func() {
+	var bus *mockServiceBus
+	var d365 *mockDynamics365
+	var key string
+	var ctx context.Context
+	var cancel context.CancelFunc
+	var sor *Dynamics365Service
+
+	// watch records the events dispatched to a watcher of the given organization and workgroup
+	watch := func(ctx context.Context, svc *Dynamics365Service, organizationID, workgroupID string) chan *OutboundRecord {
+		records := make(chan *OutboundRecord, 8)
+		go svc.Watch(ctx, organizationID, workgroupID, func(record *OutboundRecord) error {
+			records <- record
+			return nil
+		})
+		return records
+	}
+
+	watchers := func() int {
+		sor.subscriber.mutex.Lock()
+		defer sor.subscriber.mutex.Unlock()
+		return len(sor.subscriber.watchers)
+	}
+
+	receivers := func(bus *mockServiceBus) func() int32 {
+		return func() int32 {
+			return atomic.LoadInt32(&bus.receivers)
+		}
+	}
+
+	BeforeEach(func() {
+		bus = newMockServiceBus()
+		d365 = &mockDynamics365{bus: bus}
+		key = uuid.Must(uuid.NewV4()).String()
+
+		ctx, cancel = context.WithCancel(context.Background())
+		go d365.serve(ctx)
+
+		sor = dynamics365ServiceFactory(key, bus, defaultServiceBusInboundQueueName, defaultServiceBusOutboundQueueName, time.Second, nil)
+	})
+
+	AfterEach(func() {
+		cancel()
+	})
+
+	It("should share a single subscriber per namespace", func() {
+		other := dynamics365ServiceFactory(key, bus, defaultServiceBusInboundQueueName, defaultServiceBusOutboundQueueName, time.Second, nil)
+		Expect(other.subscriber).To(BeIdenticalTo(sor.subscriber))
+
+		Expect(sor.HealthCheck()).To(Succeed())
+		Expect(other.HealthCheck()).To(Succeed())
+		Expect(atomic.LoadInt32(&bus.receivers)).To(Equal(int32(2))) // the mock D365 and the shared subscriber
+	})
+
+	It("should key subscribers by namespace rather than by shared access key", func() {
+		namespace := uuid.Must(uuid.NewV4()).String()
+		connStr := func(key string) string {
+			return "Endpoint=sb://" + namespace + ".servicebus.windows.net/;SharedAccessKeyName=RootManageSharedAccessKey;SharedAccessKey=" + key
+		}
+
+		svc := dynamics365ServiceFactory(connStr("key-1"), bus, defaultServiceBusInboundQueueName, defaultServiceBusOutboundQueueName, time.Second, nil)
+		Expect(svc.HealthCheck()).To(Succeed())
+
+		rotated := newMockServiceBus()
+		other := dynamics365ServiceFactory(connStr("key-2"), rotated, defaultServiceBusInboundQueueName, defaultServiceBusOutboundQueueName, time.Second, nil)
+		Expect(other.subscriber).To(BeIdenticalTo(svc.subscriber))
+
+		// the subscriber receives using the bus of the rotated key
+		Eventually(receivers(rotated)).Should(Equal(int32(1)))
+		Eventually(receivers(bus)).Should(Equal(int32(1))) // the mock D365
+	})
+
+	It("should stop receiving once the subscriber is idle", func() {
+		sor.subscriber.mutex.Lock()
+		sor.subscriber.idleTimeout = time.Millisecond * 50
+		sor.subscriber.mutex.Unlock()
+
+		Expect(sor.HealthCheck()).To(Succeed())
+		Eventually(receivers(bus)).Should(Equal(int32(1))) // the mock D365
+
+		watchCtx, stopWatching := context.WithCancel(context.Background())
+		watch(watchCtx, sor, "org-a", "workgroup-a")
+		Eventually(receivers(bus)).Should(Equal(int32(2)))
+		Consistently(receivers(bus), time.Millisecond*200).Should(Equal(int32(2)))
+
+		stopWatching()
+		Eventually(receivers(bus)).Should(Equal(int32(1)))
+	})
+
+	It("should abandon replies to requests which are not pending", func() {
+		reply := servicebus.NewMessage(nil)
+		reply.CorrelationID = uuid.Must(uuid.NewV4()).String()
+		Expect(sor.subscriber.dispatch(reply)).To(MatchError(ContainSubstring(reply.CorrelationID)))
+	})
+
+	It("should correlate replies to concurrent requests", func() {
+		other := dynamics365ServiceFactory(key, bus, defaultServiceBusInboundQueueName, defaultServiceBusOutboundQueueName, time.Second, nil)
+
+		var wg sync.WaitGroup
+		schemas := make([]interface{}, 2)
+		for i, svc := range []*Dynamics365Service{sor, other} {
+			wg.Add(1)
+			go func(i int, svc *Dynamics365Service) {
+				defer GinkgoRecover()
+				defer wg.Done()
+
+				var err error
+				if i == 0 {
+					schemas[i], err = svc.GetSchema("purchase_order", map[string]interface{}{})
+				} else {
+					schemas[i], err = svc.ListSchemas(map[string]interface{}{})
+				}
+				Expect(err).NotTo(HaveOccurred())
+			}(i, svc)
+		}
+		wg.Wait()
+
+		Expect(schemas[0]).To(HaveKeyWithValue("name", "PurchaseOrderHeadersV2"))
+		Expect(schemas[1]).To(HaveLen(1))
+	})
+
+	Describe("CreateObject", func() {
+		It("should send the record to the inbound queue and return the reply", func() {
+			resp, err := sor.CreateObject(map[string]interface{}{
+				"baseline_id": "c3f6ea1e-3c9a-4a1f-9a3b-52a0e2b0b1a4",
+				"payload":     map[string]interface{}{"PurchaseOrderName": "baselined"},
+				"type":        "purchase_order",
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp).To(Equal(map[string]interface{}{"id": "PO-000042"}))
+
+			req := d365.lastRequest()
+			Expect(req.ReplyTo).To(Equal(defaultServiceBusOutboundQueueName))
+			Expect(req.UserProperties).To(Equal(map[string]interface{}{
+				"baseline_id": "c3f6ea1e-3c9a-4a1f-9a3b-52a0e2b0b1a4",
+				"operation":   dynamics365OperationCreateObject,
+				"type":        "purchase_order",
+			}))
+			Expect(string(req.Data)).To(MatchJSON(`{"PurchaseOrderName": "baselined"}`))
+		})
+	})
+
+	Describe("UpdateObject", func() {
+		It("should send the id of the record to update", func() {
+			err := sor.UpdateObject("PO-000042", map[string]interface{}{
+				"payload": map[string]interface{}{"PurchaseOrderName": "rebaselined"},
+				"type":    "purchase_order",
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(d365.lastRequest().UserProperties).To(HaveKeyWithValue("id", "PO-000042"))
+		})
+	})
+
+	Describe("GetSchema", func() {
+		It("should fail when D365 replies with an error", func() {
+			_, err := sor.GetSchema("invoice", map[string]interface{}{})
+			Expect(err).To(MatchError(ContainSubstring("unknown entity")))
+		})
+	})
+
+	Describe("HealthCheck", func() {
+		It("should fail when D365 does not reply", func() {
+			d365.mutex.Lock()
+			d365.silent = true
+			d365.mutex.Unlock()
+			Expect(sor.HealthCheck()).To(MatchError(ContainSubstring("timed out")))
+		})
+	})
+
+	Describe("Watch", func() {
+		It("should dispatch events to the watcher as records to be baselined", func() {
+			watchCtx, stopWatching := context.WithCancel(context.Background())
+			defer stopWatching()
+
+			records := watch(watchCtx, sor, "org-a", "workgroup-a")
+			d365.sendEvent("org-a", "workgroup-a", "PO-000043", "purchase_order", map[string]interface{}{"PurchaseOrderName": "from d365"})
+
+			var record *OutboundRecord
+			Eventually(records).Should(Receive(&record))
+			Expect(record).To(Equal(&OutboundRecord{
+				ID:      "PO-000043",
+				Type:    "purchase_order",
+				Payload: map[string]interface{}{"PurchaseOrderName": "from d365"},
+			}))
+		})
+
+		It("should redeliver events received while no watcher is registered", func() {
+			sor.HealthCheck() // starts the shared subscriber
+			d365.sendEvent("org-a", "workgroup-a", "PO-000044", "purchase_order", map[string]interface{}{})
+
+			time.Sleep(time.Millisecond * 100)
+
+			watchCtx, stopWatching := context.WithCancel(context.Background())
+			defer stopWatching()
+
+			records := watch(watchCtx, sor, "org-a", "workgroup-a")
+
+			var record *OutboundRecord
+			Eventually(records).Should(Receive(&record))
+			Expect(record.ID).To(Equal("PO-000044"))
+		})
+
+		It("should route events to the watcher of their organization", func() {
+			other := dynamics365ServiceFactory(key, bus, defaultServiceBusInboundQueueName, defaultServiceBusOutboundQueueName, time.Second, nil)
+
+			watchCtx, stopWatching := context.WithCancel(context.Background())
+			defer stopWatching()
+
+			recordsA := watch(watchCtx, sor, "org-a", "workgroup-a")
+			recordsB := watch(watchCtx, other, "org-b", "workgroup-a")
+			Eventually(watchers).Should(Equal(2))
+
+			d365.sendEvent("org-a", "workgroup-a", "PO-000045", "purchase_order", map[string]interface{}{})
+			d365.sendEvent("org-b", "workgroup-a", "PO-000046", "purchase_order", map[string]interface{}{})
+
+			var record *OutboundRecord
+			Eventually(recordsA).Should(Receive(&record))
+			Expect(record.ID).To(Equal("PO-000045"))
+			Eventually(recordsB).Should(Receive(&record))
+			Expect(record.ID).To(Equal("PO-000046"))
+			Consistently(recordsA, time.Millisecond*100).ShouldNot(Receive())
+		})
+
+		It("should dispatch events without an organization id to the only watching organization", func() {
+			watchCtx, stopWatching := context.WithCancel(context.Background())
+			defer stopWatching()
+
+			records := watch(watchCtx, sor, "org-a", "workgroup-a")
+			d365.sendEvent("", "", "PO-000047", "purchase_order", map[string]interface{}{})
+
+			var record *OutboundRecord
+			Eventually(records).Should(Receive(&record))
+			Expect(record.ID).To(Equal("PO-000047"))
+		})
+
+		It("should not dispatch events without an organization id when multiple organizations are watching", func() {
+			other := dynamics365ServiceFactory(key, bus, defaultServiceBusInboundQueueName, defaultServiceBusOutboundQueueName, time.Second, nil)
+
+			watchCtx, stopWatching := context.WithCancel(context.Background())
+			defer stopWatching()
+
+			recordsA := watch(watchCtx, sor, "org-a", "workgroup-a")
+			recordsB := watch(watchCtx, other, "org-b", "workgroup-a")
+			Eventually(watchers).Should(Equal(2))
+
+			d365.sendEvent("", "", "PO-000048", "purchase_order", map[string]interface{}{})
+			Consistently(recordsA, time.Millisecond*200).ShouldNot(Receive())
+			Consistently(recordsB).ShouldNot(Receive())
+		})
+
+		It("should route events to the watcher of their workgroup", func() {
+			watchCtx, stopWatching := context.WithCancel(context.Background())
+			defer stopWatching()
+
+			recordsA := watch(watchCtx, sor, "org-a", "workgroup-a")
+			recordsB := watch(watchCtx, sor, "org-a", "workgroup-b")
+			Eventually(watchers).Should(Equal(2))
+
+			d365.sendEvent("org-a", "workgroup-b", "PO-000049", "purchase_order", map[string]interface{}{})
+			d365.sendEvent("org-a", "workgroup-a", "PO-000050", "purchase_order", map[string]interface{}{})
+
+			var record *OutboundRecord
+			Eventually(recordsB).Should(Receive(&record))
+			Expect(record.ID).To(Equal("PO-000049"))
+			Eventually(recordsA).Should(Receive(&record))
+			Expect(record.ID).To(Equal("PO-000050"))
+			Consistently(recordsB, time.Millisecond*100).ShouldNot(Receive())
+		})
+
+		It("should not dispatch events without a workgroup id when the organization watches multiple workgroups", func() {
+			watchCtx, stopWatching := context.WithCancel(context.Background())
+			defer stopWatching()
+
+			recordsA := watch(watchCtx, sor, "org-a", "workgroup-a")
+			recordsB := watch(watchCtx, sor, "org-a", "workgroup-b")
+			Eventually(watchers).Should(Equal(2))
+
+			d365.sendEvent("org-a", "", "PO-000051", "purchase_order", map[string]interface{}{})
+			Consistently(recordsA, time.Millisecond*200).ShouldNot(Receive())
+			Consistently(recordsB).ShouldNot(Receive())
+		})
+
+		It("should require the organization id", func() {
+			Expect(sor.Watch(context.Background(), "", "workgroup-a", func(*OutboundRecord) error { return nil })).NotTo(Succeed())
+		})
+
+		It("should require the workgroup id", func() {
+			Expect(sor.Watch(context.Background(), "org-a", "", func(*OutboundRecord) error { return nil })).NotTo(Succeed())
+		})
+	})
+})
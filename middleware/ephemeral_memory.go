@@ -21,7 +21,6 @@ import (
 	"sync"
 
 	uuid "github.com/kthomas/go.uuid"
-	"github.com/provideplatform/provide-go/common"
 )
 
 // EphemeralMemoryService
@@ -31,10 +30,10 @@ type EphemeralMemoryService struct {
 	status  map[string]interface{}
 }
 
-// EphemeralMemoryFactory initializes a EphemeralMemory instance
+// EphemeralMemoryFactory initializes a EphemeralMemory instance; the in-memory store is
+// not configurable, so the given system is not used
 func EphemeralMemoryFactory(params *System) *EphemeralMemoryService {
-	common.Log.Warningf("EphemeralMemory not implemented")
-	return nil
+	return InitEphemeralMemoryService(nil)
 }
 
 // InitEphemeralMemoryService convenience method to initialize a EphemeralMemory instance
@@ -93,9 +92,6 @@ func (s *EphemeralMemoryService) UpdateObject(id string, params map[string]inter
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
-
 	s.records[id] = params
 	return nil
 }
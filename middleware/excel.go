@@ -17,32 +17,77 @@
 package middleware
 
 import (
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"syscall"
+	"time"
 
-	"github.com/provideplatform/provide-go/api"
+	uuid "github.com/kthomas/go.uuid"
 	"github.com/provideplatform/provide-go/common"
+	"github.com/xuri/excelize/v2"
 )
 
-// ExcelService
+const excelColumnID = "id"
+const excelColumnBaselineID = "baseline_id"
+const excelColumnStatus = "baseline_status"
+const excelColumnMessageID = "baseline_message_id"
+const excelColumnErrors = "baseline_errors"
+const excelColumnUpdatedAt = "baseline_updated_at"
+
+const excelDefaultSheetName = "Sheet1"
+const excelMaxSheetNameLength = 31
+
+// excelRecordTypesSheetName is the hidden sheet which records the record type of each sheet
+// whose name differs from its record type, such that record types which sanitize or truncate
+// to the same sheet name are detected rather than written to the same sheet
+const excelRecordTypesSheetName = "_baseline_record_types"
+
+// excelSheetNameReplacer replaces the characters which are not permitted in sheet names
+var excelSheetNameReplacer = strings.NewReplacer(
+	"[", "_",
+	"]", "_",
+	":", "_",
+	"*", "_",
+	"?", "_",
+	"/", "_",
+	"\\", "_",
+)
+
+// ExcelService is a system of record backed by a local .xlsx workbook; each record type is
+// stored in its own sheet, the first row of which is the header naming the record fields
 type ExcelService struct {
-	api.Client
 	mutex sync.Mutex
+	path  string
 }
 
-// ExcelFactory initializes an Excel instance
+// ExcelFactory initializes an Excel instance; the endpoint url is the path to the workbook,
+// optionally using the file:// scheme, relative to or within the EXCEL_WORKBOOK_ROOT directory
 func ExcelFactory(params *System) *ExcelService {
-	common.Log.Warningf("ExcelFactory not implemented")
-	return nil
+	path, err := systemFilePath(params, os.Getenv("EXCEL_WORKBOOK_ROOT"))
+	if err != nil {
+		common.Log.Warningf("invalid excel workbook path; %s", err.Error())
+		return nil
+	}
+
+	return &ExcelService{
+		mutex: sync.Mutex{},
+		path:  path,
+	}
 }
 
 // InitExcelService convenience method to initialize a default `ExcelService` instance
+// using the workbook at EXCEL_WORKBOOK_PATH
 func InitExcelService(token *string) *ExcelService {
 	return &ExcelService{
-		api.Client{
-			Token: token,
-		},
-		sync.Mutex{},
+		mutex: sync.Mutex{},
+		path:  os.Getenv("EXCEL_WORKBOOK_PATH"),
 	}
 }
 
@@ -51,52 +96,313 @@ func (s *ExcelService) Authenticate() error {
 	return nil
 }
 
-// ConfigureTenant configures a new proxy instance - not implemented for Excel at this time
+// ConfigureTenant creates the workbook if it does not yet exist
 func (s *ExcelService) ConfigureTenant(params map[string]interface{}) error {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	return fmt.Errorf("not implemented")
+	lock, err := s.lock(syscall.LOCK_EX)
+	if err != nil {
+		return err
+	}
+	defer s.unlock(lock)
+
+	if _, err := os.Stat(s.path); err == nil {
+		return nil
+	}
+
+	return s.save(excelize.NewFile())
 }
 
-// ListSchemas retrieves a list of available schemas
+// ListSchemas retrieves a list of available schemas; one schema is listed for each sheet
+// having a header row
 func (s *ExcelService) ListSchemas(params map[string]interface{}) (interface{}, error) {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	return nil, fmt.Errorf("not implemented")
+	lock, err := s.lock(syscall.LOCK_SH)
+	if err != nil {
+		return nil, err
+	}
+	defer s.unlock(lock)
+
+	schemas := make([]interface{}, 0)
+
+	f, err := s.open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch business object model; %s", err.Error())
+	}
+
+	for _, sheet := range f.GetSheetList() {
+		if sheet == excelRecordTypesSheetName {
+			continue
+		}
+
+		rows, err := f.GetRows(sheet)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch business object model; %s", err.Error())
+		}
+
+		if len(rows) == 0 {
+			continue
+		}
+
+		schemas = append(schemas, map[string]interface{}{
+			"description": fmt.Sprintf("%d record(s)", len(rows)-1),
+			"name":        sheet,
+			"type":        excelSheetRecordType(f, sheet),
+		})
+	}
+
+	return schemas, nil
 }
 
-// GetSchema retrieves a business object model by type
+// GetSchema retrieves a business object model by type; the fields are read from the header row
+// of the sheet and their types are inferred from the first record
 func (s *ExcelService) GetSchema(recordType string, params map[string]interface{}) (interface{}, error) {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	return nil, fmt.Errorf("not implemented")
+	lock, err := s.lock(syscall.LOCK_SH)
+	if err != nil {
+		return nil, err
+	}
+	defer s.unlock(lock)
+
+	f, err := s.open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch business object model; %s", err.Error())
+	}
+
+	sheet, err := excelSheet(f, recordType)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch business object model; %s", err.Error())
+	}
+
+	if f.GetSheetIndex(sheet) == -1 {
+		return nil, fmt.Errorf("failed to fetch business object model; no sheet for record type: %s", recordType)
+	}
+
+	rows, err := f.GetRows(sheet)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch business object model; %s", err.Error())
+	}
+
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("failed to fetch business object model; no header row for record type: %s", recordType)
+	}
+
+	fields := make([]interface{}, 0)
+	for i, name := range rows[0] {
+		if name == "" || isExcelStatusColumn(name) {
+			continue
+		}
+
+		var sample string
+		if len(rows) > 1 && i < len(rows[1]) {
+			sample = rows[1][i]
+		}
+
+		fields = append(fields, map[string]interface{}{
+			"name":        name,
+			"description": name,
+			"type":        excelFieldType(sample),
+		})
+	}
+
+	return map[string]interface{}{
+		"description": fmt.Sprintf("%d record(s)", len(rows)-1),
+		"fields":      fields,
+		"name":        sheet,
+		"type":        recordType,
+	}, nil
 }
 
-// CreateObject is a generic way to create a business object in the Excel environment
+// CreateObject appends a row to the sheet for the record type, creating the sheet and
+// any missing header columns as necessary
 func (s *ExcelService) CreateObject(params map[string]interface{}) (interface{}, error) {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	return nil, fmt.Errorf("not implemented")
+	recordType, payload := sorRecordParams(params)
+	if recordType == "" {
+		return nil, fmt.Errorf("failed to create business object; record type required")
+	}
+
+	id, err := uuid.NewV4()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create business object; %s", err.Error())
+	}
+
+	record := map[string]interface{}{}
+	for k, v := range payload {
+		record[k] = v
+	}
+	record[excelColumnID] = id.String()
+	if baselineID, ok := params[excelColumnBaselineID].(string); ok {
+		record[excelColumnBaselineID] = baselineID
+	}
+
+	lock, err := s.lock(syscall.LOCK_EX)
+	if err != nil {
+		return nil, err
+	}
+	defer s.unlock(lock)
+
+	f, err := s.open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create business object; %s", err.Error())
+	}
+
+	sheet, err := excelSheet(f, recordType)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create business object; %s", err.Error())
+	}
+
+	if f.GetSheetIndex(sheet) == -1 {
+		if f.NewSheet(sheet) == -1 || f.GetSheetIndex(sheet) == -1 {
+			return nil, fmt.Errorf("failed to create business object; failed to create sheet: %s", sheet)
+		}
+		err = setExcelSheetRecordType(f, sheet, recordType)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create business object; %s", err.Error())
+		}
+		if rows, _ := f.GetRows(excelDefaultSheetName); len(rows) == 0 && f.GetSheetIndex(excelDefaultSheetName) != -1 {
+			f.DeleteSheet(excelDefaultSheetName)
+		}
+	}
+
+	rows, err := f.GetRows(sheet)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create business object; %s", err.Error())
+	}
+
+	row := len(rows) + 1
+	if row == 1 {
+		row++ // the first row of a new sheet is reserved for the header
+	}
+
+	err = writeExcelRow(f, sheet, row, record)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create business object; %s", err.Error())
+	}
+
+	err = s.save(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create business object; %s", err.Error())
+	}
+
+	return record, nil
 }
 
-// UpdateObject updates a business object
+// UpdateObject updates the row of the business object with the given id
 func (s *ExcelService) UpdateObject(id string, params map[string]interface{}) error {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	return fmt.Errorf("not implemented")
+	lock, err := s.lock(syscall.LOCK_EX)
+	if err != nil {
+		return err
+	}
+	defer s.unlock(lock)
+
+	f, err := s.open()
+	if err != nil {
+		return fmt.Errorf("failed to update business object; %s", err.Error())
+	}
+
+	recordType, payload := sorRecordParams(params)
+
+	sheets := f.GetSheetList()
+	if recordType != "" {
+		sheet, err := excelSheet(f, recordType)
+		if err != nil {
+			return fmt.Errorf("failed to update business object; %s", err.Error())
+		}
+		sheets = []string{sheet}
+	}
+
+	sheet, row := findExcelRow(f, id, sheets)
+	if row == 0 {
+		return fmt.Errorf("failed to update business object; record not found: %s", id)
+	}
+
+	record := map[string]interface{}{}
+	for k, v := range payload {
+		if k != excelColumnID {
+			record[k] = v
+		}
+	}
+	if baselineID, ok := params[excelColumnBaselineID].(string); ok {
+		record[excelColumnBaselineID] = baselineID
+	}
+
+	err = writeExcelRow(f, sheet, row, record)
+	if err != nil {
+		return fmt.Errorf("failed to update business object; %s", err.Error())
+	}
+
+	err = s.save(f)
+	if err != nil {
+		return fmt.Errorf("failed to update business object; %s", err.Error())
+	}
+
+	return nil
 }
 
-// UpdateObjectStatus updates the status of a business object
+// UpdateObjectStatus writes the baseline status columns of the business object with the given id
 func (s *ExcelService) UpdateObjectStatus(id string, params map[string]interface{}) error {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	return fmt.Errorf("not implemented")
+	recordType := sorRecordType(params)
+
+	lock, err := s.lock(syscall.LOCK_EX)
+	if err != nil {
+		return err
+	}
+	defer s.unlock(lock)
+
+	f, err := s.open()
+	if err != nil {
+		return fmt.Errorf("failed to update business object status; %s", err.Error())
+	}
+
+	sheets := f.GetSheetList()
+	if recordType != "" {
+		sheet, err := excelSheet(f, recordType)
+		if err != nil {
+			return fmt.Errorf("failed to update business object status; %s", err.Error())
+		}
+		sheets = []string{sheet}
+	}
+
+	sheet, row := findExcelRow(f, id, sheets)
+	if row == 0 {
+		return fmt.Errorf("failed to update business object status; record not found: %s", id)
+	}
+
+	status := map[string]interface{}{
+		excelColumnStatus:    params["status"],
+		excelColumnMessageID: params["message_id"],
+		excelColumnErrors:    params["errors"],
+		excelColumnUpdatedAt: time.Now().UTC().Format(time.RFC3339),
+	}
+	if baselineID, ok := params[excelColumnBaselineID].(string); ok {
+		status[excelColumnBaselineID] = baselineID
+	}
+
+	err = writeExcelRow(f, sheet, row, status)
+	if err != nil {
+		return fmt.Errorf("failed to update business object status; %s", err.Error())
+	}
+
+	err = s.save(f)
+	if err != nil {
+		return fmt.Errorf("failed to update business object status; %s", err.Error())
+	}
+
+	return nil
 }
 
 // DeleteTenant drops a proxy configuration for the given organization
@@ -107,18 +413,312 @@ func (s *ExcelService) DeleteTenant(organizationID string) error {
 	return fmt.Errorf("not implemented")
 }
 
-// HealthCheck checks the health of the instance - not implemented for Excel at this time
+// HealthCheck checks that the workbook directory exists and, if the workbook exists, that it can be read
 func (s *ExcelService) HealthCheck() error {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	return fmt.Errorf("not implemented")
+	if s.path == "" {
+		return fmt.Errorf("excel workbook path not configured")
+	}
+
+	info, err := os.Stat(filepath.Dir(s.path))
+	if err != nil {
+		return fmt.Errorf("excel workbook directory not accessible; %s", err.Error())
+	}
+
+	if !info.IsDir() {
+		return fmt.Errorf("excel workbook directory not accessible: %s", filepath.Dir(s.path))
+	}
+
+	lock, err := s.lock(syscall.LOCK_SH)
+	if err != nil {
+		return err
+	}
+	defer s.unlock(lock)
+
+	_, err = s.open()
+	return err
 }
 
-// ProxyHealthCheck
+// TenantHealthCheck checks the health of the workbook; the workbook is not tenant-specific
 func (s *ExcelService) TenantHealthCheck(organizationID string) error {
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
+	return s.HealthCheck()
+}
 
-	return fmt.Errorf("not implemented")
+// lock acquires an advisory lock on the workbook such that concurrent writers, including
+// those in other processes, do not clobber one another
+func (s *ExcelService) lock(how int) (*os.File, error) {
+	if s.path == "" {
+		return nil, fmt.Errorf("excel workbook path not configured")
+	}
+
+	lockfile, err := os.OpenFile(fmt.Sprintf("%s.lock", s.path), os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open excel workbook lock; %s", err.Error())
+	}
+
+	err = syscall.Flock(int(lockfile.Fd()), how)
+	if err != nil {
+		lockfile.Close()
+		return nil, fmt.Errorf("failed to acquire excel workbook lock; %s", err.Error())
+	}
+
+	return lockfile, nil
+}
+
+func (s *ExcelService) unlock(lockfile *os.File) {
+	syscall.Flock(int(lockfile.Fd()), syscall.LOCK_UN)
+	lockfile.Close()
+}
+
+// open the workbook, or initialize a new one if it does not yet exist
+func (s *ExcelService) open() (*excelize.File, error) {
+	if _, err := os.Stat(s.path); os.IsNotExist(err) {
+		return excelize.NewFile(), nil
+	}
+
+	return excelize.OpenFile(s.path)
+}
+
+// save the workbook by writing it to a temporary file which then atomically replaces the workbook
+func (s *ExcelService) save(f *excelize.File) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), fmt.Sprintf(".%s.*", filepath.Base(s.path)))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	err = f.Write(tmp)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), s.path)
+}
+
+// excelSheetName returns the name of the sheet for the given record type; characters which
+// are not permitted in sheet names are replaced and the name is truncated to the maximum length
+func excelSheetName(recordType string) string {
+	name := strings.Trim(excelSheetNameReplacer.Replace(recordType), "'")
+	if name == "" {
+		name = "_"
+	}
+
+	runes := []rune(name)
+	if len(runes) > excelMaxSheetNameLength {
+		return string(runes[:excelMaxSheetNameLength])
+	}
+	return name
+}
+
+// excelSheet returns the name of the sheet for the given record type, or an error if the sheet
+// of that name is used by another record type
+func excelSheet(f *excelize.File, recordType string) (string, error) {
+	sheet := excelSheetName(recordType)
+	if sheet == excelRecordTypesSheetName {
+		return "", fmt.Errorf("reserved sheet name for record type: %s", recordType)
+	}
+
+	if f.GetSheetIndex(sheet) != -1 {
+		if sheetRecordType := excelSheetRecordType(f, sheet); sheetRecordType != recordType {
+			return "", fmt.Errorf("sheet %s for record type %s is used by record type: %s", sheet, recordType, sheetRecordType)
+		}
+	}
+
+	return sheet, nil
+}
+
+// excelSheetRecordType returns the record type of the given sheet; unless recorded otherwise,
+// the record type of a sheet is its name
+func excelSheetRecordType(f *excelize.File, sheet string) string {
+	if f.GetSheetIndex(excelRecordTypesSheetName) == -1 {
+		return sheet
+	}
+
+	rows, _ := f.GetRows(excelRecordTypesSheetName)
+	for _, row := range rows {
+		if len(row) > 1 && row[0] == sheet {
+			return row[1]
+		}
+	}
+
+	return sheet
+}
+
+// setExcelSheetRecordType records the record type of the given sheet in the hidden record
+// types sheet, if the name of the sheet differs from its record type
+func setExcelSheetRecordType(f *excelize.File, sheet, recordType string) error {
+	if sheet == recordType {
+		return nil
+	}
+
+	if f.GetSheetIndex(excelRecordTypesSheetName) == -1 {
+		if f.NewSheet(excelRecordTypesSheetName) == -1 {
+			return fmt.Errorf("failed to create sheet: %s", excelRecordTypesSheetName)
+		}
+
+		err := f.SetSheetVisible(excelRecordTypesSheetName, false)
+		if err != nil {
+			return err
+		}
+	}
+
+	rows, err := f.GetRows(excelRecordTypesSheetName)
+	if err != nil {
+		return err
+	}
+
+	return f.SetSheetRow(excelRecordTypesSheetName, fmt.Sprintf("A%d", len(rows)+1), &[]interface{}{sheet, recordType})
+}
+
+// findExcelRow returns the sheet and row number of the record with the given id, searching the
+// given sheets; the returned row is 0 if no record is found
+func findExcelRow(f *excelize.File, id string, sheets []string) (string, int) {
+	for _, sheet := range sheets {
+		if sheet == excelRecordTypesSheetName {
+			continue
+		}
+
+		rows, err := f.GetRows(sheet)
+		if err != nil || len(rows) == 0 {
+			continue
+		}
+
+		col := -1
+		for i, name := range rows[0] {
+			if name == excelColumnID {
+				col = i
+				break
+			}
+		}
+
+		if col == -1 {
+			continue
+		}
+
+		for i, row := range rows[1:] {
+			if col < len(row) && row[col] == id {
+				return sheet, i + 2
+			}
+		}
+	}
+
+	return "", 0
+}
+
+// writeExcelRow writes the given values to the given row, appending header columns for any
+// fields which are not yet present in the header row
+func writeExcelRow(f *excelize.File, sheet string, row int, values map[string]interface{}) error {
+	rows, err := f.GetRows(sheet)
+	if err != nil {
+		return err
+	}
+
+	columns := map[string]int{}
+	var header []string
+	if len(rows) > 0 {
+		header = rows[0]
+	}
+	for i, name := range header {
+		if name != "" {
+			columns[name] = i + 1
+		}
+	}
+
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		// the id and baseline id are the leading columns of new sheets
+		return excelColumnOrder(keys[i]) < excelColumnOrder(keys[j]) ||
+			(excelColumnOrder(keys[i]) == excelColumnOrder(keys[j]) && keys[i] < keys[j])
+	})
+
+	for _, k := range keys {
+		col, ok := columns[k]
+		if !ok {
+			col = len(header) + 1
+			header = append(header, k)
+			columns[k] = col
+
+			cell, _ := excelize.CoordinatesToCellName(col, 1)
+			err = f.SetCellValue(sheet, cell, k)
+			if err != nil {
+				return err
+			}
+		}
+
+		cell, _ := excelize.CoordinatesToCellName(col, row)
+		err = f.SetCellValue(sheet, cell, excelCellValue(values[k]))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func excelColumnOrder(name string) int {
+	switch name {
+	case excelColumnID:
+		return 0
+	case excelColumnBaselineID:
+		return 1
+	default:
+		if isExcelStatusColumn(name) {
+			return 3
+		}
+		return 2
+	}
+}
+
+// excelCellValue returns the given value in a form which can be written to a cell; composite
+// values are written as json and booleans as TRUE or FALSE, since boolean cells are otherwise
+// read back as 1 or 0 and would be inferred as numbers
+func excelCellValue(val interface{}) interface{} {
+	switch v := val.(type) {
+	case nil:
+		return ""
+	case bool:
+		return strings.ToUpper(strconv.FormatBool(v))
+	case *string:
+		if v == nil {
+			return ""
+		}
+		return *v
+	case string, float64, float32, int, int64, int32:
+		return v
+	default:
+		raw, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprintf("%v", v)
+		}
+		return string(raw)
+	}
+}
+
+// excelFieldType infers the type of a field from a sample cell value
+func excelFieldType(sample string) string {
+	if sample == "" {
+		return "string"
+	}
+	if _, err := strconv.ParseFloat(sample, 64); err == nil {
+		return "number"
+	}
+	if _, err := strconv.ParseBool(sample); err == nil {
+		return "boolean"
+	}
+	return "string"
+}
+
+func isExcelStatusColumn(name string) bool {
+	return name == excelColumnStatus || name == excelColumnMessageID || name == excelColumnErrors || name == excelColumnUpdatedAt
 }
@@ -0,0 +1,296 @@
+// +build unit
+
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package middleware
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/provideplatform/provide-go/common"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+func excelSchemaFields(schema interface{}) map[string]string {
+	fields := map[string]string{}
+	for _, field := range schema.(map[string]interface{})["fields"].([]interface{}) {
+		f := field.(map[string]interface{})
+		fields[f["name"].(string)] = f["type"].(string)
+	}
+	return fields
+}
+
+var _ = Describe("Excel", func() {
+	var dir string
+	var excel *ExcelService
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "baseline-excel")
+		Expect(err).NotTo(HaveOccurred())
+		os.Setenv("EXCEL_WORKBOOK_ROOT", dir)
+
+		excel = ExcelFactory(&System{EndpointURL: common.StringOrNil(fmt.Sprintf("file://%s", filepath.Join(dir, "workbook.xlsx")))})
+		Expect(excel).NotTo(BeNil())
+	})
+
+	AfterEach(func() {
+		os.Unsetenv("EXCEL_WORKBOOK_ROOT")
+		os.RemoveAll(dir)
+	})
+
+	Describe("ExcelFactory", func() {
+		It("should resolve relative paths against the workbook root", func() {
+			excel := ExcelFactory(&System{EndpointURL: common.StringOrNil("tenant/workbook.xlsx")})
+			Expect(excel).NotTo(BeNil())
+			Expect(excel.path).To(Equal(filepath.Join(dir, "tenant", "workbook.xlsx")))
+		})
+
+		DescribeTable("should reject paths outside of the workbook root",
+			func(endpointURL string) {
+				Expect(ExcelFactory(&System{EndpointURL: common.StringOrNil(endpointURL)})).To(BeNil())
+			},
+			Entry("parent directory", "../workbook.xlsx"),
+			Entry("nested parent directory", "tenant/../../workbook.xlsx"),
+			Entry("absolute path", "/etc/workbook.xlsx"),
+			Entry("file url", "file:///etc/workbook.xlsx"),
+		)
+
+		It("should reject paths when the workbook root is not configured", func() {
+			os.Unsetenv("EXCEL_WORKBOOK_ROOT")
+			Expect(ExcelFactory(&System{EndpointURL: common.StringOrNil(filepath.Join(dir, "workbook.xlsx"))})).To(BeNil())
+		})
+	})
+
+	DescribeTable("excelSheetName",
+		func(recordType, expected string) {
+			Expect(excelSheetName(recordType)).To(Equal(expected))
+		},
+		Entry("valid name", "purchase_order", "purchase_order"),
+		Entry("path separators", "orders/2022\\q1", "orders_2022_q1"),
+		Entry("reserved characters", "[po]:*?", "_po____"),
+		Entry("leading and trailing apostrophes", "'po'", "po"),
+		Entry("only apostrophes", "''", "_"),
+		Entry("truncated to the maximum length", "a_record_type_which_is_longer_than_permitted", "a_record_type_which_is_longer_t"),
+		Entry("truncated after replacement", "a/record/type/which/is/longer/than/permitted", "a_record_type_which_is_longer_t"),
+	)
+
+	Describe("CreateObject", func() {
+		It("should create the workbook and a sheet for the record type", func() {
+			resp, err := excel.CreateObject(map[string]interface{}{
+				"type":        "purchase_order",
+				"baseline_id": "b-1",
+				"payload":     map[string]interface{}{"po_number": "PO-1"},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.(map[string]interface{})["id"]).NotTo(BeEmpty())
+
+			schemas, err := excel.ListSchemas(map[string]interface{}{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(schemas).To(HaveLen(1))
+			Expect(schemas.([]interface{})[0].(map[string]interface{})["type"]).To(Equal("purchase_order"))
+		})
+
+		It("should sanitize the sheet name of the record type", func() {
+			_, err := excel.CreateObject(map[string]interface{}{
+				"type":    "orders/2022: [q1]",
+				"payload": map[string]interface{}{"po_number": "PO-1"},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			schema, err := excel.GetSchema("orders/2022: [q1]", map[string]interface{}{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(schema.(map[string]interface{})["name"]).To(Equal("orders_2022_ _q1_"))
+		})
+
+		It("should report the record type of sanitized sheets", func() {
+			_, err := excel.CreateObject(map[string]interface{}{"type": "orders/q1", "payload": map[string]interface{}{"po_number": "PO-1"}})
+			Expect(err).NotTo(HaveOccurred())
+
+			schemas, err := excel.ListSchemas(map[string]interface{}{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(schemas).To(HaveLen(1))
+			Expect(schemas.([]interface{})[0].(map[string]interface{})["name"]).To(Equal("orders_q1"))
+			Expect(schemas.([]interface{})[0].(map[string]interface{})["type"]).To(Equal("orders/q1"))
+
+			_, err = excel.CreateObject(map[string]interface{}{"type": "orders/q1", "payload": map[string]interface{}{"po_number": "PO-2"}})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		DescribeTable("should fail when record types resolve to the same sheet",
+			func(recordType, collidingRecordType string) {
+				_, err := excel.CreateObject(map[string]interface{}{"type": recordType, "payload": map[string]interface{}{"po_number": "PO-1"}})
+				Expect(err).NotTo(HaveOccurred())
+
+				_, err = excel.CreateObject(map[string]interface{}{"type": collidingRecordType, "payload": map[string]interface{}{"po_number": "PO-2"}})
+				Expect(err).To(MatchError(ContainSubstring("is used by record type: %s", recordType)))
+
+				_, err = excel.GetSchema(collidingRecordType, map[string]interface{}{})
+				Expect(err).To(HaveOccurred())
+			},
+			Entry("sanitized", "orders/q1", "orders_q1"),
+			Entry("sanitized name of another record type", "orders_q1", "orders/q1"),
+			Entry("truncated", "a_record_type_which_is_longer_than_permitted", "a_record_type_which_is_longer_than_the_other"),
+		)
+
+		It("should fail without a record type", func() {
+			_, err := excel.CreateObject(map[string]interface{}{"po_number": "PO-1"})
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("GetSchema", func() {
+		It("should read the fields from the header and infer their types from the first record", func() {
+			_, err := excel.CreateObject(map[string]interface{}{
+				"type":        "purchase_order",
+				"baseline_id": "b-1",
+				"payload": map[string]interface{}{
+					"amount":    12.5,
+					"approved":  true,
+					"po_number": "PO-1",
+				},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			schema, err := excel.GetSchema("purchase_order", map[string]interface{}{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(excelSchemaFields(schema)).To(Equal(map[string]string{
+				"id":          "string",
+				"baseline_id": "string",
+				"amount":      "number",
+				"approved":    "boolean",
+				"po_number":   "string",
+			}))
+		})
+
+		It("should add header columns for fields first seen on later records", func() {
+			_, err := excel.CreateObject(map[string]interface{}{"type": "purchase_order", "payload": map[string]interface{}{"po_number": "PO-1"}})
+			Expect(err).NotTo(HaveOccurred())
+			_, err = excel.CreateObject(map[string]interface{}{"type": "purchase_order", "payload": map[string]interface{}{"po_number": "PO-2", "quantity": 3.0}})
+			Expect(err).NotTo(HaveOccurred())
+
+			schema, err := excel.GetSchema("purchase_order", map[string]interface{}{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(excelSchemaFields(schema)).To(HaveKeyWithValue("quantity", "string")) // the first record has no sample
+			Expect(schema.(map[string]interface{})["description"]).To(Equal("2 record(s)"))
+		})
+
+		It("should exclude the baseline status columns", func() {
+			resp, err := excel.CreateObject(map[string]interface{}{"type": "purchase_order", "payload": map[string]interface{}{"po_number": "PO-1"}})
+			Expect(err).NotTo(HaveOccurred())
+
+			err = excel.UpdateObjectStatus(resp.(map[string]interface{})["id"].(string), map[string]interface{}{
+				"type":       "purchase_order",
+				"status":     "success",
+				"message_id": "m-1",
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			fields := excelSchemaFields(func() interface{} {
+				schema, err := excel.GetSchema("purchase_order", map[string]interface{}{})
+				Expect(err).NotTo(HaveOccurred())
+				return schema
+			}())
+			Expect(fields).To(HaveKey("po_number"))
+			Expect(fields).NotTo(HaveKey(excelColumnStatus))
+			Expect(fields).NotTo(HaveKey(excelColumnMessageID))
+			Expect(fields).NotTo(HaveKey(excelColumnUpdatedAt))
+		})
+
+		It("should fail when there is no sheet for the record type", func() {
+			Expect(excel.ConfigureTenant(map[string]interface{}{})).To(Succeed())
+			_, err := excel.GetSchema("invoice", map[string]interface{}{})
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("UpdateObject", func() {
+		It("should write the payload of the business object to its row", func() {
+			resp, err := excel.CreateObject(map[string]interface{}{"type": "purchase_order", "payload": map[string]interface{}{"po_number": "PO-1"}})
+			Expect(err).NotTo(HaveOccurred())
+
+			err = excel.UpdateObject(resp.(map[string]interface{})["id"].(string), map[string]interface{}{
+				"type":    "purchase_order",
+				"payload": map[string]interface{}{"po_number": "PO-2", "type": "standard"},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			f, err := excel.open()
+			Expect(err).NotTo(HaveOccurred())
+			rows, err := f.GetRows("purchase_order")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(rows).To(HaveLen(2))
+			Expect(rows[0]).To(Equal([]string{"id", "po_number", "type"}))
+			Expect(rows[1][1:]).To(Equal([]string{"PO-2", "standard"}))
+		})
+
+		It("should fail when the record is not found", func() {
+			Expect(excel.ConfigureTenant(map[string]interface{}{})).To(Succeed())
+			Expect(excel.UpdateObject("po-1", map[string]interface{}{"po_number": "PO-1"})).NotTo(Succeed())
+		})
+	})
+
+	Describe("locking", func() {
+		It("should not lose records written concurrently by independent writers", func() {
+			const writers = 16
+
+			var wg sync.WaitGroup
+			for i := 0; i < writers; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer GinkgoRecover()
+					defer wg.Done()
+
+					// each writer has its own service, as would writers in separate processes
+					writer := ExcelFactory(&System{EndpointURL: common.StringOrNil(excel.path)})
+					_, err := writer.CreateObject(map[string]interface{}{"type": "purchase_order", "payload": map[string]interface{}{"po_number": fmt.Sprintf("PO-%d", i)}})
+					Expect(err).NotTo(HaveOccurred())
+				}(i)
+			}
+			wg.Wait()
+
+			schema, err := excel.GetSchema("purchase_order", map[string]interface{}{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(schema.(map[string]interface{})["description"]).To(Equal(fmt.Sprintf("%d record(s)", writers)))
+		})
+
+		It("should block writers while the workbook is locked", func() {
+			lock, err := excel.lock(syscall.LOCK_EX)
+			Expect(err).NotTo(HaveOccurred())
+
+			done := make(chan error, 1)
+			go func() {
+				writer := ExcelFactory(&System{EndpointURL: common.StringOrNil(excel.path)})
+				_, err := writer.CreateObject(map[string]interface{}{"type": "purchase_order", "payload": map[string]interface{}{"po_number": "PO-1"}})
+				done <- err
+			}()
+
+			Consistently(done, 250*time.Millisecond).ShouldNot(Receive())
+			excel.unlock(lock)
+			Eventually(done, 5*time.Second).Should(Receive(BeNil()))
+		})
+	})
+})
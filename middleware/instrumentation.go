@@ -0,0 +1,182 @@
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/provideplatform/baseline/metrics"
+	"github.com/provideplatform/baseline/tracing"
+	"github.com/provideplatform/provide-go/api"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentedSOR records the latency and errors of every call to the wrapped system of record
+// and traces each call as a child of the span in its context
+type instrumentedSOR struct {
+	ctx    context.Context
+	sor    SOR
+	system string
+}
+
+// instrumentSOR wraps the given system of record such that its calls are recorded as metrics
+func instrumentSOR(system string, sor SOR) SOR {
+	if sor == nil {
+		return nil
+	}
+
+	// factories return typed nil pointers when the system is misconfigured
+	if val := reflect.ValueOf(sor); val.Kind() == reflect.Ptr && val.IsNil() {
+		return nil
+	}
+
+	return &instrumentedSOR{
+		ctx:    context.Background(),
+		sor:    sor,
+		system: system,
+	}
+}
+
+// SORWithContext returns the given system of record such that its calls are traced as children
+// of the span in the given context
+func SORWithContext(ctx context.Context, sor SOR) SOR {
+	if s, ok := sor.(*instrumentedSOR); ok && ctx != nil {
+		return &instrumentedSOR{
+			ctx:    ctx,
+			sor:    s.sor,
+			system: s.system,
+		}
+	}
+
+	return sor
+}
+
+// observe traces and records the metrics for a single call to the wrapped system of record
+func (s *instrumentedSOR) observe(operation string, fn func() error) error {
+	ctx, span := tracing.Start(s.ctx, fmt.Sprintf("sor.%s", operation),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("sor.system", s.system)),
+	)
+
+	if client := sorClient(s.sor); client != nil {
+		if client.Headers == nil {
+			client.Headers = map[string][]string{}
+		}
+		tracing.InjectHeader(ctx, client.Headers)
+	}
+
+	startedAt := time.Now()
+	err := fn()
+	metrics.ObserveSORRequest(s.system, operation, startedAt, err)
+	tracing.End(span, err)
+	return err
+}
+
+// sorClient returns the http client of the given system of record, if any
+func sorClient(sor SOR) *api.Client {
+	switch s := sor.(type) {
+	case *QBSService:
+		if s != nil {
+			return &s.Client
+		}
+	case *RESTService:
+		if s != nil {
+			return &s.Client
+		}
+	case *SalesforceService:
+		if s != nil {
+			return &s.Client
+		}
+	case *SAPService:
+		if s != nil {
+			return &s.Client
+		}
+	case *ServiceNowService:
+		if s != nil {
+			return &s.Client
+		}
+	}
+
+	return nil
+}
+
+func (s *instrumentedSOR) ConfigureTenant(params map[string]interface{}) error {
+	return s.observe("configure_tenant", func() error {
+		return s.sor.ConfigureTenant(params)
+	})
+}
+
+func (s *instrumentedSOR) CreateObject(params map[string]interface{}) (interface{}, error) {
+	var resp interface{}
+	err := s.observe("create_object", func() (err error) {
+		resp, err = s.sor.CreateObject(params)
+		return err
+	})
+	return resp, err
+}
+
+func (s *instrumentedSOR) DeleteTenant(organizationID string) error {
+	return s.observe("delete_tenant", func() error {
+		return s.sor.DeleteTenant(organizationID)
+	})
+}
+
+func (s *instrumentedSOR) ListSchemas(params map[string]interface{}) (interface{}, error) {
+	var resp interface{}
+	err := s.observe("list_schemas", func() (err error) {
+		resp, err = s.sor.ListSchemas(params)
+		return err
+	})
+	return resp, err
+}
+
+func (s *instrumentedSOR) GetSchema(recordType string, params map[string]interface{}) (interface{}, error) {
+	var resp interface{}
+	err := s.observe("get_schema", func() (err error) {
+		resp, err = s.sor.GetSchema(recordType, params)
+		return err
+	})
+	return resp, err
+}
+
+func (s *instrumentedSOR) HealthCheck() error {
+	return s.observe("health_check", func() error {
+		return s.sor.HealthCheck()
+	})
+}
+
+func (s *instrumentedSOR) TenantHealthCheck(organizationID string) error {
+	return s.observe("tenant_health_check", func() error {
+		return s.sor.TenantHealthCheck(organizationID)
+	})
+}
+
+func (s *instrumentedSOR) UpdateObject(id string, params map[string]interface{}) error {
+	return s.observe("update_object", func() error {
+		return s.sor.UpdateObject(id, params)
+	})
+}
+
+func (s *instrumentedSOR) UpdateObjectStatus(id string, params map[string]interface{}) error {
+	return s.observe("update_object_status", func() error {
+		return s.sor.UpdateObjectStatus(id, params)
+	})
+}
@@ -21,235 +21,451 @@ import (
 	"fmt"
 	"net/url"
 	"os"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/provideplatform/provide-go/api"
 	"github.com/provideplatform/provide-go/common"
-	provide "github.com/provideplatform/provide-go/common"
 )
 
-// QBSService for the QBS API
+const defaultQBSHost = "quickbooks.api.intuit.com"
+const defaultQBSPath = "v3/company"
+const defaultQBSScheme = "https"
+const defaultQBSTokenURL = "https://oauth.platform.intuit.com/oauth2/v1/tokens/bearer"
+
+// qbsMinorVersion is the minor version of the QuickBooks Online accounting API requested
+const qbsMinorVersion = "65"
+
+// qbsMaxCustomFields is the number of custom fields QuickBooks supports per transaction form
+const qbsMaxCustomFields = 3
+
+// qbsTokenExpiryLeeway is subtracted from the lifetime of access tokens such that tokens are
+// refreshed before they expire in flight
+const qbsTokenExpiryLeeway = time.Second * 30
+
+const qbsRecordTypeInvoice = "invoice"
+const qbsRecordTypePurchaseOrder = "purchase_order"
+
+// qbsEntity is the metadata of a QuickBooks entity to which records are routed
+type qbsEntity struct {
+	name        string
+	description string
+	resource    string
+	fields      []map[string]interface{}
+
+	// customFieldPrefs is the path of the custom field preferences of the entity form, and
+	// customFieldUse and customFieldName the formats of the preferences for each custom field
+	customFieldPrefs []string
+	customFieldUse   string
+	customFieldName  string
+}
+
+// qbsEntities maps the supported record types to QuickBooks entities
+var qbsEntities = map[string]*qbsEntity{
+	qbsRecordTypeInvoice: {
+		name:        "Invoice",
+		description: "A sales form for which the customer pays for a product or service at a later time",
+		resource:    "invoice",
+		fields: []map[string]interface{}{
+			qbsField("Id", "Unique identifier of the invoice", "string", false),
+			qbsField("SyncToken", "Version number of the invoice", "string", false),
+			qbsField("DocNumber", "Reference number of the invoice", "string", false),
+			qbsField("TxnDate", "Date of the invoice", "date", false),
+			qbsField("DueDate", "Date by which the invoice is to be paid", "date", false),
+			qbsField("CustomerRef", "Reference to the customer", "reference", true),
+			qbsField("CurrencyRef", "Reference to the currency of the amounts", "reference", false),
+			qbsField("Line", "Line items of the invoice", "array", true),
+			qbsField("TotalAmt", "Total amount of the invoice", "decimal", false),
+			qbsField("Balance", "Balance remaining to be paid", "decimal", false),
+			qbsField("PrivateNote", "Memo which is not displayed on the invoice", "string", false),
+		},
+		customFieldPrefs: []string{"SalesFormsPrefs", "CustomField"},
+		customFieldUse:   "SalesFormsPrefs.UseSalesCustom%d",
+		customFieldName:  "SalesFormsPrefs.SalesCustomName%d",
+	},
+	qbsRecordTypePurchaseOrder: {
+		name:        "PurchaseOrder",
+		description: "A non-posting transaction requesting goods or services from a vendor",
+		resource:    "purchaseorder",
+		fields: []map[string]interface{}{
+			qbsField("Id", "Unique identifier of the purchase order", "string", false),
+			qbsField("SyncToken", "Version number of the purchase order", "string", false),
+			qbsField("DocNumber", "Reference number of the purchase order", "string", false),
+			qbsField("TxnDate", "Date of the purchase order", "date", false),
+			qbsField("VendorRef", "Reference to the vendor", "reference", true),
+			qbsField("APAccountRef", "Reference to the accounts payable account", "reference", true),
+			qbsField("CurrencyRef", "Reference to the currency of the amounts", "reference", false),
+			qbsField("Line", "Line items of the purchase order", "array", true),
+			qbsField("TotalAmt", "Total amount of the purchase order", "decimal", false),
+			qbsField("POStatus", "Status of the purchase order; Open or Closed", "string", false),
+			qbsField("Memo", "Memo displayed on the purchase order", "string", false),
+		},
+		customFieldPrefs: []string{"VendorAndPurchasesPrefs", "POCustomField"},
+		customFieldUse:   "PurchasePrefs.UsePurchaseCustom%d",
+		customFieldName:  "PurchasePrefs.PurchaseCustomName%d",
+	},
+}
+
+// QBSService for the QuickBooks Online accounting API
 type QBSService struct {
 	api.Client
-	mutex        sync.Mutex
-	clientID     *string
-	clientSecret *string
+	mutex          sync.Mutex
+	clientID       *string
+	clientSecret   *string
+	realmID        string
+	refreshToken   *string
+	tokenURL       string
+	tokenExpiresAt *time.Time
 }
 
-// QBSFactory initializes a QBSService instance
+// QBSFactory initializes a QBSService instance using the endpoint url and auth of the given
+// system; the realm_id config identifies the QuickBooks company of the tenant, and access
+// tokens are refreshed using the refresh token and client credentials, if provided. Refresh
+// tokens rotated by QuickBooks are held by the returned instance only and are not written back
+// to the system; the configured refresh token must be updated when it is no longer accepted
 func QBSFactory(params *System) *QBSService {
-	var endpoint *url.URL
-	var err error
+	client, err := systemClient(params)
+	if err != nil {
+		common.Log.Warningf("failed to initialize QBS system; %s", err.Error())
+		return nil
+	}
 
-	if params.EndpointURL != nil {
-		endpoint, err = url.Parse(*params.EndpointURL)
-		if err != nil {
-			common.Log.Warningf("failed to parse endpoint url: %s", *params.EndpointURL)
-			return nil
-		}
+	if client.Path == "" || client.Path == "/" {
+		client.Path = defaultQBSPath
+	}
+
+	// basic auth is not supported by the accounting API
+	client.Username = nil
+	client.Password = nil
+
+	realmID, _ := params.Config["realm_id"].(string)
+	if realmID == "" {
+		common.Log.Warningf("failed to initialize QBS system; realm id not provided")
+		return nil
+	}
+
+	auth := params.Auth
+	if auth == nil {
+		auth = &SystemAuthentication{}
+	}
+
+	if auth.RefreshToken != nil && (auth.ClientID == nil || auth.ClientSecret == nil) {
+		common.Log.Warningf("failed to initialize QBS system; client id and client secret required to refresh access tokens")
+		return nil
 	}
 
-	if params.Auth == nil {
-		// HACK
-		params.Auth = &SystemAuthentication{}
+	tokenURL := defaultQBSTokenURL
+	if auth.TokenURL != nil {
+		tokenURL = *auth.TokenURL
 	}
 
 	return &QBSService{
-		api.Client{
-			Host:     endpoint.Host,
-			Path:     endpoint.Path,
-			Scheme:   endpoint.Scheme,
-			Token:    params.Auth.Token,
-			Username: provide.StringOrNil(*params.Auth.Username),
-			Password: provide.StringOrNil(*params.Auth.Password),
-		},
+		*client,
 		sync.Mutex{},
-		params.Auth.ClientID,
-		params.Auth.ClientSecret,
+		auth.ClientID,
+		auth.ClientSecret,
+		realmID,
+		auth.RefreshToken,
+		tokenURL,
+		nil,
 	}
 }
 
 // InitQBSService convenience method to initialize a `QBSService` instance
 func InitQBSService(token *string) *QBSService {
-	var host string
-	var path string
-	var scheme string
-	var username string
-	var password string
+	realmID := os.Getenv("QBS_REALM_ID")
+	if realmID == "" {
+		common.Log.Warningf("QBS_REALM_ID not provided")
+		return nil
+	}
 
+	host := defaultQBSHost
 	if os.Getenv("QBS_API_HOST") != "" {
 		host = os.Getenv("QBS_API_HOST")
 	}
 
+	path := defaultQBSPath
 	if os.Getenv("QBS_API_PATH") != "" {
 		path = os.Getenv("QBS_API_PATH")
 	}
 
+	scheme := defaultQBSScheme
 	if os.Getenv("QBS_API_SCHEME") != "" {
 		scheme = os.Getenv("QBS_API_SCHEME")
 	}
 
-	if os.Getenv("QBS_API_USERNAME") != "" {
-		username = os.Getenv("QBS_API_USERNAME")
+	tokenURL := defaultQBSTokenURL
+	if os.Getenv("QBS_API_TOKEN_URL") != "" {
+		tokenURL = os.Getenv("QBS_API_TOKEN_URL")
 	}
 
-	if os.Getenv("QBS_API_PASSWORD") != "" {
-		password = os.Getenv("QBS_API_PASSWORD")
-	}
+	clientID := common.StringOrNil(os.Getenv("QBS_API_CLIENT_ID"))
+	clientSecret := common.StringOrNil(os.Getenv("QBS_API_CLIENT_SECRET"))
+	refreshToken := common.StringOrNil(os.Getenv("QBS_API_REFRESH_TOKEN"))
 
-	var clientID *string
-	if os.Getenv("QBS_API_CLIENT_ID") != "" {
-		_clientID := os.Getenv("QBS_API_CLIENT_ID")
-		clientID = &_clientID
-	}
-
-	var clientSecret *string
-	if os.Getenv("QBS_API_CLIENT_SECRET") != "" {
-		_clientSecret := os.Getenv("QBS_API_CLIENT_SECRET")
-		clientSecret = &_clientSecret
+	if refreshToken != nil && (clientID == nil || clientSecret == nil) {
+		common.Log.Warningf("QBS_API_CLIENT_ID and QBS_API_CLIENT_SECRET required to refresh access tokens")
+		return nil
 	}
 
 	return &QBSService{
 		api.Client{
-			Host:     host,
-			Path:     path,
-			Scheme:   scheme,
-			Token:    token,
-			Username: provide.StringOrNil(username),
-			Password: provide.StringOrNil(password),
+			Host:   host,
+			Path:   path,
+			Scheme: scheme,
+			Token:  token,
 		},
 		sync.Mutex{},
 		clientID,
 		clientSecret,
+		realmID,
+		refreshToken,
+		tokenURL,
+		nil,
 	}
 }
 
-func (s *QBSService) requestURI(uri string) string {
-	_uri := string(uri)
-	if s.clientID != nil || s.clientSecret != nil {
-		_uri = fmt.Sprintf("%s?", _uri)
+// Authenticate refreshes the access token using the refresh token grant, if a refresh token was
+// provided and the previously-vended access token is missing or about to expire; QuickBooks
+// rotates refresh tokens, so the refresh token returned with the access token replaces it in
+// memory for the lifetime of this instance. The rotated refresh token is not persisted, so it
+// is lost on restart, after which the refresh token of the system config is used again
+func (s *QBSService) Authenticate() error {
+	if s.refreshToken == nil {
+		return nil
+	}
 
-		if s.clientID != nil {
-			_uri = fmt.Sprintf("%sclient_id=%s&", _uri, url.QueryEscape(*s.clientID))
-		}
+	if s.Token != nil && (s.tokenExpiresAt == nil || time.Now().Before(*s.tokenExpiresAt)) {
+		return nil
+	}
 
-		if s.clientSecret != nil {
-			_uri = fmt.Sprintf("%sclient_secret=%s&", _uri, url.QueryEscape(*s.clientSecret))
-		}
+	tokenURL, err := url.Parse(s.tokenURL)
+	if err != nil {
+		return fmt.Errorf("failed to parse oauth2 token url: %s; %s", s.tokenURL, err.Error())
+	}
 
-		_uri = _uri[0 : len(_uri)-2]
+	client := &api.Client{
+		Host:     tokenURL.Host,
+		Scheme:   tokenURL.Scheme,
+		Username: s.clientID,
+		Password: s.clientSecret,
 	}
 
-	return _uri
-}
+	status, resp, err := client.PostWWWFormURLEncoded(strings.TrimPrefix(tokenURL.RequestURI(), "/"), map[string]interface{}{
+		"grant_type":    "refresh_token",
+		"refresh_token": *s.refreshToken,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to refresh oauth2 access token; status: %v; %s", status, err.Error())
+	}
 
-// Authenticate a user by email address and password, returning a newly-authorized X-CSRF-Token token
-func (s *QBSService) Authenticate() error {
-	return errors.New("not implemented")
+	if status != 200 {
+		return fmt.Errorf("failed to refresh oauth2 access token; status: %v", status)
+	}
+
+	body, _ := resp.(map[string]interface{})
+	accessToken, ok := body["access_token"].(string)
+	if !ok {
+		return errors.New("failed to refresh oauth2 access token; no access token in response")
+	}
+
+	s.Token = &accessToken
+	if refreshToken, ok := body["refresh_token"].(string); ok && refreshToken != "" {
+		if refreshToken != *s.refreshToken {
+			common.Log.Debugf("QuickBooks rotated the refresh token of realm: %s; the rotated token is held in memory only", s.realmID)
+		}
+		s.refreshToken = &refreshToken
+	}
+
+	s.tokenExpiresAt = nil
+	if expiresIn, ok := body["expires_in"].(float64); ok && expiresIn > 0 {
+		expiresAt := time.Now().Add(time.Duration(expiresIn) * time.Second).Add(-qbsTokenExpiryLeeway)
+		s.tokenExpiresAt = &expiresAt
+	}
+
+	return nil
 }
 
-// ConfigureTenant configures a new tenant instance in QBS for a given organization
+// ConfigureTenant verifies the QuickBooks company of the tenant is accessible; the company is
+// provisioned in QuickBooks, so there is nothing further to configure
 func (s *QBSService) ConfigureTenant(params map[string]interface{}) error {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	err := s.Authenticate()
+	err := s.companyInfo()
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to configure tenant; %s", err.Error())
 	}
 
-	return errors.New("not implemented")
+	return nil
 }
 
-// ListSchemas retrieves a list of available schemas
+// ListSchemas retrieves a list of the entities to which records can be routed
 func (s *QBSService) ListSchemas(params map[string]interface{}) (interface{}, error) {
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
-
-	err := s.Authenticate()
-	if err != nil {
-		return nil, err
+	recordTypes := make([]string, 0, len(qbsEntities))
+	for recordType := range qbsEntities {
+		recordTypes = append(recordTypes, recordType)
+	}
+	sort.Strings(recordTypes)
+
+	schemas := make([]interface{}, 0, len(recordTypes))
+	for _, recordType := range recordTypes {
+		entity := qbsEntities[recordType]
+		schemas = append(schemas, map[string]interface{}{
+			"description": entity.description,
+			"name":        entity.name,
+			"type":        recordType,
+		})
 	}
 
-	return nil, errors.New("not implemented")
+	return schemas, nil
 }
 
-// GetSchema retrieves a business object data model by type
+// GetSchema retrieves a business object data model by type, including the custom fields
+// enabled for the entity form in the company preferences
 func (s *QBSService) GetSchema(recordType string, params map[string]interface{}) (interface{}, error) {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	err := s.Authenticate()
+	entity, err := qbsEntityFor(recordType)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch business object model; %s", err.Error())
+	}
+
+	status, resp, err := s.request("GET", s.uri("preferences"), nil)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("failed to fetch business object model; status: %v; %s", status, err.Error())
+	}
+
+	if status != 200 {
+		return nil, fmt.Errorf("failed to fetch business object model; status: %v%s", status, qbsFaultMessage(resp))
+	}
+
+	fields := make([]interface{}, 0, len(entity.fields)+qbsMaxCustomFields)
+	for _, field := range entity.fields {
+		fields = append(fields, field)
+	}
+
+	body, ok := resp.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("failed to fetch business object model; unexpected preferences response")
 	}
 
-	return nil, errors.New("not implemented")
+	preferences, _ := body["Preferences"].(map[string]interface{})
+	fields = append(fields, entity.customFields(preferences)...)
+
+	return map[string]interface{}{
+		"description": entity.description,
+		"fields":      fields,
+		"name":        entity.name,
+		"type":        recordType,
+	}, nil
 }
 
-// CreateObject is a generic way to create a business object in the QBS environment
+// CreateObject creates an invoice or purchase order in the QuickBooks company
 func (s *QBSService) CreateObject(params map[string]interface{}) (interface{}, error) {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	err := s.Authenticate()
+	recordType, payload := sorRecordParams(params)
+	entity, err := qbsEntityFor(recordType)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("failed to create business object; %s", err.Error())
 	}
 
-	return nil, errors.New("not implemented")
+	status, resp, err := s.request("POST", s.uri(entity.resource), payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create business object; status: %v; %s", status, err.Error())
+	}
+
+	if status != 200 {
+		return nil, fmt.Errorf("failed to create business object; status: %v%s", status, qbsFaultMessage(resp))
+	}
+
+	body, ok := resp.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("failed to create business object; unexpected response for %s", entity.name)
+	}
+
+	if obj, ok := body[entity.name].(map[string]interface{}); ok {
+		body["id"] = obj["Id"]
+	}
+
+	return body, nil
 }
 
-// UpdateObject updates a business object
+// UpdateObject sparse updates an invoice or purchase order in the QuickBooks company, using the
+// sync token of its current version
 func (s *QBSService) UpdateObject(id string, params map[string]interface{}) error {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	err := s.Authenticate()
+	recordType, payload := sorRecordParams(params)
+	entity, err := qbsEntityFor(recordType)
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to update business object; %s", err.Error())
 	}
 
-	return errors.New("not implemented")
-}
+	status, resp, err := s.request("GET", s.uri(fmt.Sprintf("%s/%s", entity.resource, url.PathEscape(id))), nil)
+	if err != nil {
+		return fmt.Errorf("failed to update business object; status: %v; %s", status, err.Error())
+	}
 
-// UpdateObjectStatus updates the status of a business object
-func (s *QBSService) UpdateObjectStatus(id string, params map[string]interface{}) error {
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
+	if status != 200 {
+		return fmt.Errorf("failed to update business object; status: %v%s", status, qbsFaultMessage(resp))
+	}
 
-	err := s.Authenticate()
+	body, _ := resp.(map[string]interface{})
+	obj, _ := body[entity.name].(map[string]interface{})
+	syncToken, ok := obj["SyncToken"].(string)
+	if !ok {
+		return fmt.Errorf("failed to update business object; sync token not resolved for %s: %s", entity.name, id)
+	}
+
+	update := map[string]interface{}{}
+	for key, val := range payload {
+		update[key] = val
+	}
+	update["Id"] = id
+	update["SyncToken"] = syncToken
+	update["sparse"] = true
+
+	status, resp, err = s.request("POST", s.uri(entity.resource), update)
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to update business object; status: %v; %s", status, err.Error())
 	}
 
-	return errors.New("not implemented")
+	if status != 200 {
+		return fmt.Errorf("failed to update business object; status: %v%s", status, qbsFaultMessage(resp))
+	}
+
+	return nil
+}
+
+// UpdateObjectStatus is a no-op; QuickBooks entities have no baseline status
+func (s *QBSService) UpdateObjectStatus(id string, params map[string]interface{}) error {
+	return nil
 }
 
 // DeleteTenant drops a BPI tenant configuration for the given organization
 func (s *QBSService) DeleteTenant(organizationID string) error {
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
-
-	err := s.Authenticate()
-	if err != nil {
-		return err
-	}
-
 	return errors.New("not implemented")
 }
 
-// HealthCheck checks the health of the QBS instance
+// HealthCheck checks the health of the QBS instance, including that the configured credentials
+// are authorized to access the QuickBooks company
 func (s *QBSService) HealthCheck() error {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	err := s.Authenticate()
+	err := s.companyInfo()
 	if err != nil {
-		return err
+		return fmt.Errorf("health check failed; %s", err.Error())
 	}
 
-	return errors.New("not implemented")
+	return nil
 }
 
 // TenantHealthCheck checks the health of the tenant configuration for the given organization
@@ -257,10 +473,151 @@ func (s *QBSService) TenantHealthCheck(organizationID string) error {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
+	err := s.companyInfo()
+	if err != nil {
+		return fmt.Errorf("tenant health check failed; %s", err.Error())
+	}
+
+	return nil
+}
+
+// companyInfo fetches the company info of the QuickBooks company
+func (s *QBSService) companyInfo() error {
+	status, resp, err := s.request("GET", s.uri(fmt.Sprintf("companyinfo/%s", url.PathEscape(s.realmID))), nil)
+	if err != nil {
+		return fmt.Errorf("status: %v; %s", status, err.Error())
+	}
+
+	if status != 200 {
+		return fmt.Errorf("status: %v%s", status, qbsFaultMessage(resp))
+	}
+
+	return nil
+}
+
+// request makes the given request using the access token; an access token rejected by
+// QuickBooks is refreshed and the request retried once
+func (s *QBSService) request(method, uri string, params map[string]interface{}) (int, interface{}, error) {
 	err := s.Authenticate()
 	if err != nil {
-		return err
+		return 0, nil, err
 	}
 
-	return errors.New("not implemented")
+	status, resp, err := s.send(method, uri, params)
+	if status == 401 && s.refreshToken != nil {
+		s.Token = nil
+		err = s.Authenticate()
+		if err != nil {
+			return 0, nil, err
+		}
+		status, resp, err = s.send(method, uri, params)
+	}
+
+	return status, resp, err
+}
+
+func (s *QBSService) send(method, uri string, params map[string]interface{}) (int, interface{}, error) {
+	switch method {
+	case "GET":
+		return s.Get(uri, params)
+	case "POST":
+		return s.Post(uri, params)
+	default:
+		return 0, nil, fmt.Errorf("unsupported method: %s", method)
+	}
+}
+
+// uri returns the uri of the given resource of the QuickBooks company
+func (s *QBSService) uri(resource string) string {
+	return fmt.Sprintf("%s/%s?minorversion=%s", url.PathEscape(s.realmID), resource, qbsMinorVersion)
+}
+
+// customFields returns the custom fields enabled for the entity form in the given preferences;
+// QuickBooks groups the preferences of each custom field by name, i.e. UseSalesCustom1 and
+// SalesCustomName1
+func (e *qbsEntity) customFields(preferences map[string]interface{}) []interface{} {
+	var prefs interface{} = preferences
+	for _, key := range e.customFieldPrefs {
+		obj, _ := prefs.(map[string]interface{})
+		prefs = obj[key]
+	}
+
+	values := map[string]interface{}{}
+	groups, _ := prefs.([]interface{})
+	for _, group := range groups {
+		_group, _ := group.(map[string]interface{})
+		items, _ := _group["CustomField"].([]interface{})
+		for _, item := range items {
+			if pref, ok := item.(map[string]interface{}); ok {
+				if name, ok := pref["Name"].(string); ok {
+					if val, ok := pref["BooleanValue"]; ok {
+						values[name] = val
+					} else {
+						values[name] = pref["StringValue"]
+					}
+				}
+			}
+		}
+	}
+
+	fields := make([]interface{}, 0)
+	for i := 1; i <= qbsMaxCustomFields; i++ {
+		if values[fmt.Sprintf(e.customFieldUse, i)] != true {
+			continue
+		}
+
+		if name, ok := values[fmt.Sprintf(e.customFieldName, i)].(string); ok && name != "" {
+			field := qbsField(name, fmt.Sprintf("Custom field %d of the %s form", i, e.name), "custom_field", false)
+			field["definition_id"] = fmt.Sprintf("%d", i)
+			fields = append(fields, field)
+		}
+	}
+
+	return fields
+}
+
+// qbsEntityFor returns the entity to which records of the given type are routed; the entity
+// name may be used as the record type
+func qbsEntityFor(recordType string) (*qbsEntity, error) {
+	if entity, ok := qbsEntities[recordType]; ok {
+		return entity, nil
+	}
+
+	for _, entity := range qbsEntities {
+		if entity.name == recordType {
+			return entity, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unsupported record type: %s", recordType)
+}
+
+func qbsField(name, description, typ string, required bool) map[string]interface{} {
+	return map[string]interface{}{
+		"name":        name,
+		"description": description,
+		"type":        typ,
+		"required":    required,
+	}
+}
+
+// qbsFaultMessage returns the messages of the fault in the given response, if any, for inclusion
+// in an error
+func qbsFaultMessage(resp interface{}) string {
+	body, _ := resp.(map[string]interface{})
+	fault, _ := body["Fault"].(map[string]interface{})
+	items, _ := fault["Error"].([]interface{})
+
+	messages := make([]string, 0, len(items))
+	for _, item := range items {
+		if _item, ok := item.(map[string]interface{}); ok {
+			messages = append(messages, fmt.Sprintf("%v: %v", _item["Message"], _item["Detail"]))
+		}
+	}
+
+	if len(messages) == 0 {
+		return ""
+	}
+
+	return fmt.Sprintf("; %s", strings.Join(messages, "; "))
 }
@@ -0,0 +1,370 @@
+// +build unit
+
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package middleware
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	"github.com/provideplatform/provide-go/common"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+const mockQBSClientID = "ABqbsClientID"
+const mockQBSClientSecret = "s3cr3t"
+const mockQBSRealmID = "4620816365272950000"
+const mockQBSRefreshToken = "AB11refresh0"
+
+// mockQBSRequest is a request received by the mock QuickBooks accounting API
+type mockQBSRequest struct {
+	method string
+	path   string
+	query  string
+	body   map[string]interface{}
+}
+
+// mockQBS is a minimal QuickBooks token endpoint and accounting API serving a single company
+// with one purchase order
+type mockQBS struct {
+	*httptest.Server
+	mutex         sync.Mutex
+	requests      []*mockQBSRequest
+	refreshTokens []string
+	refreshToken  string
+	tokens        []string
+	tokensSent    int
+	expiresIn     int
+}
+
+func newMockQBS() *mockQBS {
+	mock := &mockQBS{
+		refreshToken: mockQBSRefreshToken,
+		expiresIn:    3600,
+	}
+	mock.Server = httptest.NewServer(http.HandlerFunc(mock.serve))
+	return mock
+}
+
+func (m *mockQBS) serve(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.URL.Path == "/oauth2/v1/tokens/bearer" {
+		m.token(w, r)
+		return
+	}
+
+	m.mutex.Lock()
+	authorized := false
+	for _, token := range m.tokens {
+		authorized = authorized || r.Header.Get("Authorization") == fmt.Sprintf("bearer %s", token)
+	}
+	m.mutex.Unlock()
+
+	if !authorized {
+		w.WriteHeader(401)
+		json.NewEncoder(w).Encode(mockQBSFault("AuthenticationFailed", "Token expired"))
+		return
+	}
+
+	req := &mockQBSRequest{
+		method: r.Method,
+		path:   r.URL.Path,
+		query:  r.URL.RawQuery,
+	}
+	if raw, _ := ioutil.ReadAll(r.Body); len(raw) > 0 {
+		json.Unmarshal(raw, &req.body)
+	}
+
+	m.mutex.Lock()
+	m.requests = append(m.requests, req)
+	m.mutex.Unlock()
+
+	path := strings.TrimPrefix(r.URL.Path, fmt.Sprintf("/v3/company/%s/", mockQBSRealmID))
+	switch {
+	case r.Method == http.MethodGet && path == fmt.Sprintf("companyinfo/%s", mockQBSRealmID):
+		json.NewEncoder(w).Encode(map[string]interface{}{"CompanyInfo": map[string]interface{}{"CompanyName": "Baseline Supplies"}})
+	case r.Method == http.MethodGet && path == "preferences":
+		json.NewEncoder(w).Encode(map[string]interface{}{"Preferences": mockQBSPreferences})
+	case r.Method == http.MethodGet && path == "purchaseorder/145":
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"PurchaseOrder": map[string]interface{}{"Id": "145", "SyncToken": "2", "POStatus": "Open"},
+		})
+	case r.Method == http.MethodGet && path == "purchaseorder/146":
+		json.NewEncoder(w).Encode(map[string]interface{}{"PurchaseOrder": map[string]interface{}{"Id": "146"}})
+	case r.Method == http.MethodPost && path == "invoice":
+		json.NewEncoder(w).Encode(map[string]interface{}{"Invoice": map[string]interface{}{"Id": "130", "SyncToken": "0"}})
+	case r.Method == http.MethodPost && path == "purchaseorder":
+		if req.body["sparse"] == true && req.body["SyncToken"] != "2" {
+			w.WriteHeader(400)
+			json.NewEncoder(w).Encode(mockQBSFault("Stale Object Error", "You and another user were working on the same thing"))
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"PurchaseOrder": map[string]interface{}{"Id": "145", "SyncToken": "3"}})
+	case r.Method == http.MethodGet && path == "invoice/131":
+		json.NewEncoder(w).Encode([]interface{}{})
+	default:
+		w.WriteHeader(400)
+		json.NewEncoder(w).Encode(mockQBSFault("Object Not Found", "Object Not Found"))
+	}
+}
+
+// token vends an access token and rotates the refresh token for a valid refresh token grant
+// authenticated using the client credentials
+func (m *mockQBS) token(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	username, password, ok := r.BasicAuth()
+	authorized := ok && username == mockQBSClientID && password == mockQBSClientSecret
+	authorized = authorized && r.PostForm.Get("grant_type") == "refresh_token"
+	authorized = authorized && r.PostForm.Get("refresh_token") == m.refreshToken
+	m.refreshTokens = append(m.refreshTokens, r.PostForm.Get("refresh_token"))
+
+	if !authorized {
+		w.WriteHeader(400)
+		json.NewEncoder(w).Encode(map[string]interface{}{"error": "invalid_grant"})
+		return
+	}
+
+	m.tokensSent++
+	token := fmt.Sprintf("eyJqbsAccessToken%d", m.tokensSent)
+	m.tokens = append(m.tokens, token)
+	m.refreshToken = fmt.Sprintf("AB11refresh%d", m.tokensSent)
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token":  token,
+		"expires_in":    m.expiresIn,
+		"refresh_token": m.refreshToken,
+		"token_type":    "bearer",
+	})
+}
+
+// revokeTokens invalidates all previously-vended access tokens
+func (m *mockQBS) revokeTokens() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.tokens = nil
+}
+
+func (m *mockQBS) lastRequest() *mockQBSRequest {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.requests[len(m.requests)-1]
+}
+
+var mockQBSPreferences = map[string]interface{}{
+	"VendorAndPurchasesPrefs": map[string]interface{}{
+		"POCustomField": []interface{}{
+			map[string]interface{}{
+				"CustomField": []interface{}{
+					map[string]interface{}{"Name": "PurchasePrefs.UsePurchaseCustom1", "Type": "BooleanType", "BooleanValue": true},
+					map[string]interface{}{"Name": "PurchasePrefs.UsePurchaseCustom2", "Type": "BooleanType", "BooleanValue": false},
+				},
+			},
+			map[string]interface{}{
+				"CustomField": []interface{}{
+					map[string]interface{}{"Name": "PurchasePrefs.PurchaseCustomName1", "Type": "StringType", "StringValue": "Baseline ID"},
+					map[string]interface{}{"Name": "PurchasePrefs.PurchaseCustomName2", "Type": "StringType", "StringValue": "Unused"},
+				},
+			},
+		},
+	},
+}
+
+func mockQBSFault(message, detail string) map[string]interface{} {
+	return map[string]interface{}{
+		"Fault": map[string]interface{}{
+			"Error": []interface{}{
+				map[string]interface{}{"Message": message, "Detail": detail},
+			},
+			"type": "ValidationFault",
+		},
+	}
+}
+
+var _ = Describe("QBS", func() {
+	var mock *mockQBS
+	var sor SOR
+
+	systemFactory := func(auth *SystemAuthentication, config map[string]interface{}) SOR {
+		if auth != nil {
+			auth.TokenURL = common.StringOrNil(fmt.Sprintf("%s/oauth2/v1/tokens/bearer", mock.URL))
+		}
+		return SystemFactory(&System{
+			Name:        common.StringOrNil(sorIdentifierQBS),
+			EndpointURL: common.StringOrNil(mock.URL),
+			Auth:        auth,
+			Config:      config,
+		})
+	}
+
+	refreshTokenAuth := func() *SystemAuthentication {
+		return &SystemAuthentication{
+			ClientID:     common.StringOrNil(mockQBSClientID),
+			ClientSecret: common.StringOrNil(mockQBSClientSecret),
+			RefreshToken: common.StringOrNil(mockQBSRefreshToken),
+		}
+	}
+
+	BeforeEach(func() {
+		mock = newMockQBS()
+		sor = systemFactory(refreshTokenAuth(), map[string]interface{}{"realm_id": mockQBSRealmID})
+	})
+
+	AfterEach(func() {
+		mock.Close()
+	})
+
+	Describe("QBSFactory", func() {
+		It("should require the realm id", func() {
+			Expect(systemFactory(refreshTokenAuth(), nil)).To(BeNil())
+		})
+
+		It("should require the client credentials to refresh access tokens", func() {
+			auth := refreshTokenAuth()
+			auth.ClientSecret = nil
+			Expect(systemFactory(auth, map[string]interface{}{"realm_id": mockQBSRealmID})).To(BeNil())
+		})
+	})
+
+	Describe("HealthCheck", func() {
+		It("should refresh the access token and fetch the company info", func() {
+			Expect(sor.HealthCheck()).To(Succeed())
+			Expect(mock.tokensSent).To(Equal(1))
+			Expect(mock.lastRequest().path).To(Equal(fmt.Sprintf("/v3/company/%s/companyinfo/%s", mockQBSRealmID, mockQBSRealmID)))
+			Expect(mock.lastRequest().query).To(Equal("minorversion=65"))
+		})
+
+		It("should reuse the access token until it expires", func() {
+			Expect(sor.HealthCheck()).To(Succeed())
+			Expect(sor.HealthCheck()).To(Succeed())
+			Expect(mock.tokensSent).To(Equal(1))
+		})
+
+		It("should refresh the access token using the rotated refresh token once it expires", func() {
+			// the access token expires within the leeway and is refreshed for each request
+			mock.expiresIn = 1
+			Expect(sor.HealthCheck()).To(Succeed())
+			Expect(sor.HealthCheck()).To(Succeed())
+			Expect(mock.tokensSent).To(Equal(2))
+			Expect(mock.refreshTokens).To(Equal([]string{mockQBSRefreshToken, "AB11refresh1"}))
+		})
+
+		It("should refresh a rejected access token and retry the request once", func() {
+			Expect(sor.HealthCheck()).To(Succeed())
+			mock.revokeTokens()
+			Expect(sor.HealthCheck()).To(Succeed())
+			Expect(mock.tokensSent).To(Equal(2))
+			Expect(mock.refreshTokens).To(Equal([]string{mockQBSRefreshToken, "AB11refresh1"}))
+		})
+
+		It("should fail when the refresh token is not accepted", func() {
+			auth := refreshTokenAuth()
+			auth.RefreshToken = common.StringOrNil("AB11revoked")
+			sor = systemFactory(auth, map[string]interface{}{"realm_id": mockQBSRealmID})
+			Expect(sor.HealthCheck()).NotTo(Succeed())
+			Expect(mock.tokensSent).To(Equal(0))
+		})
+	})
+
+	Describe("GetSchema", func() {
+		It("should include the custom fields enabled for the entity form", func() {
+			schema, err := sor.GetSchema(qbsRecordTypePurchaseOrder, nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			fields := schema.(map[string]interface{})["fields"].([]interface{})
+			Expect(fields).To(HaveLen(len(qbsEntities[qbsRecordTypePurchaseOrder].fields) + 1))
+
+			custom := fields[len(fields)-1].(map[string]interface{})
+			Expect(custom["name"]).To(Equal("Baseline ID"))
+			Expect(custom["definition_id"]).To(Equal("1"))
+		})
+
+		It("should reject unsupported record types", func() {
+			_, err := sor.GetSchema("bill", nil)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("CreateObject", func() {
+		It("should create the entity and return its id", func() {
+			resp, err := sor.CreateObject(map[string]interface{}{
+				"type":    qbsRecordTypeInvoice,
+				"payload": map[string]interface{}{"CustomerRef": map[string]interface{}{"value": "1"}},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.(map[string]interface{})["id"]).To(Equal("130"))
+			Expect(mock.lastRequest().path).To(Equal(fmt.Sprintf("/v3/company/%s/invoice", mockQBSRealmID)))
+		})
+	})
+
+	Describe("UpdateObject", func() {
+		It("should sparse update the entity using the sync token of its current version", func() {
+			err := sor.UpdateObject("145", map[string]interface{}{
+				"type":    qbsRecordTypePurchaseOrder,
+				"payload": map[string]interface{}{"POStatus": "Closed"},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			req := mock.lastRequest()
+			Expect(req.method).To(Equal(http.MethodPost))
+			Expect(req.path).To(Equal(fmt.Sprintf("/v3/company/%s/purchaseorder", mockQBSRealmID)))
+			Expect(req.body).To(Equal(map[string]interface{}{
+				"Id":        "145",
+				"SyncToken": "2",
+				"POStatus":  "Closed",
+				"sparse":    true,
+			}))
+		})
+
+		It("should fail when the sync token is not resolved", func() {
+			err := sor.UpdateObject("146", map[string]interface{}{
+				"type":    qbsRecordTypePurchaseOrder,
+				"payload": map[string]interface{}{"POStatus": "Closed"},
+			})
+			Expect(err).To(MatchError(ContainSubstring("sync token not resolved")))
+			Expect(mock.lastRequest().method).To(Equal(http.MethodGet))
+		})
+
+		It("should fail rather than panic on an unexpected response", func() {
+			err := sor.UpdateObject("131", map[string]interface{}{
+				"type":    qbsRecordTypeInvoice,
+				"payload": map[string]interface{}{"PrivateNote": "paid"},
+			})
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should include the fault of a rejected entity", func() {
+			err := sor.UpdateObject("147", map[string]interface{}{
+				"type":    qbsRecordTypePurchaseOrder,
+				"payload": map[string]interface{}{"POStatus": "Closed"},
+			})
+			Expect(err).To(MatchError(ContainSubstring("Object Not Found")))
+		})
+	})
+})
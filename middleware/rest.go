@@ -0,0 +1,650 @@
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package middleware
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/provideplatform/provide-go/api"
+	"github.com/provideplatform/provide-go/common"
+)
+
+const restAuthMethodAPIKey = "api_key"
+const restAuthMethodBasic = "basic"
+const restAuthMethodBearer = "bearer"
+const restAuthMethodOAuth2ClientCredentials = "oauth2_client_credentials"
+
+const restDefaultAPIKeyHeader = "X-API-Key"
+const restDefaultIDPath = "id"
+
+// restTokenExpiryLeeway is subtracted from the lifetime of oauth2 access tokens such that
+// tokens are refreshed before they expire in flight
+const restTokenExpiryLeeway = time.Second * 30
+
+const restOperationConfigureTenant = "configure_tenant"
+const restOperationCreateObject = "create_object"
+const restOperationDeleteTenant = "delete_tenant"
+const restOperationGetSchema = "get_schema"
+const restOperationHealthCheck = "health_check"
+const restOperationListSchemas = "list_schemas"
+const restOperationTenantHealthCheck = "tenant_health_check"
+const restOperationUpdateObject = "update_object"
+const restOperationUpdateObjectStatus = "update_object_status"
+
+// RESTConfig configures a generic REST system of record; it is read from the config of the system
+type RESTConfig struct {
+	Headers    map[string]string         `json:"headers,omitempty"`
+	Operations map[string]*RESTOperation `json:"operations"`
+}
+
+// RESTOperation configures the request made for a single system of record operation, i.e., one of
+// configure_tenant, create_object, delete_tenant, get_schema, health_check, list_schemas,
+// tenant_health_check, update_object or update_object_status
+type RESTOperation struct {
+	// Method is the http method; defaults to POST for create_object and configure_tenant, PUT for
+	// update_object and update_object_status, DELETE for delete_tenant and GET otherwise
+	Method string `json:"method,omitempty"`
+
+	// URL is absolute or relative to the endpoint url of the system; the {id}, {type}, {baseline_id}
+	// and {organization_id} placeholders are substituted
+	URL string `json:"url"`
+
+	// Status lists the response statuses indicating success; any 2xx status when not provided
+	Status []int `json:"status,omitempty"`
+
+	// RequestMapping maps request body field paths to field paths of the record, i.e., the object
+	// having id, baseline_id, type and payload fields; the record itself is sent when not provided
+	RequestMapping map[string]string `json:"request_mapping,omitempty"`
+
+	// ResponseMapping maps result fields to response field paths; the response itself is returned
+	// when not provided
+	ResponseMapping map[string]string `json:"response_mapping,omitempty"`
+
+	// ItemsPath is the path to the list of items in the response, each of which is mapped using the
+	// response mapping
+	ItemsPath string `json:"items_path,omitempty"`
+
+	// IDPath is the path to the id of the created business object in the response; defaults to id
+	IDPath string `json:"id_path,omitempty"`
+}
+
+// RESTService is a generic system of record which integrates any REST or webhook API as
+// configured by its system config, without requiring a dedicated connector
+type RESTService struct {
+	api.Client
+	mutex          sync.Mutex
+	auth           *SystemAuthentication
+	config         *RESTConfig
+	tokenExpiresAt *time.Time
+}
+
+// RESTFactory initializes a RESTService instance using the endpoint url, auth and config of the given system
+func RESTFactory(params *System) *RESTService {
+	if params.EndpointURL == nil {
+		common.Log.Warningf("rest system endpoint url not provided")
+		return nil
+	}
+
+	endpoint, err := url.Parse(*params.EndpointURL)
+	if err != nil {
+		common.Log.Warningf("failed to parse endpoint url: %s", *params.EndpointURL)
+		return nil
+	}
+
+	config := &RESTConfig{}
+	if params.Config != nil {
+		raw, _ := json.Marshal(params.Config)
+		err = json.Unmarshal(raw, &config)
+		if err != nil {
+			common.Log.Warningf("failed to parse rest system config; %s", err.Error())
+			return nil
+		}
+	}
+
+	auth := params.Auth
+	if auth == nil {
+		auth = &SystemAuthentication{}
+	}
+
+	headers := map[string][]string{}
+	for name, val := range config.Headers {
+		headers[name] = []string{val}
+	}
+
+	svc := &RESTService{
+		Client: api.Client{
+			Host:    endpoint.Host,
+			Path:    endpoint.Path,
+			Scheme:  endpoint.Scheme,
+			Headers: headers,
+		},
+		mutex:  sync.Mutex{},
+		auth:   auth,
+		config: config,
+	}
+
+	var method string
+	if auth.Method != nil {
+		method = *auth.Method
+	}
+
+	switch method {
+	case "":
+		break
+	case restAuthMethodAPIKey:
+		if auth.APIKey == nil {
+			common.Log.Warningf("rest system api key not provided")
+			return nil
+		}
+
+		header := restDefaultAPIKeyHeader
+		if auth.APIKeyHeader != nil {
+			header = *auth.APIKeyHeader
+		}
+		svc.Headers[header] = []string{*auth.APIKey}
+	case restAuthMethodBasic:
+		svc.Username = auth.Username
+		svc.Password = auth.Password
+	case restAuthMethodBearer:
+		svc.Token = auth.Token
+	case restAuthMethodOAuth2ClientCredentials:
+		if auth.TokenURL == nil || auth.ClientID == nil || auth.ClientSecret == nil {
+			common.Log.Warningf("rest system oauth2 token url, client id and client secret required")
+			return nil
+		}
+	default:
+		common.Log.Warningf("unsupported rest system auth method: %s", method)
+		return nil
+	}
+
+	return svc
+}
+
+// Authenticate vends an oauth2 access token using the client credentials grant, if the system
+// is configured to use it and the previously-vended token is missing or about to expire
+func (s *RESTService) Authenticate() error {
+	if s.auth.Method == nil || *s.auth.Method != restAuthMethodOAuth2ClientCredentials {
+		return nil
+	}
+
+	if s.Token != nil && (s.tokenExpiresAt == nil || time.Now().Before(*s.tokenExpiresAt)) {
+		return nil
+	}
+
+	tokenURL, err := url.Parse(*s.auth.TokenURL)
+	if err != nil {
+		return fmt.Errorf("failed to parse oauth2 token url: %s; %s", *s.auth.TokenURL, err.Error())
+	}
+
+	client := &api.Client{
+		Host:   tokenURL.Host,
+		Scheme: tokenURL.Scheme,
+	}
+
+	params := map[string]interface{}{
+		"grant_type":    "client_credentials",
+		"client_id":     *s.auth.ClientID,
+		"client_secret": *s.auth.ClientSecret,
+	}
+	if s.auth.Scope != nil {
+		params["scope"] = *s.auth.Scope
+	}
+
+	status, resp, err := client.PostWWWFormURLEncoded(strings.TrimPrefix(tokenURL.RequestURI(), "/"), params)
+	if err != nil {
+		return fmt.Errorf("failed to authorize oauth2 access token; status: %v; %s", status, err.Error())
+	}
+
+	if status != 200 {
+		return fmt.Errorf("failed to authorize oauth2 access token; status: %v", status)
+	}
+
+	body, _ := resp.(map[string]interface{})
+	accessToken, ok := body["access_token"].(string)
+	if !ok {
+		return fmt.Errorf("failed to authorize oauth2 access token; no access token in response")
+	}
+
+	s.Token = &accessToken
+	s.tokenExpiresAt = nil
+	if expiresIn, ok := body["expires_in"].(float64); ok && expiresIn > 0 {
+		expiresAt := time.Now().Add(time.Duration(expiresIn) * time.Second).Add(-restTokenExpiryLeeway)
+		s.tokenExpiresAt = &expiresAt
+	}
+
+	return nil
+}
+
+// ConfigureTenant configures a new tenant instance in the system for a given organization
+func (s *RESTService) ConfigureTenant(params map[string]interface{}) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	vars := map[string]string{
+		"organization_id": restParamString(params, "organization_id"),
+	}
+
+	_, err := s.request(restOperationConfigureTenant, vars, params)
+	if err != nil {
+		return fmt.Errorf("failed to configure tenant; %s", err.Error())
+	}
+
+	return nil
+}
+
+// ListSchemas retrieves a list of available schemas
+func (s *RESTService) ListSchemas(params map[string]interface{}) (interface{}, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	resp, err := s.request(restOperationListSchemas, map[string]string{}, params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch business object model; %s", err.Error())
+	}
+
+	return resp, nil
+}
+
+// GetSchema retrieves a business object data model by type
+func (s *RESTService) GetSchema(recordType string, params map[string]interface{}) (interface{}, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	vars := map[string]string{
+		"type": recordType,
+	}
+
+	resp, err := s.request(restOperationGetSchema, vars, params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch business object model; %s", err.Error())
+	}
+
+	return resp, nil
+}
+
+// CreateObject creates a business object; the id of the created object is read from the response
+// using the configured id path and is returned as the id field of the result
+func (s *RESTService) CreateObject(params map[string]interface{}) (interface{}, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	vars := map[string]string{
+		"baseline_id": restParamString(params, "baseline_id"),
+		"type":        restParamString(params, "type"),
+	}
+
+	resp, err := s.request(restOperationCreateObject, vars, params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create business object; %s", err.Error())
+	}
+
+	idPath := s.config.Operations[restOperationCreateObject].IDPath
+	if idPath == "" {
+		idPath = restDefaultIDPath
+	}
+
+	id := restValueAtPath(resp, idPath)
+	if id == nil {
+		return nil, fmt.Errorf("failed to create business object; no id in response at path: %s", idPath)
+	}
+
+	result, ok := resp.(map[string]interface{})
+	if !ok {
+		result = map[string]interface{}{}
+	}
+	result["id"] = restValueString(id)
+
+	return result, nil
+}
+
+// UpdateObject updates a business object
+func (s *RESTService) UpdateObject(id string, params map[string]interface{}) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	record := params
+	if _, ok := params["payload"]; !ok {
+		record = map[string]interface{}{
+			"payload": params,
+		}
+	}
+
+	vars := map[string]string{
+		"id":          id,
+		"baseline_id": restParamString(record, "baseline_id"),
+		"type":        restParamString(record, "type"),
+	}
+
+	_, err := s.request(restOperationUpdateObject, vars, restRecord(id, record))
+	if err != nil {
+		return fmt.Errorf("failed to update business object; %s", err.Error())
+	}
+
+	return nil
+}
+
+// UpdateObjectStatus updates the status of a business object
+func (s *RESTService) UpdateObjectStatus(id string, params map[string]interface{}) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	vars := map[string]string{
+		"id":          id,
+		"baseline_id": restParamString(params, "baseline_id"),
+		"type":        restParamString(params, "type"),
+	}
+
+	_, err := s.request(restOperationUpdateObjectStatus, vars, restRecord(id, params))
+	if err != nil {
+		return fmt.Errorf("failed to update business object status; %s", err.Error())
+	}
+
+	return nil
+}
+
+// DeleteTenant drops a BPI tenant configuration for the given organization
+func (s *RESTService) DeleteTenant(organizationID string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	vars := map[string]string{
+		"organization_id": organizationID,
+	}
+
+	_, err := s.request(restOperationDeleteTenant, vars, nil)
+	if err != nil {
+		return fmt.Errorf("failed to delete tenant config for organization %s; %s", organizationID, err.Error())
+	}
+
+	return nil
+}
+
+// HealthCheck checks the health of the system
+func (s *RESTService) HealthCheck() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	_, err := s.request(restOperationHealthCheck, map[string]string{}, nil)
+	if err != nil {
+		return fmt.Errorf("health check failed; %s", err.Error())
+	}
+
+	return nil
+}
+
+// TenantHealthCheck checks the health of the system for the given organization, falling back
+// to the health check when no tenant health check is configured
+func (s *RESTService) TenantHealthCheck(organizationID string) error {
+	if s.config.Operations[restOperationTenantHealthCheck] == nil {
+		return s.HealthCheck()
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	vars := map[string]string{
+		"organization_id": organizationID,
+	}
+
+	_, err := s.request(restOperationTenantHealthCheck, vars, nil)
+	if err != nil {
+		return fmt.Errorf("tenant health check failed for organization %s; %s", organizationID, err.Error())
+	}
+
+	return nil
+}
+
+// request makes the configured request for the given operation, mapping the given record to the
+// request body and the response to the result; an oauth2 access token rejected by the system is
+// refreshed and the request retried once
+func (s *RESTService) request(operation string, vars map[string]string, record map[string]interface{}) (interface{}, error) {
+	op := s.config.Operations[operation]
+	if op == nil {
+		return nil, fmt.Errorf("%s operation not configured", operation)
+	}
+
+	err := s.Authenticate()
+	if err != nil {
+		return nil, err
+	}
+
+	status, resp, err := s.send(op, operation, vars, record)
+	if status == 401 && s.auth.Method != nil && *s.auth.Method == restAuthMethodOAuth2ClientCredentials {
+		s.Token = nil
+		err = s.Authenticate()
+		if err != nil {
+			return nil, err
+		}
+		status, resp, err = s.send(op, operation, vars, record)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("status: %v; %s", status, err.Error())
+	}
+
+	if !op.accepts(status) {
+		return nil, fmt.Errorf("status: %v", status)
+	}
+
+	return op.mapResponse(resp), nil
+}
+
+func (s *RESTService) send(op *RESTOperation, operation string, vars map[string]string, record map[string]interface{}) (int, interface{}, error) {
+	client := s.Client
+	uri := restExpandURL(op.URL, vars)
+	if endpoint, err := url.Parse(uri); err == nil && endpoint.IsAbs() {
+		client.Host = endpoint.Host
+		client.Path = ""
+		client.Scheme = endpoint.Scheme
+		uri = endpoint.RequestURI()
+	}
+	uri = strings.TrimPrefix(uri, "/")
+
+	body := op.mapRequest(record)
+
+	switch op.method(operation) {
+	case "DELETE":
+		return client.Delete(uri)
+	case "GET":
+		return client.Get(uri, body)
+	case "PATCH":
+		return client.Patch(uri, body)
+	case "POST":
+		return client.Post(uri, body)
+	case "PUT":
+		return client.Put(uri, body)
+	default:
+		return 0, nil, fmt.Errorf("unsupported method: %s", op.Method)
+	}
+}
+
+func (op *RESTOperation) method(operation string) string {
+	if op.Method != "" {
+		return strings.ToUpper(op.Method)
+	}
+
+	switch operation {
+	case restOperationConfigureTenant, restOperationCreateObject:
+		return "POST"
+	case restOperationUpdateObject, restOperationUpdateObjectStatus:
+		return "PUT"
+	case restOperationDeleteTenant:
+		return "DELETE"
+	default:
+		return "GET"
+	}
+}
+
+func (op *RESTOperation) accepts(status int) bool {
+	if len(op.Status) == 0 {
+		return status >= 200 && status < 300
+	}
+
+	for _, s := range op.Status {
+		if s == status {
+			return true
+		}
+	}
+
+	return false
+}
+
+// mapRequest returns the request body for the given record using the request mapping
+func (op *RESTOperation) mapRequest(record map[string]interface{}) map[string]interface{} {
+	if record == nil || len(op.RequestMapping) == 0 {
+		return record
+	}
+
+	// normalize the record such that its fields can be resolved by path
+	var normalized interface{}
+	raw, _ := json.Marshal(record)
+	json.Unmarshal(raw, &normalized)
+
+	body := map[string]interface{}{}
+	for target, source := range op.RequestMapping {
+		if val := restValueAtPath(normalized, source); val != nil {
+			restSetValueAtPath(body, target, val)
+		}
+	}
+
+	return body
+}
+
+// mapResponse returns the result for the given response using the items path and response mapping
+func (op *RESTOperation) mapResponse(resp interface{}) interface{} {
+	items, isList := resp.([]interface{})
+	if op.ItemsPath != "" {
+		items, isList = restValueAtPath(resp, op.ItemsPath).([]interface{})
+		if !isList {
+			return make([]interface{}, 0)
+		}
+	}
+
+	if !isList {
+		return op.mapItem(resp)
+	}
+
+	results := make([]interface{}, 0, len(items))
+	for _, item := range items {
+		results = append(results, op.mapItem(item))
+	}
+
+	return results
+}
+
+func (op *RESTOperation) mapItem(item interface{}) interface{} {
+	if len(op.ResponseMapping) == 0 {
+		return item
+	}
+
+	result := map[string]interface{}{}
+	for field, path := range op.ResponseMapping {
+		result[field] = restValueAtPath(item, path)
+	}
+
+	return result
+}
+
+// restRecord returns the given params with the given id, such that the id is available to request mappings
+func restRecord(id string, params map[string]interface{}) map[string]interface{} {
+	record := map[string]interface{}{}
+	for k, v := range params {
+		record[k] = v
+	}
+	record["id"] = id
+	return record
+}
+
+// restExpandURL substitutes the given vars for their {placeholders} in the given url template
+func restExpandURL(template string, vars map[string]string) string {
+	uri := template
+	for name, val := range vars {
+		uri = strings.ReplaceAll(uri, fmt.Sprintf("{%s}", name), url.PathEscape(val))
+	}
+	return uri
+}
+
+// restParamString returns the string or *string param with the given key, or an empty string
+func restParamString(params map[string]interface{}, key string) string {
+	switch val := params[key].(type) {
+	case string:
+		return val
+	case *string:
+		if val != nil {
+			return *val
+		}
+	}
+	return ""
+}
+
+// restValueString returns the given json value as a string
+func restValueString(val interface{}) string {
+	switch v := val.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+// restValueAtPath resolves the given dot-separated path in the given json value; numeric
+// path segments index into lists
+func restValueAtPath(val interface{}, path string) interface{} {
+	if path == "" || path == "." {
+		return val
+	}
+
+	for _, segment := range strings.Split(path, ".") {
+		switch v := val.(type) {
+		case map[string]interface{}:
+			val = v[segment]
+		case []interface{}:
+			i, err := strconv.Atoi(segment)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil
+			}
+			val = v[i]
+		default:
+			return nil
+		}
+	}
+
+	return val
+}
+
+// restSetValueAtPath sets the given value at the given dot-separated path in the given object,
+// creating intermediate objects as necessary
+func restSetValueAtPath(obj map[string]interface{}, path string, val interface{}) {
+	segments := strings.Split(path, ".")
+	for _, segment := range segments[:len(segments)-1] {
+		next, ok := obj[segment].(map[string]interface{})
+		if !ok {
+			next = map[string]interface{}{}
+			obj[segment] = next
+		}
+		obj = next
+	}
+	obj[segments[len(segments)-1]] = val
+}
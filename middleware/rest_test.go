@@ -0,0 +1,456 @@
+// +build unit
+
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package middleware
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+
+	"github.com/provideplatform/provide-go/common"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+const mockRESTClientID = "baseline"
+const mockRESTClientSecret = "s3cr3t"
+
+// mockRESTRequest is a request received by the mock REST API
+type mockRESTRequest struct {
+	method        string
+	path          string
+	query         url.Values
+	authorization string
+	apiKey        string
+	body          map[string]interface{}
+}
+
+// mockREST is a minimal REST API which responds with the configured status and body, along
+// with an oauth2 token endpoint vending a new access token for each client credentials grant
+type mockREST struct {
+	*httptest.Server
+	mutex         sync.Mutex
+	requests      []*mockRESTRequest
+	tokenRequests []url.Values
+	revoked       map[string]bool
+	status        int
+	response      interface{}
+}
+
+func newMockREST() *mockREST {
+	mock := &mockREST{
+		revoked: map[string]bool{},
+		status:  200,
+	}
+	mock.Server = httptest.NewServer(http.HandlerFunc(mock.serve))
+	return mock
+}
+
+func (m *mockREST) serve(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if r.URL.Path == "/oauth/token" {
+		r.ParseForm()
+		m.tokenRequests = append(m.tokenRequests, r.PostForm)
+
+		if r.PostForm.Get("client_id") != mockRESTClientID || r.PostForm.Get("client_secret") != mockRESTClientSecret {
+			w.WriteHeader(401)
+			return
+		}
+
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": fmt.Sprintf("token-%d", len(m.tokenRequests)),
+			"expires_in":   3600,
+			"token_type":   "bearer",
+		})
+		return
+	}
+
+	req := &mockRESTRequest{
+		method:        r.Method,
+		path:          r.URL.EscapedPath(),
+		query:         r.URL.Query(),
+		authorization: r.Header.Get("Authorization"),
+		apiKey:        r.Header.Get("X-API-Key"),
+	}
+	if raw, _ := ioutil.ReadAll(r.Body); len(raw) > 0 {
+		json.Unmarshal(raw, &req.body)
+	}
+	m.requests = append(m.requests, req)
+
+	if m.revoked[req.authorization] {
+		w.WriteHeader(401)
+		return
+	}
+
+	w.WriteHeader(m.status)
+	if m.response != nil {
+		json.NewEncoder(w).Encode(m.response)
+	}
+}
+
+// respond configures the status and body of subsequent responses
+func (m *mockREST) respond(status int, response interface{}) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.status = status
+	m.response = response
+}
+
+// revoke rejects subsequent requests authorized using the given access token
+func (m *mockREST) revoke(token string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.revoked[fmt.Sprintf("bearer %s", token)] = true
+}
+
+func (m *mockREST) lastRequest() *mockRESTRequest {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if len(m.requests) == 0 {
+		return nil
+	}
+	return m.requests[len(m.requests)-1]
+}
+
+func (m *mockREST) requestCount() int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return len(m.requests)
+}
+
+func (m *mockREST) tokenRequestCount() int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return len(m.tokenRequests)
+}
+
+var _ = Describe("REST", func() {
+	var mock *mockREST
+
+	systemFactory := func(auth *SystemAuthentication, operations map[string]interface{}) *System {
+		return &System{
+			Auth:        auth,
+			Config:      map[string]interface{}{"operations": operations},
+			EndpointURL: common.StringOrNil(fmt.Sprintf("%s/api", mock.URL)),
+			Name:        common.StringOrNil(sorIdentifierREST),
+		}
+	}
+
+	BeforeEach(func() {
+		mock = newMockREST()
+	})
+
+	AfterEach(func() {
+		mock.Close()
+	})
+
+	DescribeTable("restExpandURL",
+		func(template string, expected string) {
+			Expect(restExpandURL(template, map[string]string{
+				"id":   "po/1",
+				"type": "purchase_order",
+			})).To(Equal(expected))
+		},
+		Entry("without placeholders", "orders", "orders"),
+		Entry("single placeholder", "{type}", "purchase_order"),
+		Entry("several placeholders", "records/{type}/{id}", "records/purchase_order/po%2F1"),
+		Entry("repeated placeholder", "{type}/{type}", "purchase_order/purchase_order"),
+		Entry("unknown placeholder", "tenants/{organization_id}", "tenants/{organization_id}"),
+	)
+
+	DescribeTable("restValueAtPath",
+		func(path string, expected interface{}) {
+			val := map[string]interface{}{
+				"data": map[string]interface{}{
+					"items": []interface{}{
+						map[string]interface{}{"id": 42.0},
+					},
+				},
+			}
+			if expected == nil {
+				Expect(restValueAtPath(val, path)).To(BeNil())
+				return
+			}
+			Expect(restValueAtPath(val, path)).To(Equal(expected))
+		},
+		Entry("nested field", "data.items.0.id", 42.0),
+		Entry("list", "data.items", []interface{}{map[string]interface{}{"id": 42.0}}),
+		Entry("missing field", "data.id", nil),
+		Entry("index out of range", "data.items.1.id", nil),
+		Entry("non-numeric index", "data.items.first", nil),
+	)
+
+	Describe("CreateObject", func() {
+		It("should expand the url placeholders and map the record to the request body", func() {
+			mock.respond(201, map[string]interface{}{"data": map[string]interface{}{"order": map[string]interface{}{"id": 42}}})
+
+			svc := RESTFactory(systemFactory(nil, map[string]interface{}{
+				"create_object": map[string]interface{}{
+					"url": "records/{type}?baseline_id={baseline_id}",
+					"request_mapping": map[string]interface{}{
+						"order.number":      "payload.po_number",
+						"order.amount":      "payload.amount",
+						"order.baseline_id": "baseline_id",
+					},
+					"id_path": "data.order.id",
+				},
+			}))
+			Expect(svc).NotTo(BeNil())
+
+			resp, err := svc.CreateObject(map[string]interface{}{
+				"type":        "purchase_order",
+				"baseline_id": "b-1",
+				"payload":     map[string]interface{}{"po_number": "PO-1", "amount": 12.5},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.(map[string]interface{})["id"]).To(Equal("42"))
+
+			req := mock.lastRequest()
+			Expect(req.method).To(Equal(http.MethodPost))
+			Expect(req.path).To(Equal("/api/records/purchase_order"))
+			Expect(req.query.Get("baseline_id")).To(Equal("b-1"))
+			Expect(req.body).To(Equal(map[string]interface{}{
+				"order": map[string]interface{}{
+					"number":      "PO-1",
+					"amount":      12.5,
+					"baseline_id": "b-1",
+				},
+			}))
+		})
+
+		It("should send the record when no request mapping is configured", func() {
+			mock.respond(201, map[string]interface{}{"id": "po-1"})
+
+			svc := RESTFactory(systemFactory(nil, map[string]interface{}{
+				"create_object": map[string]interface{}{"url": "records"},
+			}))
+
+			resp, err := svc.CreateObject(map[string]interface{}{
+				"type":    "purchase_order",
+				"payload": map[string]interface{}{"po_number": "PO-1"},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.(map[string]interface{})["id"]).To(Equal("po-1"))
+			Expect(mock.lastRequest().body).To(Equal(map[string]interface{}{
+				"type":    "purchase_order",
+				"payload": map[string]interface{}{"po_number": "PO-1"},
+			}))
+		})
+
+		It("should fail when the response has no id at the id path", func() {
+			mock.respond(201, map[string]interface{}{"data": map[string]interface{}{}})
+
+			svc := RESTFactory(systemFactory(nil, map[string]interface{}{
+				"create_object": map[string]interface{}{"url": "records", "id_path": "data.id"},
+			}))
+
+			_, err := svc.CreateObject(map[string]interface{}{"type": "purchase_order"})
+			Expect(err).To(MatchError(ContainSubstring("no id in response at path: data.id")))
+		})
+
+		It("should fail when the response status is not accepted", func() {
+			mock.respond(200, map[string]interface{}{"id": "po-1"})
+
+			svc := RESTFactory(systemFactory(nil, map[string]interface{}{
+				"create_object": map[string]interface{}{"url": "records", "status": []interface{}{201}},
+			}))
+
+			_, err := svc.CreateObject(map[string]interface{}{"type": "purchase_order"})
+			Expect(err).To(MatchError(ContainSubstring("status: 200")))
+		})
+
+		It("should fail when the operation is not configured", func() {
+			svc := RESTFactory(systemFactory(nil, map[string]interface{}{}))
+
+			_, err := svc.CreateObject(map[string]interface{}{"type": "purchase_order"})
+			Expect(err).To(MatchError(ContainSubstring("create_object operation not configured")))
+			Expect(mock.requestCount()).To(Equal(0))
+		})
+	})
+
+	Describe("UpdateObject", func() {
+		It("should escape the id in the url and make the id available to the request mapping", func() {
+			svc := RESTFactory(systemFactory(nil, map[string]interface{}{
+				"update_object": map[string]interface{}{
+					"method": "patch",
+					"url":    "records/{id}",
+					"request_mapping": map[string]interface{}{
+						"ref":    "id",
+						"number": "payload.po_number",
+					},
+				},
+			}))
+
+			Expect(svc.UpdateObject("po/1", map[string]interface{}{"po_number": "PO-1"})).To(Succeed())
+
+			req := mock.lastRequest()
+			Expect(req.method).To(Equal(http.MethodPatch))
+			Expect(req.path).To(Equal("/api/records/po%2F1"))
+			Expect(req.body).To(Equal(map[string]interface{}{"ref": "po/1", "number": "PO-1"}))
+		})
+	})
+
+	Describe("ListSchemas", func() {
+		It("should map each item of the response", func() {
+			mock.respond(200, map[string]interface{}{
+				"data": map[string]interface{}{
+					"schemas": []interface{}{
+						map[string]interface{}{"name": "purchase_order", "label": "Purchase Order"},
+						map[string]interface{}{"name": "invoice", "label": "Invoice"},
+					},
+				},
+			})
+
+			svc := RESTFactory(systemFactory(nil, map[string]interface{}{
+				"list_schemas": map[string]interface{}{
+					"url":              "schemas",
+					"items_path":       "data.schemas",
+					"response_mapping": map[string]interface{}{"type": "name", "description": "label"},
+				},
+			}))
+
+			schemas, err := svc.ListSchemas(map[string]interface{}{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(schemas).To(Equal([]interface{}{
+				map[string]interface{}{"type": "purchase_order", "description": "Purchase Order"},
+				map[string]interface{}{"type": "invoice", "description": "Invoice"},
+			}))
+			Expect(mock.lastRequest().method).To(Equal(http.MethodGet))
+			Expect(mock.lastRequest().path).To(Equal("/api/schemas"))
+		})
+
+		It("should return no schemas when the items path does not resolve a list", func() {
+			mock.respond(200, map[string]interface{}{"data": map[string]interface{}{}})
+
+			svc := RESTFactory(systemFactory(nil, map[string]interface{}{
+				"list_schemas": map[string]interface{}{"url": "schemas", "items_path": "data.schemas"},
+			}))
+
+			schemas, err := svc.ListSchemas(map[string]interface{}{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(schemas).To(BeEmpty())
+		})
+	})
+
+	Describe("HealthCheck", func() {
+		It("should request absolute urls regardless of the endpoint url", func() {
+			svc := RESTFactory(systemFactory(nil, map[string]interface{}{
+				"health_check": map[string]interface{}{"url": fmt.Sprintf("%s/status", mock.URL)},
+			}))
+
+			Expect(svc.HealthCheck()).To(Succeed())
+			Expect(mock.lastRequest().path).To(Equal("/status"))
+		})
+	})
+
+	Describe("authentication", func() {
+		healthCheck := map[string]interface{}{
+			"health_check": map[string]interface{}{"url": "status"},
+		}
+
+		It("should send the api key in the default header", func() {
+			svc := RESTFactory(systemFactory(&SystemAuthentication{
+				Method: common.StringOrNil(restAuthMethodAPIKey),
+				APIKey: common.StringOrNil("k3y"),
+			}, healthCheck))
+
+			Expect(svc.HealthCheck()).To(Succeed())
+			Expect(mock.lastRequest().apiKey).To(Equal("k3y"))
+		})
+
+		It("should send bearer tokens", func() {
+			svc := RESTFactory(systemFactory(&SystemAuthentication{
+				Method: common.StringOrNil(restAuthMethodBearer),
+				Token:  common.StringOrNil("t0k3n"),
+			}, healthCheck))
+
+			Expect(svc.HealthCheck()).To(Succeed())
+			Expect(mock.lastRequest().authorization).To(Equal("bearer t0k3n"))
+		})
+
+		It("should reject unsupported auth methods", func() {
+			Expect(RESTFactory(systemFactory(&SystemAuthentication{
+				Method: common.StringOrNil("digest"),
+			}, healthCheck))).To(BeNil())
+		})
+
+		Describe("oauth2 client credentials", func() {
+			var svc *RESTService
+
+			BeforeEach(func() {
+				svc = RESTFactory(systemFactory(&SystemAuthentication{
+					Method:       common.StringOrNil(restAuthMethodOAuth2ClientCredentials),
+					ClientID:     common.StringOrNil(mockRESTClientID),
+					ClientSecret: common.StringOrNil(mockRESTClientSecret),
+					Scope:        common.StringOrNil("records.write"),
+					TokenURL:     common.StringOrNil(fmt.Sprintf("%s/oauth/token", mock.URL)),
+				}, healthCheck))
+				Expect(svc).NotTo(BeNil())
+			})
+
+			It("should vend an access token once and reuse it until it expires", func() {
+				Expect(svc.HealthCheck()).To(Succeed())
+				Expect(svc.HealthCheck()).To(Succeed())
+
+				Expect(mock.tokenRequestCount()).To(Equal(1))
+				Expect(mock.tokenRequests[0].Get("grant_type")).To(Equal("client_credentials"))
+				Expect(mock.tokenRequests[0].Get("scope")).To(Equal("records.write"))
+				Expect(mock.lastRequest().authorization).To(Equal("bearer token-1"))
+			})
+
+			It("should refresh the access token and retry once when the request is unauthorized", func() {
+				Expect(svc.HealthCheck()).To(Succeed())
+				mock.revoke("token-1")
+
+				Expect(svc.HealthCheck()).To(Succeed())
+				Expect(mock.tokenRequestCount()).To(Equal(2))
+				Expect(mock.requestCount()).To(Equal(3))
+				Expect(mock.lastRequest().authorization).To(Equal("bearer token-2"))
+			})
+
+			It("should fail when the refreshed access token is also unauthorized", func() {
+				mock.revoke("token-1")
+				mock.revoke("token-2")
+
+				err := svc.HealthCheck()
+				Expect(err).To(MatchError(ContainSubstring("status: 401")))
+				Expect(mock.tokenRequestCount()).To(Equal(2))
+				Expect(mock.requestCount()).To(Equal(2))
+			})
+
+			It("should fail when the client credentials are rejected", func() {
+				svc.auth.ClientSecret = common.StringOrNil("wrong")
+
+				err := svc.HealthCheck()
+				Expect(err).To(MatchError(ContainSubstring("failed to authorize oauth2 access token")))
+				Expect(mock.requestCount()).To(Equal(0))
+			})
+		})
+	})
+})
@@ -17,38 +17,126 @@
 package middleware
 
 import (
+	"encoding/json"
 	"fmt"
+	"net/url"
 	"os"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 
+	"github.com/dgrijalva/jwt-go"
 	"github.com/provideplatform/provide-go/api"
 	"github.com/provideplatform/provide-go/common"
 )
 
+const defaultSalesforceAPIVersion = "v56.0"
 const defaultSalesforceHost = "testnet.dappsuite.network"
-const defaultSalesforcePath = "api"
 const defaultSalesforceScheme = "https"
+const defaultSalesforceTokenURL = "https://login.salesforce.com/services/oauth2/token"
 
-// SalesforceService for the SAP API
+const salesforceAuthMethodOAuth2ClientCredentials = "oauth2_client_credentials"
+const salesforceAuthMethodOAuth2JWTBearer = "oauth2_jwt_bearer"
+
+const salesforceGrantTypeClientCredentials = "client_credentials"
+const salesforceGrantTypeJWTBearer = "urn:ietf:params:oauth:grant-type:jwt-bearer"
+
+// salesforceJWTAssertionTTL is the lifetime of the signed assertion exchanged for an access token
+const salesforceJWTAssertionTTL = time.Minute * 3
+
+// salesforceTokenExpiryLeeway is subtracted from the lifetime of access tokens, when provided,
+// such that tokens are refreshed before they expire in flight
+const salesforceTokenExpiryLeeway = time.Second * 30
+
+// SalesforceService for the Salesforce REST API
 type SalesforceService struct {
 	api.Client
-	mutex sync.Mutex
+	mutex          sync.Mutex
+	auth           *SystemAuthentication
+	sobjects       map[string]string
+	tokenExpiresAt *time.Time
 }
 
-// SalesforceService initializes a Salesforce instance
+// SalesforceFactory initializes a Salesforce instance using the endpoint url and auth of the given
+// system; access tokens are vended using the oauth2_jwt_bearer or oauth2_client_credentials auth
+// method, if configured, and the sobjects config maps record types to sObjects; when no sObjects
+// are mapped, records are routed to the sObject named by the record type
 func SalesforceFactory(params *System) *SalesforceService {
-	common.Log.Warningf("SalesforceNowFactory not implemented")
-	return nil
+	client, err := systemClient(params)
+	if err != nil {
+		common.Log.Warningf("failed to initialize Salesforce system; %s", err.Error())
+		return nil
+	}
+
+	if client.Path == "" || client.Path == "/" {
+		apiVersion := defaultSalesforceAPIVersion
+		if version, ok := params.Config["api_version"].(string); ok && version != "" {
+			apiVersion = version
+		}
+		client.Path = salesforceDataPath(apiVersion)
+	}
+
+	auth := params.Auth
+	if auth == nil {
+		auth = &SystemAuthentication{}
+	}
+
+	if auth.Method != nil {
+		switch *auth.Method {
+		case salesforceAuthMethodOAuth2ClientCredentials:
+			if auth.ClientID == nil || auth.ClientSecret == nil {
+				common.Log.Warningf("salesforce system oauth2 client id and client secret required")
+				return nil
+			}
+		case salesforceAuthMethodOAuth2JWTBearer:
+			if auth.ClientID == nil || auth.Username == nil || auth.PrivateKey == nil {
+				common.Log.Warningf("salesforce system oauth2 client id, username and private key required")
+				return nil
+			}
+
+			_, err := jwt.ParseRSAPrivateKeyFromPEM([]byte(*auth.PrivateKey))
+			if err != nil {
+				common.Log.Warningf("failed to parse salesforce system private key; %s", err.Error())
+				return nil
+			}
+		default:
+			common.Log.Warningf("unsupported salesforce system auth method: %s", *auth.Method)
+			return nil
+		}
+
+		// basic auth is not supported by the REST API
+		client.Username = nil
+		client.Password = nil
+	}
+
+	sobjects := map[string]string{}
+	if _sobjects, ok := params.Config["sobjects"].(map[string]interface{}); ok {
+		for recordType, sobject := range _sobjects {
+			if _sobject, ok := sobject.(string); ok {
+				sobjects[recordType] = _sobject
+			}
+		}
+	}
+
+	return &SalesforceService{
+		*client,
+		sync.Mutex{},
+		auth,
+		sobjects,
+		nil,
+	}
 }
 
-// InitSalesforceService convenience method to initialize a Salesforce instance
+// InitSalesforceService convenience method to initialize a Salesforce instance; the record type
+// to sObject routing is read from SALESFORCE_SOBJECTS as a json object
 func InitSalesforceService(token *string) *SalesforceService {
 	host := defaultSalesforceHost
 	if os.Getenv("SALESFORCE_API_HOST") != "" {
 		host = os.Getenv("SALESFORCE_API_HOST")
 	}
 
-	path := defaultSalesforcePath
+	path := salesforceDataPath(defaultSalesforceAPIVersion)
 	if os.Getenv("SALESFORCE_API_PATH") != "" {
 		path = os.Getenv("SALESFORCE_API_PATH")
 	}
@@ -58,6 +146,15 @@ func InitSalesforceService(token *string) *SalesforceService {
 		scheme = os.Getenv("SALESFORCE_API_SCHEME")
 	}
 
+	sobjects := map[string]string{}
+	if os.Getenv("SALESFORCE_SOBJECTS") != "" {
+		err := json.Unmarshal([]byte(os.Getenv("SALESFORCE_SOBJECTS")), &sobjects)
+		if err != nil {
+			common.Log.Warningf("failed to parse SALESFORCE_SOBJECTS; %s", err.Error())
+			return nil
+		}
+	}
+
 	return &SalesforceService{
 		api.Client{
 			Host:   host,
@@ -66,36 +163,215 @@ func InitSalesforceService(token *string) *SalesforceService {
 			Token:  token,
 		},
 		sync.Mutex{},
+		&SystemAuthentication{},
+		sobjects,
+		nil,
 	}
 }
 
-// Authenticate a user not implemented for Salesforce
+// Authenticate vends an access token using the oauth2 jwt bearer or client credentials grant, if
+// the system is configured to use either and the previously-vended token is missing or expired;
+// the instance url returned with the token is used for subsequent requests
 func (s *SalesforceService) Authenticate() error {
+	if s.auth.Method == nil {
+		return nil
+	}
+
+	if s.Token != nil && (s.tokenExpiresAt == nil || time.Now().Before(*s.tokenExpiresAt)) {
+		return nil
+	}
+
+	tokenURL, err := url.Parse(s.tokenURL())
+	if err != nil {
+		return fmt.Errorf("failed to parse oauth2 token url: %s; %s", s.tokenURL(), err.Error())
+	}
+
+	var params map[string]interface{}
+	switch *s.auth.Method {
+	case salesforceAuthMethodOAuth2ClientCredentials:
+		params = map[string]interface{}{
+			"grant_type":    salesforceGrantTypeClientCredentials,
+			"client_id":     *s.auth.ClientID,
+			"client_secret": *s.auth.ClientSecret,
+		}
+	case salesforceAuthMethodOAuth2JWTBearer:
+		assertion, err := s.assertion(fmt.Sprintf("%s://%s", tokenURL.Scheme, tokenURL.Host))
+		if err != nil {
+			return fmt.Errorf("failed to sign oauth2 jwt bearer assertion; %s", err.Error())
+		}
+
+		params = map[string]interface{}{
+			"grant_type": salesforceGrantTypeJWTBearer,
+			"assertion":  assertion,
+		}
+	}
+
+	client := &api.Client{
+		Host:   tokenURL.Host,
+		Scheme: tokenURL.Scheme,
+	}
+
+	status, resp, err := client.PostWWWFormURLEncoded(strings.TrimPrefix(tokenURL.RequestURI(), "/"), params)
+	if err != nil {
+		return fmt.Errorf("failed to authorize oauth2 access token; status: %v; %s", status, err.Error())
+	}
+
+	body, _ := resp.(map[string]interface{})
+	if status != 200 {
+		if description, ok := body["error_description"].(string); ok {
+			return fmt.Errorf("failed to authorize oauth2 access token; status: %v; %s", status, description)
+		}
+		return fmt.Errorf("failed to authorize oauth2 access token; status: %v", status)
+	}
+
+	accessToken, ok := body["access_token"].(string)
+	if !ok {
+		return fmt.Errorf("failed to authorize oauth2 access token; no access token in response")
+	}
+
+	if instanceURL, ok := body["instance_url"].(string); ok {
+		instance, err := url.Parse(instanceURL)
+		if err != nil || instance.Host == "" {
+			return fmt.Errorf("failed to authorize oauth2 access token; invalid instance url: %s", instanceURL)
+		}
+
+		s.Host = instance.Host
+		s.Scheme = instance.Scheme
+	}
+
+	s.Token = &accessToken
+	s.tokenExpiresAt = nil
+	if expiresIn, ok := body["expires_in"].(float64); ok && expiresIn > 0 {
+		expiresAt := time.Now().Add(time.Duration(expiresIn) * time.Second).Add(-salesforceTokenExpiryLeeway)
+		s.tokenExpiresAt = &expiresAt
+	}
+
 	return nil
 }
 
-// ConfigureTenant configures a new proxy instance in Salesforce for a given organization
+// ConfigureTenant verifies the sObjects to which record types are routed can be created and
+// updated by the configured user
 func (s *SalesforceService) ConfigureTenant(params map[string]interface{}) error {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	return fmt.Errorf("not implemented")
+	for _, recordType := range s.recordTypes() {
+		sobject := s.sobjects[recordType]
+		resp, err := s.describe(sobject)
+		if err != nil {
+			return fmt.Errorf("failed to configure tenant; %s", err.Error())
+		}
+
+		if resp["createable"] != true || resp["updateable"] != true {
+			return fmt.Errorf("failed to configure tenant; sObject routed for record type %s is not createable and updateable: %s", recordType, sobject)
+		}
+	}
+
+	return nil
 }
 
-// ListSchemas retrieves a list of available schemas
+// ListSchemas retrieves a list of available schemas from the global sObject describe; when record
+// types are routed to sObjects, only the routed sObjects are listed
 func (s *SalesforceService) ListSchemas(params map[string]interface{}) (interface{}, error) {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	return nil, fmt.Errorf("not implemented")
+	status, resp, err := s.request("GET", "sobjects", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch business object model; status: %v; %s", status, err.Error())
+	}
+
+	if status != 200 {
+		return nil, fmt.Errorf("failed to fetch business object model; status: %v%s", status, salesforceErrorMessage(resp))
+	}
+
+	body, ok := resp.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("failed to fetch business object model; unexpected response")
+	}
+
+	items, _ := body["sobjects"].([]interface{})
+
+	labels := map[string]interface{}{}
+	schemas := make([]interface{}, 0)
+	q, _ := params["q"].(string)
+	for _, item := range items {
+		sobject, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		name := fmt.Sprintf("%v", sobject["name"])
+		labels[name] = sobject["label"]
+
+		if len(s.sobjects) == 0 {
+			if q != "" && !salesforceMatches(q, name, fmt.Sprintf("%v", sobject["label"])) {
+				continue
+			}
+
+			schemas = append(schemas, map[string]interface{}{
+				"description": sobject["label"],
+				"name":        name,
+				"type":        name,
+			})
+		}
+	}
+
+	for _, recordType := range s.recordTypes() {
+		sobject := s.sobjects[recordType]
+		if _, ok := labels[sobject]; !ok {
+			common.Log.Warningf("Salesforce sObject routed for record type %s not found: %s", recordType, sobject)
+			continue
+		}
+
+		schemas = append(schemas, map[string]interface{}{
+			"description": labels[sobject],
+			"name":        sobject,
+			"type":        recordType,
+		})
+	}
+
+	return schemas, nil
 }
 
-// GetSchema retrieves a business object model by type
+// GetSchema retrieves a business object model by type from the describe of the sObject to which
+// records of the given type are routed
 func (s *SalesforceService) GetSchema(recordType string, params map[string]interface{}) (interface{}, error) {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	return nil, fmt.Errorf("not implemented")
+	sobject, err := s.sobject(recordType)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch business object model; %s", err.Error())
+	}
+
+	resp, err := s.describe(sobject)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch business object model; %s", err.Error())
+	}
+
+	items, _ := resp["fields"].([]interface{})
+	fields := make([]interface{}, 0, len(items))
+	for _, item := range items {
+		field, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		fields = append(fields, map[string]interface{}{
+			"name":        field["name"],
+			"description": field["label"],
+			"type":        field["type"],
+			"required":    field["createable"] == true && field["nillable"] == false && field["defaultedOnCreate"] == false,
+		})
+	}
+
+	return map[string]interface{}{
+		"description": resp["label"],
+		"fields":      fields,
+		"name":        sobject,
+		"type":        recordType,
+	}, nil
 }
 
 // CreateObject is a generic way to create a business object in the Salesforce environment
@@ -103,18 +379,19 @@ func (s *SalesforceService) CreateObject(params map[string]interface{}) (interfa
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	err := s.Authenticate()
+	recordType, _params := sorRecordParams(params)
+	sobject, err := s.sobject(recordType)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("failed to create business object; %s", err.Error())
 	}
 
-	status, resp, err := s.Post("objects", params)
+	status, resp, err := s.request("POST", fmt.Sprintf("sobjects/%s", url.PathEscape(sobject)), _params)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create business object; status: %v; %s", status, err.Error())
 	}
 
 	if status != 201 {
-		return nil, fmt.Errorf("failed to create business object; status: %v", status)
+		return nil, fmt.Errorf("failed to create business object; status: %v%s", status, salesforceErrorMessage(resp))
 	}
 
 	return resp, nil
@@ -125,14 +402,20 @@ func (s *SalesforceService) UpdateObject(id string, params map[string]interface{
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	uri := fmt.Sprintf("objects/%s", id)
-	status, _, err := s.Patch(uri, params)
+	recordType, _params := sorRecordParams(params)
+	sobject, err := s.sobject(recordType)
+	if err != nil {
+		return fmt.Errorf("failed to update business object; %s", err.Error())
+	}
+
+	uri := fmt.Sprintf("sobjects/%s/%s", url.PathEscape(sobject), url.PathEscape(id))
+	status, resp, err := s.request("PATCH", uri, _params)
 	if err != nil {
 		return fmt.Errorf("failed to update business object; status: %v; %s", status, err.Error())
 	}
 
-	if status != 200 {
-		return fmt.Errorf("failed to update business object; status: %v", status)
+	if status != 200 && status != 204 {
+		return fmt.Errorf("failed to update business object; status: %v%s", status, salesforceErrorMessage(resp))
 	}
 
 	return nil
@@ -154,18 +437,167 @@ func (s *SalesforceService) DeleteTenant(organizationID string) error {
 	return fmt.Errorf("not implemented")
 }
 
-// HealthCheck checks the health of the Salesforce instance
+// HealthCheck checks the health of the Salesforce instance, including that an access token
+// can be vended and is authorized to read the org limits
 func (s *SalesforceService) HealthCheck() error {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	return fmt.Errorf("not implemented")
+	status, resp, err := s.request("GET", "limits", nil)
+	if err != nil {
+		return fmt.Errorf("health check failed; status: %v; %s", status, err.Error())
+	}
+
+	if status != 200 {
+		return fmt.Errorf("health check failed; status: %v%s", status, salesforceErrorMessage(resp))
+	}
+
+	return nil
 }
 
 // TenantHealthCheck
 func (s *SalesforceService) TenantHealthCheck(organizationID string) error {
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
+	return nil
+}
 
-	return fmt.Errorf("not implemented")
+// assertion returns a signed jwt bearer assertion for the configured client and user
+func (s *SalesforceService) assertion(audience string) (string, error) {
+	privateKey, err := jwt.ParseRSAPrivateKeyFromPEM([]byte(*s.auth.PrivateKey))
+	if err != nil {
+		return "", err
+	}
+
+	return jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"aud": audience,
+		"exp": time.Now().Add(salesforceJWTAssertionTTL).Unix(),
+		"iss": *s.auth.ClientID,
+		"sub": *s.auth.Username,
+	}).SignedString(privateKey)
+}
+
+// describe returns the describe of the given sObject
+func (s *SalesforceService) describe(sobject string) (map[string]interface{}, error) {
+	status, resp, err := s.request("GET", fmt.Sprintf("sobjects/%s/describe", url.PathEscape(sobject)), nil)
+	if err != nil {
+		return nil, fmt.Errorf("status: %v; %s", status, err.Error())
+	}
+
+	if status != 200 {
+		return nil, fmt.Errorf("status: %v%s", status, salesforceErrorMessage(resp))
+	}
+
+	describe, ok := resp.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid describe response for sObject: %s", sobject)
+	}
+
+	return describe, nil
+}
+
+// recordTypes returns the sorted record types routed to sObjects
+func (s *SalesforceService) recordTypes() []string {
+	recordTypes := make([]string, 0, len(s.sobjects))
+	for recordType := range s.sobjects {
+		recordTypes = append(recordTypes, recordType)
+	}
+	sort.Strings(recordTypes)
+	return recordTypes
+}
+
+// request makes the given request using a vended access token; an access token rejected by the
+// instance is refreshed and the request retried once
+func (s *SalesforceService) request(method, uri string, params map[string]interface{}) (int, interface{}, error) {
+	err := s.Authenticate()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	status, resp, err := s.send(method, uri, params)
+	if status == 401 && s.auth.Method != nil {
+		s.Token = nil
+		err = s.Authenticate()
+		if err != nil {
+			return 0, nil, err
+		}
+		status, resp, err = s.send(method, uri, params)
+	}
+
+	return status, resp, err
+}
+
+func (s *SalesforceService) send(method, uri string, params map[string]interface{}) (int, interface{}, error) {
+	switch method {
+	case "GET":
+		return s.Get(uri, params)
+	case "PATCH":
+		return s.Patch(uri, params)
+	case "POST":
+		return s.Post(uri, params)
+	default:
+		return 0, nil, fmt.Errorf("unsupported method: %s", method)
+	}
+}
+
+// sobject returns the sObject to which records of the given type are routed; when sObjects are
+// mapped, records of unmapped types are rejected
+func (s *SalesforceService) sobject(recordType string) (string, error) {
+	if recordType == "" {
+		return "", fmt.Errorf("record type required")
+	}
+
+	if sobject, ok := s.sobjects[recordType]; ok {
+		return sobject, nil
+	}
+
+	if len(s.sobjects) > 0 {
+		return "", fmt.Errorf("record type is not routed to an sObject: %s", recordType)
+	}
+
+	return recordType, nil
+}
+
+func (s *SalesforceService) tokenURL() string {
+	if s.auth.TokenURL != nil {
+		return *s.auth.TokenURL
+	}
+
+	return defaultSalesforceTokenURL
+}
+
+// salesforceDataPath returns the path of the REST API for the given version
+func salesforceDataPath(apiVersion string) string {
+	return fmt.Sprintf("services/data/%s", apiVersion)
+}
+
+// salesforceErrorMessage returns the messages of the errors in the given response, if any, for
+// inclusion in an error
+func salesforceErrorMessage(resp interface{}) string {
+	items, ok := resp.([]interface{})
+	if !ok {
+		return ""
+	}
+
+	messages := make([]string, 0, len(items))
+	for _, item := range items {
+		if _item, ok := item.(map[string]interface{}); ok {
+			messages = append(messages, fmt.Sprintf("%v: %v", _item["errorCode"], _item["message"]))
+		}
+	}
+
+	if len(messages) == 0 {
+		return ""
+	}
+
+	return fmt.Sprintf("; %s", strings.Join(messages, "; "))
+}
+
+func salesforceMatches(q string, values ...string) bool {
+	q = strings.ToLower(q)
+	for _, val := range values {
+		if strings.Contains(strings.ToLower(val), q) {
+			return true
+		}
+	}
+
+	return false
 }
@@ -0,0 +1,402 @@
+// +build unit
+
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package middleware
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/provideplatform/provide-go/common"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+const mockSalesforceClientID = "3MVG9baseline"
+const mockSalesforceClientSecret = "s3cr3t"
+const mockSalesforceUsername = "integration@baseline.example"
+
+// mockSalesforceRequest is a request received by the mock Salesforce REST API
+type mockSalesforceRequest struct {
+	method string
+	path   string
+	body   map[string]interface{}
+}
+
+// mockSalesforce is a minimal Salesforce token endpoint and REST API serving the Account and
+// Purchase_Order__c sObjects
+type mockSalesforce struct {
+	*httptest.Server
+	mutex      sync.Mutex
+	publicKey  *rsa.PublicKey
+	requests   []*mockSalesforceRequest
+	tokens     []string
+	tokensSent int
+}
+
+var mockSalesforceSObjects = []interface{}{
+	map[string]interface{}{"name": "Account", "label": "Account", "createable": true, "updateable": true},
+	map[string]interface{}{"name": "Purchase_Order__c", "label": "Purchase Order", "createable": true, "updateable": true},
+	map[string]interface{}{"name": "UserLicense", "label": "User License", "createable": false, "updateable": false},
+}
+
+var mockSalesforcePurchaseOrderFields = []interface{}{
+	map[string]interface{}{"name": "Id", "label": "Record ID", "type": "id", "createable": false, "nillable": false, "defaultedOnCreate": true},
+	map[string]interface{}{"name": "Name", "label": "Purchase Order Name", "type": "string", "createable": true, "nillable": true, "defaultedOnCreate": true},
+	map[string]interface{}{"name": "Amount__c", "label": "Amount", "type": "currency", "createable": true, "nillable": false, "defaultedOnCreate": false},
+}
+
+func newMockSalesforce(publicKey *rsa.PublicKey) *mockSalesforce {
+	mock := &mockSalesforce{publicKey: publicKey}
+	mock.Server = httptest.NewServer(http.HandlerFunc(mock.serve))
+	return mock
+}
+
+func (m *mockSalesforce) serve(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.URL.Path == "/services/oauth2/token" {
+		m.token(w, r)
+		return
+	}
+
+	m.mutex.Lock()
+	authorized := false
+	for _, token := range m.tokens {
+		authorized = authorized || r.Header.Get("Authorization") == fmt.Sprintf("bearer %s", token)
+	}
+	m.mutex.Unlock()
+
+	if !authorized {
+		w.WriteHeader(401)
+		json.NewEncoder(w).Encode([]interface{}{
+			map[string]interface{}{"errorCode": "INVALID_SESSION_ID", "message": "Session expired or invalid"},
+		})
+		return
+	}
+
+	req := &mockSalesforceRequest{
+		method: r.Method,
+		path:   r.URL.Path,
+	}
+	if raw, _ := ioutil.ReadAll(r.Body); len(raw) > 0 {
+		json.Unmarshal(raw, &req.body)
+	}
+
+	m.mutex.Lock()
+	m.requests = append(m.requests, req)
+	m.mutex.Unlock()
+
+	path := strings.TrimPrefix(r.URL.Path, "/services/data/v56.0/")
+	switch {
+	case r.Method == http.MethodGet && path == "limits":
+		json.NewEncoder(w).Encode(map[string]interface{}{"DailyApiRequests": map[string]interface{}{"Max": 15000, "Remaining": 14998}})
+	case r.Method == http.MethodGet && path == "sobjects":
+		json.NewEncoder(w).Encode(map[string]interface{}{"sobjects": mockSalesforceSObjects})
+	case r.Method == http.MethodGet && path == "sobjects/Purchase_Order__c/describe":
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"name":       "Purchase_Order__c",
+			"label":      "Purchase Order",
+			"createable": true,
+			"updateable": true,
+			"fields":     mockSalesforcePurchaseOrderFields,
+		})
+	case r.Method == http.MethodGet && path == "sobjects/UserLicense/describe":
+		json.NewEncoder(w).Encode(map[string]interface{}{"name": "UserLicense", "label": "User License", "createable": false, "updateable": false})
+	case r.Method == http.MethodPost && (path == "sobjects/Account" || path == "sobjects/Purchase_Order__c"):
+		w.WriteHeader(201)
+		json.NewEncoder(w).Encode(map[string]interface{}{"id": "a015g00000XyZ12AAB", "success": true, "errors": []interface{}{}})
+	case r.Method == http.MethodPatch && strings.HasPrefix(path, "sobjects/Purchase_Order__c/"):
+		w.WriteHeader(204)
+	default:
+		w.WriteHeader(404)
+		json.NewEncoder(w).Encode([]interface{}{
+			map[string]interface{}{"errorCode": "NOT_FOUND", "message": "The requested resource does not exist"},
+		})
+	}
+}
+
+// token vends an access token for a valid jwt bearer assertion or client credentials grant
+func (m *mockSalesforce) token(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+
+	authorized := false
+	switch r.PostForm.Get("grant_type") {
+	case salesforceGrantTypeClientCredentials:
+		authorized = r.PostForm.Get("client_id") == mockSalesforceClientID && r.PostForm.Get("client_secret") == mockSalesforceClientSecret
+	case salesforceGrantTypeJWTBearer:
+		token, err := jwt.Parse(r.PostForm.Get("assertion"), func(token *jwt.Token) (interface{}, error) {
+			if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
+				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+			}
+			return m.publicKey, nil
+		})
+		if err == nil {
+			claims := token.Claims.(jwt.MapClaims)
+			authorized = claims["iss"] == mockSalesforceClientID && claims["sub"] == mockSalesforceUsername && claims["aud"] == m.URL
+		}
+	}
+
+	if !authorized {
+		w.WriteHeader(400)
+		json.NewEncoder(w).Encode(map[string]interface{}{"error": "invalid_grant", "error_description": "authentication failure"})
+		return
+	}
+
+	m.mutex.Lock()
+	m.tokensSent++
+	token := fmt.Sprintf("00D5g000004Token%d", m.tokensSent)
+	m.tokens = append(m.tokens, token)
+	m.mutex.Unlock()
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token": token,
+		"instance_url": m.URL,
+		"token_type":   "Bearer",
+	})
+}
+
+// revokeTokens invalidates all previously-vended access tokens
+func (m *mockSalesforce) revokeTokens() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.tokens = nil
+}
+
+func (m *mockSalesforce) lastRequest() *mockSalesforceRequest {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.requests[len(m.requests)-1]
+}
+
+var _ = Describe("Salesforce", func() {
+	var privateKey *rsa.PrivateKey
+	var privateKeyPEM string
+	var mock *mockSalesforce
+	var sor SOR
+
+	systemFactory := func(auth *SystemAuthentication, config map[string]interface{}) SOR {
+		auth.TokenURL = common.StringOrNil(fmt.Sprintf("%s/services/oauth2/token", mock.URL))
+		return SystemFactory(&System{
+			Name: common.StringOrNil(sorIdentifierSalesforce),
+			// the instance url returned with the access token is used for requests
+			EndpointURL: common.StringOrNil("https://login.salesforce.example"),
+			Auth:        auth,
+			Config:      config,
+		})
+	}
+
+	jwtBearerAuth := func() *SystemAuthentication {
+		return &SystemAuthentication{
+			Method:     common.StringOrNil(salesforceAuthMethodOAuth2JWTBearer),
+			ClientID:   common.StringOrNil(mockSalesforceClientID),
+			Username:   common.StringOrNil(mockSalesforceUsername),
+			PrivateKey: common.StringOrNil(privateKeyPEM),
+		}
+	}
+
+	BeforeEach(func() {
+		var err error
+		privateKey, err = rsa.GenerateKey(rand.Reader, 2048)
+		Expect(err).NotTo(HaveOccurred())
+		privateKeyPEM = string(pem.EncodeToMemory(&pem.Block{
+			Type:  "RSA PRIVATE KEY",
+			Bytes: x509.MarshalPKCS1PrivateKey(privateKey),
+		}))
+
+		mock = newMockSalesforce(&privateKey.PublicKey)
+		sor = systemFactory(jwtBearerAuth(), map[string]interface{}{
+			"sobjects": map[string]interface{}{
+				"purchase_order": "Purchase_Order__c",
+			},
+		})
+	})
+
+	AfterEach(func() {
+		mock.Close()
+	})
+
+	Describe("SalesforceFactory", func() {
+		It("should require a private key for the jwt bearer auth method", func() {
+			auth := jwtBearerAuth()
+			auth.PrivateKey = common.StringOrNil("invalid")
+			Expect(systemFactory(auth, nil)).To(BeNil())
+		})
+
+		It("should not support other auth methods", func() {
+			Expect(systemFactory(&SystemAuthentication{Method: common.StringOrNil("password")}, nil)).To(BeNil())
+		})
+	})
+
+	Describe("HealthCheck", func() {
+		It("should vend an access token using the jwt bearer flow", func() {
+			Expect(sor.HealthCheck()).To(Succeed())
+			Expect(mock.tokensSent).To(Equal(1))
+		})
+
+		It("should vend an access token using the client credentials flow", func() {
+			sor = systemFactory(&SystemAuthentication{
+				Method:       common.StringOrNil(salesforceAuthMethodOAuth2ClientCredentials),
+				ClientID:     common.StringOrNil(mockSalesforceClientID),
+				ClientSecret: common.StringOrNil(mockSalesforceClientSecret),
+			}, nil)
+			Expect(sor.HealthCheck()).To(Succeed())
+			Expect(mock.tokensSent).To(Equal(1))
+		})
+
+		It("should fail when the client is not authorized", func() {
+			sor = systemFactory(&SystemAuthentication{
+				Method:       common.StringOrNil(salesforceAuthMethodOAuth2ClientCredentials),
+				ClientID:     common.StringOrNil(mockSalesforceClientID),
+				ClientSecret: common.StringOrNil("invalid"),
+			}, nil)
+			Expect(sor.HealthCheck()).NotTo(Succeed())
+		})
+
+		It("should refresh an access token rejected by the instance", func() {
+			Expect(sor.HealthCheck()).To(Succeed())
+			mock.revokeTokens()
+			Expect(sor.HealthCheck()).To(Succeed())
+			Expect(mock.tokensSent).To(Equal(2))
+		})
+	})
+
+	Describe("ConfigureTenant", func() {
+		It("should verify the routed sObjects are createable and updateable", func() {
+			Expect(sor.ConfigureTenant(map[string]interface{}{})).To(Succeed())
+		})
+
+		It("should fail when a routed sObject is not createable", func() {
+			sor = systemFactory(jwtBearerAuth(), map[string]interface{}{
+				"sobjects": map[string]interface{}{"license": "UserLicense"},
+			})
+			Expect(sor.ConfigureTenant(map[string]interface{}{})).NotTo(Succeed())
+		})
+	})
+
+	Describe("ListSchemas", func() {
+		It("should list the sObjects routed by record type", func() {
+			schemas, err := sor.ListSchemas(map[string]interface{}{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(schemas).To(Equal([]interface{}{
+				map[string]interface{}{
+					"description": "Purchase Order",
+					"name":        "Purchase_Order__c",
+					"type":        "purchase_order",
+				},
+			}))
+		})
+
+		It("should list the sObjects matching the query when no record types are routed", func() {
+			sor = systemFactory(jwtBearerAuth(), nil)
+			schemas, err := sor.ListSchemas(map[string]interface{}{"q": "license"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(schemas).To(Equal([]interface{}{
+				map[string]interface{}{
+					"description": "User License",
+					"name":        "UserLicense",
+					"type":        "UserLicense",
+				},
+			}))
+		})
+	})
+
+	Describe("GetSchema", func() {
+		It("should describe the sObject routed for the record type", func() {
+			schema, err := sor.GetSchema("purchase_order", map[string]interface{}{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(schema).To(Equal(map[string]interface{}{
+				"description": "Purchase Order",
+				"name":        "Purchase_Order__c",
+				"type":        "purchase_order",
+				"fields": []interface{}{
+					map[string]interface{}{"name": "Id", "description": "Record ID", "type": "id", "required": false},
+					map[string]interface{}{"name": "Name", "description": "Purchase Order Name", "type": "string", "required": false},
+					map[string]interface{}{"name": "Amount__c", "description": "Amount", "type": "currency", "required": true},
+				},
+			}))
+		})
+
+		It("should fail when the record type is not routed to an sObject", func() {
+			_, err := sor.GetSchema("invoice", map[string]interface{}{})
+			Expect(err).To(MatchError(ContainSubstring("not routed")))
+		})
+
+		It("should fail when the sObject does not exist", func() {
+			sor = systemFactory(jwtBearerAuth(), nil)
+			_, err := sor.GetSchema("Invoice__c", map[string]interface{}{})
+			Expect(err).To(MatchError(ContainSubstring("NOT_FOUND")))
+		})
+	})
+
+	Describe("CreateObject", func() {
+		It("should create the record in the sObject routed for the record type", func() {
+			resp, err := sor.CreateObject(map[string]interface{}{
+				"baseline_id": "c3f6ea1e-3c9a-4a1f-9a3b-52a0e2b0b1a4",
+				"payload":     map[string]interface{}{"Amount__c": 100},
+				"type":        common.StringOrNil("purchase_order"),
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.(map[string]interface{})["id"]).To(Equal("a015g00000XyZ12AAB"))
+			Expect(mock.lastRequest().path).To(Equal("/services/data/v56.0/sobjects/Purchase_Order__c"))
+			Expect(mock.lastRequest().body).To(Equal(map[string]interface{}{"Amount__c": float64(100)}))
+		})
+
+		It("should reject records of types not routed to an sObject", func() {
+			_, err := sor.CreateObject(map[string]interface{}{
+				"payload": map[string]interface{}{"Name": "Baseline"},
+				"type":    "Account",
+			})
+			Expect(err).To(MatchError(ContainSubstring("not routed")))
+		})
+
+		It("should create records in the sObject named by the record type when no sObjects are routed", func() {
+			sor = systemFactory(jwtBearerAuth(), nil)
+			_, err := sor.CreateObject(map[string]interface{}{
+				"payload": map[string]interface{}{"Name": "Baseline"},
+				"type":    "Account",
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(mock.lastRequest().path).To(Equal("/services/data/v56.0/sobjects/Account"))
+		})
+	})
+
+	Describe("UpdateObject", func() {
+		It("should update the record in the sObject routed for the record type", func() {
+			err := sor.UpdateObject("a015g00000XyZ12AAB", map[string]interface{}{
+				"payload": map[string]interface{}{"Amount__c": 150},
+				"type":    "purchase_order",
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(mock.lastRequest().method).To(Equal(http.MethodPatch))
+			Expect(mock.lastRequest().path).To(Equal("/services/data/v56.0/sobjects/Purchase_Order__c/a015g00000XyZ12AAB"))
+			Expect(mock.lastRequest().body).To(Equal(map[string]interface{}{"Amount__c": float64(150)}))
+		})
+	})
+})
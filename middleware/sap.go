@@ -39,34 +39,23 @@ type SAPService struct {
 
 // SAPFactory initializes a SAPService instance
 func SAPFactory(params *System) *SAPService {
-	var endpoint *url.URL
-	var err error
-
-	if params.EndpointURL != nil {
-		endpoint, err = url.Parse(*params.EndpointURL)
-		if err != nil {
-			common.Log.Warningf("failed to parse endpoint url: %s", *params.EndpointURL)
-			return nil
-		}
+	client, err := systemClient(params)
+	if err != nil {
+		common.Log.Warningf("failed to initialize SAP system; %s", err.Error())
+		return nil
 	}
 
-	if params.Auth == nil {
-		// HACK
-		params.Auth = &SystemAuthentication{}
+	var clientID, clientSecret *string
+	if params.Auth != nil {
+		clientID = params.Auth.ClientID
+		clientSecret = params.Auth.ClientSecret
 	}
 
 	return &SAPService{
-		api.Client{
-			Host:     endpoint.Host,
-			Path:     endpoint.Path,
-			Scheme:   endpoint.Scheme,
-			Token:    params.Auth.Token,
-			Username: provide.StringOrNil(*params.Auth.Username),
-			Password: provide.StringOrNil(*params.Auth.Password),
-		},
+		*client,
 		sync.Mutex{},
-		params.Auth.ClientID,
-		params.Auth.ClientSecret,
+		clientID,
+		clientSecret,
 	}
 }
 
@@ -345,8 +334,10 @@ func (s *SAPService) UpdateObject(id string, params map[string]interface{}) erro
 		return err
 	}
 
+	_, payload := sorRecordParams(params)
+
 	uri := s.requestURI(fmt.Sprintf("proubc/objects/%s", id))
-	status, _, err := s.Put(uri, params)
+	status, _, err := s.Put(uri, payload)
 	if err != nil {
 		return fmt.Errorf("failed to update business object; status: %v; %s", status, err.Error())
 	}
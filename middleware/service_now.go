@@ -17,40 +17,80 @@
 package middleware
 
 import (
+	"encoding/json"
 	"fmt"
-	"net"
+	"net/url"
 	"os"
+	"sort"
+	"strings"
 	"sync"
-	"time"
 
 	"github.com/provideplatform/provide-go/api"
 	"github.com/provideplatform/provide-go/common"
 )
 
-const defaultServiceNowHost = "base2demo.service-now.com"
 const defaultServiceNowPath = "api/now/table"
 const defaultServiceNowScheme = "https"
-const defaultServiceNowUsername = "admin"
-const defaultServiceNowPassword = "providenow"
-const defaultServiceNowReachabilityTimeout = time.Second * 5
+const defaultServiceNowTable = "incident"
 
-// ServiceNowService for the SAP API
+// serviceNowMaxTableHierarchyDepth bounds the walk up the table hierarchy when resolving the
+// fields a table inherits from the tables it extends, i.e., incident extends task
+const serviceNowMaxTableHierarchyDepth = 8
+
+const serviceNowTableDictionary = "sys_dictionary"
+const serviceNowTableObject = "sys_db_object"
+
+// ServiceNowService for the ServiceNow table API
 type ServiceNowService struct {
 	api.Client
-	mutex sync.Mutex
+	mutex        sync.Mutex
+	defaultTable string
+	tables       map[string]string
 }
 
-// ServiceNowFactory initializes a ServiceNow instance
+// ServiceNowFactory initializes a ServiceNow instance using the endpoint url and auth of the given
+// system; the tables config maps record types to tables, and records of any other type are
+// routed to the default_table config, or incident if not provided
 func ServiceNowFactory(params *System) *ServiceNowService {
-	common.Log.Warningf("ServiceNowFactory not implemented")
-	return nil
+	client, err := systemClient(params)
+	if err != nil {
+		common.Log.Warningf("failed to initialize ServiceNow system; %s", err.Error())
+		return nil
+	}
+
+	if client.Path == "" || client.Path == "/" {
+		client.Path = defaultServiceNowPath
+	}
+
+	defaultTable := defaultServiceNowTable
+	if table, ok := params.Config["default_table"].(string); ok && table != "" {
+		defaultTable = table
+	}
+
+	tables := map[string]string{}
+	if _tables, ok := params.Config["tables"].(map[string]interface{}); ok {
+		for recordType, table := range _tables {
+			if _table, ok := table.(string); ok {
+				tables[recordType] = _table
+			}
+		}
+	}
+
+	return &ServiceNowService{
+		*client,
+		sync.Mutex{},
+		defaultTable,
+		tables,
+	}
 }
 
-// InitServiceNowService convenience method to initialize a ServiceNow instance
+// InitServiceNowService convenience method to initialize a ServiceNow instance; the record type to
+// table routing is read from SERVICENOW_TABLES as a json object and SERVICENOW_DEFAULT_TABLE
 func InitServiceNowService(token *string) *ServiceNowService {
-	host := defaultServiceNowHost
-	if os.Getenv("SERVICENOW_API_HOST") != "" {
-		host = os.Getenv("SERVICENOW_API_HOST")
+	host := os.Getenv("SERVICENOW_API_HOST")
+	if host == "" {
+		common.Log.Warningf("SERVICENOW_API_HOST not provided")
+		return nil
 	}
 
 	path := defaultServiceNowPath
@@ -63,14 +103,18 @@ func InitServiceNowService(token *string) *ServiceNowService {
 		scheme = os.Getenv("SERVICENOW_API_SCHEME")
 	}
 
-	username := defaultServiceNowUsername
-	if os.Getenv("SERVICENOW_API_USERNAME") != "" {
-		username = os.Getenv("SERVICENOW_API_USERNAME")
+	defaultTable := defaultServiceNowTable
+	if os.Getenv("SERVICENOW_DEFAULT_TABLE") != "" {
+		defaultTable = os.Getenv("SERVICENOW_DEFAULT_TABLE")
 	}
 
-	password := defaultServiceNowPassword
-	if os.Getenv("SERVICENOW_API_PASSWORD") != "" {
-		password = os.Getenv("SERVICENOW_API_PASSWORD")
+	tables := map[string]string{}
+	if os.Getenv("SERVICENOW_TABLES") != "" {
+		err := json.Unmarshal([]byte(os.Getenv("SERVICENOW_TABLES")), &tables)
+		if err != nil {
+			common.Log.Warningf("failed to parse SERVICENOW_TABLES; %s", err.Error())
+			return nil
+		}
 	}
 
 	return &ServiceNowService{
@@ -79,14 +123,16 @@ func InitServiceNowService(token *string) *ServiceNowService {
 			Path:     path,
 			Scheme:   scheme,
 			Token:    token,
-			Username: common.StringOrNil(username),
-			Password: common.StringOrNil(password),
+			Username: common.StringOrNil(os.Getenv("SERVICENOW_API_USERNAME")),
+			Password: common.StringOrNil(os.Getenv("SERVICENOW_API_PASSWORD")),
 		},
 		sync.Mutex{},
+		defaultTable,
+		tables,
 	}
 }
 
-// Authenticate a user by email address and password, returning a newly-authorized X-CSRF-Token token
+// Authenticate is not required; requests are authorized using basic auth or the bearer token
 func (s *ServiceNowService) Authenticate() error {
 	return nil
 }
@@ -96,20 +142,153 @@ func (s *ServiceNowService) ConfigureTenant(params map[string]interface{}) error
 	return nil
 }
 
-// ListSchemas retrieves a list of available schemas
+// ListSchemas retrieves a list of available schemas from sys_db_object; when record types are
+// routed to tables, only the routed tables are listed
 func (s *ServiceNowService) ListSchemas(params map[string]interface{}) (interface{}, error) {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	return nil, fmt.Errorf("not implemented")
+	query := "ORDERBYname"
+	if len(s.tables) > 0 {
+		tables := make([]string, 0, len(s.tables))
+		for _, table := range s.tables {
+			tables = append(tables, table)
+		}
+		sort.Strings(tables)
+		query = fmt.Sprintf("nameIN%s^%s", strings.Join(tables, ","), query)
+	} else if q, ok := params["q"].(string); ok && q != "" {
+		query = fmt.Sprintf("nameLIKE%s^ORlabelLIKE%s^%s", q, q, query)
+	}
+
+	result, err := s.query(serviceNowTableObject, map[string]interface{}{
+		"sysparm_fields": "name,label",
+		"sysparm_query":  query,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch business object model; %s", err.Error())
+	}
+
+	labels := map[string]interface{}{}
+	schemas := make([]interface{}, 0)
+	for _, item := range result {
+		labels[fmt.Sprintf("%v", item["name"])] = item["label"]
+		if len(s.tables) == 0 {
+			schemas = append(schemas, map[string]interface{}{
+				"description": item["label"],
+				"name":        item["name"],
+				"type":        item["name"],
+			})
+		}
+	}
+
+	if len(s.tables) > 0 {
+		recordTypes := make([]string, 0, len(s.tables))
+		for recordType := range s.tables {
+			recordTypes = append(recordTypes, recordType)
+		}
+		sort.Strings(recordTypes)
+
+		for _, recordType := range recordTypes {
+			table := s.tables[recordType]
+			if _, ok := labels[table]; !ok {
+				common.Log.Warningf("ServiceNow table routed for record type %s not found: %s", recordType, table)
+				continue
+			}
+
+			schemas = append(schemas, map[string]interface{}{
+				"description": labels[table],
+				"name":        table,
+				"type":        recordType,
+			})
+		}
+	}
+
+	return schemas, nil
 }
 
-// GetSchema retrieves a business object model by type
+// GetSchema retrieves a business object model by type from sys_dictionary; the fields include
+// those inherited from the tables which the table for the record type extends
 func (s *ServiceNowService) GetSchema(recordType string, params map[string]interface{}) (interface{}, error) {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	return nil, fmt.Errorf("not implemented")
+	table := s.table(recordType)
+
+	var label interface{}
+	tables := make([]string, 0)
+	for name := table; name != "" && len(tables) < serviceNowMaxTableHierarchyDepth; {
+		result, err := s.query(serviceNowTableObject, map[string]interface{}{
+			"sysparm_fields": "name,label,super_class.name",
+			"sysparm_query":  fmt.Sprintf("name=%s", name),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch business object model; %s", err.Error())
+		}
+
+		if len(result) == 0 {
+			if len(tables) == 0 {
+				return nil, fmt.Errorf("failed to fetch business object model; table not found: %s", table)
+			}
+			break
+		}
+
+		if label == nil {
+			label = result[0]["label"]
+		}
+
+		tables = append(tables, name)
+		name, _ = result[0]["super_class.name"].(string)
+	}
+
+	result, err := s.query(serviceNowTableDictionary, map[string]interface{}{
+		"sysparm_fields": "name,element,column_label,internal_type,mandatory",
+		"sysparm_query":  fmt.Sprintf("nameIN%s^elementISNOTEMPTY^ORDERBYelement", strings.Join(tables, ",")),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch business object model; %s", err.Error())
+	}
+
+	// fields defined by the table override those it inherits
+	depth := map[string]int{}
+	for i, name := range tables {
+		depth[name] = i
+	}
+
+	fieldsByName := map[string]map[string]interface{}{}
+	fieldDepth := map[string]int{}
+	for _, item := range result {
+		element, _ := item["element"].(string)
+		tableName, _ := item["name"].(string)
+		if d, ok := fieldDepth[element]; ok && d <= depth[tableName] {
+			continue
+		}
+
+		fieldDepth[element] = depth[tableName]
+		fieldsByName[element] = map[string]interface{}{
+			"name":        element,
+			"description": item["column_label"],
+			"type":        item["internal_type"],
+			"required":    item["mandatory"] == "true",
+		}
+	}
+
+	names := make([]string, 0, len(fieldsByName))
+	for name := range fieldsByName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fields := make([]interface{}, 0, len(names))
+	for _, name := range names {
+		fields = append(fields, fieldsByName[name])
+	}
+
+	return map[string]interface{}{
+		"description": label,
+		"fields":      fields,
+		"name":        table,
+		"type":        recordType,
+	}, nil
 }
 
 // CreateObject is a generic way to create a business object in the ServiceNow environment
@@ -122,14 +301,12 @@ func (s *ServiceNowService) CreateObject(params map[string]interface{}) (interfa
 		return nil, err
 	}
 
-	_params := params
-	if payload, payloadOk := params["payload"].(map[string]interface{}); payloadOk {
-		if replicate, replicateOk := payload["replicate"].(map[string]interface{}); replicateOk {
-			_params = replicate
-		}
+	recordType, _params := sorRecordParams(params)
+	if replicate, replicateOk := _params["replicate"].(map[string]interface{}); replicateOk {
+		_params = replicate
 	}
 
-	status, resp, err := s.Post("incident", _params)
+	status, resp, err := s.Post(s.table(recordType), _params)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create business object; status: %v; %s", status, err.Error())
 	}
@@ -152,14 +329,12 @@ func (s *ServiceNowService) UpdateObject(id string, params map[string]interface{
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	_params := params
-	if payload, payloadOk := params["payload"].(map[string]interface{}); payloadOk {
-		if replicate, replicateOk := payload["replicate"].(map[string]interface{}); replicateOk {
-			_params = replicate
-		}
+	recordType, _params := sorRecordParams(params)
+	if replicate, replicateOk := _params["replicate"].(map[string]interface{}); replicateOk {
+		_params = replicate
 	}
 
-	uri := fmt.Sprintf("incident/%s", id)
+	uri := fmt.Sprintf("%s/%s", s.table(recordType), url.PathEscape(id))
 	status, _, err := s.Patch(uri, _params)
 	if err != nil {
 		return fmt.Errorf("failed to update business object; status: %v; %s", status, err.Error())
@@ -188,21 +363,64 @@ func (s *ServiceNowService) DeleteTenant(organizationID string) error {
 	return fmt.Errorf("not implemented")
 }
 
-// HealthCheck checks the health of the ServiceNow instance
+// HealthCheck checks the health of the ServiceNow instance, including that the configured
+// credentials are authorized to read the table hierarchy
 func (s *ServiceNowService) HealthCheck() error {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	conn, err := net.DialTimeout("tcp", s.Host, defaultServiceNowReachabilityTimeout)
-	if err == nil {
-		defer conn.Close()
-		return nil
+	_, err := s.query(serviceNowTableObject, map[string]interface{}{
+		"sysparm_fields": "name",
+		"sysparm_limit":  "1",
+	})
+	if err != nil {
+		return fmt.Errorf("ServiceNow health check failed; %s", err.Error())
 	}
 
-	return err
+	return nil
 }
 
 // TenantHealthCheck
 func (s *ServiceNowService) TenantHealthCheck(organizationID string) error {
 	return nil
 }
+
+// table returns the table to which records of the given type are routed
+func (s *ServiceNowService) table(recordType string) string {
+	if table, ok := s.tables[recordType]; ok {
+		return table
+	}
+
+	if recordType == sorTypeServiceNowIncident {
+		return defaultServiceNowTable
+	}
+
+	return s.defaultTable
+}
+
+// query the given table, returning the records in the result
+func (s *ServiceNowService) query(table string, params map[string]interface{}) ([]map[string]interface{}, error) {
+	params["sysparm_exclude_reference_link"] = "true"
+
+	status, resp, err := s.Get(table, params)
+	if err != nil {
+		return nil, fmt.Errorf("status: %v; %s", status, err.Error())
+	}
+
+	if status != 200 {
+		return nil, fmt.Errorf("status: %v", status)
+	}
+
+	records := make([]map[string]interface{}, 0)
+	if body, ok := resp.(map[string]interface{}); ok {
+		if result, ok := body["result"].([]interface{}); ok {
+			for _, item := range result {
+				if record, ok := item.(map[string]interface{}); ok {
+					records = append(records, record)
+				}
+			}
+		}
+	}
+
+	return records, nil
+}
@@ -0,0 +1,277 @@
+// +build unit
+
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package middleware
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/provideplatform/provide-go/common"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+const mockServiceNowUsername = "baseline"
+const mockServiceNowPassword = "s3cr3t"
+
+// mockServiceNowRequest is a request received by the mock ServiceNow table API
+type mockServiceNowRequest struct {
+	method string
+	path   string
+	body   map[string]interface{}
+}
+
+// mockServiceNow is a minimal ServiceNow table API serving the task, incident and
+// u_purchase_order tables, the latter two of which extend task
+type mockServiceNow struct {
+	*httptest.Server
+	mutex    sync.Mutex
+	requests []*mockServiceNowRequest
+}
+
+var mockServiceNowObjects = []map[string]interface{}{
+	{"name": "incident", "label": "Incident", "super_class.name": "task"},
+	{"name": "task", "label": "Task", "super_class.name": ""},
+	{"name": "u_purchase_order", "label": "Purchase Order", "super_class.name": "task"},
+}
+
+var mockServiceNowDictionary = []map[string]interface{}{
+	{"name": "task", "element": "number", "column_label": "Number", "internal_type": "string", "mandatory": "true"},
+	{"name": "task", "element": "short_description", "column_label": "Short description", "internal_type": "string", "mandatory": "false"},
+	{"name": "incident", "element": "severity", "column_label": "Severity", "internal_type": "integer", "mandatory": "false"},
+	{"name": "incident", "element": "short_description", "column_label": "Incident summary", "internal_type": "string", "mandatory": "true"},
+	{"name": "u_purchase_order", "element": "u_amount", "column_label": "Amount", "internal_type": "decimal", "mandatory": "true"},
+}
+
+func newMockServiceNow() *mockServiceNow {
+	mock := &mockServiceNow{}
+	mock.Server = httptest.NewServer(http.HandlerFunc(mock.serve))
+	return mock
+}
+
+func (m *mockServiceNow) serve(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	username, password, ok := r.BasicAuth()
+	if !ok || username != mockServiceNowUsername || password != mockServiceNowPassword {
+		w.WriteHeader(401)
+		return
+	}
+
+	req := &mockServiceNowRequest{
+		method: r.Method,
+		path:   r.URL.Path,
+	}
+	if raw, _ := ioutil.ReadAll(r.Body); len(raw) > 0 {
+		json.Unmarshal(raw, &req.body)
+	}
+
+	m.mutex.Lock()
+	m.requests = append(m.requests, req)
+	m.mutex.Unlock()
+
+	path := strings.TrimPrefix(r.URL.Path, "/api/now/table/")
+	query := r.URL.Query().Get("sysparm_query")
+
+	switch {
+	case r.Method == http.MethodGet && path == serviceNowTableObject:
+		json.NewEncoder(w).Encode(map[string]interface{}{"result": mockServiceNowFilter(mockServiceNowObjects, query)})
+	case r.Method == http.MethodGet && path == serviceNowTableDictionary:
+		json.NewEncoder(w).Encode(map[string]interface{}{"result": mockServiceNowFilter(mockServiceNowDictionary, query)})
+	case r.Method == http.MethodPost && !strings.Contains(path, "/"):
+		w.WriteHeader(201)
+		json.NewEncoder(w).Encode(map[string]interface{}{"result": map[string]interface{}{"sys_id": "6816f79cc0a8016401c5a33be04be441"}})
+	case r.Method == http.MethodPatch && strings.Contains(path, "/"):
+		json.NewEncoder(w).Encode(map[string]interface{}{"result": req.body})
+	default:
+		w.WriteHeader(404)
+	}
+}
+
+func (m *mockServiceNow) lastRequest() *mockServiceNowRequest {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.requests[len(m.requests)-1]
+}
+
+// mockServiceNowFilter applies the name=, nameIN and nameLIKE conditions of the given encoded query
+func mockServiceNowFilter(records []map[string]interface{}, query string) []interface{} {
+	results := make([]interface{}, 0)
+	for _, record := range records {
+		match := true
+		for _, condition := range strings.Split(query, "^") {
+			name := record["name"].(string)
+			switch {
+			case strings.HasPrefix(condition, "name="):
+				match = match && name == strings.TrimPrefix(condition, "name=")
+			case strings.HasPrefix(condition, "nameIN"):
+				in := false
+				for _, n := range strings.Split(strings.TrimPrefix(condition, "nameIN"), ",") {
+					in = in || n == name
+				}
+				match = match && in
+			case strings.HasPrefix(condition, "nameLIKE"):
+				match = match && strings.Contains(name, strings.TrimPrefix(condition, "nameLIKE"))
+			}
+		}
+		if match {
+			results = append(results, record)
+		}
+	}
+	return results
+}
+
+var _ = Describe("ServiceNow", func() {
+	var mock *mockServiceNow
+	var sor SOR
+
+	systemFactory := func(config map[string]interface{}, password string) SOR {
+		return SystemFactory(&System{
+			Name:        common.StringOrNil(sorIdentifierServiceNow),
+			EndpointURL: common.StringOrNil(mock.URL),
+			Auth: &SystemAuthentication{
+				Username: common.StringOrNil(mockServiceNowUsername),
+				Password: common.StringOrNil(password),
+			},
+			Config: config,
+		})
+	}
+
+	BeforeEach(func() {
+		mock = newMockServiceNow()
+		sor = systemFactory(map[string]interface{}{
+			"tables": map[string]interface{}{
+				"purchase_order": "u_purchase_order",
+			},
+		}, mockServiceNowPassword)
+	})
+
+	AfterEach(func() {
+		mock.Close()
+	})
+
+	Describe("InitServiceNowService", func() {
+		It("should not default to a demo instance", func() {
+			os.Unsetenv("SERVICENOW_API_HOST")
+			Expect(InitServiceNowService(nil)).To(BeNil())
+		})
+	})
+
+	Describe("HealthCheck", func() {
+		It("should succeed when the credentials are authorized", func() {
+			Expect(sor.HealthCheck()).To(Succeed())
+		})
+
+		It("should fail when the credentials are not authorized", func() {
+			sor = systemFactory(nil, "invalid")
+			Expect(sor.HealthCheck()).NotTo(Succeed())
+		})
+	})
+
+	Describe("ListSchemas", func() {
+		It("should list the tables routed by record type", func() {
+			schemas, err := sor.ListSchemas(map[string]interface{}{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(schemas).To(Equal([]interface{}{
+				map[string]interface{}{
+					"description": "Purchase Order",
+					"name":        "u_purchase_order",
+					"type":        "purchase_order",
+				},
+			}))
+		})
+
+		It("should list all tables when no record types are routed", func() {
+			sor = systemFactory(nil, mockServiceNowPassword)
+			schemas, err := sor.ListSchemas(map[string]interface{}{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(schemas).To(HaveLen(len(mockServiceNowObjects)))
+		})
+	})
+
+	Describe("GetSchema", func() {
+		It("should include the fields inherited from the tables it extends", func() {
+			schema, err := sor.GetSchema("purchase_order", map[string]interface{}{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(schema.(map[string]interface{})["name"]).To(Equal("u_purchase_order"))
+			Expect(schema.(map[string]interface{})["description"]).To(Equal("Purchase Order"))
+			Expect(schema.(map[string]interface{})["fields"]).To(Equal([]interface{}{
+				map[string]interface{}{"name": "number", "description": "Number", "type": "string", "required": true},
+				map[string]interface{}{"name": "short_description", "description": "Short description", "type": "string", "required": false},
+				map[string]interface{}{"name": "u_amount", "description": "Amount", "type": "decimal", "required": true},
+			}))
+		})
+
+		It("should prefer the fields defined by the table over those it inherits", func() {
+			schema, err := sor.GetSchema("servicenow_incident", map[string]interface{}{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(schema.(map[string]interface{})["fields"]).To(ContainElement(
+				map[string]interface{}{"name": "short_description", "description": "Incident summary", "type": "string", "required": true},
+			))
+		})
+
+		It("should fail when the table does not exist", func() {
+			sor = systemFactory(map[string]interface{}{"default_table": "u_missing"}, mockServiceNowPassword)
+			_, err := sor.GetSchema("invoice", map[string]interface{}{})
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("CreateObject", func() {
+		It("should create the record in the table routed for the record type", func() {
+			resp, err := sor.CreateObject(map[string]interface{}{
+				"baseline_id": "c3f6ea1e-3c9a-4a1f-9a3b-52a0e2b0b1a4",
+				"payload":     map[string]interface{}{"u_amount": 100},
+				"type":        common.StringOrNil("purchase_order"),
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.(map[string]interface{})["id"]).To(Equal("6816f79cc0a8016401c5a33be04be441"))
+			Expect(mock.lastRequest().path).To(Equal("/api/now/table/u_purchase_order"))
+			Expect(mock.lastRequest().body).To(Equal(map[string]interface{}{"u_amount": float64(100)}))
+		})
+
+		It("should create records of other types in the default table", func() {
+			_, err := sor.CreateObject(map[string]interface{}{
+				"payload": map[string]interface{}{"short_description": "baselined"},
+				"type":    "general_consistency",
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(mock.lastRequest().path).To(Equal("/api/now/table/incident"))
+		})
+	})
+
+	Describe("UpdateObject", func() {
+		It("should update the record in the table routed for the record type", func() {
+			err := sor.UpdateObject("6816f79cc0a8016401c5a33be04be441", map[string]interface{}{
+				"payload": map[string]interface{}{"u_amount": 150},
+				"type":    "purchase_order",
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(mock.lastRequest().method).To(Equal(http.MethodPatch))
+			Expect(mock.lastRequest().path).To(Equal("/api/now/table/u_purchase_order/6816f79cc0a8016401c5a33be04be441"))
+			Expect(mock.lastRequest().body).To(Equal(map[string]interface{}{"u_amount": float64(150)}))
+		})
+	})
+})
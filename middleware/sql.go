@@ -0,0 +1,796 @@
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package middleware
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	_ "github.com/lib/pq" // postgres
+	"github.com/provideplatform/provide-go/common"
+)
+
+const sqlDriverMySQL = "mysql"
+const sqlDriverPostgres = "postgres"
+const sqlDriverSQLite = "sqlite3"
+
+const sqlDefaultIDColumn = "id"
+const sqlDefaultStatusTable = "baseline_object_status"
+
+var (
+	// sqlDatabases are the connection pools shared by all SQL system instances, keyed by driver and dsn
+	sqlDatabases      = map[string]*sql.DB{}
+	sqlDatabasesMutex = &sync.Mutex{}
+
+	// sqlStatusTables are the status tables known to exist, keyed by driver, dsn and table
+	sqlStatusTables = map[string]bool{}
+)
+
+// SQLConfig configures a SQL system of record; it is read from the config of the system
+type SQLConfig struct {
+	// Driver is the database/sql driver; inferred from the endpoint url scheme when not provided.
+	// The postgres driver is registered by this package; any other driver must be registered by
+	// the importing binary
+	Driver string `json:"driver,omitempty"`
+
+	// Schema is the schema containing the record tables; defaults to the current schema
+	Schema string `json:"schema,omitempty"`
+
+	// Tables maps record types to tables; when no tables are mapped, the table is named by the
+	// record type, otherwise record types which are not mapped are rejected
+	Tables map[string]string `json:"tables,omitempty"`
+
+	// IDColumn is the primary key column of the record tables; defaults to id
+	IDColumn string `json:"id_column,omitempty"`
+
+	// StatusTable is the table to which baseline status is written; defaults to baseline_object_status
+	StatusTable string `json:"status_table,omitempty"`
+}
+
+// SQLService is a system of record backed by a database; each record type is stored as a row
+// in its own table and the baseline status of each record is written to a status table
+type SQLService struct {
+	mutex  sync.Mutex
+	config *SQLConfig
+	driver string
+	dsn    string
+}
+
+// sqlColumn is an introspected table column
+type sqlColumn struct {
+	name     string
+	dataType string
+	nullable bool
+}
+
+// sqlQueryer is implemented by both databases and transactions
+type sqlQueryer interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// SQLFactory initializes a SQLService instance; the endpoint url is the database url, i.e.,
+// postgres://host:5432/db?sslmode=require, mysql://host:3306/db or sqlite3:///path/to/db,
+// to which the username and password are added when provided; systems using a driver which
+// is not registered are rejected
+func SQLFactory(params *System) *SQLService {
+	if params.EndpointURL == nil {
+		common.Log.Warningf("sql system database url not provided")
+		return nil
+	}
+
+	config := &SQLConfig{}
+	if params.Config != nil {
+		raw, _ := json.Marshal(params.Config)
+		err := json.Unmarshal(raw, &config)
+		if err != nil {
+			common.Log.Warningf("failed to parse sql system config; %s", err.Error())
+			return nil
+		}
+	}
+
+	if config.IDColumn == "" {
+		config.IDColumn = sqlDefaultIDColumn
+	}
+
+	if config.StatusTable == "" {
+		config.StatusTable = sqlDefaultStatusTable
+	}
+
+	endpoint, err := url.Parse(*params.EndpointURL)
+	if err != nil {
+		common.Log.Warningf("failed to parse database url: %s", *params.EndpointURL)
+		return nil
+	}
+
+	driver := config.Driver
+	if driver == "" {
+		switch endpoint.Scheme {
+		case "postgres", "postgresql":
+			driver = sqlDriverPostgres
+		case "mysql":
+			driver = sqlDriverMySQL
+		case "sqlite", "sqlite3", "file":
+			driver = sqlDriverSQLite
+		default:
+			common.Log.Warningf("sql system driver not provided and not inferred from database url scheme: %s", endpoint.Scheme)
+			return nil
+		}
+	}
+
+	if !isSQLDriverRegistered(driver) {
+		common.Log.Warningf("sql system driver not registered: %s", driver)
+		return nil
+	}
+
+	if params.Auth != nil && params.Auth.Username != nil {
+		if params.Auth.Password != nil {
+			endpoint.User = url.UserPassword(*params.Auth.Username, *params.Auth.Password)
+		} else {
+			endpoint.User = url.User(*params.Auth.Username)
+		}
+	}
+
+	var dsn string
+	switch driver {
+	case sqlDriverPostgres:
+		dsn = endpoint.String()
+	case sqlDriverMySQL:
+		// the mysql driver does not accept urls; i.e., user:password@tcp(host:port)/db?params
+		var userinfo string
+		if endpoint.User != nil {
+			userinfo = fmt.Sprintf("%s@", endpoint.User.String())
+			if password, ok := endpoint.User.Password(); ok {
+				userinfo = fmt.Sprintf("%s:%s@", endpoint.User.Username(), password)
+			}
+		}
+		dsn = fmt.Sprintf("%stcp(%s)%s", userinfo, endpoint.Host, endpoint.Path)
+		if endpoint.RawQuery != "" {
+			dsn = fmt.Sprintf("%s?%s", dsn, endpoint.RawQuery)
+		}
+	case sqlDriverSQLite:
+		dsn = endpoint.Path
+		if endpoint.RawQuery != "" {
+			dsn = fmt.Sprintf("file:%s?%s", dsn, endpoint.RawQuery)
+		}
+	default:
+		dsn = *params.EndpointURL
+	}
+
+	return &SQLService{
+		mutex:  sync.Mutex{},
+		config: config,
+		driver: driver,
+		dsn:    dsn,
+	}
+}
+
+// db returns the shared connection pool for the database
+func (s *SQLService) db() (*sql.DB, error) {
+	sqlDatabasesMutex.Lock()
+	defer sqlDatabasesMutex.Unlock()
+
+	key := fmt.Sprintf("%s:%s", s.driver, s.dsn)
+	if db, ok := sqlDatabases[key]; ok {
+		return db, nil
+	}
+
+	db, err := sql.Open(s.driver, s.dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s database; %s", s.driver, err.Error())
+	}
+
+	sqlDatabases[key] = db
+	return db, nil
+}
+
+// Authenticate is not implemented for SQL; the database credentials are part of the dsn
+func (s *SQLService) Authenticate() error {
+	return nil
+}
+
+// ConfigureTenant creates the status table if it does not yet exist
+func (s *SQLService) ConfigureTenant(params map[string]interface{}) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	db, err := s.db()
+	if err != nil {
+		return err
+	}
+
+	return s.requireStatusTable(db)
+}
+
+// ListSchemas retrieves a list of available schemas; one schema is listed for each mapped
+// record type or, when no tables are mapped, for each table
+func (s *SQLService) ListSchemas(params map[string]interface{}) (interface{}, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	schemas := make([]interface{}, 0)
+
+	if len(s.config.Tables) > 0 {
+		recordTypes := make([]string, 0, len(s.config.Tables))
+		for recordType := range s.config.Tables {
+			recordTypes = append(recordTypes, recordType)
+		}
+		sort.Strings(recordTypes)
+
+		for _, recordType := range recordTypes {
+			schemas = append(schemas, map[string]interface{}{
+				"description": s.config.Tables[recordType],
+				"name":        recordType,
+				"type":        recordType,
+			})
+		}
+
+		return schemas, nil
+	}
+
+	db, err := s.db()
+	if err != nil {
+		return nil, err
+	}
+
+	var query string
+	var args []interface{}
+	if s.driver == sqlDriverSQLite {
+		query = "SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' ORDER BY name"
+	} else {
+		schemaClause, schemaArgs := s.schemaClause(1)
+		query = fmt.Sprintf("SELECT table_name FROM information_schema.tables WHERE table_type = 'BASE TABLE' AND %s ORDER BY table_name", schemaClause)
+		args = schemaArgs
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch business object model; %s", err.Error())
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var table string
+		err = rows.Scan(&table)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch business object model; %s", err.Error())
+		}
+
+		if table == s.config.StatusTable {
+			continue
+		}
+
+		schemas = append(schemas, map[string]interface{}{
+			"description": table,
+			"name":        table,
+			"type":        table,
+		})
+	}
+
+	return schemas, rows.Err()
+}
+
+// GetSchema retrieves a business object data model by type; the fields are the introspected
+// columns of the table for the record type
+func (s *SQLService) GetSchema(recordType string, params map[string]interface{}) (interface{}, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	db, err := s.db()
+	if err != nil {
+		return nil, err
+	}
+
+	table, err := s.table(recordType)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch business object model; %s", err.Error())
+	}
+
+	columns, err := s.columns(db, table)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch business object model; %s", err.Error())
+	}
+
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("failed to fetch business object model; no table for record type: %s", recordType)
+	}
+
+	fields := make([]interface{}, 0)
+	for _, column := range columns {
+		fields = append(fields, map[string]interface{}{
+			"name":        column.name,
+			"description": column.dataType,
+			"type":        column.dataType,
+			"required":    !column.nullable,
+		})
+	}
+
+	return map[string]interface{}{
+		"description": table,
+		"fields":      fields,
+		"name":        recordType,
+		"type":        recordType,
+	}, nil
+}
+
+// CreateObject inserts a row into the table for the record type; payload fields which are not
+// columns of the table are ignored. The row and its status are written in a single transaction
+func (s *SQLService) CreateObject(params map[string]interface{}) (interface{}, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	recordType, payload := sorRecordParams(params)
+	if recordType == "" {
+		return nil, fmt.Errorf("failed to create business object; record type required")
+	}
+
+	table, err := s.table(recordType)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create business object; %s", err.Error())
+	}
+
+	db, err := s.db()
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.requireStatusTable(db)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create business object; %s", err.Error())
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create business object; %s", err.Error())
+	}
+	defer tx.Rollback()
+
+	names, values, err := s.columnValues(tx, table, payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create business object; %s", err.Error())
+	}
+
+	placeholders := make([]string, len(names))
+	for i := range names {
+		placeholders[i] = s.placeholder(i + 1)
+	}
+
+	query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", s.qualifiedTable(table), strings.Join(names, ", "), strings.Join(placeholders, ", "))
+
+	var id interface{}
+	if val, ok := payload[s.config.IDColumn]; ok && val != nil {
+		id = val
+		_, err = tx.Exec(query, values...)
+	} else if s.driver == sqlDriverPostgres {
+		err = tx.QueryRow(fmt.Sprintf("%s RETURNING %s", query, s.quote(s.config.IDColumn)), values...).Scan(&id)
+	} else {
+		var result sql.Result
+		result, err = tx.Exec(query, values...)
+		if err == nil {
+			id, err = result.LastInsertId()
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create business object; %s", err.Error())
+	}
+
+	if raw, ok := id.([]byte); ok {
+		id = string(raw)
+	}
+
+	record := map[string]interface{}{}
+	for k, v := range payload {
+		record[k] = v
+	}
+	record["id"] = fmt.Sprintf("%v", id)
+
+	status := map[string]interface{}{
+		"record_type": recordType,
+	}
+	if baselineID, ok := params["baseline_id"].(string); ok {
+		status["baseline_id"] = baselineID
+	}
+
+	err = s.writeStatus(tx, record["id"].(string), status)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create business object; %s", err.Error())
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create business object; %s", err.Error())
+	}
+
+	return record, nil
+}
+
+// UpdateObject updates the row of the business object with the given id; the table is resolved
+// using the record type, if given, or the record type written to the status table on create
+func (s *SQLService) UpdateObject(id string, params map[string]interface{}) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	recordType, payload := sorRecordParams(params)
+
+	db, err := s.db()
+	if err != nil {
+		return err
+	}
+
+	if recordType == "" {
+		query := fmt.Sprintf("SELECT record_type FROM %s WHERE object_id = %s", s.qualifiedTable(s.config.StatusTable), s.placeholder(1))
+		var _recordType sql.NullString
+		err = db.QueryRow(query, id).Scan(&_recordType)
+		if err != nil || !_recordType.Valid {
+			return fmt.Errorf("failed to update business object; record type not resolved for record: %s", id)
+		}
+		recordType = _recordType.String
+	}
+
+	table, err := s.table(recordType)
+	if err != nil {
+		return fmt.Errorf("failed to update business object; %s", err.Error())
+	}
+
+	fields := map[string]interface{}{}
+	for k, v := range payload {
+		if k != s.config.IDColumn {
+			fields[k] = v
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to update business object; %s", err.Error())
+	}
+	defer tx.Rollback()
+
+	names, values, err := s.columnValues(tx, table, fields)
+	if err != nil {
+		return fmt.Errorf("failed to update business object; %s", err.Error())
+	}
+
+	assignments := make([]string, len(names))
+	for i, name := range names {
+		assignments[i] = fmt.Sprintf("%s = %s", name, s.placeholder(i+1))
+	}
+
+	query := fmt.Sprintf("UPDATE %s SET %s WHERE %s = %s",
+		s.qualifiedTable(table),
+		strings.Join(assignments, ", "),
+		s.quote(s.config.IDColumn),
+		s.placeholder(len(names)+1),
+	)
+
+	result, err := tx.Exec(query, append(values, id)...)
+	if err != nil {
+		return fmt.Errorf("failed to update business object; %s", err.Error())
+	}
+
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return fmt.Errorf("failed to update business object; record not found: %s", id)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("failed to update business object; %s", err.Error())
+	}
+
+	return nil
+}
+
+// UpdateObjectStatus writes the baseline status of the business object with the given id to the status table
+func (s *SQLService) UpdateObjectStatus(id string, params map[string]interface{}) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	db, err := s.db()
+	if err != nil {
+		return err
+	}
+
+	err = s.requireStatusTable(db)
+	if err != nil {
+		return fmt.Errorf("failed to update business object status; %s", err.Error())
+	}
+
+	status := map[string]interface{}{
+		"status":     params["status"],
+		"message_id": params["message_id"],
+	}
+	if recordType := sorRecordType(params); recordType != "" {
+		status["record_type"] = recordType
+	}
+	if baselineID, ok := params["baseline_id"].(string); ok {
+		status["baseline_id"] = baselineID
+	}
+	if params["errors"] != nil {
+		raw, _ := json.Marshal(params["errors"])
+		status["errors"] = string(raw)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to update business object status; %s", err.Error())
+	}
+	defer tx.Rollback()
+
+	err = s.writeStatus(tx, id, status)
+	if err != nil {
+		return fmt.Errorf("failed to update business object status; %s", err.Error())
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("failed to update business object status; %s", err.Error())
+	}
+
+	return nil
+}
+
+// DeleteTenant drops a proxy configuration for the given organization
+func (s *SQLService) DeleteTenant(organizationID string) error {
+	return fmt.Errorf("not implemented")
+}
+
+// HealthCheck checks that the database is reachable
+func (s *SQLService) HealthCheck() error {
+	db, err := s.db()
+	if err != nil {
+		return err
+	}
+
+	err = db.Ping()
+	if err != nil {
+		return fmt.Errorf("%s database not reachable; %s", s.driver, err.Error())
+	}
+
+	return nil
+}
+
+// TenantHealthCheck checks the health of the database; the database is not tenant-specific
+func (s *SQLService) TenantHealthCheck(organizationID string) error {
+	return s.HealthCheck()
+}
+
+// requireStatusTable creates the status table, once, if it does not yet exist
+func (s *SQLService) requireStatusTable(db *sql.DB) error {
+	key := fmt.Sprintf("%s:%s:%s", s.driver, s.dsn, s.config.StatusTable)
+
+	sqlDatabasesMutex.Lock()
+	defer sqlDatabasesMutex.Unlock()
+
+	if sqlStatusTables[key] {
+		return nil
+	}
+
+	_, err := db.Exec(fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		object_id varchar(255) NOT NULL PRIMARY KEY,
+		record_type varchar(255),
+		baseline_id varchar(255),
+		status varchar(64),
+		message_id varchar(255),
+		errors text,
+		updated_at timestamp
+	)`, s.qualifiedTable(s.config.StatusTable)))
+	if err != nil {
+		return fmt.Errorf("failed to create status table; %s", err.Error())
+	}
+
+	sqlStatusTables[key] = true
+	return nil
+}
+
+// writeStatus updates the given status columns of the status row for the given object, inserting
+// the row if it does not yet exist
+func (s *SQLService) writeStatus(tx *sql.Tx, id string, status map[string]interface{}) error {
+	status["updated_at"] = time.Now().UTC()
+
+	names := make([]string, 0, len(status))
+	for name := range status {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	assignments := make([]string, len(names))
+	values := make([]interface{}, len(names))
+	for i, name := range names {
+		assignments[i] = fmt.Sprintf("%s = %s", s.quote(name), s.placeholder(i+1))
+		values[i] = sqlValue(status[name])
+	}
+
+	table := s.qualifiedTable(s.config.StatusTable)
+	query := fmt.Sprintf("UPDATE %s SET %s WHERE object_id = %s", table, strings.Join(assignments, ", "), s.placeholder(len(names)+1))
+	result, err := tx.Exec(query, append(values, id)...)
+	if err != nil {
+		return err
+	}
+
+	if affected, err := result.RowsAffected(); err == nil && affected > 0 {
+		return nil
+	}
+
+	columns := []string{"object_id"}
+	placeholders := []string{s.placeholder(1)}
+	for i, name := range names {
+		columns = append(columns, s.quote(name))
+		placeholders = append(placeholders, s.placeholder(i+2))
+	}
+
+	query = fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", table, strings.Join(columns, ", "), strings.Join(placeholders, ", "))
+	_, err = tx.Exec(query, append([]interface{}{id}, values...)...)
+	return err
+}
+
+// columnValues returns the quoted column names and values of the given fields which are columns of the given table
+func (s *SQLService) columnValues(q sqlQueryer, table string, fields map[string]interface{}) ([]string, []interface{}, error) {
+	columns, err := s.columns(q, table)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(columns) == 0 {
+		return nil, nil, fmt.Errorf("table not found: %s", table)
+	}
+
+	known := map[string]bool{}
+	for _, column := range columns {
+		known[column.name] = true
+	}
+
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		if known[k] {
+			keys = append(keys, k)
+		} else {
+			common.Log.Debugf("ignoring field which is not a column of table %s: %s", table, k)
+		}
+	}
+	sort.Strings(keys)
+
+	if len(keys) == 0 {
+		return nil, nil, fmt.Errorf("no fields map to columns of table: %s", table)
+	}
+
+	names := make([]string, len(keys))
+	values := make([]interface{}, len(keys))
+	for i, k := range keys {
+		names[i] = s.quote(k)
+		values[i] = sqlValue(fields[k])
+	}
+
+	return names, values, nil
+}
+
+// columns introspects the columns of the given table
+func (s *SQLService) columns(q sqlQueryer, table string) ([]*sqlColumn, error) {
+	var rows *sql.Rows
+	var err error
+
+	if s.driver == sqlDriverSQLite {
+		rows, err = q.Query(`SELECT name, type, "notnull" = 0 FROM pragma_table_info(?)`, table)
+	} else {
+		schemaClause, schemaArgs := s.schemaClause(2)
+		query := fmt.Sprintf("SELECT column_name, data_type, is_nullable = 'YES' FROM information_schema.columns WHERE table_name = %s AND %s ORDER BY ordinal_position", s.placeholder(1), schemaClause)
+		rows, err = q.Query(query, append([]interface{}{table}, schemaArgs...)...)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns := make([]*sqlColumn, 0)
+	for rows.Next() {
+		column := &sqlColumn{}
+		err = rows.Scan(&column.name, &column.dataType, &column.nullable)
+		if err != nil {
+			return nil, err
+		}
+		columns = append(columns, column)
+	}
+
+	return columns, rows.Err()
+}
+
+// schemaClause returns the information_schema predicate selecting the configured or current schema
+func (s *SQLService) schemaClause(placeholder int) (string, []interface{}) {
+	if s.config.Schema != "" {
+		return fmt.Sprintf("table_schema = %s", s.placeholder(placeholder)), []interface{}{s.config.Schema}
+	}
+
+	switch s.driver {
+	case sqlDriverPostgres:
+		return "table_schema = current_schema()", nil
+	case sqlDriverMySQL:
+		return "table_schema = DATABASE()", nil
+	default:
+		return "table_schema NOT IN ('information_schema', 'pg_catalog')", nil
+	}
+}
+
+// table returns the table for the given record type; the status table is never a record table
+func (s *SQLService) table(recordType string) (string, error) {
+	table := recordType
+	if len(s.config.Tables) > 0 {
+		mapped, ok := s.config.Tables[recordType]
+		if !ok {
+			return "", fmt.Errorf("record type is not mapped to a table: %s", recordType)
+		}
+		table = mapped
+	}
+
+	if table == "" || strings.EqualFold(table, s.config.StatusTable) {
+		return "", fmt.Errorf("invalid table for record type: %s", recordType)
+	}
+
+	return table, nil
+}
+
+// qualifiedTable returns the quoted, schema-qualified name of the given table
+func (s *SQLService) qualifiedTable(table string) string {
+	if s.config.Schema != "" && s.driver != sqlDriverSQLite {
+		return fmt.Sprintf("%s.%s", s.quote(s.config.Schema), s.quote(table))
+	}
+	return s.quote(table)
+}
+
+// quote the given identifier for the database
+func (s *SQLService) quote(name string) string {
+	if s.driver == sqlDriverMySQL {
+		return fmt.Sprintf("`%s`", strings.ReplaceAll(name, "`", "``"))
+	}
+	return fmt.Sprintf(`"%s"`, strings.ReplaceAll(name, `"`, `""`))
+}
+
+// placeholder returns the ith bind parameter placeholder for the database
+func (s *SQLService) placeholder(i int) string {
+	if s.driver == sqlDriverPostgres {
+		return fmt.Sprintf("$%d", i)
+	}
+	return "?"
+}
+
+// sqlValue returns the given value in a form which can be bound to a query; composite values are bound as json
+func sqlValue(val interface{}) interface{} {
+	switch v := val.(type) {
+	case *string:
+		if v == nil {
+			return nil
+		}
+		return *v
+	case map[string]interface{}, []interface{}:
+		raw, _ := json.Marshal(v)
+		return string(raw)
+	default:
+		return v
+	}
+}
+
+// isSQLDriverRegistered returns true if the given database/sql driver is registered
+func isSQLDriverRegistered(driver string) bool {
+	for _, name := range sql.Drivers() {
+		if name == driver {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,2 @@
+ALTER TABLE ONLY workgroups_participants DROP COLUMN suspended_at;
+ALTER TABLE ONLY workgroups_participants DROP COLUMN status;
@@ -0,0 +1,2 @@
+ALTER TABLE ONLY workgroups_participants ADD COLUMN status varchar(32) NOT NULL DEFAULT 'active';
+ALTER TABLE ONLY workgroups_participants ADD COLUMN suspended_at timestamp with time zone;
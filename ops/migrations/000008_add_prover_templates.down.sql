@@ -0,0 +1 @@
+DROP TABLE prover_templates;
@@ -0,0 +1,26 @@
+CREATE TABLE public.prover_templates (
+    id uuid DEFAULT public.uuid_generate_v4() NOT NULL,
+    created_at timestamp with time zone NOT NULL,
+    organization_id uuid NOT NULL,
+    workgroup_id uuid,
+    name text NOT NULL,
+    version text NOT NULL,
+    description text,
+    identifier varchar(64) NOT NULL,
+    provider varchar(64) NOT NULL,
+    proving_scheme varchar(32) NOT NULL,
+    curve varchar(32) NOT NULL,
+    metadata json
+);
+
+ALTER TABLE public.prover_templates OWNER TO baseline;
+
+ALTER TABLE ONLY public.prover_templates
+    ADD CONSTRAINT prover_templates_pkey PRIMARY KEY (id);
+
+CREATE INDEX idx_prover_templates_organization_id_workgroup_id ON public.prover_templates USING btree (organization_id, workgroup_id);
+CREATE UNIQUE INDEX idx_prover_templates_organization_id_name_version ON public.prover_templates USING btree (organization_id, name, version) WHERE workgroup_id IS NULL;
+CREATE UNIQUE INDEX idx_prover_templates_workgroup_id_name_version ON public.prover_templates USING btree (workgroup_id, name, version) WHERE workgroup_id IS NOT NULL;
+
+ALTER TABLE ONLY public.prover_templates
+  ADD CONSTRAINT prover_templates_workgroup_id_foreign FOREIGN KEY (workgroup_id) REFERENCES public.workgroups(id) ON UPDATE CASCADE ON DELETE CASCADE;
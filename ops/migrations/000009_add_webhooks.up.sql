@@ -0,0 +1,47 @@
+CREATE TABLE public.webhooks (
+    id uuid DEFAULT public.uuid_generate_v4() NOT NULL,
+    created_at timestamp with time zone NOT NULL,
+    organization_id uuid NOT NULL,
+    workgroup_id uuid,
+    url text NOT NULL,
+    description text,
+    events json NOT NULL,
+    secret text NOT NULL,
+    active bool NOT NULL DEFAULT true
+);
+
+ALTER TABLE public.webhooks OWNER TO baseline;
+
+ALTER TABLE ONLY public.webhooks
+    ADD CONSTRAINT webhooks_pkey PRIMARY KEY (id);
+
+CREATE INDEX idx_webhooks_organization_id_workgroup_id ON public.webhooks USING btree (organization_id, workgroup_id);
+
+ALTER TABLE ONLY public.webhooks
+  ADD CONSTRAINT webhooks_workgroup_id_foreign FOREIGN KEY (workgroup_id) REFERENCES public.workgroups(id) ON UPDATE CASCADE ON DELETE CASCADE;
+
+CREATE TABLE public.webhook_deliveries (
+    id uuid DEFAULT public.uuid_generate_v4() NOT NULL,
+    created_at timestamp with time zone NOT NULL,
+    webhook_id uuid NOT NULL,
+    event varchar(64) NOT NULL,
+    payload json NOT NULL,
+    status varchar(32) NOT NULL DEFAULT 'pending',
+    attempts int NOT NULL DEFAULT 0,
+    response_status int,
+    error text,
+    last_attempt_at timestamp with time zone,
+    next_attempt_at timestamp with time zone,
+    delivered_at timestamp with time zone
+);
+
+ALTER TABLE public.webhook_deliveries OWNER TO baseline;
+
+ALTER TABLE ONLY public.webhook_deliveries
+    ADD CONSTRAINT webhook_deliveries_pkey PRIMARY KEY (id);
+
+CREATE INDEX idx_webhook_deliveries_webhook_id_created_at ON public.webhook_deliveries USING btree (webhook_id, created_at);
+CREATE INDEX idx_webhook_deliveries_status ON public.webhook_deliveries USING btree (status);
+
+ALTER TABLE ONLY public.webhook_deliveries
+  ADD CONSTRAINT webhook_deliveries_webhook_id_foreign FOREIGN KEY (webhook_id) REFERENCES public.webhooks(id) ON UPDATE CASCADE ON DELETE CASCADE;
@@ -0,0 +1,2 @@
+DROP TABLE stats_retention_policies;
+DROP TABLE stats_logs;
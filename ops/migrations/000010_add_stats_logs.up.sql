@@ -0,0 +1,32 @@
+CREATE TABLE public.stats_logs (
+    id uuid DEFAULT public.uuid_generate_v4() NOT NULL,
+    created_at timestamp with time zone NOT NULL,
+    organization_id uuid NOT NULL,
+    baseline_id text,
+    object_id text,
+    severity varchar(32) NOT NULL,
+    type varchar(64),
+    message text NOT NULL,
+    timestamp timestamp with time zone NOT NULL
+);
+
+ALTER TABLE public.stats_logs OWNER TO baseline;
+
+ALTER TABLE ONLY public.stats_logs
+    ADD CONSTRAINT stats_logs_pkey PRIMARY KEY (id);
+
+CREATE INDEX idx_stats_logs_organization_id_timestamp ON public.stats_logs USING btree (organization_id, timestamp);
+CREATE INDEX idx_stats_logs_organization_id_baseline_id ON public.stats_logs USING btree (organization_id, baseline_id);
+CREATE INDEX idx_stats_logs_organization_id_object_id ON public.stats_logs USING btree (organization_id, object_id);
+
+CREATE TABLE public.stats_retention_policies (
+    organization_id uuid NOT NULL,
+    created_at timestamp with time zone NOT NULL,
+    updated_at timestamp with time zone,
+    retention_days int NOT NULL
+);
+
+ALTER TABLE public.stats_retention_policies OWNER TO baseline;
+
+ALTER TABLE ONLY public.stats_retention_policies
+    ADD CONSTRAINT stats_retention_policies_pkey PRIMARY KEY (organization_id);
@@ -16,12 +16,265 @@
 
 package stats
 
-// LogMessage -- FIXME -- move to stats package
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	dbconf "github.com/kthomas/go-db-config"
+	uuid "github.com/kthomas/go.uuid"
+	"github.com/provideplatform/baseline/common"
+	provide "github.com/provideplatform/provide-go/api"
+)
+
+const logMessageSeverityDebug = "debug"
+const logMessageSeverityInfo = "info"
+const logMessageSeverityWarning = "warning"
+const logMessageSeverityError = "error"
+const logMessageSeverityCritical = "critical"
+
+var logMessageSeverities = []string{
+	logMessageSeverityDebug,
+	logMessageSeverityInfo,
+	logMessageSeverityWarning,
+	logMessageSeverityError,
+	logMessageSeverityCritical,
+}
+
+// maxRetentionDays is the upper bound of any stats log retention policy
+const maxRetentionDays = 3650
+
+// LogMessage is a stats log message reported by a system of record integration
 type LogMessage struct {
-	BaselineID *string `json:"baseline_id,omitempty"`
-	Message    *string `json:"message"`
-	ObjectID   *string `json:"object_id"`
-	Severity   *string `json:"severity"`
-	Timestamp  *string `json:"timestamp"`
-	Type       *string `json:"type,omitempty"`
+	provide.Model
+	OrganizationID *uuid.UUID `json:"organization_id"`
+	BaselineID     *string    `json:"baseline_id,omitempty"`
+	Message        *string    `json:"message"`
+	ObjectID       *string    `json:"object_id"`
+	Severity       *string    `json:"severity"`
+	Timestamp      *time.Time `json:"timestamp"`
+	Type           *string    `json:"type,omitempty"`
+}
+
+// logMessageTimestampLayouts are the layouts, in order of preference, used to leniently parse
+// log message timestamps reported as strings
+var logMessageTimestampLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999",
+	time.RFC1123Z,
+	time.RFC1123,
+	"2006-01-02",
+}
+
+// UnmarshalJSON unmarshals the log message, leniently parsing the timestamp which may be given as
+// an RFC3339 or similarly formatted string or as unix epoch seconds or milliseconds; timestamps
+// which cannot be parsed are ignored, in which case the log message defaults to the current time
+func (m *LogMessage) UnmarshalJSON(raw []byte) error {
+	type logMessage LogMessage
+	msg := struct {
+		*logMessage
+		Timestamp interface{} `json:"timestamp"`
+	}{
+		logMessage: (*logMessage)(m),
+	}
+
+	err := json.Unmarshal(raw, &msg)
+	if err != nil {
+		return err
+	}
+
+	m.Timestamp = nil
+	if msg.Timestamp != nil {
+		m.Timestamp = parseLogMessageTimestamp(msg.Timestamp)
+		if m.Timestamp == nil {
+			common.Log.Debugf("ignoring unparseable log message timestamp: %v", msg.Timestamp)
+		}
+	}
+
+	return nil
+}
+
+func parseLogMessageTimestamp(val interface{}) *time.Time {
+	var epoch float64
+
+	switch v := val.(type) {
+	case float64:
+		epoch = v
+	case string:
+		str := strings.TrimSpace(v)
+		for _, layout := range logMessageTimestampLayouts {
+			if t, err := time.Parse(layout, str); err == nil {
+				return &t
+			}
+		}
+
+		f, err := strconv.ParseFloat(str, 64)
+		if err != nil {
+			return nil
+		}
+		epoch = f
+	default:
+		return nil
+	}
+
+	if epoch <= 0 {
+		return nil
+	}
+
+	var t time.Time
+	if epoch >= 1e12 {
+		// treat implausibly large epochs as milliseconds
+		t = time.Unix(0, int64(epoch)*int64(time.Millisecond)).UTC()
+	} else {
+		sec := int64(epoch)
+		t = time.Unix(sec, int64((epoch-float64(sec))*1e9)).UTC()
+	}
+	return &t
+}
+
+// TableName returns the table in which log messages are persisted
+func (m *LogMessage) TableName() string {
+	return "stats_logs"
+}
+
+// RetentionPolicy is the number of days the log messages of an organization are retained
+type RetentionPolicy struct {
+	OrganizationID uuid.UUID  `gorm:"primary_key" json:"organization_id"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      *time.Time `json:"updated_at"`
+	RetentionDays  int        `json:"retention_days"`
+
+	Errors []*provide.Error `sql:"-" json:"errors,omitempty"`
+}
+
+// TableName returns the table in which retention policies are persisted
+func (p *RetentionPolicy) TableName() string {
+	return "stats_retention_policies"
+}
+
+// FindRetentionPolicyByOrganizationID returns the retention policy of the given organization, or
+// the default policy when the organization has not configured one
+func FindRetentionPolicyByOrganizationID(organizationID uuid.UUID) *RetentionPolicy {
+	db := dbconf.DatabaseConnection()
+	policy := &RetentionPolicy{}
+	db.Where("organization_id = ?", organizationID).Find(&policy)
+	if policy == nil || policy.OrganizationID == uuid.Nil {
+		return &RetentionPolicy{
+			OrganizationID: organizationID,
+			RetentionDays:  common.StatsLogRetentionDays,
+		}
+	}
+	return policy
+}
+
+// Create the log message
+func (m *LogMessage) Create() bool {
+	if !m.Validate() {
+		return false
+	}
+
+	db := dbconf.DatabaseConnection()
+
+	success := false
+	if db.NewRecord(m) {
+		result := db.Create(&m)
+		rowsAffected := result.RowsAffected
+		errors := result.GetErrors()
+		if len(errors) > 0 {
+			for _, err := range errors {
+				m.Errors = append(m.Errors, &provide.Error{
+					Message: common.StringOrNil(err.Error()),
+				})
+			}
+		}
+		if !db.NewRecord(m) {
+			success = rowsAffected > 0
+		}
+	}
+
+	return success
+}
+
+// Validate the log message; the severity defaults to info and the timestamp to the current time
+func (m *LogMessage) Validate() bool {
+	if m.OrganizationID == nil {
+		m.Errors = append(m.Errors, &provide.Error{
+			Message: common.StringOrNil("organization id is required"),
+		})
+	}
+
+	if m.Message == nil || *m.Message == "" {
+		m.Errors = append(m.Errors, &provide.Error{
+			Message: common.StringOrNil("message is required"),
+		})
+	}
+
+	if m.Severity == nil {
+		m.Severity = common.StringOrNil(logMessageSeverityInfo)
+	} else {
+		m.Severity = common.StringOrNil(strings.ToLower(*m.Severity))
+		supported := false
+		for _, severity := range logMessageSeverities {
+			if *m.Severity == severity {
+				supported = true
+				break
+			}
+		}
+		if !supported {
+			m.Errors = append(m.Errors, &provide.Error{
+				Message: common.StringOrNil(fmt.Sprintf("unsupported severity: %s", *m.Severity)),
+			})
+		}
+	}
+
+	if m.Timestamp == nil {
+		now := time.Now()
+		m.Timestamp = &now
+	}
+
+	return len(m.Errors) == 0
+}
+
+// Save the retention policy
+func (p *RetentionPolicy) Save() bool {
+	if p.RetentionDays <= 0 || p.RetentionDays > maxRetentionDays {
+		p.Errors = append(p.Errors, &provide.Error{
+			Message: common.StringOrNil(fmt.Sprintf("retention days must be between 1 and %d", maxRetentionDays)),
+		})
+		return false
+	}
+
+	db := dbconf.DatabaseConnection()
+	result := db.Save(&p)
+	errors := result.GetErrors()
+	if len(errors) > 0 {
+		for _, err := range errors {
+			p.Errors = append(p.Errors, &provide.Error{
+				Message: common.StringOrNil(err.Error()),
+			})
+		}
+	}
+
+	return len(p.Errors) == 0
+}
+
+// purgeExpiredLogMessages deletes the log messages which have outlived the retention policy of their
+// organization; organizations without a policy are subject to the configured default retention
+func purgeExpiredLogMessages() (int64, error) {
+	db := dbconf.DatabaseConnection()
+	result := db.Exec(
+		`DELETE FROM stats_logs l
+		 WHERE l.timestamp < now() - make_interval(days => COALESCE(
+		   (SELECT p.retention_days FROM stats_retention_policies p WHERE p.organization_id = l.organization_id), ?
+		 ))`,
+		common.StatsLogRetentionDays,
+	)
+	if err := result.Error; err != nil {
+		return 0, err
+	}
+	return result.RowsAffected, nil
 }
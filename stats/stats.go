@@ -17,20 +17,48 @@
 package stats
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"time"
 
-	// FIXME
-	// FIXME
 	"github.com/gin-gonic/gin"
+	dbconf "github.com/kthomas/go-db-config"
 	"github.com/provideplatform/baseline/common"
 	provide "github.com/provideplatform/provide-go/common"
 	"github.com/provideplatform/provide-go/common/util"
 )
 
+const retentionDaemonTickInterval = time.Hour
+
 // InstallStatsAPI installs stats logging APIs
 func InstallStatsAPI(r *gin.Engine) {
+	r.GET("/api/v1/stats", listStatsLogsHandler)
 	r.POST("/api/v1/stats", statsLogHandler)
+	r.GET("/api/v1/stats/retention", statsRetentionPolicyHandler)
+	r.PUT("/api/v1/stats/retention", updateStatsRetentionPolicyHandler)
+}
+
+// RunRetentionDaemon periodically purges the log messages which have outlived their retention
+// policy until the given context is done
+func RunRetentionDaemon(ctx context.Context) {
+	timer := time.NewTicker(retentionDaemonTickInterval)
+	defer timer.Stop()
+
+	for {
+		purged, err := purgeExpiredLogMessages()
+		if err != nil {
+			common.Log.Warningf("failed to purge expired stats log messages; %s", err.Error())
+		} else if purged > 0 {
+			common.Log.Debugf("purged %d expired stats log message(s)", purged)
+		}
+
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return
+		}
+	}
 }
 
 func statsLogHandler(c *gin.Context) {
@@ -56,11 +84,116 @@ func statsLogHandler(c *gin.Context) {
 		return
 	}
 
-	if err == nil {
+	msg.OrganizationID = organizationID
+
+	if msg.Create() {
 		provide.Render(nil, 204, c)
+	} else if len(msg.Errors) > 0 {
+		obj := map[string]interface{}{}
+		obj["errors"] = msg.Errors
+		provide.Render(obj, 422, c)
 	} else {
+		provide.RenderError("internal persistence error", 500, c)
+	}
+}
+
+func listStatsLogsHandler(c *gin.Context) {
+	organizationID := util.AuthorizedSubjectID(c, "organization")
+	if organizationID == nil {
+		provide.RenderError("unauthorized", 401, c)
+		return
+	}
+
+	var messages []*LogMessage
+
+	db := dbconf.DatabaseConnection()
+	query := db.Where("organization_id = ?", organizationID)
+
+	if c.Query("baseline_id") != "" {
+		query = query.Where("baseline_id = ?", c.Query("baseline_id"))
+	}
+
+	if c.Query("object_id") != "" {
+		query = query.Where("object_id = ?", c.Query("object_id"))
+	}
+
+	if c.Query("severity") != "" {
+		query = query.Where("severity = ?", c.Query("severity"))
+	}
+
+	if c.Query("type") != "" {
+		query = query.Where("type = ?", c.Query("type"))
+	}
+
+	if c.Query("start_at") != "" {
+		startAt, err := time.Parse(time.RFC3339, c.Query("start_at"))
+		if err != nil {
+			provide.RenderError(fmt.Sprintf("invalid start_at; %s", err.Error()), 422, c)
+			return
+		}
+		query = query.Where("timestamp >= ?", startAt)
+	}
+
+	if c.Query("end_at") != "" {
+		endAt, err := time.Parse(time.RFC3339, c.Query("end_at"))
+		if err != nil {
+			provide.RenderError(fmt.Sprintf("invalid end_at; %s", err.Error()), 422, c)
+			return
+		}
+		query = query.Where("timestamp < ?", endAt)
+	}
+
+	query = query.Order("timestamp DESC")
+	provide.Paginate(c, query, &LogMessage{}).Find(&messages)
+	provide.Render(messages, 200, c)
+}
+
+func statsRetentionPolicyHandler(c *gin.Context) {
+	organizationID := util.AuthorizedSubjectID(c, "organization")
+	if organizationID == nil {
+		provide.RenderError("unauthorized", 401, c)
+		return
+	}
+
+	provide.Render(FindRetentionPolicyByOrganizationID(*organizationID), 200, c)
+}
+
+func updateStatsRetentionPolicyHandler(c *gin.Context) {
+	organizationID := util.AuthorizedSubjectID(c, "organization")
+	if organizationID == nil {
+		provide.RenderError("unauthorized", 401, c)
+		return
+	}
+
+	buf, err := c.GetRawData()
+	if err != nil {
+		provide.RenderError(err.Error(), 400, c)
+		return
+	}
+
+	var params map[string]interface{}
+	err = json.Unmarshal(buf, &params)
+	if err != nil {
+		provide.RenderError(err.Error(), 422, c)
+		return
+	}
+
+	retentionDays, retentionDaysOk := params["retention_days"].(float64)
+	if !retentionDaysOk {
+		provide.RenderError("retention_days is required", 422, c)
+		return
+	}
+
+	policy := FindRetentionPolicyByOrganizationID(*organizationID)
+	policy.RetentionDays = int(retentionDays)
+
+	if policy.Save() {
+		provide.Render(policy, 200, c)
+	} else if len(policy.Errors) > 0 {
 		obj := map[string]interface{}{}
-		obj["errors"] = []interface{}{} // FIXME
+		obj["errors"] = policy.Errors
 		provide.Render(obj, 422, c)
+	} else {
+		provide.RenderError("internal persistence error", 500, c)
 	}
 }
@@ -0,0 +1,197 @@
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/provideplatform/provide-go/common"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/provideplatform/baseline"
+
+const tracesExporterNone = "none"
+const tracesExporterOTLP = "otlp"
+
+const otlpProtocolGRPC = "grpc"
+const otlpProtocolHTTP = "http/protobuf"
+
+var propagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+
+func init() {
+	otel.SetTextMapPropagator(propagator)
+}
+
+// RequireTracer configures the global tracer provider; the exporter is selected using the
+// OTEL_TRACES_EXPORTER (otlp or none) and OTEL_EXPORTER_OTLP_PROTOCOL (grpc or http/protobuf)
+// environment variables, and the OTLP endpoint, headers and tls settings are read by the
+// exporter from the standard OTEL_EXPORTER_OTLP_* environment variables; the returned func
+// flushes any buffered spans and stops the exporter
+func RequireTracer(defaultServiceName string) func(context.Context) error {
+	exporter := strings.ToLower(os.Getenv("OTEL_TRACES_EXPORTER"))
+	if exporter == "" || exporter == tracesExporterNone {
+		common.Log.Debug("OTEL_TRACES_EXPORTER not provided; trace context will be propagated but spans will not be exported")
+		return func(ctx context.Context) error { return nil }
+	}
+
+	if exporter != tracesExporterOTLP {
+		common.Log.Panicf("unsupported OTEL_TRACES_EXPORTER: %s", exporter)
+	}
+
+	serviceName := defaultServiceName
+	if os.Getenv("OTEL_SERVICE_NAME") != "" {
+		serviceName = os.Getenv("OTEL_SERVICE_NAME")
+	}
+
+	var client otlptrace.Client
+	protocol := strings.ToLower(os.Getenv("OTEL_EXPORTER_OTLP_PROTOCOL"))
+	switch protocol {
+	case "", otlpProtocolGRPC:
+		client = otlptracegrpc.NewClient()
+	case otlpProtocolHTTP:
+		client = otlptracehttp.NewClient()
+	default:
+		common.Log.Panicf("unsupported OTEL_EXPORTER_OTLP_PROTOCOL: %s", protocol)
+	}
+
+	exp, err := otlptrace.New(context.Background(), client)
+	if err != nil {
+		common.Log.Panicf("failed to initialize OTLP trace exporter; %s", err.Error())
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exp),
+		sdktrace.WithResource(resource.NewWithAttributes(
+			semconv.SchemaURL,
+			semconv.ServiceNameKey.String(serviceName),
+		)),
+	)
+	otel.SetTracerProvider(provider)
+
+	common.Log.Debugf("exporting traces for service %s using OTLP exporter", serviceName)
+	return provider.Shutdown
+}
+
+// Start a span with the given name as a child of the span in the given context, if any
+func Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return otel.Tracer(tracerName).Start(ctx, name, opts...)
+}
+
+// End the given span, marking it as failed when err is non-nil
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Middleware starts a server span for each http request, continuing the trace propagated
+// by the caller, if any, and makes it available via the request context
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		route := c.FullPath()
+		if route == "" {
+			route = c.Request.URL.Path
+		}
+
+		ctx := ExtractHeader(c.Request.Context(), c.Request.Header)
+		ctx, span := Start(ctx, fmt.Sprintf("%s %s", c.Request.Method, route),
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPMethodKey.String(c.Request.Method),
+				semconv.HTTPRouteKey.String(route),
+				semconv.HTTPTargetKey.String(c.Request.URL.RequestURI()),
+			),
+		)
+		defer span.End()
+
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+
+		status := c.Writer.Status()
+		span.SetAttributes(semconv.HTTPStatusCodeKey.Int(status))
+		span.SetStatus(semconv.SpanStatusFromHTTPStatusCode(status))
+	}
+}
+
+// Inject the trace context of the given context into a map suitable for embedding in a message envelope
+func Inject(ctx context.Context) map[string]string {
+	carrier := mapCarrier{}
+	propagator.Inject(ctx, carrier)
+	if len(carrier) == 0 {
+		return nil
+	}
+	return carrier
+}
+
+// Extract the trace context previously embedded in a message envelope using Inject
+func Extract(ctx context.Context, carrier map[string]string) context.Context {
+	if carrier == nil {
+		return ctx
+	}
+	return propagator.Extract(ctx, mapCarrier(carrier))
+}
+
+// InjectHeader injects the trace context of the given context into the given http or NATS headers
+func InjectHeader(ctx context.Context, header map[string][]string) {
+	propagator.Inject(ctx, propagation.HeaderCarrier(header))
+}
+
+// ExtractHeader extracts the trace context from the given http or NATS headers
+func ExtractHeader(ctx context.Context, header map[string][]string) context.Context {
+	if header == nil {
+		return ctx
+	}
+	return propagator.Extract(ctx, propagation.HeaderCarrier(header))
+}
+
+// mapCarrier adapts a map to the propagation.TextMapCarrier interface
+type mapCarrier map[string]string
+
+func (c mapCarrier) Get(key string) string {
+	return c[key]
+}
+
+func (c mapCarrier) Set(key, value string) {
+	c[key] = value
+}
+
+func (c mapCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}